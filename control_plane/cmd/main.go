@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	docs "github.com/usamaroman/faas_demo/control_plane/docs"
+	"github.com/usamaroman/faas_demo/control_plane/internal/balance"
 	"github.com/usamaroman/faas_demo/control_plane/internal/config"
 	httpapi "github.com/usamaroman/faas_demo/control_plane/internal/http"
 	"github.com/usamaroman/faas_demo/pkg/k8s"
 	"github.com/usamaroman/faas_demo/pkg/kafka"
 	"github.com/usamaroman/faas_demo/pkg/logger"
+	"github.com/usamaroman/faas_demo/pkg/observability"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
 )
 
 // @title			Control Plane API
@@ -51,18 +57,75 @@ func run() {
 		actionsTopic = "function_actions"
 	}
 
+	balanceTopic := os.Getenv("KAFKA_BALANCE_TOPIC")
+	if balanceTopic == "" {
+		balanceTopic = "tenant_balance"
+	}
+
+	budgetTopic := os.Getenv("KAFKA_BUDGET_TOPIC")
+	if budgetTopic == "" {
+		budgetTopic = "tenant_budget"
+	}
+
 	brokers := strings.Split(addresses, ",")
 
 	actionsProducer := kafka.NewProducer(kafka.ProducerConfig{Topic: actionsTopic, Addrs: brokers})
 
+	balances := balance.NewBlocklist("balance")
+	go runBlocklist(context.Background(), balances, kafka.ConsumerConfig{Topic: balanceTopic, Addrs: brokers})
+
+	caps := balance.NewBlocklist("budget cap")
+	go runBlocklist(context.Background(), caps, kafka.ConsumerConfig{Topic: budgetTopic, Addrs: brokers})
+
+	health := observability.NewHealth()
+	health.Add("kafka", observability.TCPCheck(brokers...))
+	health.Add("kubernetes", k8sCheck(restCfg))
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	health.Register(mux)
 
-	api := httpapi.New(cfg, actionsProducer, restCfg)
+	api := httpapi.New(cfg, actionsProducer, restCfg, balances, caps)
 	api.Register(mux)
 
 	slog.Info("control plane listening", slog.String("addr", cfg.HTTP.Addr))
-	if err := http.ListenAndServe(cfg.HTTP.Addr, mux); err != nil {
+	if err := http.ListenAndServe(cfg.HTTP.Addr, observability.Middleware(mux)); err != nil {
 		slog.Error("server error", slog.String("error", err.Error()))
 	}
 }
+
+// runBlocklist feeds the blocklist from every partition of the topic. Every
+// replica reads the whole topic, so no consumer group. The topic is created by
+// invoicer, it is looked up until it exists.
+func runBlocklist(ctx context.Context, list *balance.Blocklist, cfg kafka.ConsumerConfig) {
+	for {
+		readers, err := kafka.NewPartitionConsumers(ctx, cfg)
+		if err == nil {
+			list.Run(ctx, readers)
+			for _, r := range readers {
+				_ = r.Close()
+			}
+			return
+		}
+
+		slog.Error("failed to look up blocklist topic", slog.String("topic", cfg.Topic), slog.String("error", err.Error()))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// k8sCheck asks the API server for its version, which needs no RBAC permissions.
+func k8sCheck(restCfg *rest.Config) observability.Check {
+	return func(ctx context.Context) error {
+		cli, err := discovery.NewDiscoveryClientForConfig(restCfg)
+		if err != nil {
+			return err
+		}
+
+		_, err = cli.RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+		return err
+	}
+}
@@ -17,7 +17,7 @@ const docTemplate = `{
     "paths": {
         "/v1/functions/run": {
             "post": {
-                "description": "Create a Knative service for the provided function image and envs",
+                "description": "Create a Knative service for the provided function image and envs. Prepaid tenants with a negative balance are refused until they top up, tenants over a budget with a hard cap until the cap lifts.",
                 "consumes": [
                     "application/json"
                 ],
@@ -52,6 +52,64 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "402": {
+                        "description": "negative balance or budget cap reached",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "method not allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/functions/scale-to-zero": {
+            "post": {
+                "description": "Take every Knative service of the tenant off the ingress and drop its minimum scale to zero, so its replicas are removed once idle. Invoicer calls it when a budget with a hard cap is used up.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "functions"
+                ],
+                "summary": "Scale tenant functions to zero",
+                "parameters": [
+                    {
+                        "description": "Request body",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/httpapi.ScaleToZeroRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/httpapi.ScaleToZeroResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "invalid json",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "405": {
                         "description": "method not allowed",
                         "schema": {
@@ -101,6 +159,26 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "httpapi.ScaleToZeroRequest": {
+            "type": "object",
+            "properties": {
+                "tenant": {
+                    "type": "string",
+                    "example": "user@example.com"
+                }
+            }
+        },
+        "httpapi.ScaleToZeroResponse": {
+            "type": "object",
+            "properties": {
+                "services": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,100 @@
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Event is the state of a tenant published by invoicer: of a prepaid wallet
+// on the balance topic, of a budget with a hard cap on the budget topic.
+// Balance and Currency are only set by wallets.
+type Event struct {
+	Tenant   string `json:"tenant"`
+	Balance  string `json:"balance"`
+	Currency string `json:"currency"`
+	Blocked  bool   `json:"blocked"`
+}
+
+// Blocklist keeps the tenants blocked by the last event of a topic: those
+// whose wallet is negative or whose budget cap is reached. It is rebuilt from
+// every partition of the topic on start, tenants without events are never
+// blocked. Events are keyed by tenant, so the events of a tenant are read in
+// order.
+type Blocklist struct {
+	// name tells the lists apart in logs
+	name    string
+	mu      sync.RWMutex
+	blocked map[string]bool
+}
+
+func NewBlocklist(name string) *Blocklist {
+	return &Blocklist{name: name, blocked: make(map[string]bool)}
+}
+
+func (b *Blocklist) Blocked(tenant string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.blocked[tenant]
+}
+
+func (b *Blocklist) apply(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e.Blocked {
+		b.blocked[e.Tenant] = true
+	} else {
+		delete(b.blocked, e.Tenant)
+	}
+}
+
+// Run reads the readers, one per partition, until ctx is cancelled.
+func (b *Blocklist) Run(ctx context.Context, readers []*kafka.Reader) {
+	slog.Info("blocklist consumer started", slog.String("list", b.name), slog.Int("partitions", len(readers)))
+
+	var wg sync.WaitGroup
+	for _, r := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.read(ctx, r)
+		}()
+	}
+	wg.Wait()
+
+	slog.Info("blocklist consumer stopped", slog.String("list", b.name))
+}
+
+func (b *Blocklist) read(ctx context.Context, reader *kafka.Reader) {
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			slog.Error("failed to read blocklist message", slog.String("list", b.name), slog.String("error", err.Error()))
+			continue
+		}
+
+		var e Event
+		if err := json.Unmarshal(msg.Value, &e); err != nil || e.Tenant == "" {
+			slog.Error("invalid blocklist event", slog.String("list", b.name), slog.String("value", string(msg.Value)))
+			continue
+		}
+
+		if e.Blocked != b.Blocked(e.Tenant) {
+			slog.Info("tenant blocked state changed",
+				slog.String("list", b.name),
+				slog.String("tenant", e.Tenant),
+				slog.String("balance", e.Balance),
+				slog.Bool("blocked", e.Blocked))
+		}
+		b.apply(e)
+	}
+}
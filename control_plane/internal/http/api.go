@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/segmentio/kafka-go"
+	"github.com/usamaroman/faas_demo/control_plane/internal/balance"
 	"github.com/usamaroman/faas_demo/control_plane/internal/config"
 	"github.com/usamaroman/faas_demo/pkg/knative"
 	"k8s.io/client-go/rest"
@@ -20,14 +22,17 @@ type API struct {
 	cfg      config.Config
 	producer *kafka.Writer // optional
 	restCfg  *rest.Config
+	balances *balance.Blocklist // optional
+	caps     *balance.Blocklist // optional
 }
 
-func New(cfg config.Config, producer *kafka.Writer, restCfg *rest.Config) *API {
-	return &API{cfg: cfg, producer: producer, restCfg: restCfg}
+func New(cfg config.Config, producer *kafka.Writer, restCfg *rest.Config, balances, caps *balance.Blocklist) *API {
+	return &API{cfg: cfg, producer: producer, restCfg: restCfg, balances: balances, caps: caps}
 }
 
 func (a *API) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/v1/functions/run", a.handleRun)
+	mux.HandleFunc("/v1/functions/scale-to-zero", a.handleScaleToZero)
 }
 
 type RunRequest struct {
@@ -45,13 +50,14 @@ type RunResponse struct {
 // handleRun godoc
 //
 //	@Summary		Run a function
-//	@Description	Create a Knative service for the provided function image and envs
+//	@Description	Create a Knative service for the provided function image and envs. Prepaid tenants with a negative balance are refused until they top up, tenants over a budget with a hard cap until the cap lifts.
 //	@Tags			functions
 //	@Accept			json
 //	@Produce		json
 //	@Param			input	body		RunRequest	true	"Request body"
 //	@Success		200		{object}	RunResponse
 //	@Failure		400		{string}	string	"invalid json"
+//	@Failure		402		{string}	string	"negative balance or budget cap reached"
 //	@Failure		405		{string}	string	"method not allowed"
 //	@Failure		500		{string}	string
 //	@Router			/v1/functions/run [post]
@@ -65,6 +71,14 @@ func (a *API) handleRun(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
+	if a.balances != nil && a.balances.Blocked(req.Email) {
+		http.Error(w, "negative balance, top up the wallet to run functions", http.StatusPaymentRequired)
+		return
+	}
+	if a.caps != nil && a.caps.Blocked(req.Email) {
+		http.Error(w, "budget hard cap reached, raise the budget to run functions", http.StatusPaymentRequired)
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
 	defer cancel()
@@ -74,7 +88,7 @@ func (a *API) handleRun(w http.ResponseWriter, r *http.Request) {
 	envs := req.Envs
 	annotations := map[string]string{}
 	if req.Email != "" {
-		annotations["tenant"] = req.Email
+		annotations[knative.TenantAnnotation] = req.Email
 	}
 	// включаем имя образа и envs (в json) прямо в метаданные сервиса
 	if req.ImageName != "" {
@@ -134,6 +148,59 @@ func (a *API) handleRun(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+type ScaleToZeroRequest struct {
+	Tenant string `json:"tenant" example:"user@example.com"`
+}
+
+type ScaleToZeroResponse struct {
+	Services []string `json:"services"`
+}
+
+// handleScaleToZero godoc
+//
+//	@Summary		Scale tenant functions to zero
+//	@Description	Take every Knative service of the tenant off the ingress and drop its minimum scale to zero, so its replicas are removed once idle. Invoicer calls it when a budget with a hard cap is used up.
+//	@Tags			functions
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		ScaleToZeroRequest	true	"Request body"
+//	@Success		200		{object}	ScaleToZeroResponse
+//	@Failure		400		{string}	string	"invalid json"
+//	@Failure		405		{string}	string	"method not allowed"
+//	@Failure		500		{string}	string
+//	@Router			/v1/functions/scale-to-zero [post]
+func (a *API) handleScaleToZero(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ScaleToZeroRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Tenant == "" {
+		http.Error(w, "tenant is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	scaled, err := knative.ScaleToZero(ctx, a.restCfg, a.cfg.K8S.Namespace, req.Tenant)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	slog.Info("stopped tenant functions", slog.String("tenant", req.Tenant), slog.Int("services", len(scaled)))
+
+	if scaled == nil {
+		scaled = []string{}
+	}
+	writeJSON(w, http.StatusOK, ScaleToZeroResponse{Services: scaled})
+}
+
 func httpError(w http.ResponseWriter, err error) {
 	var code = http.StatusInternalServerError
 	if errors.Is(err, context.DeadlineExceeded) {
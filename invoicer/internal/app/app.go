@@ -0,0 +1,328 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/config"
+	"github.com/usamaroman/faas_demo/invoicer/internal/consumer"
+	v1 "github.com/usamaroman/faas_demo/invoicer/internal/controller/v1"
+	"github.com/usamaroman/faas_demo/invoicer/internal/export"
+	"github.com/usamaroman/faas_demo/invoicer/internal/render"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/scheduler"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/invoicer/internal/storage"
+	"github.com/usamaroman/faas_demo/invoicer/internal/webapi/controlplane"
+	"github.com/usamaroman/faas_demo/invoicer/internal/webapi/payment"
+	"github.com/usamaroman/faas_demo/invoicer/internal/webapi/price"
+	"github.com/usamaroman/faas_demo/pkg/clickhouse"
+	"github.com/usamaroman/faas_demo/pkg/kafka"
+	"github.com/usamaroman/faas_demo/pkg/logger"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/observability"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+)
+
+func Run() {
+	logger.NewLogger()
+	slog.Info("Starting Invoicer service")
+
+	cfg := config.Load()
+	if len(cfg.Kafka.Brokers) == 0 {
+		slog.Error("provide KAFKA_ADDRS env var")
+		os.Exit(1)
+	}
+
+	clickhouseClient, err := newClickHouse(cfg)
+	if err != nil {
+		slog.Error("failed to connect to clickhouse", slog.String("host", cfg.ClickHouse.Host), slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	defer clickhouseClient.Close()
+
+	slog.Info("postgresql starting")
+	postgres, err := newPostgres(cfg)
+	if err != nil {
+		slog.Error("failed to init postgresql", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	defer postgres.Close()
+
+	billingCfg, err := billingConfig(cfg)
+	if err != nil {
+		slog.Error("invalid billing settings", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	exportCfg, exportStore, err := usageExport(cfg)
+	if err != nil {
+		slog.Error("invalid usage export settings", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	actionsReader := kafka.NewConsumer(kafka.ConsumerConfig{
+		Topic:   cfg.Kafka.ActionsTopic,
+		GroupID: cfg.Kafka.ActionsConsumerGroup,
+		Addrs:   cfg.Kafka.Brokers,
+	})
+	defer actionsReader.Close()
+
+	deadLetterProducer := kafka.NewProducer(kafka.ProducerConfig{
+		Topic: cfg.Kafka.ActionsDLQTopic,
+		Addrs: cfg.Kafka.Brokers,
+	})
+	defer deadLetterProducer.Close()
+
+	notifyProducer := kafka.NewProducer(kafka.ProducerConfig{
+		Topic: cfg.Kafka.NotifyTopic,
+		Addrs: cfg.Kafka.Brokers,
+	})
+	defer notifyProducer.Close()
+
+	// control_plane rebuilds the blocked tenants from every partition of the
+	// topic, events are keyed by tenant to keep them in order. The topic is
+	// compacted to the last event of every tenant, so it does not grow with
+	// the history and retention never drops a tenant that is still blocked.
+	balanceProducer := kafka.NewProducer(kafka.ProducerConfig{
+		Topic:   cfg.Kafka.BalanceTopic,
+		Addrs:   cfg.Kafka.Brokers,
+		Compact: true,
+	})
+	defer balanceProducer.Close()
+
+	// control_plane refuses to run the functions of capped tenants, read
+	// like the balance topic
+	budgetProducer := kafka.NewProducer(kafka.ProducerConfig{
+		Topic:   cfg.Kafka.BudgetTopic,
+		Addrs:   cfg.Kafka.Brokers,
+		Compact: true,
+	})
+	defer budgetProducer.Close()
+
+	slog.Info("repositories init")
+	repositories := repo.NewRepositories(clickhouseClient, postgres, cfg.Usage.SampleIntervalSec)
+
+	var scaler service.FunctionScaler
+	if cfg.ControlPlane.URL != "" {
+		scaler = controlplane.New(cfg.ControlPlane.URL)
+	} else {
+		slog.Warn("CONTROL_PLANE_URL is not set, budget hard caps do not stop running functions")
+	}
+
+	if cfg.Payment.WebhookSecret == "" {
+		slog.Warn("PAYMENT_WEBHOOK_SECRET is not set, local payment webhooks are rejected")
+	}
+
+	slog.Info("services init")
+	services := service.NewServices(&service.Dependencies{
+		Repos:   repositories,
+		Tariffs: price.New(cfg.PriceService.URL),
+		Billing: billingCfg,
+		Brand: render.Brand{
+			Name:    cfg.Documents.BrandName,
+			Address: cfg.Documents.BrandAddress,
+			Email:   cfg.Documents.BrandEmail,
+			Color:   cfg.Documents.BrandColor,
+		},
+		Notify:      notifyProducer,
+		NotifyTopic: cfg.Kafka.NotifyTopic,
+		Balance:     balanceProducer,
+		BudgetCaps:  budgetProducer,
+		Scaler:      scaler,
+		Providers: []service.PaymentProvider{
+			payment.NewLocal(cfg.Payment.WebhookSecret, cfg.Payment.CheckoutURL),
+		},
+		Payment: service.PaymentConfig{
+			ReminderInterval: time.Duration(cfg.Dunning.ReminderDays) * day,
+			MaxReminders:     cfg.Dunning.MaxReminders,
+		},
+		ExportStore: exportStore,
+		Export:      exportCfg,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if cfg.Billing.FXRatesFile != "" {
+		if err := loadFXRates(ctx, services.FX, cfg.Billing.FXRatesFile); err != nil {
+			slog.Error("failed to load FX_RATES_FILE", slog.String("path", cfg.Billing.FXRatesFile), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	go consumer.NewActions(actionsReader, services.Notification, deadLetterProducer).Run(ctx)
+	go scheduler.NewPeriodCloser(services.Billing, services.Notification, cfg.Billing.CloseInterval).Run(ctx)
+	go scheduler.NewBudgetChecker(services.Budget, cfg.Budget.CheckInterval).Run(ctx)
+	go scheduler.NewLedgerSync(services.Ledger, cfg.Ledger.SyncInterval).Run(ctx)
+	go scheduler.NewDunning(services.Payment, cfg.Dunning.CheckInterval).Run(ctx)
+	go scheduler.NewOutboxRelay(services.Outbox, cfg.Outbox.RelayInterval).Run(ctx)
+	if exportStore != nil {
+		go scheduler.NewUsageExporter(services.Export, cfg.Export.CheckInterval).Run(ctx)
+	}
+
+	health := observability.NewHealth()
+	health.Add("clickhouse", clickhouseClient.Ping)
+	health.Add("postgres", postgres.Pool.Ping)
+	health.Add("kafka", observability.TCPCheck(cfg.Kafka.Brokers...))
+	health.Add("price_service", observability.HTTPCheck(cfg.PriceService.URL+"/health"))
+
+	r := gin.Default()
+	v1.NewRouter(r, services, health)
+
+	server := &http.Server{
+		Addr:    ":" + cfg.HTTP.Port,
+		Handler: r,
+	}
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		slog.Info("Starting HTTP server", slog.String("port", cfg.HTTP.Port))
+		serverErrors <- server.ListenAndServe()
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case s := <-interrupt:
+		slog.Info("application got signal", slog.String("signal", s.String()))
+	case err = <-serverErrors:
+		if err != nil {
+			slog.Error("http server error", slog.String("error", err.Error()))
+		}
+	}
+
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shutdown http server", slog.String("error", err.Error()))
+	}
+
+	slog.Info("application stopped")
+}
+
+func loadFXRates(ctx context.Context, fx service.FX, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := fx.Load(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("loaded fx rates", slog.String("path", path), slog.Int("rates", n))
+	return nil
+}
+
+const day = 24 * time.Hour
+
+func newClickHouse(cfg config.Config) (*clickhouse.Client, error) {
+	return clickhouse.New(context.Background(), clickhouse.Config{
+		Host:     cfg.ClickHouse.Host,
+		Port:     cfg.ClickHouse.Port,
+		Username: cfg.ClickHouse.Username,
+		Password: cfg.ClickHouse.Password,
+		Database: cfg.ClickHouse.Database,
+	})
+}
+
+func newPostgres(cfg config.Config) (*postgresql.Postgres, error) {
+	return postgresql.New(postgresql.Config{
+		Host:     cfg.Postgresql.Host,
+		Port:     cfg.Postgresql.Port,
+		User:     cfg.Postgresql.User,
+		Password: cfg.Postgresql.Password,
+		Database: cfg.Postgresql.Database,
+	})
+}
+
+// billingConfig checks the billing settings and turns them into the billing
+// service config.
+func billingConfig(cfg config.Config) (service.BillingConfig, error) {
+	period, err := service.ParsePeriod(cfg.Billing.Period)
+	if err != nil {
+		return service.BillingConfig{}, fmt.Errorf("invalid BILLING_PERIOD: %w", err)
+	}
+
+	policy, err := money.NewPolicy(cfg.Billing.Currency, cfg.Billing.Precision, cfg.Billing.Rounding)
+	if err != nil {
+		return service.BillingConfig{}, fmt.Errorf("invalid billing money settings: %w", err)
+	}
+
+	sellerCountry := strings.ToUpper(strings.TrimSpace(cfg.Billing.SellerCountry))
+	if sellerCountry != "" && len(sellerCountry) != 2 {
+		return service.BillingConfig{}, fmt.Errorf("invalid BILLING_SELLER_COUNTRY %q, expected a two-letter code", cfg.Billing.SellerCountry)
+	}
+
+	return service.BillingConfig{
+		DefaultTariffID: cfg.PriceService.DefaultTariffID,
+		Period:          period,
+		Grace:           cfg.Billing.Grace,
+		Money:           policy,
+		SellerCountry:   sellerCountry,
+		PaymentTerms:    time.Duration(cfg.Payment.TermsDays) * day,
+	}, nil
+}
+
+// usageExport checks the usage export settings and opens the store of the
+// scheduled exports, nil when USAGE_EXPORT_PATH is empty.
+func usageExport(cfg config.Config) (service.ExportConfig, service.ExportStore, error) {
+	if export.ContentType(cfg.Export.Format) == "" {
+		return service.ExportConfig{}, nil, fmt.Errorf("invalid USAGE_EXPORT_FORMAT %q, expected csv, jsonl or parquet", cfg.Export.Format)
+	}
+
+	period, err := service.ParsePeriod(cfg.Export.Period)
+	if err != nil {
+		return service.ExportConfig{}, nil, fmt.Errorf("invalid USAGE_EXPORT_PERIOD: %w", err)
+	}
+
+	exportCfg := service.ExportConfig{
+		Format: cfg.Export.Format,
+		Period: period,
+		Delay:  cfg.Export.Delay,
+	}
+
+	path := cfg.Export.Path
+	switch {
+	case path == "":
+		slog.Info("USAGE_EXPORT_PATH is not set, usage is not exported on schedule")
+		return exportCfg, nil, nil
+	case strings.HasPrefix(path, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(path, "s3://"), "/")
+		if bucket == "" {
+			return service.ExportConfig{}, nil, fmt.Errorf("invalid USAGE_EXPORT_PATH %q, expected s3://bucket/prefix", path)
+		}
+		store, err := storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.Export.S3.Endpoint,
+			AccessKey: cfg.Export.S3.AccessKey,
+			SecretKey: cfg.Export.S3.SecretKey,
+			Region:    cfg.Export.S3.Region,
+			UseSSL:    cfg.Export.S3.UseSSL,
+		}, bucket, prefix)
+		if err != nil {
+			return service.ExportConfig{}, nil, err
+		}
+		return exportCfg, store, nil
+	default:
+		store, err := storage.NewLocal(path)
+		if err != nil {
+			return service.ExportConfig{}, nil, err
+		}
+		return exportCfg, store, nil
+	}
+}
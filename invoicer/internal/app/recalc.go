@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/config"
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/invoicer/internal/webapi/price"
+)
+
+// Recalc runs `invoicer recalc`: it bills an issued invoice again and prints
+// how it differs. It returns the exit code.
+func Recalc(args []string) int {
+	fs := flag.NewFlagSet("recalc", flag.ContinueOnError)
+	var (
+		tenant  = fs.String("tenant", "", "tenant of the invoice")
+		period  = fs.String("period", "", "start of the invoice period, 2006-01-02 or RFC3339")
+		tariff  = fs.Int("tariff", 0, "price the usage of this tariff with -version")
+		version = fs.Int("version", 0, "version of -tariff, the latest if 0")
+		mode    = fs.String("mode", entity.RecalcDryRun, "dry_run, credit_note, debit_note or correct")
+		asJSON  = fs.Bool("json", false, "print the result as JSON")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: invoicer recalc -tenant TENANT -period START [-tariff ID [-version N]] [-mode MODE] [-json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	start, err := parsePeriodStart(*period)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -period: %v\n", err)
+		return 2
+	}
+
+	// the comparison goes to stdout, keep the logs out of its way
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	cfg := config.Load()
+
+	billingCfg, err := billingConfig(cfg)
+	if err != nil {
+		slog.Error("invalid billing settings", slog.String("error", err.Error()))
+		return 1
+	}
+
+	clickhouseClient, err := newClickHouse(cfg)
+	if err != nil {
+		slog.Error("failed to connect to clickhouse", slog.String("host", cfg.ClickHouse.Host), slog.String("error", err.Error()))
+		return 1
+	}
+	defer clickhouseClient.Close()
+
+	postgres, err := newPostgres(cfg)
+	if err != nil {
+		slog.Error("failed to init postgresql", slog.String("error", err.Error()))
+		return 1
+	}
+	defer postgres.Close()
+
+	repositories := repo.NewRepositories(clickhouseClient, postgres, cfg.Usage.SampleIntervalSec)
+	billing := service.NewBillingService(repositories.Usage, repositories.Invoice, repositories.Tax, repositories.FX,
+		price.New(cfg.PriceService.URL), billingCfg)
+
+	rc, err := billing.Recalc(context.Background(), &service.RecalcInput{
+		Tenant:        *tenant,
+		PeriodStart:   start,
+		TariffID:      *tariff,
+		TariffVersion: *version,
+		Mode:          *mode,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "recalc failed: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rc); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	printRecalc(os.Stdout, rc)
+	return 0
+}
+
+func parsePeriodStart(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// printRecalc prints the comparison as a table of the changed amounts.
+func printRecalc(w io.Writer, rc *entity.Recalc) {
+	fmt.Fprintf(w, "invoice %d of %s, %s - %s, %s\n", rc.Invoice, rc.Tenant,
+		rc.PeriodStart.Format(time.RFC3339), rc.PeriodEnd.Format(time.RFC3339), rc.Currency)
+	if len(rc.CreditNotes) > 0 {
+		fmt.Fprintf(w, "credit notes: %v\n", rc.CreditNotes)
+	}
+	if len(rc.DebitNotes) > 0 {
+		fmt.Fprintf(w, "debit notes: %v\n", rc.DebitNotes)
+	}
+	fmt.Fprintln(w)
+
+	if len(rc.Lines) == 0 {
+		fmt.Fprintln(w, "no changes")
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "function\titem\tissued\trecalculated\tdelta\t")
+		for _, l := range rc.Lines {
+			function := l.Function
+			if function == "" {
+				function = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", function, l.Item, l.Issued, l.Recalculated, l.Delta)
+		}
+		_ = tw.Flush()
+	}
+
+	fmt.Fprintf(w, "\nissued %s, recalculated %s, delta %s %s\n", rc.Issued, rc.Recalculated, rc.Delta, rc.Currency)
+
+	switch {
+	case rc.Document != nil && rc.Document.Kind == entity.InvoiceKindCreditNote:
+		fmt.Fprintf(w, "issued credit note %d\n", rc.Document.Number)
+	case rc.Document != nil && rc.Document.Kind == entity.InvoiceKindDebitNote:
+		fmt.Fprintf(w, "issued debit note %d\n", rc.Document.Number)
+	case rc.Document != nil:
+		fmt.Fprintf(w, "issued invoice %d replacing invoice %d\n", rc.Document.Number, rc.Invoice)
+	case rc.Mode == entity.RecalcDryRun && rc.Settle != "":
+		fmt.Fprintf(w, "dry run, nothing issued, settle with -mode %s\n", rc.Settle)
+	case rc.Mode == entity.RecalcDryRun:
+		fmt.Fprintln(w, "dry run, nothing issued")
+	default:
+		fmt.Fprintln(w, "nothing to settle")
+	}
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HTTPConfig struct {
+	Port string
+}
+
+type ClickHouseConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Database string
+}
+
+type PriceServiceConfig struct {
+	URL             string
+	DefaultTariffID int
+}
+
+type ControlPlaneConfig struct {
+	// URL is where budget hard caps scale functions to zero, empty turns
+	// hard caps into alerts only.
+	URL string
+}
+
+type BudgetConfig struct {
+	CheckInterval time.Duration
+}
+
+type LedgerConfig struct {
+	// SyncInterval is how often prepaid wallets are debited for live usage.
+	SyncInterval time.Duration
+}
+
+type PaymentConfig struct {
+	// TermsDays is how many days tenants have to pay an invoice, zero for
+	// invoices that are never overdue.
+	TermsDays int
+	// WebhookSecret signs the webhooks of the local payment provider.
+	WebhookSecret string
+	// CheckoutURL is the base of the local provider's checkout links.
+	CheckoutURL string
+}
+
+type DunningConfig struct {
+	CheckInterval time.Duration
+	// ReminderDays is the time between reminders of an overdue invoice.
+	ReminderDays int
+	// MaxReminders is how many reminders an overdue invoice gets, zero for
+	// none.
+	MaxReminders int
+}
+
+type OutboxConfig struct {
+	// RelayInterval is how often the outbox is published to Kafka.
+	RelayInterval time.Duration
+}
+
+type PostgresqlConfig struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Database string
+}
+
+type BillingConfig struct {
+	// Period is the billing period length: month, day or hour.
+	Period string
+	// Grace keeps a period open after its end for usage still in flight.
+	Grace         time.Duration
+	CloseInterval time.Duration
+	// Currency, Precision and Rounding turn exact totals into the amount
+	// due. A negative precision keeps the currency's minor unit.
+	Currency  string
+	Precision int
+	Rounding  string
+	// SellerCountry is the two-letter country the seller is registered in
+	// for tax.
+	SellerCountry string
+	// FXRatesFile is a CSV of exchange rates (date,currency,rate) loaded on
+	// start, empty for none.
+	FXRatesFile string
+}
+
+// DocumentConfig brands the HTML and PDF invoices.
+type DocumentConfig struct {
+	BrandName    string
+	BrandAddress string
+	BrandEmail   string
+	// BrandColor is the accent color as hex RGB.
+	BrandColor string
+}
+
+type UsageConfig struct {
+	// SampleIntervalSec is how often meter_agent samples a pod
+	// (SCRAPE_INTERVAL_SEC), every metric row accounts for that many seconds.
+	SampleIntervalSec int
+}
+
+type ExportConfig struct {
+	// Path is where the scheduled usage exports are written: a local
+	// directory or s3://bucket/prefix. Empty turns them off.
+	Path string
+	// Format is csv, jsonl or parquet.
+	Format string
+	// Period is the span of usage in one file: month, day or hour.
+	Period string
+	// Delay keeps a period from being exported until late usage has landed.
+	Delay         time.Duration
+	CheckInterval time.Duration
+	S3            S3Config
+}
+
+// S3Config reaches the S3 compatible store of s3:// export paths.
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+}
+
+type KafkaConfig struct {
+	Brokers              []string
+	ActionsTopic         string
+	ActionsConsumerGroup string
+	NotifyTopic          string
+	BalanceTopic         string
+	BudgetTopic          string
+	// ActionsDLQTopic receives the actions that failed to be processed.
+	ActionsDLQTopic string
+}
+
+type Config struct {
+	HTTP         HTTPConfig
+	ClickHouse   ClickHouseConfig
+	Postgresql   PostgresqlConfig
+	PriceService PriceServiceConfig
+	ControlPlane ControlPlaneConfig
+	Billing      BillingConfig
+	Documents    DocumentConfig
+	Budget       BudgetConfig
+	Ledger       LedgerConfig
+	Payment      PaymentConfig
+	Dunning      DunningConfig
+	Outbox       OutboxConfig
+	Usage        UsageConfig
+	Export       ExportConfig
+	Kafka        KafkaConfig
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func getEnvBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return def
+}
+
+func Load() Config {
+	return Config{
+		HTTP: HTTPConfig{
+			Port: getEnv("PORT", "8080"),
+		},
+		ClickHouse: ClickHouseConfig{
+			Host:     getEnv("CLICKHOUSE_HOST", "localhost"),
+			Port:     getEnv("CLICKHOUSE_PORT", "9000"),
+			Username: getEnv("CLICKHOUSE_USER", "default"),
+			Password: getEnv("CLICKHOUSE_PASSWORD", ""),
+			Database: getEnv("CLICKHOUSE_DB", "metrics"),
+		},
+		Postgresql: PostgresqlConfig{
+			Host:     getEnv("PG_HOST", "127.0.0.1"),
+			Port:     getEnv("PG_PORT", "5432"),
+			User:     getEnv("PG_USER", "postgres"),
+			Password: getEnv("PG_PASSWORD", "5432"),
+			Database: getEnv("PG_DATABASE", "control-plane"),
+		},
+		Billing: BillingConfig{
+			Period:        getEnv("BILLING_PERIOD", "month"),
+			Grace:         getEnvDuration("BILLING_GRACE", time.Hour),
+			CloseInterval: getEnvDuration("BILLING_CLOSE_INTERVAL", 10*time.Minute),
+			Currency:      getEnv("BILLING_CURRENCY", "USD"),
+			Precision:     getEnvInt("BILLING_PRECISION", -1),
+			Rounding:      getEnv("BILLING_ROUNDING", "half_even"),
+			SellerCountry: getEnv("BILLING_SELLER_COUNTRY", ""),
+			FXRatesFile:   getEnv("FX_RATES_FILE", ""),
+		},
+		Documents: DocumentConfig{
+			BrandName:    getEnv("INVOICE_BRAND_NAME", "FaaS"),
+			BrandAddress: getEnv("INVOICE_BRAND_ADDRESS", ""),
+			BrandEmail:   getEnv("INVOICE_BRAND_EMAIL", ""),
+			BrandColor:   getEnv("INVOICE_BRAND_COLOR", "#1f6feb"),
+		},
+		Budget: BudgetConfig{
+			CheckInterval: getEnvDuration("BUDGET_CHECK_INTERVAL", 5*time.Minute),
+		},
+		Ledger: LedgerConfig{
+			SyncInterval: getEnvDuration("LEDGER_SYNC_INTERVAL", time.Minute),
+		},
+		Payment: PaymentConfig{
+			TermsDays:     getEnvInt("BILLING_PAYMENT_TERMS_DAYS", 14),
+			WebhookSecret: getEnv("PAYMENT_WEBHOOK_SECRET", ""),
+			CheckoutURL:   getEnv("PAYMENT_CHECKOUT_URL", "http://localhost:8080"),
+		},
+		Dunning: DunningConfig{
+			CheckInterval: getEnvDuration("DUNNING_CHECK_INTERVAL", time.Hour),
+			ReminderDays:  getEnvInt("DUNNING_REMINDER_DAYS", 7),
+			MaxReminders:  getEnvInt("DUNNING_MAX_REMINDERS", 3),
+		},
+		Outbox: OutboxConfig{
+			RelayInterval: getEnvDuration("OUTBOX_RELAY_INTERVAL", time.Second),
+		},
+		PriceService: PriceServiceConfig{
+			URL:             getEnv("PRICE_SERVICE_URL", "http://localhost:8080"),
+			DefaultTariffID: getEnvInt("DEFAULT_TARIFF_ID", 1),
+		},
+		ControlPlane: ControlPlaneConfig{
+			URL: getEnv("CONTROL_PLANE_URL", ""),
+		},
+		Usage: UsageConfig{
+			SampleIntervalSec: getEnvInt("METRICS_SAMPLE_INTERVAL_SEC", 1),
+		},
+		Export: ExportConfig{
+			Path:          getEnv("USAGE_EXPORT_PATH", ""),
+			Format:        getEnv("USAGE_EXPORT_FORMAT", "parquet"),
+			Period:        getEnv("USAGE_EXPORT_PERIOD", "day"),
+			Delay:         getEnvDuration("USAGE_EXPORT_DELAY", time.Hour),
+			CheckInterval: getEnvDuration("USAGE_EXPORT_CHECK_INTERVAL", 10*time.Minute),
+			S3: S3Config{
+				Endpoint:  getEnv("USAGE_EXPORT_S3_ENDPOINT", "localhost:9000"),
+				AccessKey: getEnv("USAGE_EXPORT_S3_ACCESS_KEY", ""),
+				SecretKey: getEnv("USAGE_EXPORT_S3_SECRET_KEY", ""),
+				Region:    getEnv("USAGE_EXPORT_S3_REGION", ""),
+				UseSSL:    getEnvBool("USAGE_EXPORT_S3_USE_SSL", false),
+			},
+		},
+		Kafka: KafkaConfig{
+			Brokers:              splitAndTrim(os.Getenv("KAFKA_ADDRS")),
+			ActionsTopic:         getEnv("KAFKA_ACTIONS_TOPIC", "function_actions"),
+			ActionsConsumerGroup: getEnv("KAFKA_ACTIONS_CONSUMER_GROUP_NAME", "invoicer-actions"),
+			ActionsDLQTopic:      getEnv("KAFKA_ACTIONS_DLQ_TOPIC", "function_actions_dlq"),
+			NotifyTopic:          getEnv("KAFKA_NOTIFY_TOPIC", "notify"),
+			BalanceTopic:         getEnv("KAFKA_BALANCE_TOPIC", "tenant_balance"),
+			BudgetTopic:          getEnv("KAFKA_BUDGET_TOPIC", "tenant_budget"),
+		},
+	}
+}
+
+func splitAndTrim(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		t := strings.TrimSpace(p)
+		if t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,215 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/observability"
+	"github.com/usamaroman/faas_demo/pkg/types"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+const (
+	// maxAttempts bounds the attempts to process an action, about two
+	// minutes of backoff, before it goes to the dead letter topic.
+	maxAttempts = 8
+	// maxRetryBackoff caps the wait between attempts to process an action.
+	maxRetryBackoff = time.Minute
+)
+
+// Dead letter headers tell why an action was given up on.
+const (
+	headerError    = "error"
+	headerAttempts = "attempts"
+)
+
+// Reasons an action is dead lettered for, counted by
+// observability.DeadLettered.
+const (
+	reasonMalformed = "malformed"
+	reasonPermanent = "permanent"
+	reasonExhausted = "exhausted"
+)
+
+// Actions reads pod lifecycle actions and notifies tenants about stopped pods.
+// An offset is committed only once its action is processed, so a crash
+// redelivers the action instead of losing it; the notification service
+// records processed actions, so the redelivery notifies nobody twice.
+//
+// An action that keeps failing would hold up its whole partition, so after
+// maxAttempts, or right away when retrying cannot help or the message is not
+// an action at all, it is written to the dead letter topic and committed. Dead letters can be replayed into the
+// actions topic once the cause is fixed.
+type Actions struct {
+	reader        *gokafka.Reader
+	notifications service.Notification
+	deadLetters   service.Publisher
+	backoff       time.Duration
+}
+
+func NewActions(reader *gokafka.Reader, notifications service.Notification, deadLetters service.Publisher) *Actions {
+	return &Actions{
+		reader:        reader,
+		notifications: notifications,
+		deadLetters:   deadLetters,
+		backoff:       time.Second,
+	}
+}
+
+func (a *Actions) Run(ctx context.Context) {
+	slog.Info("actions consumer started")
+
+	backoff := a.backoff
+	for {
+		msg, err := a.reader.FetchMessage(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				slog.Info("actions consumer stopped")
+				return
+			}
+			slog.Error("failed to read action message", slog.Duration("backoff", backoff), slog.String("error", err.Error()))
+			if !sleep(ctx, backoff) {
+				slog.Info("actions consumer stopped")
+				return
+			}
+			backoff = min(2*backoff, maxRetryBackoff)
+			continue
+		}
+		backoff = a.backoff
+
+		if !a.process(ctx, msg) {
+			slog.Info("actions consumer stopped")
+			return
+		}
+
+		if err := a.reader.CommitMessages(ctx, msg); err != nil {
+			if errors.Is(err, context.Canceled) {
+				slog.Info("actions consumer stopped")
+				return
+			}
+			slog.Error("failed to commit action offset", slog.Int64("offset", msg.Offset), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// process handles the action, retrying failures with backoff up to
+// maxAttempts. It returns false when the consumer stopped before the action
+// was done with.
+func (a *Actions) process(ctx context.Context, msg gokafka.Message) bool {
+	var action types.Action
+	if err := json.Unmarshal(msg.Value, &action); err != nil {
+		return a.deadLetter(ctx, msg, 1, reasonMalformed, fmt.Errorf("malformed action: %w", err))
+	}
+
+	slog.Info("processing action",
+		slog.String("pod", action.Pod),
+		slog.String("action", string(action.Action)),
+		slog.String("revision", action.Revision),
+		slog.String("replica", action.Replica),
+		slog.String("reason", action.Reason),
+	)
+
+	if action.Action != types.ActionStop {
+		return true
+	}
+
+	backoff := a.backoff
+	for attempt := 1; ; attempt++ {
+		err := a.notifications.NotifyStop(ctx, action)
+		if err == nil {
+			return true
+		}
+		// there is nothing to notify about
+		if errors.Is(err, service.ErrNoUsage) {
+			slog.Warn("skipped stop notification",
+				slog.String("tenant", action.Tenant),
+				slog.String("pod", action.Pod),
+				slog.String("error", err.Error()))
+			return true
+		}
+		if permanent(err) {
+			return a.deadLetter(ctx, msg, attempt, reasonPermanent, err)
+		}
+		if attempt >= maxAttempts {
+			return a.deadLetter(ctx, msg, attempt, reasonExhausted, err)
+		}
+
+		slog.Error("failed to send stop notification, retrying",
+			slog.String("tenant", action.Tenant),
+			slog.String("pod", action.Pod),
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()))
+
+		if !sleep(ctx, backoff) {
+			return false
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// permanent tells the failures that the same action fails with again until
+// the data is fixed: a missing exchange rate, a tariff without prices or in
+// another currency.
+func permanent(err error) bool {
+	return errors.Is(err, service.ErrNoFXRate) ||
+		errors.Is(err, service.ErrNoTariffPrices) ||
+		errors.Is(err, money.ErrCurrencyMismatch)
+}
+
+// deadLetter writes the action to the dead letter topic with the error, so
+// its offset can be committed, and counts it by reason. The write is retried
+// until it succeeds, the action must not be lost. It returns false when the
+// consumer stopped first.
+func (a *Actions) deadLetter(ctx context.Context, msg gokafka.Message, attempts int, reason string, cause error) bool {
+	dead := gokafka.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: append(msg.Headers,
+			gokafka.Header{Key: headerError, Value: []byte(cause.Error())},
+			gokafka.Header{Key: headerAttempts, Value: []byte(strconv.Itoa(attempts))}),
+	}
+
+	backoff := a.backoff
+	for {
+		err := a.deadLetters.WriteMessages(ctx, dead)
+		if err == nil {
+			observability.DeadLettered(msg.Topic, reason)
+			slog.Error("gave up on action, moved to the dead letter topic",
+				slog.Int("partition", msg.Partition),
+				slog.Int64("offset", msg.Offset),
+				slog.Int("attempts", attempts),
+				slog.String("reason", reason),
+				slog.String("error", cause.Error()))
+			return true
+		}
+
+		slog.Error("failed to write dead letter, retrying",
+			slog.Int64("offset", msg.Offset),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()))
+
+		if !sleep(ctx, backoff) {
+			return false
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// sleep waits for d, false if the context is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,143 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/types"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// fakeNotifications fails the first failures calls of NotifyStop with err.
+type fakeNotifications struct {
+	service.Notification
+	err      error
+	failures int
+	calls    int
+}
+
+func (f *fakeNotifications) NotifyStop(context.Context, types.Action) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+// fakePublisher fails the first failures writes.
+type fakePublisher struct {
+	failures int
+	msgs     []gokafka.Message
+}
+
+func (f *fakePublisher) WriteMessages(_ context.Context, msgs ...gokafka.Message) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("broker not available")
+	}
+	f.msgs = append(f.msgs, msgs...)
+	return nil
+}
+
+func stopMessage(t *testing.T) gokafka.Message {
+	t.Helper()
+
+	value, err := json.Marshal(types.Action{Tenant: "alice", Pod: "hello", Action: types.ActionStop})
+	require.NoError(t, err)
+	return gokafka.Message{Key: []byte("alice"), Value: value, Offset: 42}
+}
+
+func header(msg gokafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func Test_ProcessRetriesTransientErrors(t *testing.T) {
+	notifications := &fakeNotifications{err: errors.New("connection refused"), failures: 3}
+	dead := &fakePublisher{}
+	a := NewActions(nil, notifications, dead)
+	a.backoff = time.Millisecond
+
+	assert.True(t, a.process(context.Background(), stopMessage(t)))
+	assert.Equal(t, 4, notifications.calls)
+	assert.Empty(t, dead.msgs)
+}
+
+func Test_ProcessDeadLetters(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{name: "transient error keeps failing", err: errors.New("connection refused"), calls: maxAttempts},
+		{name: "currency mismatch", err: fmt.Errorf("tariff 1: %w", money.ErrCurrencyMismatch), calls: 1},
+		{name: "no exchange rate", err: fmt.Errorf("%w: USD to EUR", service.ErrNoFXRate), calls: 1},
+		{name: "no tariff prices", err: fmt.Errorf("%w: tariff 7", service.ErrNoTariffPrices), calls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifications := &fakeNotifications{err: tt.err, failures: maxAttempts + 1}
+			// the dead letter write is retried until it goes through
+			dead := &fakePublisher{failures: 2}
+			a := NewActions(nil, notifications, dead)
+			a.backoff = time.Millisecond
+			msg := stopMessage(t)
+
+			assert.True(t, a.process(context.Background(), msg), "the action is done with")
+			assert.Equal(t, tt.calls, notifications.calls)
+			require.Len(t, dead.msgs, 1)
+			assert.Equal(t, msg.Value, dead.msgs[0].Value)
+			assert.Equal(t, msg.Key, dead.msgs[0].Key)
+			assert.Equal(t, tt.err.Error(), header(dead.msgs[0], headerError))
+			assert.Equal(t, fmt.Sprint(tt.calls), header(dead.msgs[0], headerAttempts))
+		})
+	}
+}
+
+func Test_ProcessDeadLettersMalformedActions(t *testing.T) {
+	notifications := &fakeNotifications{}
+	dead := &fakePublisher{}
+	a := NewActions(nil, notifications, dead)
+	msg := gokafka.Message{Key: []byte("alice"), Value: []byte(`{"tenant":`), Offset: 7}
+
+	assert.True(t, a.process(context.Background(), msg), "the message is done with")
+	assert.Zero(t, notifications.calls)
+	require.Len(t, dead.msgs, 1)
+	assert.Equal(t, msg.Value, dead.msgs[0].Value)
+	assert.Contains(t, header(dead.msgs[0], headerError), "malformed action")
+	assert.Equal(t, "1", header(dead.msgs[0], headerAttempts))
+}
+
+func Test_ProcessSkipsActionsWithoutUsage(t *testing.T) {
+	notifications := &fakeNotifications{err: service.ErrNoUsage, failures: 1}
+	dead := &fakePublisher{}
+	a := NewActions(nil, notifications, dead)
+
+	assert.True(t, a.process(context.Background(), stopMessage(t)))
+	assert.Equal(t, 1, notifications.calls)
+	assert.Empty(t, dead.msgs)
+}
+
+func Test_ProcessStops(t *testing.T) {
+	notifications := &fakeNotifications{err: errors.New("connection refused"), failures: maxAttempts}
+	a := NewActions(nil, notifications, &fakePublisher{})
+	a.backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, a.process(ctx, stopMessage(t)), "the offset is not committed")
+}
@@ -0,0 +1,102 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type billingRoutes struct {
+	billingService service.Billing
+}
+
+func newBillingRoutes(g *gin.RouterGroup, billingService service.Billing) {
+	slog.Debug("component", slog.String("name", "billing routes"))
+
+	r := &billingRoutes{
+		billingService: billingService,
+	}
+
+	g.GET("/:tenant_id", r.getDraft)
+	g.POST("/:tenant_id/recalc", r.recalc)
+}
+
+type recalcRequest struct {
+	PeriodStart   time.Time `json:"period_start" binding:"required"`
+	TariffID      int       `json:"tariff_id"`
+	TariffVersion int       `json:"tariff_version"`
+	// Mode is dry_run, credit_note, debit_note or correct, a dry run if empty
+	Mode string `json:"mode"`
+}
+
+// getDraft returns the usage not billed by any issued invoice yet.
+func (r *billingRoutes) getDraft(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	if tenantID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id is required"})
+		return
+	}
+
+	invoice, err := r.billingService.Draft(c, tenantID)
+	if err != nil {
+		if errors.Is(err, service.ErrNoUsage) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no billing data found for tenant"})
+			return
+		}
+		if errors.Is(err, service.ErrNoFXRate) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+
+		slog.Error("failed to build invoice", slog.String("tenant", tenantID), slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build invoice"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// recalc bills an issued invoice of the tenant again and returns how it
+// differs, settling the difference unless it is a dry run.
+func (r *billingRoutes) recalc(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+
+	var req recalcRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rc, err := r.billingService.Recalc(c, &service.RecalcInput{
+		Tenant:        tenantID,
+		PeriodStart:   req.PeriodStart,
+		TariffID:      req.TariffID,
+		TariffVersion: req.TariffVersion,
+		Mode:          req.Mode,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRecalc):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvoiceNotFound), errors.Is(err, service.ErrNoUsage):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrNothingToCredit), errors.Is(err, service.ErrNothingToDebit),
+			errors.Is(err, service.ErrInvoiceCredited),
+			errors.Is(err, service.ErrInvoiceNotOpen):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrNoFXRate), errors.Is(err, money.ErrCurrencyMismatch):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			slog.Error("failed to recalculate invoice", slog.String("tenant", tenantID), slog.String("error", err.Error()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to recalculate invoice"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, rc)
+}
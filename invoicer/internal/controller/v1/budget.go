@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type budgetRoutes struct {
+	budgetService service.Budget
+}
+
+func newBudgetRoutes(g *gin.RouterGroup, budgetService service.Budget) {
+	slog.Debug("component", slog.String("name", "budget routes"))
+
+	r := &budgetRoutes{
+		budgetService: budgetService,
+	}
+
+	g.POST("", r.createBudget)
+	g.GET("/:tenant", r.getBudget)
+	g.PATCH("/:tenant", r.updateBudget)
+	g.DELETE("/:tenant", r.deleteBudget)
+}
+
+type createBudgetRequest struct {
+	Tenant     string         `json:"tenant" binding:"required"`
+	Amount     *money.Decimal `json:"amount" binding:"required"`
+	Currency   string         `json:"currency"`
+	Thresholds []int          `json:"thresholds"`
+	HardCap    bool           `json:"hard_cap"`
+}
+
+type updateBudgetRequest struct {
+	Amount     *money.Decimal `json:"amount"`
+	Thresholds []int          `json:"thresholds"`
+	HardCap    *bool          `json:"hard_cap"`
+}
+
+func (r *budgetRoutes) createBudget(c *gin.Context) {
+	var req createBudgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	budget, err := r.budgetService.Create(c, req.Tenant, &service.BudgetInput{
+		Amount:     req.Amount,
+		Currency:   req.Currency,
+		Thresholds: req.Thresholds,
+		HardCap:    &req.HardCap,
+	})
+	if err != nil {
+		r.error(c, req.Tenant, "failed to create budget", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, budget)
+}
+
+// getBudget returns the budget with what the current month cost so far.
+func (r *budgetRoutes) getBudget(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	status, err := r.budgetService.GetStatus(c, tenant)
+	if err != nil {
+		r.error(c, tenant, "failed to get budget", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (r *budgetRoutes) updateBudget(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	var req updateBudgetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	budget, err := r.budgetService.Update(c, tenant, &service.BudgetInput{
+		Amount:     req.Amount,
+		Thresholds: req.Thresholds,
+		HardCap:    req.HardCap,
+	})
+	if err != nil {
+		r.error(c, tenant, "failed to update budget", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, budget)
+}
+
+func (r *budgetRoutes) deleteBudget(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	if err := r.budgetService.Delete(c, tenant); err != nil {
+		r.error(c, tenant, "failed to delete budget", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (r *budgetRoutes) error(c *gin.Context, tenant, msg string, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidBudget):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrBudgetNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrBudgetExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNoFXRate):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		slog.Error(msg, slog.String("tenant", tenant), slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
@@ -0,0 +1,101 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type fxRoutes struct {
+	fxService service.FX
+}
+
+func newFXRoutes(g *gin.RouterGroup, fxService service.FX) {
+	slog.Debug("component", slog.String("name", "fx routes"))
+
+	r := &fxRoutes{
+		fxService: fxService,
+	}
+
+	g.GET("", r.getRates)
+	g.PUT("", r.saveRates)
+	g.POST("/import", r.importRates)
+}
+
+type fxRateRequest struct {
+	Currency string        `json:"currency" binding:"required"`
+	Rate     money.Decimal `json:"rate" binding:"required"`
+	// Date is YYYY-MM-DD
+	Date string `json:"date" binding:"required"`
+}
+
+type saveRatesRequest struct {
+	Rates []fxRateRequest `json:"rates" binding:"required,dive"`
+}
+
+// getRates lists the rates from the billing currency, of a single currency
+// with ?currency=.
+func (r *fxRoutes) getRates(c *gin.Context) {
+	rates, err := r.fxService.GetRates(c, c.Query("currency"))
+	if err != nil {
+		r.error(c, "failed to get fx rates", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// saveRates stores the rates, replacing the ones of the same currency and
+// date.
+func (r *fxRoutes) saveRates(c *gin.Context) {
+	var req saveRatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	in := make([]service.FXRateInput, 0, len(req.Rates))
+	for _, rate := range req.Rates {
+		date, err := time.Parse(time.DateOnly, rate.Date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+			return
+		}
+		in = append(in, service.FXRateInput{Currency: rate.Currency, Rate: rate.Rate, Date: date})
+	}
+
+	rates, err := r.fxService.SaveRates(c, in)
+	if err != nil {
+		r.error(c, "failed to save fx rates", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// importRates stores the rates of a CSV body with date, currency and rate
+// columns and a header row.
+func (r *fxRoutes) importRates(c *gin.Context) {
+	n, err := r.fxService.Load(c, c.Request.Body)
+	if err != nil {
+		r.error(c, "failed to import fx rates", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imported": n})
+}
+
+func (r *fxRoutes) error(c *gin.Context, msg string, err error) {
+	if errors.Is(err, service.ErrInvalidFXRate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.Error(msg, slog.String("error", err.Error()))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+}
@@ -0,0 +1,163 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/render"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+type invoiceRoutes struct {
+	invoiceService service.Invoice
+}
+
+func newInvoiceRoutes(g *gin.RouterGroup, invoiceService service.Invoice) {
+	slog.Debug("component", slog.String("name", "invoice routes"))
+
+	r := &invoiceRoutes{
+		invoiceService: invoiceService,
+	}
+
+	g.GET("", r.getInvoices)
+	g.GET("/:number", r.getInvoiceByNumber)
+}
+
+type getInvoicesResponse struct {
+	Invoices []entity.InvoiceHeader `json:"invoices"`
+}
+
+func (r *invoiceRoutes) getInvoices(c *gin.Context) {
+	filters, err := buildInvoiceFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invoices, err := r.invoiceService.GetAll(c, filters)
+	if err != nil {
+		slog.Error("failed to get invoices", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invoices"})
+		return
+	}
+	if invoices == nil {
+		invoices = []entity.InvoiceHeader{}
+	}
+
+	c.JSON(http.StatusOK, getInvoicesResponse{Invoices: invoices})
+}
+
+// documentTypes are the rendered forms of an invoice, picked by the
+// extension of the number: /v1/invoices/7.pdf.
+var documentTypes = map[string]string{
+	render.FormatPDF:  "application/pdf",
+	render.FormatHTML: "text/html; charset=utf-8",
+}
+
+func (r *invoiceRoutes) getInvoiceByNumber(c *gin.Context) {
+	param, format := c.Param("number"), ""
+	if i := strings.LastIndexByte(param, '.'); i >= 0 {
+		param, format = param[:i], param[i+1:]
+		if _, ok := documentTypes[format]; !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown document format, expected pdf or html"})
+			return
+		}
+	}
+
+	number, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || number <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invoice number"})
+		return
+	}
+
+	if format != "" {
+		r.getDocument(c, number, format)
+		return
+	}
+
+	invoice, err := r.invoiceService.GetByNumber(c, number)
+	if err != nil {
+		r.error(c, number, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+func (r *invoiceRoutes) getDocument(c *gin.Context, number int64, format string) {
+	doc, err := r.invoiceService.Document(c, number, format)
+	if err != nil {
+		r.error(c, number, err)
+		return
+	}
+
+	if format == render.FormatPDF {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%d.pdf"`, number))
+	}
+	c.Data(http.StatusOK, documentTypes[format], doc)
+}
+
+func (r *invoiceRoutes) error(c *gin.Context, number int64, err error) {
+	if errors.Is(err, service.ErrInvoiceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.Error("failed to get invoice", slog.Int64("number", number), slog.String("error", err.Error()))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invoice"})
+}
+
+// periodLayouts are the accepted forms of the period query parameter, each
+// selecting invoices whose period starts within that month, day or hour.
+var periodLayouts = []struct {
+	layout string
+	next   func(time.Time) time.Time
+}{
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01-02T15", func(t time.Time) time.Time { return t.Add(time.Hour) }},
+}
+
+func buildInvoiceFilters(c *gin.Context) (*entity.InvoiceFilters, error) {
+	limit, err := strconv.ParseUint(c.DefaultQuery("limit", "10"), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid limit parameter")
+	}
+	offset, err := strconv.ParseUint(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid offset parameter")
+	}
+
+	filters := &entity.InvoiceFilters{
+		Tenant: c.Query("tenant"),
+		Status: c.Query("status"),
+		Limit:  limit,
+		Offset: offset,
+	}
+	switch filters.Status {
+	case "", entity.InvoiceStatusIssued, entity.InvoiceStatusPaid, entity.InvoiceStatusOverdue, entity.InvoiceStatusVoid:
+	default:
+		return nil, errors.New("invalid status parameter, expected issued, paid, overdue or void")
+	}
+
+	period := c.Query("period")
+	if period == "" {
+		return filters, nil
+	}
+	for _, l := range periodLayouts {
+		if from, err := time.Parse(l.layout, period); err == nil {
+			to := l.next(from)
+			filters.PeriodFrom, filters.PeriodTo = &from, &to
+			return filters, nil
+		}
+	}
+
+	return nil, errors.New("invalid period parameter, expected YYYY-MM, YYYY-MM-DD or YYYY-MM-DDTHH")
+}
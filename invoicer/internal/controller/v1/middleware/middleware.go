@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/pkg/observability"
+)
+
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+		observability.ObserveHTTP(c.Request.Method, c.FullPath(), c.Writer.Status(), started)
+	}
+}
@@ -0,0 +1,134 @@
+package v1
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+// maxWebhookBody caps what is read of a webhook call.
+const maxWebhookBody = 1 << 20
+
+type paymentRoutes struct {
+	paymentService service.Payment
+}
+
+func newPaymentRoutes(invoices, payments *gin.RouterGroup, paymentService service.Payment) {
+	slog.Debug("component", slog.String("name", "payment routes"))
+
+	r := &paymentRoutes{
+		paymentService: paymentService,
+	}
+
+	invoices.POST("/:number/checkout", r.checkout)
+	invoices.POST("/:number/void", r.void)
+	invoices.GET("/:number/payments", r.getPayments)
+
+	payments.POST("/webhook/:provider", r.webhook)
+}
+
+func (r *paymentRoutes) checkout(c *gin.Context) {
+	number, ok := invoiceNumber(c)
+	if !ok {
+		return
+	}
+
+	checkout, err := r.paymentService.Checkout(c, number, c.Query("provider"))
+	if err != nil {
+		r.error(c, "failed to start checkout", err, slog.Int64("number", number))
+		return
+	}
+
+	c.JSON(http.StatusOK, checkout)
+}
+
+func (r *paymentRoutes) void(c *gin.Context) {
+	number, ok := invoiceNumber(c)
+	if !ok {
+		return
+	}
+
+	h, err := r.paymentService.Void(c, number)
+	if err != nil {
+		r.error(c, "failed to void invoice", err, slog.Int64("number", number))
+		return
+	}
+
+	c.JSON(http.StatusOK, h)
+}
+
+type getPaymentsResponse struct {
+	Payments []entity.Payment `json:"payments"`
+}
+
+func (r *paymentRoutes) getPayments(c *gin.Context) {
+	number, ok := invoiceNumber(c)
+	if !ok {
+		return
+	}
+
+	payments, err := r.paymentService.GetPayments(c, number)
+	if err != nil {
+		r.error(c, "failed to get payments", err, slog.Int64("number", number))
+		return
+	}
+
+	c.JSON(http.StatusOK, getPaymentsResponse{Payments: payments})
+}
+
+type webhookResponse struct {
+	Payment *entity.Payment `json:"payment"`
+	// Recorded is false for a payment delivered before.
+	Recorded bool `json:"recorded"`
+}
+
+func (r *paymentRoutes) webhook(c *gin.Context) {
+	provider := c.Param("provider")
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+
+	payment, recorded, err := r.paymentService.HandleWebhook(c, provider, c.Request.Header, body)
+	if err != nil {
+		r.error(c, "failed to handle payment webhook", err, slog.String("provider", provider))
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookResponse{Payment: payment, Recorded: recorded})
+}
+
+func invoiceNumber(c *gin.Context) (int64, bool) {
+	number, err := strconv.ParseInt(c.Param("number"), 10, 64)
+	if err != nil || number <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invoice number"})
+		return 0, false
+	}
+
+	return number, true
+}
+
+func (r *paymentRoutes) error(c *gin.Context, msg string, err error, attr slog.Attr) {
+	switch {
+	case errors.Is(err, service.ErrInvalidPayment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidWebhook):
+		slog.Warn("rejected payment webhook", attr, slog.String("error", err.Error()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidWebhook.Error()})
+	case errors.Is(err, service.ErrInvoiceNotFound), errors.Is(err, service.ErrPaymentProviderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvoiceNotOpen):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		slog.Error(msg, attr, slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
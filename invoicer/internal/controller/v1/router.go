@@ -0,0 +1,35 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/controller/v1/middleware"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/observability"
+)
+
+func NewRouter(router *gin.Engine, services *service.Services, health *observability.Health) {
+	router.Use(middleware.Metrics())
+
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.GET("/healthz", gin.WrapF(health.Live))
+	router.GET("/readyz", gin.WrapF(health.Ready))
+	router.GET("/metrics", gin.WrapH(observability.Handler()))
+
+	newBillingRoutes(router.Group("/billing"), services.Billing)
+
+	v1 := router.Group("/v1")
+	{
+		invoices := v1.Group("/invoices")
+		newInvoiceRoutes(invoices, services.Invoice)
+		newPaymentRoutes(invoices, v1.Group("/payments"), services.Payment)
+		newUsageRoutes(v1.Group("/usage"), services.Usage, services.Export)
+		newBudgetRoutes(v1.Group("/budgets"), services.Budget)
+		newWalletRoutes(v1.Group("/wallets"), services.Ledger)
+		newTaxRoutes(v1.Group("/billing-profiles"), v1.Group("/tax-rules"), services.Tax)
+		newFXRoutes(v1.Group("/fx-rates"), services.FX)
+	}
+}
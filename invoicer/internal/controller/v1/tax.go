@@ -0,0 +1,221 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type taxRoutes struct {
+	taxService service.Tax
+}
+
+func newTaxRoutes(profiles, rules *gin.RouterGroup, taxService service.Tax) {
+	slog.Debug("component", slog.String("name", "tax routes"))
+
+	r := &taxRoutes{
+		taxService: taxService,
+	}
+
+	profiles.PUT("/:tenant", r.saveProfile)
+	profiles.GET("/:tenant", r.getProfile)
+	profiles.DELETE("/:tenant", r.deleteProfile)
+
+	rules.POST("", r.createRule)
+	rules.GET("", r.getRules)
+	rules.GET("/:id", r.getRule)
+	rules.PATCH("/:id", r.updateRule)
+	rules.DELETE("/:id", r.deleteRule)
+}
+
+type saveProfileRequest struct {
+	LegalName string `json:"legal_name" binding:"required"`
+	Address   string `json:"address" binding:"required"`
+	Country   string `json:"country" binding:"required"`
+	TaxID     string `json:"tax_id"`
+	// Currency is what the tenant is invoiced in, the billing currency if
+	// empty
+	Currency string `json:"currency"`
+}
+
+type createTaxRuleRequest struct {
+	Country       string         `json:"country" binding:"required"`
+	Name          *string        `json:"name" binding:"required"`
+	Rate          *money.Decimal `json:"rate" binding:"required"`
+	ReverseCharge bool           `json:"reverse_charge"`
+	Rounding      string         `json:"rounding"`
+}
+
+type updateTaxRuleRequest struct {
+	Name          *string        `json:"name"`
+	Rate          *money.Decimal `json:"rate"`
+	ReverseCharge *bool          `json:"reverse_charge"`
+	Rounding      *string        `json:"rounding"`
+}
+
+// saveProfile creates the tenant's billing profile or replaces it. Invoices
+// already issued keep the profile they were issued to.
+func (r *taxRoutes) saveProfile(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	var req saveProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := r.taxService.SaveProfile(c, tenant, &service.BillingProfileInput{
+		LegalName: req.LegalName,
+		Address:   req.Address,
+		Country:   req.Country,
+		TaxID:     req.TaxID,
+		Currency:  req.Currency,
+	})
+	if err != nil {
+		r.error(c, "failed to save billing profile", err, slog.String("tenant", tenant))
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (r *taxRoutes) getProfile(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	profile, err := r.taxService.GetProfile(c, tenant)
+	if err != nil {
+		r.error(c, "failed to get billing profile", err, slog.String("tenant", tenant))
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+func (r *taxRoutes) deleteProfile(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	if err := r.taxService.DeleteProfile(c, tenant); err != nil {
+		r.error(c, "failed to delete billing profile", err, slog.String("tenant", tenant))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (r *taxRoutes) createRule(c *gin.Context) {
+	var req createTaxRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := r.taxService.CreateRule(c, &service.TaxRuleInput{
+		Country:       req.Country,
+		Name:          req.Name,
+		Rate:          req.Rate,
+		ReverseCharge: &req.ReverseCharge,
+		Rounding:      &req.Rounding,
+	})
+	if err != nil {
+		r.error(c, "failed to create tax rule", err, slog.String("country", req.Country))
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// getRules lists the tax rules, of a single country with ?country=.
+func (r *taxRoutes) getRules(c *gin.Context) {
+	country := c.Query("country")
+
+	rules, err := r.taxService.GetRules(c, country)
+	if err != nil {
+		r.error(c, "failed to get tax rules", err, slog.String("country", country))
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (r *taxRoutes) getRule(c *gin.Context) {
+	id, ok := ruleID(c)
+	if !ok {
+		return
+	}
+
+	rule, err := r.taxService.GetRule(c, id)
+	if err != nil {
+		r.error(c, "failed to get tax rule", err, slog.Int("id", id))
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (r *taxRoutes) updateRule(c *gin.Context) {
+	id, ok := ruleID(c)
+	if !ok {
+		return
+	}
+
+	var req updateTaxRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := r.taxService.UpdateRule(c, id, &service.TaxRuleInput{
+		Name:          req.Name,
+		Rate:          req.Rate,
+		ReverseCharge: req.ReverseCharge,
+		Rounding:      req.Rounding,
+	})
+	if err != nil {
+		r.error(c, "failed to update tax rule", err, slog.Int("id", id))
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (r *taxRoutes) deleteRule(c *gin.Context) {
+	id, ok := ruleID(c)
+	if !ok {
+		return
+	}
+
+	if err := r.taxService.DeleteRule(c, id); err != nil {
+		r.error(c, "failed to delete tax rule", err, slog.Int("id", id))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func ruleID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tax rule id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (r *taxRoutes) error(c *gin.Context, msg string, err error, attr slog.Attr) {
+	switch {
+	case errors.Is(err, service.ErrInvalidProfile), errors.Is(err, service.ErrInvalidTaxRule):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrProfileNotFound), errors.Is(err, service.ErrTaxRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTaxRuleExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		slog.Error(msg, attr, slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
@@ -0,0 +1,158 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/export"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+type usageRoutes struct {
+	usageService  service.Usage
+	exportService service.Export
+}
+
+func newUsageRoutes(g *gin.RouterGroup, usageService service.Usage, exportService service.Export) {
+	slog.Debug("component", slog.String("name", "usage routes"))
+
+	r := &usageRoutes{
+		usageService:  usageService,
+		exportService: exportService,
+	}
+
+	g.GET("", r.getUsage)
+	g.GET("/export", r.getExport)
+}
+
+func (r *usageRoutes) getUsage(c *gin.Context) {
+	query, err := buildUsageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := r.usageService.Report(c, query)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidUsageQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		slog.Error("failed to get usage", slog.String("tenant", query.Tenant), slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// getExport streams the raw usage of every tenant, or of the tenant in the
+// query, over [from, to) as csv, jsonl or parquet.
+func (r *usageRoutes) getExport(c *gin.Context) {
+	q := &entity.UsageExport{
+		Tenant: c.Query("tenant"),
+		Format: c.DefaultQuery("format", export.FormatCSV),
+	}
+	var err error
+	if q.From, err = time.Parse(time.RFC3339, c.Query("from")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from parameter, expected RFC 3339"})
+		return
+	}
+	if q.To, err = time.Parse(time.RFC3339, c.Query("to")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to parameter, expected RFC 3339"})
+		return
+	}
+
+	w := &exportWriter{c: c, q: q}
+	if err := r.exportService.Export(c, q, w); err != nil {
+		if errors.Is(err, service.ErrInvalidExport) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if w.started {
+			// the status is sent already, the body is cut short
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export usage"})
+		return
+	}
+
+	// an export with no records still has its headers and empty body
+	if !w.started {
+		w.start()
+	}
+}
+
+// exportWriter sends the headers of the export on its first write, so an
+// export failing before any record still gets a JSON error.
+type exportWriter struct {
+	c       *gin.Context
+	q       *entity.UsageExport
+	started bool
+}
+
+func (w *exportWriter) start() {
+	w.started = true
+	w.c.Header("Content-Type", export.ContentType(w.q.Format))
+	w.c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s.%s"`, w.q.From.UTC().Format("20060102T1504Z"), w.q.Format))
+	w.c.Status(http.StatusOK)
+	w.c.Writer.WriteHeaderNow()
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.start()
+	}
+	return w.c.Writer.Write(p)
+}
+
+// buildUsageQuery reads the query string. group_by takes a comma separated
+// list and may be repeated, from and to are RFC 3339 timestamps.
+func buildUsageQuery(c *gin.Context) (*entity.UsageQuery, error) {
+	limit, err := strconv.ParseUint(c.DefaultQuery("limit", "10"), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid limit parameter")
+	}
+	offset, err := strconv.ParseUint(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid offset parameter")
+	}
+
+	q := &entity.UsageQuery{
+		Tenant: c.Query("tenant"),
+		Metric: c.Query("metric"),
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, errors.New("invalid from parameter, expected RFC 3339")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, errors.New("invalid to parameter, expected RFC 3339")
+		}
+	}
+
+	for _, v := range c.QueryArray("group_by") {
+		for g := range strings.SplitSeq(v, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				q.GroupBy = append(q.GroupBy, g)
+			}
+		}
+	}
+
+	return q, nil
+}
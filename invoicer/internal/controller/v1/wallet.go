@@ -0,0 +1,124 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type walletRoutes struct {
+	ledgerService service.Ledger
+}
+
+func newWalletRoutes(g *gin.RouterGroup, ledgerService service.Ledger) {
+	slog.Debug("component", slog.String("name", "wallet routes"))
+
+	r := &walletRoutes{
+		ledgerService: ledgerService,
+	}
+
+	g.GET("/:tenant", r.getWallet)
+	g.GET("/:tenant/transactions", r.getTransactions)
+	g.POST("/:tenant/top-ups", r.post(ledgerService.TopUp))
+	g.POST("/:tenant/refunds", r.post(ledgerService.Refund))
+	g.POST("/:tenant/adjustments", r.post(ledgerService.Adjust))
+}
+
+type postingRequest struct {
+	Amount         *money.Decimal `json:"amount" binding:"required"`
+	IdempotencyKey string         `json:"idempotency_key" binding:"required"`
+	Description    string         `json:"description"`
+}
+
+type getTransactionsResponse struct {
+	Transactions []entity.LedgerTransaction `json:"transactions"`
+}
+
+// getWallet returns the tenant's prepaid balance.
+func (r *walletRoutes) getWallet(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	wallet, err := r.ledgerService.GetWallet(c, tenant)
+	if err != nil {
+		r.error(c, tenant, "failed to get wallet", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wallet)
+}
+
+func (r *walletRoutes) getTransactions(c *gin.Context) {
+	tenant := c.Param("tenant")
+
+	limit, err := strconv.ParseUint(c.DefaultQuery("limit", "10"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit parameter"})
+		return
+	}
+	offset, err := strconv.ParseUint(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset parameter"})
+		return
+	}
+
+	transactions, err := r.ledgerService.GetTransactions(c, tenant, limit, offset)
+	if err != nil {
+		r.error(c, tenant, "failed to get transactions", err)
+		return
+	}
+	if transactions == nil {
+		transactions = []entity.LedgerTransaction{}
+	}
+
+	c.JSON(http.StatusOK, getTransactionsResponse{Transactions: transactions})
+}
+
+// post answers 201 for a new transaction and 200 for a retried one.
+func (r *walletRoutes) post(fn func(ctx context.Context, tenant string, in *service.LedgerInput) (*entity.LedgerTransaction, bool, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := c.Param("tenant")
+
+		var req postingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		t, posted, err := fn(c, tenant, &service.LedgerInput{
+			Amount:         *req.Amount,
+			IdempotencyKey: req.IdempotencyKey,
+			Description:    req.Description,
+		})
+		if err != nil {
+			r.error(c, tenant, "failed to post transaction", err)
+			return
+		}
+
+		status := http.StatusOK
+		if posted {
+			status = http.StatusCreated
+		}
+		c.JSON(status, t)
+	}
+}
+
+func (r *walletRoutes) error(c *gin.Context, tenant, msg string, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidLedgerInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrWalletNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInsufficientBalance), errors.Is(err, service.ErrIdempotencyConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		slog.Error(msg, slog.String("tenant", tenant), slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
@@ -0,0 +1,731 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
+)
+
+// Usage is the aggregated consumption of a single pod (replica) of a function.
+type Usage struct {
+	Function    string
+	Pod         string
+	StartTime   time.Time
+	EndTime     time.Time
+	MemoryMBSec float64
+	CPUSec      float64
+	Requests    float64
+}
+
+// UsageWindow selects the usage rows an invoice covers: rows sampled before
+// End and inserted by Cutoff, minus the rows the previous invoice already
+// billed. Rows that arrive late fall out of one window and into the next.
+type UsageWindow struct {
+	End        time.Time
+	Cutoff     time.Time
+	PrevEnd    time.Time
+	PrevCutoff time.Time
+}
+
+// Contains tells whether a row sampled at ts and inserted at insertedAt is
+// billed in the window. It mirrors the ClickHouse filter.
+func (w UsageWindow) Contains(ts, insertedAt time.Time) bool {
+	if !ts.Before(w.End) || insertedAt.After(w.Cutoff) {
+		return false
+	}
+	return !(ts.Before(w.PrevEnd) && !insertedAt.After(w.PrevCutoff))
+}
+
+// RollupStep is the bucket size of the usage rollup the windows are read from.
+const RollupStep = time.Minute
+
+// Aligned rounds End and Cutoff down to whole rollup steps, Cutoff to the last
+// second before its step. A rollup bucket then lies either wholly inside the
+// window or wholly outside of it, so filtering the buckets keeps exactly the
+// rows Contains keeps.
+func (w UsageWindow) Aligned() UsageWindow {
+	w.End = w.End.Truncate(RollupStep)
+	w.Cutoff = w.Cutoff.Truncate(RollupStep).Add(-time.Second)
+	return w
+}
+
+// Usage report dimensions and metrics, see UsageQuery.
+const (
+	UsageGroupFunction = "function"
+	UsageGroupPod      = "pod"
+	UsageGroupDay      = "day"
+	UsageGroupHour     = "hour"
+
+	UsageMetricMemory   = "mem_mb_sec"
+	UsageMetricCPU      = "cpu_sec"
+	UsageMetricDuration = "duration"
+	UsageMetricRequests = "requests"
+)
+
+// UsageQuery aggregates a tenant's raw usage over [From, To). GroupBy holds
+// the dimensions (function, pod) and at most one granularity (day, hour).
+// Limit and Offset page through series, a series is never split.
+type UsageQuery struct {
+	Tenant  string
+	From    time.Time
+	To      time.Time
+	GroupBy []string
+	Metric  string
+	Limit   uint64
+	Offset  uint64
+}
+
+// Has tells whether the query is grouped by the dimension or granularity.
+func (q *UsageQuery) Has(group string) bool {
+	for _, g := range q.GroupBy {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// UsageRow is the metric of one series in one time bucket. Function and Pod
+// are empty unless the query is grouped by them.
+type UsageRow struct {
+	Function string
+	Pod      string
+	Bucket   time.Time
+	Value    float64
+}
+
+type UsagePoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type UsageSeries struct {
+	Function string       `json:"function,omitempty"`
+	Pod      string       `json:"pod,omitempty"`
+	Total    float64      `json:"total"`
+	Points   []UsagePoint `json:"points"`
+}
+
+// UsageReport is the answer to a UsageQuery, one series per group with a
+// point for every bucket, empty buckets included.
+type UsageReport struct {
+	Tenant      string        `json:"tenant"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Metric      string        `json:"metric"`
+	Unit        string        `json:"unit"`
+	GroupBy     []string      `json:"group_by"`
+	Granularity string        `json:"granularity,omitempty"`
+	Series      []UsageSeries `json:"series"`
+	Limit       uint64        `json:"limit"`
+	Offset      uint64        `json:"offset"`
+}
+
+// UsageExport selects the raw usage exported over [From, To), of every tenant
+// when Tenant is empty.
+type UsageExport struct {
+	Tenant string
+	From   time.Time
+	To     time.Time
+	// Format is one of the formats of the export package.
+	Format string
+}
+
+// UsageRecord is the usage of one pod in one minute, a row of a usage export.
+type UsageRecord struct {
+	Tenant      string    `json:"tenant"`
+	Function    string    `json:"function"`
+	Pod         string    `json:"pod"`
+	Minute      time.Time `json:"minute"`
+	DurationSec float64   `json:"duration_sec"`
+	MemoryMBSec float64   `json:"mem_mb_sec"`
+	CPUSec      float64   `json:"cpu_sec"`
+	Requests    uint64    `json:"requests"`
+}
+
+// DurationSec is the execution time billed for the pod.
+func (u Usage) DurationSec() int64 {
+	return int64(u.EndTime.Sub(u.StartTime).Seconds())
+}
+
+// Tariff is a version of a price_service tariff, in force from EffectiveFrom
+// until the next version. Its free allowances are waived in every invoice,
+// see Adjustment. Tiers replace the flat price of the dimensions they hold.
+type Tariff struct {
+	ID            int              `json:"ID"`
+	Name          string           `json:"Name"`
+	Version       int              `json:"Version"`
+	EffectiveFrom time.Time        `json:"EffectiveFrom"`
+	ExecPrice     money.Decimal    `json:"ExecPrice"`
+	MemPrice      money.Decimal    `json:"MemPrice"`
+	CpuPrice      money.Decimal    `json:"CpuPrice"`
+	RequestPrice  money.Decimal    `json:"RequestPrice"`
+	Currency      string           `json:"Currency"`
+	FreeExecSec   money.Decimal    `json:"FreeExecSec"`
+	FreeMemMBSec  money.Decimal    `json:"FreeMemMBSec"`
+	FreeCPUSec    money.Decimal    `json:"FreeCPUSec"`
+	Tiers         pricing.Schedule `json:"Tiers"`
+}
+
+// Price is how the tariff prices a dimension: its tiers, or else its flat
+// price.
+func (t *Tariff) Price(dimension string) pricing.Price {
+	flat := money.Zero
+	switch dimension {
+	case DimensionExec:
+		flat = t.ExecPrice
+	case DimensionMemory:
+		flat = t.MemPrice
+	case DimensionCPU:
+		flat = t.CpuPrice
+	case DimensionRequests:
+		flat = t.RequestPrice
+	}
+	return t.Tiers.Price(dimension, flat)
+}
+
+// Discount takes Percent off a tenant's usage during [ValidFrom, ValidTo), as
+// returned by price_service. Discounts of a tenant never overlap.
+type Discount struct {
+	ID          int           `json:"ID"`
+	Tenant      string        `json:"Tenant"`
+	Percent     money.Decimal `json:"Percent"`
+	Description string        `json:"Description"`
+	ValidFrom   time.Time     `json:"ValidFrom"`
+	ValidTo     *time.Time    `json:"ValidTo"`
+}
+
+// Credit is a prepaid amount granted in price_service. How much of it is
+// spent is only known to invoicer, from the invoices it issued.
+type Credit struct {
+	ID          int           `json:"ID"`
+	Tenant      string        `json:"Tenant"`
+	Amount      money.Decimal `json:"Amount"`
+	Currency    string        `json:"Currency"`
+	Description string        `json:"Description"`
+	GrantedAt   time.Time     `json:"GrantedAt"`
+	ExpiresAt   *time.Time    `json:"ExpiresAt"`
+}
+
+// Subscription assigns a tariff to a tenant for [ValidFrom, ValidTo), as
+// returned by price_service. A nil ValidTo means it is still active.
+type Subscription struct {
+	ID        int        `json:"ID"`
+	Tenant    string     `json:"Tenant"`
+	TariffID  int        `json:"TariffID"`
+	ValidFrom time.Time  `json:"ValidFrom"`
+	ValidTo   *time.Time `json:"ValidTo"`
+}
+
+// Billing dimensions, each one becomes a Charge on a line item.
+const (
+	DimensionExec     = pricing.DimensionExec
+	DimensionMemory   = pricing.DimensionMemory
+	DimensionCPU      = pricing.DimensionCPU
+	DimensionRequests = pricing.DimensionRequests
+)
+
+// Charge is the cost of one billing dimension under one tariff:
+// Amount = Quantity * UnitPrice. A pod that ran across a plan change gets a
+// set of charges per tariff, covering [From, To). Under a graduated price a
+// charge crossing a tier bound is split in one charge per Tier. Amounts are
+// exact, only the invoice's AmountDue is rounded.
+type Charge struct {
+	Dimension string `json:"dimension"`
+	TariffID  int    `json:"tariff_id"`
+	// TariffVersion is the version of the tariff's prices the charge is
+	// priced with
+	TariffVersion int           `json:"tariff_version"`
+	Tier          int           `json:"tier"`
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	Quantity      money.Decimal `json:"quantity"`
+	Unit          string        `json:"unit"`
+	UnitPrice     money.Decimal `json:"unit_price"`
+	Amount        money.Decimal `json:"amount"`
+}
+
+type Totals struct {
+	DurationSec int64         `json:"duration_sec"`
+	MemoryMBSec float64       `json:"memory_mb_sec"`
+	CPUSec      float64       `json:"cpu_sec"`
+	Requests    float64       `json:"requests"`
+	ExecCost    money.Decimal `json:"exec_cost"`
+	MemoryCost  money.Decimal `json:"memory_cost"`
+	CPUCost     money.Decimal `json:"cpu_cost"`
+	RequestCost money.Decimal `json:"request_cost"`
+	TotalCost   money.Decimal `json:"total_cost"`
+}
+
+func (t *Totals) Add(o Totals) {
+	t.DurationSec += o.DurationSec
+	t.MemoryMBSec += o.MemoryMBSec
+	t.CPUSec += o.CPUSec
+	t.Requests += o.Requests
+	t.ExecCost = t.ExecCost.Add(o.ExecCost)
+	t.MemoryCost = t.MemoryCost.Add(o.MemoryCost)
+	t.CPUCost = t.CPUCost.Add(o.CPUCost)
+	t.RequestCost = t.RequestCost.Add(o.RequestCost)
+	t.TotalCost = t.TotalCost.Add(o.TotalCost)
+}
+
+// AddCharge adds a charge to the cost of its dimension and the total cost.
+func (t *Totals) AddCharge(c Charge) {
+	switch c.Dimension {
+	case DimensionExec:
+		t.ExecCost = t.ExecCost.Add(c.Amount)
+	case DimensionMemory:
+		t.MemoryCost = t.MemoryCost.Add(c.Amount)
+	case DimensionCPU:
+		t.CPUCost = t.CPUCost.Add(c.Amount)
+	case DimensionRequests:
+		t.RequestCost = t.RequestCost.Add(c.Amount)
+	}
+	t.TotalCost = t.TotalCost.Add(c.Amount)
+}
+
+type PodLine struct {
+	Pod       string    `json:"pod"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Charges   []Charge  `json:"charges"`
+	Totals    Totals    `json:"totals"`
+}
+
+type FunctionLine struct {
+	Function string    `json:"function"`
+	Pods     []PodLine `json:"pods"`
+	Totals   Totals    `json:"totals"`
+}
+
+// Adjustment kinds, in the order they are applied.
+const (
+	AdjustmentFreeTier = "free_tier"
+	AdjustmentDiscount = "discount"
+	AdjustmentCredit   = "credit"
+)
+
+// Adjustment is an invoice line that lowers the usage cost: a waived free
+// allowance, a discount or a spent credit. SourceID is the tariff, discount
+// or credit it comes from. Amount is negative.
+type Adjustment struct {
+	Kind        string        `json:"kind"`
+	SourceID    int           `json:"source_id"`
+	Dimension   string        `json:"dimension,omitempty"`
+	Description string        `json:"description"`
+	Quantity    money.Decimal `json:"quantity"`
+	Unit        string        `json:"unit"`
+	Amount      money.Decimal `json:"amount"`
+}
+
+// TariffRef is a tariff applied to the invoice during [From, To).
+type TariffRef struct {
+	ID           int              `json:"id"`
+	Name         string           `json:"name"`
+	Version      int              `json:"version"`
+	ExecPrice    money.Decimal    `json:"exec_price"`
+	MemPrice     money.Decimal    `json:"mem_price"`
+	CpuPrice     money.Decimal    `json:"cpu_price"`
+	RequestPrice money.Decimal    `json:"request_price"`
+	Tiers        pricing.Schedule `json:"tiers,omitempty"`
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+}
+
+// Invoice statuses. Issued invoices are stored and only their status changes
+// afterwards: issued -> overdue once past the due date, issued or overdue ->
+// paid once payments cover the amount due, and issued or overdue -> void.
+const (
+	// InvoiceStatusDraft is the live, not yet billed usage of a tenant.
+	InvoiceStatusDraft   = "draft"
+	InvoiceStatusIssued  = "issued"
+	InvoiceStatusPaid    = "paid"
+	InvoiceStatusOverdue = "overdue"
+	// InvoiceStatusVoid invoices were cancelled, nothing is owed for them.
+	InvoiceStatusVoid = "void"
+)
+
+// Invoice kinds. A credit note gives back part of what an earlier invoice
+// charged, its amounts are negative. A debit note charges what an earlier
+// invoice undercharged, it is paid like an invoice.
+const (
+	InvoiceKindInvoice    = "invoice"
+	InvoiceKindCreditNote = "credit_note"
+	InvoiceKindDebitNote  = "debit_note"
+)
+
+type InvoiceHeader struct {
+	Number      int64       `json:"number,omitempty"`
+	Kind        string      `json:"kind"`
+	Status      string      `json:"status"`
+	TenantID    string      `json:"tenant_id"`
+	PeriodStart time.Time   `json:"period_start"`
+	PeriodEnd   time.Time   `json:"period_end"`
+	Tariffs     []TariffRef `json:"tariffs"`
+	Totals      Totals      `json:"totals"`
+	Currency    string      `json:"currency"`
+	// AdjustmentTotal sums the invoice's adjustments, it is zero or negative.
+	AdjustmentTotal money.Decimal `json:"adjustment_total"`
+	// TaxRate sums the rates of the taxes charged on Totals.TotalCost plus
+	// AdjustmentTotal, Tax sums their rounded amounts.
+	TaxRate money.Decimal `json:"tax_rate"`
+	Tax     money.Decimal `json:"tax"`
+	// AmountDue is Totals.TotalCost plus AdjustmentTotal, rounded by the
+	// billing policy, plus Tax.
+	AmountDue    money.Decimal `json:"amount_due"`
+	CalculatedAt time.Time     `json:"calculated_at"`
+	// Customer is the tenant's billing profile when the invoice was
+	// calculated, nil for tenants without one.
+	Customer *BillingProfile `json:"customer,omitempty"`
+	// FX is the rate the invoice was converted from the tariffs' currency
+	// with, nil for invoices in the billing currency.
+	FX *FXRate `json:"fx,omitempty"`
+	// UsageCutoff is the insertion watermark of the usage billed in the
+	// invoice, zero for drafts.
+	UsageCutoff time.Time `json:"usage_cutoff"`
+	// DueAt is when the invoice must be paid by, nil for drafts and invoices
+	// issued without payment terms.
+	DueAt    *time.Time `json:"due_at,omitempty"`
+	PaidAt   *time.Time `json:"paid_at,omitempty"`
+	VoidedAt *time.Time `json:"voided_at,omitempty"`
+	// DunningLevel counts the payment reminders sent for the invoice.
+	DunningLevel int `json:"dunning_level,omitempty"`
+	// DunnedAt is when the last reminder was sent.
+	DunnedAt *time.Time `json:"dunned_at,omitempty"`
+	// Corrects is the number of the invoice a credit or debit note or a
+	// corrected invoice was issued for.
+	Corrects *int64 `json:"corrects,omitempty"`
+}
+
+type InvoiceFilters struct {
+	Tenant string
+	Status string
+	// PeriodFrom and PeriodTo select invoices whose period starts in [PeriodFrom, PeriodTo)
+	PeriodFrom *time.Time
+	PeriodTo   *time.Time
+	Limit      uint64
+	Offset     uint64
+}
+
+// Invoice is the billing document of a tenant: a header with grand totals,
+// one line per function, broken down per pod, the adjustments and the taxes.
+type Invoice struct {
+	Header      InvoiceHeader  `json:"header"`
+	Functions   []FunctionLine `json:"functions"`
+	Adjustments []Adjustment   `json:"adjustments"`
+	Taxes       []TaxLine      `json:"taxes"`
+}
+
+// Function returns the line of the given function, or nil.
+func (i *Invoice) Function(name string) *FunctionLine {
+	for idx := range i.Functions {
+		if i.Functions[idx].Function == name {
+			return &i.Functions[idx]
+		}
+	}
+	return nil
+}
+
+// Recalculation modes. A dry run only compares, the others also issue a
+// credit note for what the invoice overcharged, a debit note for what it
+// undercharged or a corrected invoice replacing it.
+const (
+	RecalcDryRun     = "dry_run"
+	RecalcCreditNote = "credit_note"
+	RecalcDebitNote  = "debit_note"
+	RecalcCorrect    = "correct"
+)
+
+// Recalc compares an issued invoice with the invoice billing its usage again
+// gives. Issued is the amount due of the invoice with its credit and debit
+// notes. Lines hold only the amounts that changed. Settle is the mode that
+// can settle the delta, empty when there is nothing to settle.
+type Recalc struct {
+	Tenant       string        `json:"tenant"`
+	Invoice      int64         `json:"invoice"`
+	PeriodStart  time.Time     `json:"period_start"`
+	PeriodEnd    time.Time     `json:"period_end"`
+	Currency     string        `json:"currency"`
+	Mode         string        `json:"mode"`
+	CreditNotes  []int64       `json:"credit_notes"`
+	DebitNotes   []int64       `json:"debit_notes"`
+	Issued       money.Decimal `json:"issued"`
+	Recalculated money.Decimal `json:"recalculated"`
+	Delta        money.Decimal `json:"delta"`
+	Lines        []RecalcLine  `json:"lines"`
+	Settle       string        `json:"settle,omitempty"`
+	// Document is the note or corrected invoice issued, nil for dry runs and
+	// invoices that were right.
+	Document *InvoiceHeader `json:"document,omitempty"`
+}
+
+// RecalcLine is an amount of the invoice before and after recalculation.
+// Function is empty for the amounts of the whole invoice.
+type RecalcLine struct {
+	Function     string        `json:"function,omitempty"`
+	Item         string        `json:"item"`
+	Issued       money.Decimal `json:"issued"`
+	Recalculated money.Decimal `json:"recalculated"`
+	Delta        money.Decimal `json:"delta"`
+}
+
+// Payment statuses reported by payment providers.
+const (
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+)
+
+// PaymentProviderWallet is the provider of the payments settled from a
+// prepaid wallet when the ledger debits the invoice.
+const PaymentProviderWallet = "wallet"
+
+// Payment is a payment of an invoice reported by a payment provider.
+// ExternalID is the provider's identifier of the payment, a payment is
+// recorded once however many times the provider reports it.
+type Payment struct {
+	ID            int64         `json:"id"`
+	InvoiceNumber int64         `json:"invoice_number"`
+	Provider      string        `json:"provider"`
+	ExternalID    string        `json:"external_id"`
+	Status        string        `json:"status"`
+	Amount        money.Decimal `json:"amount"`
+	Currency      string        `json:"currency"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// Checkout is where the tenant pays an invoice with a payment provider.
+type Checkout struct {
+	InvoiceNumber int64         `json:"invoice_number"`
+	Provider      string        `json:"provider"`
+	ExternalID    string        `json:"external_id"`
+	URL           string        `json:"url"`
+	Amount        money.Decimal `json:"amount"`
+	Currency      string        `json:"currency"`
+}
+
+// BillingProfile holds the legal details of a tenant. Country is an ISO 3166
+// alpha-2 code and picks the tax rules of the tenant's invoices. Currency is
+// what the tenant is invoiced in, empty for the billing currency.
+type BillingProfile struct {
+	Tenant    string    `json:"tenant"`
+	LegalName string    `json:"legal_name"`
+	Address   string    `json:"address"`
+	Country   string    `json:"country"`
+	TaxID     string    `json:"tax_id,omitempty"`
+	Currency  string    `json:"currency,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FXRate is what one unit of Base is worth in Currency from Date (UTC) until
+// the next rate of the pair.
+type FXRate struct {
+	Base     string        `json:"base"`
+	Currency string        `json:"currency"`
+	Rate     money.Decimal `json:"rate"`
+	Date     time.Time     `json:"date"`
+}
+
+// TaxRule is a tax charged to the tenants of a country, Rate percent of the
+// invoice amount after adjustments. With ReverseCharge, tenants with a tax ID
+// in another country than the seller account for the tax themselves.
+// Rounding overrides the billing rounding of the tax amount.
+type TaxRule struct {
+	ID            int           `json:"id"`
+	Country       string        `json:"country"`
+	Name          string        `json:"name"`
+	Rate          money.Decimal `json:"rate"`
+	ReverseCharge bool          `json:"reverse_charge"`
+	Rounding      string        `json:"rounding,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// TaxLine is a tax charged on an invoice: Rate percent of Base, the amount
+// after adjustments. A reverse-charged line has no amount.
+type TaxLine struct {
+	RuleID        int           `json:"rule_id"`
+	Name          string        `json:"name"`
+	Country       string        `json:"country,omitempty"`
+	Rate          money.Decimal `json:"rate"`
+	Base          money.Decimal `json:"base"`
+	Amount        money.Decimal `json:"amount"`
+	ReverseCharge bool          `json:"reverse_charge"`
+}
+
+// Budget caps what a tenant spends in a calendar month (UTC). Thresholds are
+// percents of Amount, crossing one alerts the tenant once a month. With
+// HardCap the tenant's functions are scaled to zero once the spend reaches
+// Amount.
+type Budget struct {
+	ID         int           `json:"id"`
+	Tenant     string        `json:"tenant"`
+	Amount     money.Decimal `json:"amount"`
+	Currency   string        `json:"currency"`
+	Thresholds []int         `json:"thresholds"`
+	HardCap    bool          `json:"hard_cap"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
+
+// DefaultBudgetThresholds are used when a budget is created without any.
+var DefaultBudgetThresholds = []int{50, 80, 100}
+
+// Budget alert kinds.
+const (
+	BudgetAlertThreshold = "threshold"
+	BudgetAlertHardCap   = "hard_cap"
+)
+
+// BudgetAlert is a threshold a budget crossed in the month starting at
+// PeriodStart. Spend is what the month cost when it was crossed.
+type BudgetAlert struct {
+	BudgetID    int           `json:"budget_id"`
+	Tenant      string        `json:"tenant"`
+	PeriodStart time.Time     `json:"period_start"`
+	Kind        string        `json:"kind"`
+	Threshold   int           `json:"threshold"`
+	Spend       money.Decimal `json:"spend"`
+	Amount      money.Decimal `json:"amount"`
+	// Currency is the budget's, set on the alerts sent to the tenant.
+	Currency  string    `json:"currency,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BudgetStatus is a budget with what the current month cost so far: usage
+// after free allowances and discounts, credits do not count.
+type BudgetStatus struct {
+	Budget      Budget        `json:"budget"`
+	PeriodStart time.Time     `json:"period_start"`
+	Spend       money.Decimal `json:"spend"`
+	Percent     money.Decimal `json:"percent"`
+	Alerts      []BudgetAlert `json:"alerts"`
+}
+
+// Wallet is the prepaid balance of a tenant. Balance goes negative when usage
+// outgrows the top-ups, Accrued is the usage debited but not invoiced yet.
+type Wallet struct {
+	Tenant    string        `json:"tenant"`
+	Currency  string        `json:"currency"`
+	Balance   money.Decimal `json:"balance"`
+	Accrued   money.Decimal `json:"accrued"`
+	Blocked   bool          `json:"blocked"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Ledger transaction kinds.
+const (
+	LedgerTopUp      = "top_up"
+	LedgerUsage      = "usage"
+	LedgerRefund     = "refund"
+	LedgerAdjustment = "adjustment"
+)
+
+// Ledger accounts of a wallet.
+const (
+	AccountWallet      = "wallet"
+	AccountFunding     = "funding"
+	AccountRevenue     = "revenue"
+	AccountAdjustments = "adjustments"
+)
+
+// LedgerTransaction is a balanced set of entries posted together. Amount is
+// the change of the wallet balance and Accrued the change of the usage
+// accrued but not invoiced yet. Usage debits of issued invoices carry the
+// invoice number.
+type LedgerTransaction struct {
+	ID             int64         `json:"id"`
+	Tenant         string        `json:"tenant"`
+	Kind           string        `json:"kind"`
+	IdempotencyKey string        `json:"idempotency_key"`
+	InvoiceNumber  *int64        `json:"invoice_number,omitempty"`
+	Description    string        `json:"description"`
+	Amount         money.Decimal `json:"amount"`
+	Accrued        money.Decimal `json:"accrued"`
+	Entries        []LedgerEntry `json:"entries"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+// LedgerEntry moves Amount into the account, Balance is the balance of the
+// account after it.
+type LedgerEntry struct {
+	Account string        `json:"account"`
+	Amount  money.Decimal `json:"amount"`
+	Balance money.Decimal `json:"balance"`
+}
+
+// BalanceEvent tells control_plane whether a prepaid tenant may start
+// functions: Blocked is set while the wallet balance is negative.
+type BalanceEvent struct {
+	Tenant    string        `json:"tenant"`
+	Balance   money.Decimal `json:"balance"`
+	Currency  string        `json:"currency"`
+	Blocked   bool          `json:"blocked"`
+	Timestamp int64         `json:"timestamp"`
+}
+
+// BudgetCapEvent tells control_plane whether a tenant may start functions:
+// Blocked is set while the spend of a budget with a hard cap is at or over
+// the amount.
+type BudgetCapEvent struct {
+	Tenant    string `json:"tenant"`
+	Blocked   bool   `json:"blocked"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// OutboxMessage is a Kafka message stored in the transaction of the change
+// that caused it and published by the relay afterwards. Attempts counts the
+// failed publishes.
+type OutboxMessage struct {
+	ID        int64           `json:"id"`
+	Topic     string          `json:"topic"`
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ProcessedAction is a pod action that was billed. ID identifies the action
+// however often it is delivered.
+type ProcessedAction struct {
+	ID        string        `json:"id"`
+	Tenant    string        `json:"tenant"`
+	Pod       string        `json:"pod"`
+	Replica   string        `json:"replica"`
+	Action    string        `json:"action"`
+	TotalCost money.Decimal `json:"total_cost"`
+	Currency  string        `json:"currency"`
+}
+
+// Notification kinds. Messages without a kind come from older invoicers and
+// are stop notifications.
+const (
+	NotificationStop    = "stop"
+	NotificationBudget  = "budget"
+	NotificationInvoice = "invoice"
+	NotificationDunning = "dunning"
+)
+
+// Notification costs are exact, the notifier rounds them for display.
+type Notification struct {
+	Kind      string        `json:"kind"`
+	TenantID  string        `json:"tenant_id"`
+	Email     string        `json:"email"`
+	MemoryMB  float64       `json:"memory_mb"`
+	CPUSec    float64       `json:"cpu_sec"`
+	CPUCost   money.Decimal `json:"cpu_cost"`
+	TotalCost money.Decimal `json:"total_cost"`
+	Currency  string        `json:"currency"`
+	Precision int32         `json:"precision"`
+	Rounding  string        `json:"rounding"`
+	PodName   string        `json:"pod_name"`
+	Timestamp int64         `json:"timestamp"`
+	// Budget is set for budget notifications.
+	Budget *BudgetAlert `json:"budget,omitempty"`
+	// Invoice is set for issued invoices, the notifier attaches its PDF, and
+	// for dunning notifications of overdue invoices.
+	Invoice *InvoiceHeader `json:"invoice,omitempty"`
+}
@@ -0,0 +1,190 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Usage export formats.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+var contentTypes = map[string]string{
+	FormatCSV:     "text/csv; charset=utf-8",
+	FormatJSONL:   "application/x-ndjson",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+// ContentType is the media type of the format, empty for unknown formats.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Writer encodes usage records one by one. Close completes the file, it
+// does not close the underlying writer.
+type Writer interface {
+	Write(rec entity.UsageRecord) error
+	Close() error
+}
+
+// NewWriter returns a writer of the format over w. Records are buffered a
+// batch at a time, never the whole export.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatJSONL:
+		return newJSONLWriter(w), nil
+	case FormatParquet:
+		return newParquetWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+var csvHeader = []string{"tenant", "function", "pod", "minute", "duration_sec", "mem_mb_sec", "cpu_sec", "requests"}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return nil, err
+	}
+
+	return &csvWriter{
+		w:      cw,
+		record: make([]string, len(csvHeader)),
+	}, nil
+}
+
+func (c *csvWriter) Write(rec entity.UsageRecord) error {
+	c.record[0] = rec.Tenant
+	c.record[1] = rec.Function
+	c.record[2] = rec.Pod
+	c.record[3] = rec.Minute.UTC().Format(time.RFC3339)
+	c.record[4] = formatFloat(rec.DurationSec)
+	c.record[5] = formatFloat(rec.MemoryMBSec)
+	c.record[6] = formatFloat(rec.CPUSec)
+	c.record[7] = strconv.FormatUint(rec.Requests, 10)
+
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type jsonlWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	buf := bufio.NewWriter(w)
+
+	return &jsonlWriter{
+		buf: buf,
+		enc: json.NewEncoder(buf),
+	}
+}
+
+func (j *jsonlWriter) Write(rec entity.UsageRecord) error {
+	rec.Minute = rec.Minute.UTC()
+	return j.enc.Encode(rec)
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.buf.Flush()
+}
+
+// parquetRecord is the parquet schema of a usage record.
+type parquetRecord struct {
+	Tenant      string    `parquet:"tenant,dict"`
+	Function    string    `parquet:"function,dict"`
+	Pod         string    `parquet:"pod,dict"`
+	Minute      time.Time `parquet:"minute,timestamp(millisecond)"`
+	DurationSec float64   `parquet:"duration_sec"`
+	MemoryMBSec float64   `parquet:"mem_mb_sec"`
+	CPUSec      float64   `parquet:"cpu_sec"`
+	Requests    uint64    `parquet:"requests"`
+}
+
+const (
+	parquetBatch = 1024
+	// parquetRowGroup bounds the rows held in memory before a row group is
+	// flushed to the output.
+	parquetRowGroup = 128 * 1024
+)
+
+type parquetWriter struct {
+	w     *parquet.GenericWriter[parquetRecord]
+	batch []parquetRecord
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w: parquet.NewGenericWriter[parquetRecord](w,
+			parquet.Compression(&parquet.Zstd),
+			parquet.MaxRowsPerRowGroup(parquetRowGroup)),
+		batch: make([]parquetRecord, 0, parquetBatch),
+	}
+}
+
+func (p *parquetWriter) Write(rec entity.UsageRecord) error {
+	p.batch = append(p.batch, parquetRecord{
+		Tenant:      rec.Tenant,
+		Function:    rec.Function,
+		Pod:         rec.Pod,
+		Minute:      rec.Minute.UTC(),
+		DurationSec: rec.DurationSec,
+		MemoryMBSec: rec.MemoryMBSec,
+		CPUSec:      rec.CPUSec,
+		Requests:    rec.Requests,
+	})
+	if len(p.batch) < parquetBatch {
+		return nil
+	}
+
+	return p.flush()
+}
+
+func (p *parquetWriter) flush() error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+	if _, err := p.w.Write(p.batch); err != nil {
+		return err
+	}
+	p.batch = p.batch[:0]
+
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+
+	return p.w.Close()
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+)
+
+func testRecords(n int) []entity.UsageRecord {
+	minute := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	recs := make([]entity.UsageRecord, n)
+	for i := range recs {
+		recs[i] = entity.UsageRecord{
+			Tenant:      "alice",
+			Function:    "hello",
+			Pod:         "hello-1",
+			Minute:      minute.Add(time.Duration(i) * time.Minute),
+			DurationSec: 60,
+			MemoryMBSec: 1536.5,
+			CPUSec:      float64(i) / 4,
+			Requests:    uint64(i),
+		}
+	}
+	return recs
+}
+
+func write(t *testing.T, format string, recs []entity.UsageRecord) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	require.NoError(t, err)
+	for _, rec := range recs {
+		require.NoError(t, w.Write(rec))
+	}
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func Test_CSV(t *testing.T) {
+	out := write(t, FormatCSV, testRecords(2))
+
+	assert.Equal(t, "tenant,function,pod,minute,duration_sec,mem_mb_sec,cpu_sec,requests\n"+
+		"alice,hello,hello-1,2025-10-01T00:00:00Z,60,1536.5,0,0\n"+
+		"alice,hello,hello-1,2025-10-01T00:01:00Z,60,1536.5,0.25,1\n", string(out))
+}
+
+func Test_JSONL(t *testing.T) {
+	recs := testRecords(3)
+	out := write(t, FormatJSONL, recs)
+
+	var got []entity.UsageRecord
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		var rec entity.UsageRecord
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &rec))
+		got = append(got, rec)
+	}
+	assert.Equal(t, recs, got)
+}
+
+func Test_Parquet(t *testing.T) {
+	// more than a batch, so some records go out before Close
+	recs := testRecords(parquetBatch + 10)
+	out := write(t, FormatParquet, recs)
+
+	rows, err := parquet.Read[parquetRecord](bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+	require.Len(t, rows, len(recs))
+	for i, row := range rows {
+		assert.Equal(t, recs[i].Tenant, row.Tenant)
+		assert.Equal(t, recs[i].Pod, row.Pod)
+		assert.True(t, recs[i].Minute.Equal(row.Minute), "minute %d", i)
+		assert.Equal(t, recs[i].CPUSec, row.CPUSec)
+		assert.Equal(t, recs[i].Requests, row.Requests)
+	}
+}
+
+func Test_EmptyExport(t *testing.T) {
+	assert.Equal(t, "tenant,function,pod,minute,duration_sec,mem_mb_sec,cpu_sec,requests\n", string(write(t, FormatCSV, nil)))
+	assert.Empty(t, write(t, FormatJSONL, nil))
+
+	out := write(t, FormatParquet, nil)
+	rows, err := parquet.Read[parquetRecord](bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func Test_UnknownFormat(t *testing.T) {
+	_, err := NewWriter("xlsx", &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.Empty(t, ContentType("xlsx"))
+}
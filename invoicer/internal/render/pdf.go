@@ -0,0 +1,180 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Column widths of the line items on an A4 page with 15 mm margins.
+const (
+	colDescription = 84.0
+	colQuantity    = 36.0
+	colUnitPrice   = 30.0
+	colAmount      = 30.0
+	rowHeight      = 6.0
+)
+
+// writePDF lays out the same document as the HTML page with the core
+// Helvetica font, so no font files are needed.
+func writePDF(w io.Writer, doc document) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.SetTitle(doc.Brand.Name+" - "+doc.Title, true)
+	pdf.SetAuthor(doc.Brand.Name, true)
+	pdf.SetCreator(doc.Brand.Name, true)
+	pdf.SetCreationDate(doc.calculatedAt)
+	pdf.SetModificationDate(doc.calculatedAt)
+	// the same invoice renders to the same file
+	pdf.SetCatalogSort(true)
+	pdf.AliasNbPages("")
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	r, g, b, _ := parseColor(doc.Brand.Color)
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(87, 96, 106)
+		pdf.CellFormat(90, 5, tr(doc.Brand.Name+" - "+doc.Title), "", 0, "L", false, 0, "")
+		pdf.CellFormat(90, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	// issuer
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.SetTextColor(r, g, b)
+	pdf.CellFormat(90, 10, tr(doc.Brand.Name), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(87, 96, 106)
+	issuer := strings.TrimSpace(doc.Brand.Address + "\n" + doc.Brand.Email)
+	pdf.MultiCell(90, 4.5, tr(issuer), "", "R", false)
+	pdf.SetY(32)
+	pdf.SetDrawColor(r, g, b)
+	pdf.SetLineWidth(0.8)
+	pdf.Line(15, pdf.GetY(), 195, pdf.GetY())
+	pdf.Ln(6)
+
+	// title and details
+	pdf.SetTextColor(36, 41, 47)
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 9, tr(doc.Title), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	meta := make([][2]string, 0, len(doc.BilledTo)+4)
+	for i, l := range doc.BilledTo {
+		label := ""
+		if i == 0 {
+			label = "Billed to"
+		}
+		meta = append(meta, [2]string{label, l})
+	}
+	for _, m := range append(meta, [][2]string{
+		{"Period", doc.Period},
+		{"Calculated at", doc.CalculatedAt},
+		{"Due", doc.DueAt},
+		{"Status", doc.Status},
+		{"Currency", doc.Currency},
+		{"Exchange rate", doc.FX},
+	}...) {
+		if m[1] == "" {
+			continue
+		}
+		pdf.SetTextColor(87, 96, 106)
+		pdf.CellFormat(30, 5.5, tr(m[0]), "", 0, "L", false, 0, "")
+		pdf.SetTextColor(36, 41, 47)
+		pdf.CellFormat(0, 5.5, tr(m[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// line items
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFillColor(r, g, b)
+		pdf.SetTextColor(255, 255, 255)
+		pdf.CellFormat(colDescription, 7, "Description", "", 0, "L", true, 0, "")
+		pdf.CellFormat(colQuantity, 7, "Quantity", "", 0, "R", true, 0, "")
+		pdf.CellFormat(colUnitPrice, 7, "Unit price", "", 0, "R", true, 0, "")
+		pdf.CellFormat(colAmount, 7, "Amount", "", 1, "R", true, 0, "")
+		pdf.SetTextColor(36, 41, 47)
+		pdf.SetDrawColor(208, 215, 222)
+		pdf.SetLineWidth(0.2)
+	}
+	group := func(title, total string) {
+		if pdf.GetY()+2*rowHeight > 277 {
+			pdf.AddPage()
+			header()
+		}
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFillColor(246, 248, 250)
+		pdf.CellFormat(colDescription+colQuantity+colUnitPrice, rowHeight, fit(pdf, tr(title), colDescription+colQuantity+colUnitPrice), "B", 0, "L", true, 0, "")
+		pdf.CellFormat(colAmount, rowHeight, total, "B", 1, "R", true, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+	}
+	item := func(l line) {
+		pdf.CellFormat(colDescription, rowHeight, fit(pdf, "    "+tr(l.Description), colDescription), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(colQuantity, rowHeight, fit(pdf, tr(l.Quantity), colQuantity), "B", 0, "R", false, 0, "")
+		pdf.CellFormat(colUnitPrice, rowHeight, fit(pdf, l.UnitPrice, colUnitPrice), "B", 0, "R", false, 0, "")
+		pdf.CellFormat(colAmount, rowHeight, l.Amount, "B", 1, "R", false, 0, "")
+	}
+
+	header()
+	for _, fn := range doc.Functions {
+		group(fn.Function, fn.Total)
+		for _, l := range fn.Lines {
+			item(l)
+		}
+	}
+	if len(doc.Adjustments) > 0 {
+		group("Adjustments", "")
+		for _, l := range doc.Adjustments {
+			item(l)
+		}
+	}
+	pdf.Ln(6)
+
+	// totals
+	total := func(label, value string, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("Helvetica", style, 10)
+		pdf.SetX(15 + colDescription + colQuantity - 20)
+		pdf.CellFormat(colUnitPrice+20, rowHeight+1, tr(label), "", 0, "L", false, 0, "")
+		pdf.CellFormat(colAmount, rowHeight+1, value+" "+doc.Currency, "", 1, "R", false, 0, "")
+	}
+	total("Subtotal", doc.Subtotal, false)
+	if doc.AdjustmentTotal != "" {
+		total("Adjustments", doc.AdjustmentTotal, false)
+	}
+	for _, t := range doc.Taxes {
+		total(t.Description, t.Amount, false)
+	}
+	pdf.SetDrawColor(r, g, b)
+	pdf.SetLineWidth(0.6)
+	pdf.Line(15+colDescription+colQuantity-20, pdf.GetY(), 195, pdf.GetY())
+	total("Amount due", doc.AmountDue, true)
+	if doc.Note != "" {
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetTextColor(87, 96, 106)
+		pdf.MultiCell(0, 5, tr(doc.Note), "", "L", false)
+	}
+
+	return pdf.Output(w)
+}
+
+// fit cuts text that does not fit the column.
+func fit(pdf *fpdf.Fpdf, text string, width float64) string {
+	width -= 2 * pdf.GetCellMargin()
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	for len(text) > 0 && pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
@@ -0,0 +1,346 @@
+package render
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// Document formats.
+const (
+	FormatHTML = "html"
+	FormatPDF  = "pdf"
+)
+
+// Brand is the issuer printed on every document.
+type Brand struct {
+	Name    string
+	Address string
+	Email   string
+	// Color is the accent color as hex RGB, e.g. #1f6feb.
+	Color string
+}
+
+const defaultColor = "#1f6feb"
+
+//go:embed templates/invoice.html
+var templates embed.FS
+
+var page = template.Must(template.ParseFS(templates, "templates/invoice.html"))
+
+// Renderer turns invoices into documents for humans: an HTML page and a PDF
+// with the same content.
+type Renderer struct {
+	brand Brand
+	money money.Policy
+}
+
+func New(brand Brand, policy money.Policy) *Renderer {
+	slog.Debug("component", slog.String("name", "invoice renderer"))
+
+	if _, _, _, ok := parseColor(brand.Color); !ok {
+		brand.Color = defaultColor
+	}
+
+	return &Renderer{
+		brand: brand,
+		money: policy,
+	}
+}
+
+// Render writes the invoice in the given format.
+func (r *Renderer) Render(inv *entity.Invoice, format string) ([]byte, error) {
+	doc := r.document(inv)
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatHTML:
+		if err := page.Execute(&buf, doc); err != nil {
+			return nil, err
+		}
+	case FormatPDF:
+		if err := writePDF(&buf, doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported document format %q", format)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// document is an invoice with every value formatted for display.
+type document struct {
+	Brand  Brand
+	Title  string
+	Status string
+	// BilledTo is the customer's legal name, address and tax ID followed by
+	// the tenant, or only the tenant without a billing profile.
+	BilledTo     []string
+	Period       string
+	CalculatedAt string
+	// DueAt is empty for invoices without payment terms.
+	DueAt    string
+	Currency string
+	// FX is the rate the invoice was converted with, empty for invoices in
+	// the billing currency.
+	FX              string
+	Functions       []functionLines
+	Adjustments     []line
+	Subtotal        string
+	AdjustmentTotal string
+	Taxes           []line
+	// Note tells the customer to account for reverse-charged taxes, empty
+	// without any.
+	Note      string
+	AmountDue string
+
+	calculatedAt time.Time
+}
+
+type functionLines struct {
+	Function string
+	Lines    []line
+	Total    string
+}
+
+type line struct {
+	Description string
+	Quantity    string
+	UnitPrice   string
+	Amount      string
+}
+
+const timeLayout = "2006-01-02 15:04 UTC"
+
+const reverseChargeNote = "Reverse charge: the customer accounts for the tax marked as reverse charge."
+
+func (r *Renderer) document(inv *entity.Invoice) document {
+	h := inv.Header
+	policy := r.policy(h.Currency)
+	amount := func(d money.Decimal) string {
+		return policy.Rounding.Round(d, policy.Precision).StringFixed(policy.Precision)
+	}
+
+	doc := document{
+		Brand:        r.brand,
+		Title:        "Draft invoice",
+		Status:       h.Status,
+		BilledTo:     billedTo(h),
+		Period:       h.PeriodStart.UTC().Format(timeLayout) + " - " + h.PeriodEnd.UTC().Format(timeLayout),
+		CalculatedAt: h.CalculatedAt.UTC().Format(timeLayout),
+		Currency:     policy.Currency.Code,
+		Subtotal:     amount(h.Totals.TotalCost),
+		AmountDue:    amount(h.AmountDue),
+		calculatedAt: h.CalculatedAt,
+	}
+	if h.DueAt != nil {
+		doc.DueAt = h.DueAt.UTC().Format(timeLayout)
+	}
+	if h.FX != nil {
+		doc.FX = fmt.Sprintf("1 %s = %s %s (%s)", h.FX.Base, h.FX.Rate, h.FX.Currency, h.FX.Date.UTC().Format(time.DateOnly))
+	}
+	switch {
+	case h.Kind == entity.InvoiceKindCreditNote && h.Corrects != nil:
+		doc.Title = fmt.Sprintf("Credit note #%d for invoice #%d", h.Number, *h.Corrects)
+	case h.Kind == entity.InvoiceKindDebitNote && h.Corrects != nil:
+		doc.Title = fmt.Sprintf("Debit note #%d for invoice #%d", h.Number, *h.Corrects)
+	case h.Number > 0 && h.Corrects != nil:
+		doc.Title = fmt.Sprintf("Invoice #%d replacing invoice #%d", h.Number, *h.Corrects)
+	case h.Number > 0:
+		doc.Title = fmt.Sprintf("Invoice #%d", h.Number)
+	}
+	// credit notes carry the adjustment total without the lines
+	if len(inv.Adjustments) > 0 || !h.AdjustmentTotal.IsZero() {
+		doc.AdjustmentTotal = amount(h.AdjustmentTotal)
+	}
+	for _, t := range inv.Taxes {
+		label := t.Name + " " + t.Rate.String() + "%"
+		if t.ReverseCharge {
+			label += " (reverse charge)"
+			doc.Note = reverseChargeNote
+		}
+		doc.Taxes = append(doc.Taxes, line{Description: label, Amount: amount(t.Amount)})
+	}
+
+	for _, fn := range inv.Functions {
+		lines := chargeLines(fn, h.Tariffs)
+		group := functionLines{Function: fn.Function, Total: amount(fn.Totals.TotalCost)}
+		for _, l := range lines {
+			group.Lines = append(group.Lines, line{
+				Description: l.description,
+				Quantity:    l.quantity.String() + " " + l.unit,
+				UnitPrice:   l.unitPrice.String(),
+				Amount:      amount(l.amount),
+			})
+		}
+		doc.Functions = append(doc.Functions, group)
+	}
+
+	for _, a := range inv.Adjustments {
+		quantity := ""
+		if !a.Quantity.IsZero() {
+			quantity = a.Quantity.String() + " " + a.Unit
+		}
+		doc.Adjustments = append(doc.Adjustments, line{
+			Description: a.Description,
+			Quantity:    quantity,
+			Amount:      amount(a.Amount),
+		})
+	}
+
+	return doc
+}
+
+func billedTo(h entity.InvoiceHeader) []string {
+	c := h.Customer
+	if c == nil {
+		return []string{h.TenantID}
+	}
+
+	out := []string{c.LegalName}
+	for _, l := range strings.Split(c.Address, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			out = append(out, l)
+		}
+	}
+	if c.Country != "" {
+		out = append(out, c.Country)
+	}
+	if c.TaxID != "" {
+		out = append(out, "Tax ID "+c.TaxID)
+	}
+	return append(out, "Tenant "+h.TenantID)
+}
+
+// policy formats amounts the way the invoice was rounded, invoices issued in
+// another currency keep that currency's minor unit.
+func (r *Renderer) policy(currency string) money.Policy {
+	c, err := money.ParseCurrency(currency)
+	if err != nil {
+		return r.money
+	}
+	return r.money.In(c)
+}
+
+// chargeLine sums the charges of a function that share a dimension, a
+// tariff version, a tier and so a unit price.
+type chargeLine struct {
+	dimension   string
+	tariffID    int
+	version     int
+	tier        int
+	unit        string
+	unitPrice   money.Decimal
+	quantity    money.Decimal
+	amount      money.Decimal
+	description string
+}
+
+var dimensions = map[string]struct {
+	order int
+	label string
+}{
+	entity.DimensionExec:     {0, "Execution time"},
+	entity.DimensionMemory:   {1, "Memory"},
+	entity.DimensionCPU:      {2, "CPU"},
+	entity.DimensionRequests: {3, "Requests"},
+}
+
+func chargeLines(fn entity.FunctionLine, tariffs []entity.TariffRef) []chargeLine {
+	var lines []*chargeLine
+	index := make(map[string]*chargeLine)
+	for _, pod := range fn.Pods {
+		for _, c := range pod.Charges {
+			key := fmt.Sprintf("%s/%d/%d/%d/%s", c.Dimension, c.TariffID, c.TariffVersion, c.Tier, c.UnitPrice)
+			l, ok := index[key]
+			if !ok {
+				l = &chargeLine{
+					dimension: c.Dimension,
+					tariffID:  c.TariffID,
+					version:   c.TariffVersion,
+					tier:      c.Tier,
+					unit:      c.Unit,
+					unitPrice: c.UnitPrice,
+				}
+				index[key] = l
+				lines = append(lines, l)
+			}
+			l.quantity = l.quantity.Add(c.Quantity)
+			l.amount = l.amount.Add(c.Amount)
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.dimension != b.dimension {
+			return dimensions[a.dimension].order < dimensions[b.dimension].order
+		}
+		if a.tariffID != b.tariffID {
+			return a.tariffID < b.tariffID
+		}
+		if a.version != b.version {
+			return a.version < b.version
+		}
+		return a.tier < b.tier
+	})
+
+	// tiers and tariffs are only spelled out when the dimension has several
+	tiered := make(map[string]bool)
+	for _, l := range lines {
+		if l.tier > 0 {
+			tiered[l.dimension] = true
+		}
+	}
+
+	out := make([]chargeLine, 0, len(lines))
+	for _, l := range lines {
+		label := dimensions[l.dimension].label
+		if label == "" {
+			label = l.dimension
+		}
+		var details []string
+		if len(tariffs) > 1 {
+			details = append(details, tariffName(tariffs, l.tariffID, l.version))
+		}
+		if tiered[l.dimension] {
+			details = append(details, fmt.Sprintf("tier %d", l.tier+1))
+		}
+		if len(details) > 0 {
+			label += " (" + strings.Join(details, ", ") + ")"
+		}
+		l.description = label
+		out = append(out, *l)
+	}
+
+	return out
+}
+
+func tariffName(tariffs []entity.TariffRef, id, version int) string {
+	for _, t := range tariffs {
+		if t.ID == id && t.Version == version {
+			return fmt.Sprintf("%s v%d", t.Name, t.Version)
+		}
+	}
+	return fmt.Sprintf("tariff %d v%d", id, version)
+}
+
+// parseColor reads a #rrggbb color.
+func parseColor(hex string) (int, int, int, bool) {
+	var r, g, b int
+	if len(hex) != 7 {
+		return 0, 0, 0, false
+	}
+	if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return 0, 0, 0, false
+	}
+	return r, g, b, true
+}
@@ -0,0 +1,186 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+var dec = money.MustParse
+
+func testInvoice() *entity.Invoice {
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	memory := func(tier int, quantity, price, amount string) entity.Charge {
+		return entity.Charge{Dimension: entity.DimensionMemory, TariffID: 1, TariffVersion: 1, Tier: tier,
+			Quantity: dec(quantity), Unit: "MB*s", UnitPrice: dec(price), Amount: dec(amount)}
+	}
+
+	return &entity.Invoice{
+		Header: entity.InvoiceHeader{
+			Number:          7,
+			Status:          entity.InvoiceStatusIssued,
+			TenantID:        "alice@example.com",
+			PeriodStart:     start,
+			PeriodEnd:       start.AddDate(0, 1, 0),
+			Tariffs:         []entity.TariffRef{{ID: 1, Name: "basic", Version: 1}},
+			Totals:          entity.Totals{TotalCost: dec("20.5")},
+			Currency:        "USD",
+			AdjustmentTotal: dec("-2"),
+			TaxRate:         dec("20"),
+			Tax:             dec("3.7"),
+			AmountDue:       dec("22.2"),
+			CalculatedAt:    start.AddDate(0, 1, 0).Add(time.Hour),
+		},
+		Functions: []entity.FunctionLine{
+			{
+				Function: "<hello>",
+				Pods: []entity.PodLine{
+					{Pod: "hello-a", Charges: []entity.Charge{
+						{Dimension: entity.DimensionExec, TariffID: 1, TariffVersion: 1, Quantity: dec("10"), Unit: "s", UnitPrice: dec("0.5"), Amount: dec("5")},
+						memory(0, "1000", "0.01", "10"),
+					}},
+					{Pod: "hello-b", Charges: []entity.Charge{
+						memory(1, "1000", "0.005", "5"),
+						{Dimension: entity.DimensionExec, TariffID: 1, TariffVersion: 1, Quantity: dec("1"), Unit: "s", UnitPrice: dec("0.5"), Amount: dec("0.5")},
+					}},
+				},
+				Totals: entity.Totals{TotalCost: dec("20.5")},
+			},
+		},
+		Adjustments: []entity.Adjustment{
+			{Kind: entity.AdjustmentFreeTier, Description: "free memory", Quantity: dec("200"), Unit: "MB*s", Amount: dec("-2")},
+		},
+		Taxes: []entity.TaxLine{
+			{Name: "VAT", Country: "DE", Rate: dec("20"), Base: dec("18.5"), Amount: dec("3.7")},
+		},
+	}
+}
+
+func Test_Document(t *testing.T) {
+	r := New(Brand{Name: "Acme", Color: "red"}, money.DefaultPolicy())
+	doc := r.document(testInvoice())
+
+	assert.Equal(t, defaultColor, doc.Brand.Color)
+	assert.Equal(t, "Invoice #7", doc.Title)
+	assert.Equal(t, "2025-10-01 00:00 UTC - 2025-11-01 00:00 UTC", doc.Period)
+
+	// charges of all pods sharing a price become one line, tiers are named
+	// since memory has two
+	require.Len(t, doc.Functions, 1)
+	assert.Equal(t, []line{
+		{Description: "Execution time", Quantity: "11 s", UnitPrice: "0.5", Amount: "5.50"},
+		{Description: "Memory (tier 1)", Quantity: "1000 MB*s", UnitPrice: "0.01", Amount: "10.00"},
+		{Description: "Memory (tier 2)", Quantity: "1000 MB*s", UnitPrice: "0.005", Amount: "5.00"},
+	}, doc.Functions[0].Lines)
+	assert.Equal(t, "20.50", doc.Functions[0].Total)
+
+	assert.Equal(t, []line{{Description: "free memory", Quantity: "200 MB*s", Amount: "-2.00"}}, doc.Adjustments)
+	assert.Equal(t, "-2.00", doc.AdjustmentTotal)
+	assert.Equal(t, "22.20", doc.AmountDue)
+	assert.Equal(t, []string{"alice@example.com"}, doc.BilledTo)
+
+	assert.Equal(t, []line{{Description: "VAT 20%", Amount: "3.70"}}, doc.Taxes)
+	assert.Empty(t, doc.Note)
+
+	// no tax line without taxes
+	inv := testInvoice()
+	inv.Header.TaxRate, inv.Header.Tax, inv.Taxes = money.Zero, money.Zero, nil
+	assert.Empty(t, r.document(inv).Taxes)
+
+	inv = testInvoice()
+	inv.Header.Customer = &entity.BillingProfile{LegalName: "Alice GmbH", Address: "Hauptstr. 1\n10115 Berlin", Country: "DE", TaxID: "DE123456789"}
+	inv.Taxes = []entity.TaxLine{
+		{Name: "GST", Rate: dec("5"), Base: dec("18.5"), Amount: dec("0.925")},
+		{Name: "VAT", Rate: dec("15"), Base: dec("18.5"), Amount: money.Zero, ReverseCharge: true},
+	}
+	doc = r.document(inv)
+	assert.Equal(t, []string{"Alice GmbH", "Hauptstr. 1", "10115 Berlin", "DE", "Tax ID DE123456789", "Tenant alice@example.com"}, doc.BilledTo)
+	assert.Equal(t, []line{
+		{Description: "GST 5%", Amount: "0.92"},
+		{Description: "VAT 15% (reverse charge)", Amount: "0.00"},
+	}, doc.Taxes)
+	assert.Equal(t, reverseChargeNote, doc.Note)
+	assert.Empty(t, doc.FX)
+	assert.Empty(t, doc.DueAt)
+
+	// invoices with payment terms show when they are due
+	inv = testInvoice()
+	due := time.Date(2025, 11, 15, 1, 0, 0, 0, time.UTC)
+	inv.Header.DueAt = &due
+	doc = r.document(inv)
+	assert.Equal(t, "2025-11-15 01:00 UTC", doc.DueAt)
+
+	// invoices in another currency keep its minor unit and show the rate
+	inv = testInvoice()
+	inv.Header.Currency = "JPY"
+	inv.Header.FX = &entity.FXRate{Base: "USD", Currency: "JPY", Rate: dec("151.2"), Date: time.Date(2025, 10, 31, 0, 0, 0, 0, time.UTC)}
+	doc = r.document(inv)
+	assert.Equal(t, "1 USD = 151.2 JPY (2025-10-31)", doc.FX)
+	assert.Equal(t, "22", doc.AmountDue)
+
+	// documents issued by recalculation name the invoice they settle
+	corrects := int64(5)
+	inv = testInvoice()
+	inv.Header.Corrects = &corrects
+	assert.Equal(t, "Invoice #7 replacing invoice #5", r.document(inv).Title)
+
+	inv = &entity.Invoice{Header: entity.InvoiceHeader{
+		Number:          8,
+		Kind:            entity.InvoiceKindCreditNote,
+		Corrects:        &corrects,
+		Currency:        "USD",
+		Totals:          entity.Totals{TotalCost: dec("-3")},
+		AdjustmentTotal: dec("0.5"),
+		AmountDue:       dec("-2.5"),
+	}}
+	doc = r.document(inv)
+	assert.Equal(t, "Credit note #8 for invoice #5", doc.Title)
+	assert.Equal(t, "0.50", doc.AdjustmentTotal)
+	assert.Equal(t, "-2.50", doc.AmountDue)
+
+	inv.Header.Kind = entity.InvoiceKindDebitNote
+	assert.Equal(t, "Debit note #8 for invoice #5", r.document(inv).Title)
+}
+
+func Test_Render(t *testing.T) {
+	r := New(Brand{Name: "Acme", Email: "billing@acme.test"}, money.DefaultPolicy())
+
+	html, err := r.Render(testInvoice(), FormatHTML)
+	require.NoError(t, err)
+	page := string(html)
+	assert.Contains(t, page, "Invoice #7")
+	assert.Contains(t, page, "&lt;hello&gt;")
+	assert.Contains(t, page, "22.20 USD")
+	assert.False(t, strings.Contains(page, "<hello>"))
+
+	pdf, err := r.Render(testInvoice(), FormatPDF)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-")))
+
+	// the same invoice always renders to the same file
+	again, err := r.Render(testInvoice(), FormatPDF)
+	require.NoError(t, err)
+	assert.Equal(t, pdf, again)
+
+	inv := testInvoice()
+	inv.Header.Customer = &entity.BillingProfile{LegalName: "Alice GmbH", Address: "Berlin", Country: "DE", TaxID: "DE123456789"}
+	inv.Taxes = []entity.TaxLine{{Name: "USt", Rate: dec("19"), Base: dec("18.5"), Amount: money.Zero, ReverseCharge: true}}
+	for _, format := range []string{FormatHTML, FormatPDF} {
+		out, err := r.Render(inv, format)
+		require.NoError(t, err, format)
+		if format == FormatHTML {
+			assert.Contains(t, string(out), "Alice GmbH<br>Berlin")
+			assert.Contains(t, string(out), reverseChargeNote)
+		}
+	}
+
+	_, err = r.Render(testInvoice(), "docx")
+	assert.Error(t, err)
+}
@@ -0,0 +1,246 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const uniqueViolation = "23505"
+
+var budgetColumns = []string{
+	"id", "tenant", "amount", "currency", "thresholds", "hard_cap", "created_at", "updated_at",
+}
+
+var alertColumns = []string{
+	"budget_id", "period_start", "kind", "threshold", "spend", "amount", "created_at",
+}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+func (r *Repo) Create(ctx context.Context, b *entity.Budget) (*entity.Budget, error) {
+	q, args, err := r.Builder.Insert("budgets").
+		Columns("tenant", "amount", "currency", "thresholds", "hard_cap").
+		Values(b.Tenant, b.Amount, b.Currency, b.Thresholds, b.HardCap).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("create budget query", slog.String("query", q))
+
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, repoerrors.ErrConflict
+		}
+
+		slog.Error("failed to create budget", slog.String("tenant", b.Tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (r *Repo) GetByTenant(ctx context.Context, tenant string) (*entity.Budget, error) {
+	budgets, err := r.budgets(ctx, r.Builder.
+		Select(budgetColumns...).
+		From("budgets").
+		Where(squirrel.Eq{"tenant": tenant}))
+	if err != nil {
+		return nil, err
+	}
+	if len(budgets) == 0 {
+		return nil, repoerrors.ErrNotFound
+	}
+
+	return &budgets[0], nil
+}
+
+func (r *Repo) GetAll(ctx context.Context) ([]entity.Budget, error) {
+	return r.budgets(ctx, r.Builder.
+		Select(budgetColumns...).
+		From("budgets").
+		OrderBy("id"))
+}
+
+// Update stores the amount, thresholds and hard cap of the tenant's budget.
+func (r *Repo) Update(ctx context.Context, b *entity.Budget) (*entity.Budget, error) {
+	q, args, err := r.Builder.Update("budgets").
+		Set("amount", b.Amount).
+		Set("thresholds", b.Thresholds).
+		Set("hard_cap", b.HardCap).
+		Set("updated_at", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Where(squirrel.Eq{"tenant": b.Tenant}).
+		Suffix("RETURNING updated_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("update budget query", slog.String("query", q))
+
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&b.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to update budget", slog.String("tenant", b.Tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (r *Repo) Delete(ctx context.Context, tenant string) error {
+	q, args, err := r.Builder.Delete("budgets").
+		Where(squirrel.Eq{"tenant": tenant}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	tag, err := r.Pool.Exec(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to delete budget", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrors.ErrNotFound
+	}
+
+	return nil
+}
+
+// AddAlert takes the alert unless it was taken before, and tells whether it
+// did. Only the replica that took an alert sends it.
+func (r *Repo) AddAlert(ctx context.Context, a *entity.BudgetAlert) (bool, error) {
+	q, args, err := r.Builder.Insert("budget_alerts").
+		Columns("budget_id", "period_start", "kind", "threshold", "spend", "amount").
+		Values(a.BudgetID, a.PeriodStart, a.Kind, a.Threshold, a.Spend, a.Amount).
+		Suffix("ON CONFLICT DO NOTHING RETURNING created_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return false, err
+	}
+
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&a.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+
+		slog.Error("failed to add budget alert", slog.Int("budget_id", a.BudgetID), slog.String("error", err.Error()))
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteAlert releases an alert that could not be sent, so the next check
+// sends it again.
+func (r *Repo) DeleteAlert(ctx context.Context, a *entity.BudgetAlert) error {
+	q, args, err := r.Builder.Delete("budget_alerts").
+		Where(squirrel.Eq{
+			"budget_id":    a.BudgetID,
+			"period_start": a.PeriodStart,
+			"kind":         a.Kind,
+			"threshold":    a.Threshold,
+		}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	if _, err := r.Pool.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to delete budget alert", slog.Int("budget_id", a.BudgetID), slog.String("error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// GetAlerts returns the alerts of the budget taken in the month starting at
+// periodStart, oldest first.
+func (r *Repo) GetAlerts(ctx context.Context, budgetID int, periodStart time.Time) ([]entity.BudgetAlert, error) {
+	q, args, err := r.Builder.
+		Select(alertColumns...).
+		From("budget_alerts").
+		Where(squirrel.Eq{"budget_id": budgetID, "period_start": periodStart}).
+		OrderBy("created_at", "threshold").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get budget alerts", slog.Int("budget_id", budgetID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.BudgetAlert
+	for rows.Next() {
+		var a entity.BudgetAlert
+		if err := rows.Scan(&a.BudgetID, &a.PeriodStart, &a.Kind, &a.Threshold, &a.Spend, &a.Amount, &a.CreatedAt); err != nil {
+			slog.Error("failed to scan budget alert", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, a)
+	}
+
+	return out, rows.Err()
+}
+
+func (r *Repo) budgets(ctx context.Context, qb squirrel.SelectBuilder) ([]entity.Budget, error) {
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get budgets query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get budgets", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.Budget
+	for rows.Next() {
+		var b entity.Budget
+		if err := rows.Scan(&b.ID, &b.Tenant, &b.Amount, &b.Currency, &b.Thresholds, &b.HardCap, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			slog.Error("failed to scan budget", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, b)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,123 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+var rateColumns = []string{"base", "currency", "rate", "date"}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+// Save stores the rates, replacing the ones of the same pair and date.
+func (r *Repo) Save(ctx context.Context, rates []entity.FXRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	qb := r.Builder.Insert("fx_rates").
+		Columns(rateColumns...).
+		Suffix("ON CONFLICT (base, currency, date) DO UPDATE SET rate = EXCLUDED.rate")
+	for _, rate := range rates {
+		qb = qb.Values(rate.Base, rate.Currency, rate.Rate, rate.Date)
+	}
+
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	slog.Debug("save fx rates query", slog.String("query", q))
+
+	if _, err := r.Pool.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to save fx rates", slog.String("error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the rate of the pair in force at the given time.
+func (r *Repo) Get(ctx context.Context, base, currency string, at time.Time) (*entity.FXRate, error) {
+	q, args, err := r.Builder.
+		Select(rateColumns...).
+		From("fx_rates").
+		Where(squirrel.Eq{"base": base, "currency": currency}).
+		Where(squirrel.LtOrEq{"date": at.UTC().Format(time.DateOnly)}).
+		OrderBy("date DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var rate entity.FXRate
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&rate.Base, &rate.Currency, &rate.Rate, &rate.Date); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to get fx rate", slog.String("currency", currency), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// GetAll returns the rates from base, of every currency if currency is
+// empty, newest first.
+func (r *Repo) GetAll(ctx context.Context, base, currency string) ([]entity.FXRate, error) {
+	where := squirrel.Eq{"base": base}
+	if currency != "" {
+		where["currency"] = currency
+	}
+
+	q, args, err := r.Builder.
+		Select(rateColumns...).
+		From("fx_rates").
+		Where(where).
+		OrderBy("currency", "date DESC").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get fx rates", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.FXRate
+	for rows.Next() {
+		var rate entity.FXRate
+		if err := rows.Scan(&rate.Base, &rate.Currency, &rate.Rate, &rate.Date); err != nil {
+			slog.Error("failed to scan fx rate", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, rate)
+	}
+
+	return out, rows.Err()
+}
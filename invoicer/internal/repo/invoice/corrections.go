@@ -0,0 +1,81 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// LastBefore returns the header of the tenant's latest invoice ending by end.
+func (r *Repo) LastBefore(ctx context.Context, tenant string, end time.Time) (*entity.InvoiceHeader, error) {
+	headers, err := r.headers(ctx, r.Builder.
+		Select(selectColumns...).
+		From("invoices").
+		Where(squirrel.Eq{"tenant": tenant, "kind": entity.InvoiceKindInvoice}).
+		Where(squirrel.LtOrEq{"period_end": end}).
+		OrderBy("period_end DESC", "number DESC").
+		Limit(1))
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, repoerrors.ErrNotFound
+	}
+
+	return &headers[0], nil
+}
+
+// GetPeriod returns every document of the tenant for the period starting at
+// start: the invoice, the invoices it replaced and the credit notes, in
+// number order.
+func (r *Repo) GetPeriod(ctx context.Context, tenant string, start time.Time) ([]entity.InvoiceHeader, error) {
+	return r.headers(ctx, r.Builder.
+		Select(selectColumns...).
+		From("invoices").
+		Where(squirrel.Eq{"tenant": tenant, "period_start": start}).
+		OrderBy("number"))
+}
+
+// Correct voids the open invoice and issues its replacement in one
+// transaction. An invoice that is paid or void already is a conflict.
+func (r *Repo) Correct(ctx context.Context, number int64, inv *entity.Invoice, at time.Time) (*entity.Invoice, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	_, err = r.update(ctx, tx, r.Builder.Update("invoices").
+		Set("status", entity.InvoiceStatusVoid).
+		Set("voided_at", at).
+		Where(squirrel.Eq{"number": number, "status": openStatuses}))
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, r.conflictIfExists(ctx, number)
+		}
+		return nil, err
+	}
+
+	inv.Header.Corrects = &number
+	created, err := r.create(ctx, tx, inv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit corrected invoice", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	inv.Header.Number = created
+	return inv, nil
+}
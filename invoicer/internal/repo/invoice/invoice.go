@@ -0,0 +1,476 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const uniqueViolation = "23505"
+
+var headerColumns = []string{
+	"number", "status", "tenant", "period_start", "period_end", "usage_cutoff", "tariffs",
+	"duration_sec", "memory_mb_sec", "cpu_sec", "requests",
+	"exec_cost", "memory_cost", "cpu_cost", "request_cost", "total_cost",
+	"currency", "adjustment_total", "tax_rate", "tax", "amount_due", "issued_at", "customer", "fx", "due_at",
+	"kind", "corrects",
+}
+
+// lifecycleColumns change after the invoice is issued.
+var lifecycleColumns = []string{
+	"paid_at", "voided_at", "dunning_level", "dunned_at",
+}
+
+var selectColumns = append(append([]string{}, headerColumns...), lifecycleColumns...)
+
+var paymentColumns = []string{
+	"p.id", "i.number", "p.provider", "p.external_id", "p.status", "p.amount", "p.currency", "p.created_at",
+}
+
+var lineColumns = []string{
+	"invoice_id", "function", "pod", "pod_start", "pod_end", "dimension", "tariff_id", "tariff_version", "tier",
+	"charge_from", "charge_to", "quantity", "unit", "unit_price", "amount",
+}
+
+var adjustmentColumns = []string{
+	"invoice_id", "kind", "source_id", "dimension", "description", "quantity", "unit", "amount",
+}
+
+var taxColumns = []string{
+	"invoice_id", "rule_id", "name", "country", "rate", "base", "amount", "reverse_charge",
+}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+// Create issues the invoice: it takes the next number and stores the header,
+// every charge and every adjustment in one transaction. Numbers have no gaps because the
+// counter row stays locked until the transaction ends.
+func (r *Repo) Create(ctx context.Context, inv *entity.Invoice) (*entity.Invoice, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	number, err := r.create(ctx, tx, inv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit invoice", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	inv.Header.Number = number
+	return inv, nil
+}
+
+// create stores the invoice within the transaction and returns its number.
+func (r *Repo) create(ctx context.Context, tx pgx.Tx, inv *entity.Invoice) (int64, error) {
+	q, args, err := r.Builder.Update("invoice_counters").
+		Set("last_number", squirrel.Expr("last_number + 1")).
+		Where(squirrel.Eq{"name": "invoice"}).
+		Suffix("RETURNING last_number").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	var number int64
+	if err := tx.QueryRow(ctx, q, args...).Scan(&number); err != nil {
+		slog.Error("failed to take invoice number", slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	h := inv.Header
+	q, args, err = r.Builder.Insert("invoices").
+		Columns(headerColumns...).
+		Values(number, h.Status, h.TenantID, h.PeriodStart, h.PeriodEnd, h.UsageCutoff, h.Tariffs,
+			h.Totals.DurationSec, h.Totals.MemoryMBSec, h.Totals.CPUSec, h.Totals.Requests,
+			h.Totals.ExecCost, h.Totals.MemoryCost, h.Totals.CPUCost, h.Totals.RequestCost, h.Totals.TotalCost,
+			h.Currency, h.AdjustmentTotal, h.TaxRate, h.Tax, h.AmountDue, h.CalculatedAt, h.Customer, h.FX, h.DueAt,
+			h.Kind, h.Corrects).
+		Suffix("RETURNING id").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	slog.Debug("create invoice query", slog.String("query", q))
+
+	var id int64
+	if err := tx.QueryRow(ctx, q, args...).Scan(&id); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return 0, repoerrors.ErrConflict
+		}
+
+		slog.Error("failed to create invoice", slog.String("tenant", h.TenantID), slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	var lines [][]any
+	for _, fn := range inv.Functions {
+		for _, pod := range fn.Pods {
+			for _, c := range pod.Charges {
+				lines = append(lines, []any{
+					id, fn.Function, pod.Pod, pod.StartTime, pod.EndTime, c.Dimension, c.TariffID, c.TariffVersion, c.Tier,
+					c.From, c.To, c.Quantity, c.Unit, c.UnitPrice, c.Amount,
+				})
+			}
+		}
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"invoice_lines"}, lineColumns, pgx.CopyFromRows(lines)); err != nil {
+		slog.Error("failed to store invoice lines", slog.Int64("number", number), slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	adjustments := make([][]any, 0, len(inv.Adjustments))
+	for _, a := range inv.Adjustments {
+		adjustments = append(adjustments, []any{
+			id, a.Kind, a.SourceID, a.Dimension, a.Description, a.Quantity, a.Unit, a.Amount,
+		})
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"invoice_adjustments"}, adjustmentColumns, pgx.CopyFromRows(adjustments)); err != nil {
+		slog.Error("failed to store invoice adjustments", slog.Int64("number", number), slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	taxes := make([][]any, 0, len(inv.Taxes))
+	for _, t := range inv.Taxes {
+		taxes = append(taxes, []any{
+			id, t.RuleID, t.Name, t.Country, t.Rate, t.Base, t.Amount, t.ReverseCharge,
+		})
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"invoice_taxes"}, taxColumns, pgx.CopyFromRows(taxes)); err != nil {
+		slog.Error("failed to store invoice taxes", slog.Int64("number", number), slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	return number, nil
+}
+
+// Last returns the header of the tenant's latest invoice.
+func (r *Repo) Last(ctx context.Context, tenant string) (*entity.InvoiceHeader, error) {
+	headers, err := r.headers(ctx, r.Builder.
+		Select(selectColumns...).
+		From("invoices").
+		Where(squirrel.Eq{"tenant": tenant}).
+		OrderBy("period_end DESC").
+		Limit(1))
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, repoerrors.ErrNotFound
+	}
+
+	return &headers[0], nil
+}
+
+func (r *Repo) GetAll(ctx context.Context, filters *entity.InvoiceFilters) ([]entity.InvoiceHeader, error) {
+	qb := r.Builder.
+		Select(selectColumns...).
+		From("invoices").
+		OrderBy("number")
+
+	if filters.Tenant != "" {
+		qb = qb.Where(squirrel.Eq{"tenant": filters.Tenant})
+	}
+	if filters.Status != "" {
+		qb = qb.Where(squirrel.Eq{"status": filters.Status})
+	}
+	if filters.PeriodFrom != nil {
+		qb = qb.Where(squirrel.GtOrEq{"period_start": *filters.PeriodFrom})
+	}
+	if filters.PeriodTo != nil {
+		qb = qb.Where(squirrel.Lt{"period_start": *filters.PeriodTo})
+	}
+
+	return r.headers(ctx, qb.Limit(filters.Limit).Offset(filters.Offset))
+}
+
+func (r *Repo) GetByNumber(ctx context.Context, number int64) (*entity.Invoice, error) {
+	headers, err := r.headers(ctx, r.Builder.
+		Select(selectColumns...).
+		From("invoices").
+		Where(squirrel.Eq{"number": number}))
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, repoerrors.ErrNotFound
+	}
+
+	q, args, err := r.Builder.
+		Select("l.function", "l.pod", "l.pod_start", "l.pod_end", "l.dimension", "l.tariff_id", "l.tariff_version", "l.tier",
+			"l.charge_from", "l.charge_to", "l.quantity", "l.unit", "l.unit_price", "l.amount").
+		From("invoice_lines l").
+		Join("invoices i ON i.id = l.invoice_id").
+		Where(squirrel.Eq{"i.number": number}).
+		OrderBy("l.function", "l.pod", "l.id").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get invoice lines query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get invoice lines", slog.Int64("number", number), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	inv := &entity.Invoice{Header: headers[0], Functions: []entity.FunctionLine{}, Adjustments: []entity.Adjustment{}, Taxes: []entity.TaxLine{}}
+	for rows.Next() {
+		var (
+			function, pod    string
+			podStart, podEnd time.Time
+			c                entity.Charge
+		)
+		if err := rows.Scan(&function, &pod, &podStart, &podEnd, &c.Dimension, &c.TariffID, &c.TariffVersion, &c.Tier,
+			&c.From, &c.To, &c.Quantity, &c.Unit, &c.UnitPrice, &c.Amount); err != nil {
+			slog.Error("failed to scan invoice line", slog.String("error", err.Error()))
+			return nil, err
+		}
+		addCharge(inv, function, pod, podStart, podEnd, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range inv.Functions {
+		fn := &inv.Functions[i]
+		for _, p := range fn.Pods {
+			fn.Totals.Add(p.Totals)
+		}
+	}
+
+	inv.Adjustments, err = r.adjustments(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	inv.Taxes, err = r.taxes(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+func (r *Repo) adjustments(ctx context.Context, number int64) ([]entity.Adjustment, error) {
+	q, args, err := r.Builder.
+		Select("a.kind", "a.source_id", "a.dimension", "a.description", "a.quantity", "a.unit", "a.amount").
+		From("invoice_adjustments a").
+		Join("invoices i ON i.id = a.invoice_id").
+		Where(squirrel.Eq{"i.number": number}).
+		OrderBy("a.id").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get invoice adjustments query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get invoice adjustments", slog.Int64("number", number), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []entity.Adjustment{}
+	for rows.Next() {
+		var a entity.Adjustment
+		if err := rows.Scan(&a.Kind, &a.SourceID, &a.Dimension, &a.Description, &a.Quantity, &a.Unit, &a.Amount); err != nil {
+			slog.Error("failed to scan invoice adjustment", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, a)
+	}
+
+	return out, rows.Err()
+}
+
+func (r *Repo) taxes(ctx context.Context, number int64) ([]entity.TaxLine, error) {
+	q, args, err := r.Builder.
+		Select("t.rule_id", "t.name", "t.country", "t.rate", "t.base", "t.amount", "t.reverse_charge").
+		From("invoice_taxes t").
+		Join("invoices i ON i.id = t.invoice_id").
+		Where(squirrel.Eq{"i.number": number}).
+		OrderBy("t.id").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get invoice taxes query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get invoice taxes", slog.Int64("number", number), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []entity.TaxLine{}
+	for rows.Next() {
+		var t entity.TaxLine
+		if err := rows.Scan(&t.RuleID, &t.Name, &t.Country, &t.Rate, &t.Base, &t.Amount, &t.ReverseCharge); err != nil {
+			slog.Error("failed to scan invoice tax", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, t)
+	}
+
+	return out, rows.Err()
+}
+
+// CreditsSpent sums the credit adjustments of the tenant's invoices, void
+// invoices gave their credits back. Credit adjustments are negative, the
+// spent amounts are returned positive.
+func (r *Repo) CreditsSpent(ctx context.Context, tenant string) (map[int]money.Decimal, error) {
+	q, args, err := r.Builder.
+		Select("a.source_id", "-sum(a.amount)").
+		From("invoice_adjustments a").
+		Join("invoices i ON i.id = a.invoice_id").
+		Where(squirrel.Eq{"i.tenant": tenant, "a.kind": entity.AdjustmentCredit}).
+		Where(squirrel.NotEq{"i.status": entity.InvoiceStatusVoid}).
+		GroupBy("a.source_id").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get spent credits query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get spent credits", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	spent := make(map[int]money.Decimal)
+	for rows.Next() {
+		var (
+			id     int
+			amount money.Decimal
+		)
+		if err := rows.Scan(&id, &amount); err != nil {
+			slog.Error("failed to scan spent credit", slog.String("error", err.Error()))
+			return nil, err
+		}
+		spent[id] = amount
+	}
+
+	return spent, rows.Err()
+}
+
+// addCharge rebuilds the function and pod lines from the stored charges. Rows
+// come ordered by function and pod, so only the last lines need checking.
+func addCharge(inv *entity.Invoice, function, pod string, podStart, podEnd time.Time, c entity.Charge) {
+	if n := len(inv.Functions); n == 0 || inv.Functions[n-1].Function != function {
+		inv.Functions = append(inv.Functions, entity.FunctionLine{Function: function})
+	}
+	fn := &inv.Functions[len(inv.Functions)-1]
+
+	if n := len(fn.Pods); n == 0 || fn.Pods[n-1].Pod != pod {
+		fn.Pods = append(fn.Pods, entity.PodLine{
+			Pod:       pod,
+			StartTime: podStart,
+			EndTime:   podEnd,
+			Totals:    entity.Totals{DurationSec: int64(podEnd.Sub(podStart).Seconds())},
+		})
+	}
+	p := &fn.Pods[len(fn.Pods)-1]
+
+	p.Charges = append(p.Charges, c)
+	switch c.Dimension {
+	case entity.DimensionMemory:
+		p.Totals.MemoryMBSec += c.Quantity.InexactFloat64()
+	case entity.DimensionCPU:
+		p.Totals.CPUSec += c.Quantity.InexactFloat64()
+	case entity.DimensionRequests:
+		p.Totals.Requests += c.Quantity.InexactFloat64()
+	}
+	p.Totals.AddCharge(c)
+}
+
+func (r *Repo) headers(ctx context.Context, qb squirrel.SelectBuilder) ([]entity.InvoiceHeader, error) {
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get invoices query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get invoices", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.InvoiceHeader
+	for rows.Next() {
+		h, err := scanHeader(rows)
+		if err != nil {
+			slog.Error("failed to scan invoice", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, *h)
+	}
+
+	return out, rows.Err()
+}
+
+// scanHeader scans the selectColumns of an invoice, preceded by dest.
+func scanHeader(row pgx.Row, dest ...any) (*entity.InvoiceHeader, error) {
+	var h entity.InvoiceHeader
+	err := row.Scan(append(dest, &h.Number, &h.Status, &h.TenantID, &h.PeriodStart, &h.PeriodEnd, &h.UsageCutoff, &h.Tariffs,
+		&h.Totals.DurationSec, &h.Totals.MemoryMBSec, &h.Totals.CPUSec, &h.Totals.Requests,
+		&h.Totals.ExecCost, &h.Totals.MemoryCost, &h.Totals.CPUCost, &h.Totals.RequestCost, &h.Totals.TotalCost,
+		&h.Currency, &h.AdjustmentTotal, &h.TaxRate, &h.Tax, &h.AmountDue, &h.CalculatedAt, &h.Customer, &h.FX, &h.DueAt,
+		&h.Kind, &h.Corrects, &h.PaidAt, &h.VoidedAt, &h.DunningLevel, &h.DunnedAt)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
@@ -0,0 +1,268 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+// openStatuses are the statuses of invoices still waiting for payment.
+var openStatuses = []string{entity.InvoiceStatusIssued, entity.InvoiceStatusOverdue}
+
+// AddPayment records the payment of the invoice once per provider and
+// external id. The invoice row stays locked while the succeeded payments are
+// summed, so an open invoice turns paid exactly once, when they first cover
+// the amount due. The bool tells whether the payment was recorded now.
+func (r *Repo) AddPayment(ctx context.Context, p *entity.Payment, at time.Time) (*entity.InvoiceHeader, bool, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, false, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q, args, err := r.Builder.
+		Select(append([]string{"id"}, selectColumns...)...).
+		From("invoices").
+		Where(squirrel.Eq{"number": p.InvoiceNumber}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, false, err
+	}
+
+	var id int64
+	h, err := scanHeader(tx.QueryRow(ctx, q, args...), &id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to lock invoice", slog.Int64("number", p.InvoiceNumber), slog.String("error", err.Error()))
+		return nil, false, err
+	}
+
+	q, args, err = r.Builder.Insert("invoice_payments").
+		Columns("invoice_id", "provider", "external_id", "status", "amount", "currency").
+		Values(id, p.Provider, p.ExternalID, p.Status, p.Amount, p.Currency).
+		Suffix("ON CONFLICT (provider, external_id) DO NOTHING RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, false, err
+	}
+
+	slog.Debug("add payment query", slog.String("query", q))
+
+	if err := tx.QueryRow(ctx, q, args...).Scan(&p.ID, &p.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return h, false, nil
+		}
+
+		slog.Error("failed to add payment", slog.Int64("number", p.InvoiceNumber), slog.String("error", err.Error()))
+		return nil, false, err
+	}
+
+	if p.Status == entity.PaymentSucceeded && (h.Status == entity.InvoiceStatusIssued || h.Status == entity.InvoiceStatusOverdue) {
+		q, args, err = r.Builder.
+			Select("COALESCE(sum(amount), 0)").
+			From("invoice_payments").
+			Where(squirrel.Eq{"invoice_id": id, "status": entity.PaymentSucceeded}).
+			ToSql()
+		if err != nil {
+			slog.Error("failed to build query", slog.String("error", err.Error()))
+			return nil, false, err
+		}
+
+		var paid money.Decimal
+		if err := tx.QueryRow(ctx, q, args...).Scan(&paid); err != nil {
+			slog.Error("failed to sum payments", slog.Int64("number", p.InvoiceNumber), slog.String("error", err.Error()))
+			return nil, false, err
+		}
+
+		if paid.GreaterThanOrEqual(h.AmountDue) {
+			h, err = r.update(ctx, tx, r.Builder.Update("invoices").
+				Set("status", entity.InvoiceStatusPaid).
+				Set("paid_at", at).
+				Where(squirrel.Eq{"id": id}))
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit payment", slog.String("error", err.Error()))
+		return nil, false, err
+	}
+
+	return h, true, nil
+}
+
+func (r *Repo) GetPayments(ctx context.Context, number int64) ([]entity.Payment, error) {
+	q, args, err := r.Builder.
+		Select(paymentColumns...).
+		From("invoice_payments p").
+		Join("invoices i ON i.id = p.invoice_id").
+		Where(squirrel.Eq{"i.number": number}).
+		OrderBy("p.id").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get payments query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get payments", slog.Int64("number", number), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []entity.Payment{}
+	for rows.Next() {
+		var p entity.Payment
+		if err := rows.Scan(&p.ID, &p.InvoiceNumber, &p.Provider, &p.ExternalID, &p.Status, &p.Amount, &p.Currency, &p.CreatedAt); err != nil {
+			slog.Error("failed to scan payment", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, p)
+	}
+
+	return out, rows.Err()
+}
+
+// Void cancels an open invoice. Invoices that are paid or void already are
+// a conflict.
+func (r *Repo) Void(ctx context.Context, number int64, at time.Time) (*entity.InvoiceHeader, error) {
+	h, err := r.update(ctx, r.Pool, r.Builder.Update("invoices").
+		Set("status", entity.InvoiceStatusVoid).
+		Set("voided_at", at).
+		Where(squirrel.Eq{"number": number, "status": openStatuses}))
+	if errors.Is(err, repoerrors.ErrNotFound) {
+		return nil, r.conflictIfExists(ctx, number)
+	}
+
+	return h, err
+}
+
+// MarkOverdue turns the issued invoices due before at overdue and returns
+// them.
+func (r *Repo) MarkOverdue(ctx context.Context, at time.Time) ([]entity.InvoiceHeader, error) {
+	q, args, err := r.Builder.Update("invoices").
+		Set("status", entity.InvoiceStatusOverdue).
+		Where(squirrel.Eq{"status": entity.InvoiceStatusIssued}).
+		Where(squirrel.Lt{"due_at": at}).
+		Suffix("RETURNING " + strings.Join(selectColumns, ", ")).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("mark overdue invoices query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to mark overdue invoices", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.InvoiceHeader
+	for rows.Next() {
+		h, err := scanHeader(rows)
+		if err != nil {
+			slog.Error("failed to scan invoice", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, *h)
+	}
+
+	return out, rows.Err()
+}
+
+// GetOverdue returns the overdue invoices with fewer than maxLevel reminders
+// whose last reminder, if any, was sent before dunnedBefore.
+func (r *Repo) GetOverdue(ctx context.Context, maxLevel int, dunnedBefore time.Time) ([]entity.InvoiceHeader, error) {
+	return r.headers(ctx, r.Builder.
+		Select(selectColumns...).
+		From("invoices").
+		Where(squirrel.Eq{"status": entity.InvoiceStatusOverdue}).
+		Where(squirrel.Lt{"dunning_level": maxLevel}).
+		Where(squirrel.Or{squirrel.Eq{"dunned_at": nil}, squirrel.Lt{"dunned_at": dunnedBefore}}).
+		OrderBy("number"))
+}
+
+// Dun takes the next reminder of an overdue invoice at the given level. An
+// invoice that was reminded, paid or voided meanwhile is a conflict.
+func (r *Repo) Dun(ctx context.Context, number int64, level int, at time.Time) (*entity.InvoiceHeader, error) {
+	h, err := r.update(ctx, r.Pool, r.Builder.Update("invoices").
+		Set("dunning_level", level+1).
+		Set("dunned_at", at).
+		Where(squirrel.Eq{"number": number, "status": entity.InvoiceStatusOverdue, "dunning_level": level}))
+	if errors.Is(err, repoerrors.ErrNotFound) {
+		return nil, r.conflictIfExists(ctx, number)
+	}
+
+	return h, err
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// update runs the update and returns the updated invoice, ErrNotFound if no
+// invoice matched.
+func (r *Repo) update(ctx context.Context, db querier, qb squirrel.UpdateBuilder) (*entity.InvoiceHeader, error) {
+	q, args, err := qb.Suffix("RETURNING " + strings.Join(selectColumns, ", ")).ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("update invoice query", slog.String("query", q))
+
+	h, err := scanHeader(db.QueryRow(ctx, q, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to update invoice", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// conflictIfExists tells apart an invoice in the wrong state from a missing
+// one after an update matched nothing.
+func (r *Repo) conflictIfExists(ctx context.Context, number int64) error {
+	headers, err := r.headers(ctx, r.Builder.
+		Select(selectColumns...).
+		From("invoices").
+		Where(squirrel.Eq{"number": number}))
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return repoerrors.ErrNotFound
+	}
+
+	return repoerrors.ErrConflict
+}
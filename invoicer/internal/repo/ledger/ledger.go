@@ -0,0 +1,638 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const uniqueViolation = "23505"
+
+var accountKinds = []string{
+	entity.AccountWallet, entity.AccountFunding, entity.AccountRevenue, entity.AccountAdjustments,
+}
+
+var walletColumns = []string{
+	"w.tenant", "w.currency", "a.balance", "w.accrued", "w.created_at", "w.updated_at",
+}
+
+var transactionColumns = []string{
+	"id", "tenant", "kind", "idempotency_key", "invoice_number", "description", "amount", "accrued", "created_at",
+}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+type account struct {
+	id      int
+	balance money.Decimal
+}
+
+// Post locks the tenant's wallet and posts the transaction plan returns for
+// the wallet's current state, nil posts nothing. A non-empty currency opens
+// the wallet first if the tenant has none. When a transaction with the same
+// idempotency key was posted before it is returned instead and plan is not
+// called. The bool tells whether a transaction was posted.
+func (r *Repo) Post(ctx context.Context, tenant, currency, key string, plan func(w *entity.Wallet) (*entity.LedgerTransaction, error)) (*entity.LedgerTransaction, *entity.Wallet, bool, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	t, w, posted, err := r.post(ctx, tx, tenant, currency, key, plan)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit ledger transaction", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+
+	return t, w, posted, nil
+}
+
+// PostInvoice posts the debit of an issued invoice like Post and settles the
+// invoice from the wallet in the same transaction. plan gets the amount still
+// owed on the invoice, the amount due less the succeeded payments, zero once
+// it is paid. An amount owed is recorded as a wallet payment and turns the
+// invoice paid, so it is neither dunned nor paid again through a provider.
+func (r *Repo) PostInvoice(ctx context.Context, tenant string, number int64, key string, plan func(w *entity.Wallet, owed money.Decimal) (*entity.LedgerTransaction, error)) (*entity.LedgerTransaction, *entity.Wallet, bool, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// the invoice is locked before the wallet, like a payment locks it
+	id, currency, owed, err := r.owed(ctx, tx, number)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	t, w, posted, err := r.post(ctx, tx, tenant, "", key, func(w *entity.Wallet) (*entity.LedgerTransaction, error) {
+		return plan(w, owed)
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if posted && owed.IsPositive() {
+		if err := r.settle(ctx, tx, id, key, owed, currency); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit ledger transaction", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+
+	return t, w, posted, nil
+}
+
+// owed locks the invoice and returns its id, currency and the amount still
+// owed on it. Credit notes owe their negative amount.
+func (r *Repo) owed(ctx context.Context, tx pgx.Tx, number int64) (int64, string, money.Decimal, error) {
+	q, args, err := r.Builder.
+		Select("id", "status", "currency", "amount_due").
+		From("invoices").
+		Where(squirrel.Eq{"number": number}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return 0, "", money.Zero, err
+	}
+
+	var (
+		id        int64
+		status    string
+		currency  string
+		amountDue money.Decimal
+	)
+	if err := tx.QueryRow(ctx, q, args...).Scan(&id, &status, &currency, &amountDue); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", money.Zero, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to lock invoice", slog.Int64("number", number), slog.String("error", err.Error()))
+		return 0, "", money.Zero, err
+	}
+	if status != entity.InvoiceStatusIssued && status != entity.InvoiceStatusOverdue {
+		return id, currency, money.Zero, nil
+	}
+	if !amountDue.IsPositive() {
+		return id, currency, amountDue, nil
+	}
+
+	q, args, err = r.Builder.
+		Select("COALESCE(sum(amount), 0)").
+		From("invoice_payments").
+		Where(squirrel.Eq{"invoice_id": id, "status": entity.PaymentSucceeded}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return 0, "", money.Zero, err
+	}
+
+	var paid money.Decimal
+	if err := tx.QueryRow(ctx, q, args...).Scan(&paid); err != nil {
+		slog.Error("failed to sum payments", slog.Int64("number", number), slog.String("error", err.Error()))
+		return 0, "", money.Zero, err
+	}
+
+	owed := amountDue.Sub(paid)
+	if owed.IsNegative() {
+		return id, currency, money.Zero, nil
+	}
+
+	return id, currency, owed, nil
+}
+
+// settle records the wallet payment of the invoice, keyed by the ledger
+// transaction's idempotency key, and turns the invoice paid.
+func (r *Repo) settle(ctx context.Context, tx pgx.Tx, id int64, key string, amount money.Decimal, currency string) error {
+	q, args, err := r.Builder.Insert("invoice_payments").
+		Columns("invoice_id", "provider", "external_id", "status", "amount", "currency").
+		Values(id, entity.PaymentProviderWallet, key, entity.PaymentSucceeded, amount, currency).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to add wallet payment", slog.Int64("invoice_id", id), slog.String("error", err.Error()))
+		return err
+	}
+
+	q, args, err = r.Builder.Update("invoices").
+		Set("status", entity.InvoiceStatusPaid).
+		Set("paid_at", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to mark invoice paid", slog.Int64("invoice_id", id), slog.String("error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// post posts the transaction in tx, see Post.
+func (r *Repo) post(ctx context.Context, tx pgx.Tx, tenant, currency, key string, plan func(w *entity.Wallet) (*entity.LedgerTransaction, error)) (*entity.LedgerTransaction, *entity.Wallet, bool, error) {
+	if currency != "" {
+		if err := r.open(ctx, tx, tenant, currency); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	wallets, err := r.wallets(ctx, tx, r.walletQuery().Where(squirrel.Eq{"w.tenant": tenant}).Suffix("FOR UPDATE OF w"))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(wallets) == 0 {
+		return nil, nil, false, repoerrors.ErrNotFound
+	}
+	w := &wallets[0]
+
+	existing, err := r.transactions(ctx, tx, r.Builder.
+		Select(transactionColumns...).
+		From("ledger_transactions").
+		Where(squirrel.Eq{"tenant": tenant, "idempotency_key": key}))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(existing) > 0 {
+		if err := r.entries(ctx, tx, existing); err != nil {
+			return nil, nil, false, err
+		}
+		return &existing[0], w, false, nil
+	}
+
+	t, err := plan(w)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if t == nil {
+		return nil, w, false, nil
+	}
+	t.Tenant = tenant
+	t.IdempotencyKey = key
+
+	accounts, err := r.accounts(ctx, tx, tenant)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	q, args, err := r.Builder.Insert("ledger_transactions").
+		Columns("tenant", "kind", "idempotency_key", "invoice_number", "description", "amount", "accrued").
+		Values(t.Tenant, t.Kind, t.IdempotencyKey, t.InvoiceNumber, t.Description, t.Amount, t.Accrued).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+
+	if err := tx.QueryRow(ctx, q, args...).Scan(&t.ID, &t.CreatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, nil, false, repoerrors.ErrConflict
+		}
+
+		slog.Error("failed to post ledger transaction", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		a, ok := accounts[e.Account]
+		if !ok {
+			return nil, nil, false, errors.New("unknown ledger account " + e.Account)
+		}
+		a.balance = a.balance.Add(e.Amount)
+		e.Balance = a.balance
+
+		if err := r.postEntry(ctx, tx, t.ID, a, e.Amount); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	q, args, err = r.Builder.Update("wallets").
+		Set("accrued", squirrel.Expr("accrued + ?", t.Accrued)).
+		Set("updated_at", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Where(squirrel.Eq{"tenant": tenant}).
+		Suffix("RETURNING accrued, updated_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+
+	if err := tx.QueryRow(ctx, q, args...).Scan(&w.Accrued, &w.UpdatedAt); err != nil {
+		slog.Error("failed to update wallet", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, nil, false, err
+	}
+
+	w.Balance = accounts[entity.AccountWallet].balance
+	w.Blocked = w.Balance.IsNegative()
+
+	return t, w, true, nil
+}
+
+func (r *Repo) GetWallet(ctx context.Context, tenant string) (*entity.Wallet, error) {
+	wallets, err := r.wallets(ctx, r.Pool, r.walletQuery().Where(squirrel.Eq{"w.tenant": tenant}))
+	if err != nil {
+		return nil, err
+	}
+	if len(wallets) == 0 {
+		return nil, repoerrors.ErrNotFound
+	}
+
+	return &wallets[0], nil
+}
+
+func (r *Repo) GetWallets(ctx context.Context) ([]entity.Wallet, error) {
+	return r.wallets(ctx, r.Pool, r.walletQuery().OrderBy("w.tenant"))
+}
+
+// GetTransactions returns the tenant's transactions with their entries,
+// newest first.
+func (r *Repo) GetTransactions(ctx context.Context, tenant string, limit, offset uint64) ([]entity.LedgerTransaction, error) {
+	out, err := r.transactions(ctx, r.Pool, r.Builder.
+		Select(transactionColumns...).
+		From("ledger_transactions").
+		Where(squirrel.Eq{"tenant": tenant}).
+		OrderBy("id DESC").
+		Limit(limit).
+		Offset(offset))
+	if err != nil {
+		return nil, err
+	}
+
+	return out, r.entries(ctx, r.Pool, out)
+}
+
+// entries loads the entries of the transactions.
+func (r *Repo) entries(ctx context.Context, db querier, out []entity.LedgerTransaction) error {
+	if len(out) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(out))
+	byID := make(map[int64]*entity.LedgerTransaction, len(out))
+	for i := range out {
+		ids = append(ids, out[i].ID)
+		byID[out[i].ID] = &out[i]
+	}
+
+	q, args, err := r.Builder.
+		Select("e.transaction_id", "a.kind", "e.amount", "e.balance").
+		From("ledger_entries e").
+		Join("ledger_accounts a ON a.id = e.account_id").
+		Where(squirrel.Eq{"e.transaction_id": ids}).
+		OrderBy("e.id").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	rows, err := db.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get ledger entries", slog.String("error", err.Error()))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id int64
+			e  entity.LedgerEntry
+		)
+		if err := rows.Scan(&id, &e.Account, &e.Amount, &e.Balance); err != nil {
+			slog.Error("failed to scan ledger entry", slog.String("error", err.Error()))
+			return err
+		}
+		byID[id].Entries = append(byID[id].Entries, e)
+	}
+
+	return rows.Err()
+}
+
+// UnpostedInvoices returns the tenant's invoices ending after since that no
+// ledger transaction debited yet, oldest first. Void invoices are never
+// debited.
+func (r *Repo) UnpostedInvoices(ctx context.Context, tenant string, since time.Time) ([]entity.InvoiceHeader, error) {
+	q, args, err := r.Builder.
+		Select("i.number", "i.period_start", "i.period_end", "i.currency", "i.amount_due").
+		From("invoices i").
+		Where(squirrel.Eq{"i.tenant": tenant}).
+		Where(squirrel.Gt{"i.period_end": since}).
+		Where(squirrel.NotEq{"i.status": entity.InvoiceStatusVoid}).
+		Where("NOT EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.invoice_number = i.number)").
+		OrderBy("i.number").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get unposted invoices", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.InvoiceHeader
+	for rows.Next() {
+		h := entity.InvoiceHeader{TenantID: tenant, Status: entity.InvoiceStatusIssued}
+		if err := rows.Scan(&h.Number, &h.PeriodStart, &h.PeriodEnd, &h.Currency, &h.AmountDue); err != nil {
+			slog.Error("failed to scan invoice", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, h)
+	}
+
+	return out, rows.Err()
+}
+
+// VoidedInvoices returns the tenant's void invoices that were debited and
+// not credited back yet, oldest first. The credit of invoice N is posted with
+// the idempotency key void:N.
+func (r *Repo) VoidedInvoices(ctx context.Context, tenant string) ([]entity.InvoiceHeader, error) {
+	q, args, err := r.Builder.
+		Select("i.number", "i.period_start", "i.period_end", "i.currency", "i.amount_due").
+		From("invoices i").
+		Join("ledger_transactions t ON t.invoice_number = i.number").
+		Where(squirrel.Eq{"i.tenant": tenant, "i.status": entity.InvoiceStatusVoid}).
+		Where("NOT EXISTS (SELECT 1 FROM ledger_transactions v WHERE v.tenant = i.tenant AND v.idempotency_key = 'void:' || i.number)").
+		OrderBy("i.number").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get voided invoices", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.InvoiceHeader
+	for rows.Next() {
+		h := entity.InvoiceHeader{TenantID: tenant, Status: entity.InvoiceStatusVoid}
+		if err := rows.Scan(&h.Number, &h.PeriodStart, &h.PeriodEnd, &h.Currency, &h.AmountDue); err != nil {
+			slog.Error("failed to scan invoice", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, h)
+	}
+
+	return out, rows.Err()
+}
+
+// open creates the wallet and its accounts unless they exist.
+func (r *Repo) open(ctx context.Context, tx pgx.Tx, tenant, currency string) error {
+	q, args, err := r.Builder.Insert("wallets").
+		Columns("tenant", "currency").
+		Values(tenant, currency).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to open wallet", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return err
+	}
+
+	qb := r.Builder.Insert("ledger_accounts").Columns("tenant", "kind")
+	for _, kind := range accountKinds {
+		qb = qb.Values(tenant, kind)
+	}
+	q, args, err = qb.Suffix("ON CONFLICT DO NOTHING").ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to open ledger accounts", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repo) accounts(ctx context.Context, tx pgx.Tx, tenant string) (map[string]*account, error) {
+	q, args, err := r.Builder.
+		Select("kind", "id", "balance").
+		From("ledger_accounts").
+		Where(squirrel.Eq{"tenant": tenant}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get ledger accounts", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]*account, len(accountKinds))
+	for rows.Next() {
+		var (
+			kind string
+			a    account
+		)
+		if err := rows.Scan(&kind, &a.id, &a.balance); err != nil {
+			slog.Error("failed to scan ledger account", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out[kind] = &a
+	}
+
+	return out, rows.Err()
+}
+
+func (r *Repo) postEntry(ctx context.Context, tx pgx.Tx, transactionID int64, a *account, amount money.Decimal) error {
+	q, args, err := r.Builder.Insert("ledger_entries").
+		Columns("transaction_id", "account_id", "amount", "balance").
+		Values(transactionID, a.id, amount, a.balance).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to post ledger entry", slog.Int64("transaction_id", transactionID), slog.String("error", err.Error()))
+		return err
+	}
+
+	q, args, err = r.Builder.Update("ledger_accounts").
+		Set("balance", a.balance).
+		Where(squirrel.Eq{"id": a.id}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to update ledger account", slog.Int("account_id", a.id), slog.String("error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repo) walletQuery() squirrel.SelectBuilder {
+	return r.Builder.
+		Select(walletColumns...).
+		From("wallets w").
+		Join("ledger_accounts a ON a.tenant = w.tenant AND a.kind = ?", entity.AccountWallet)
+}
+
+// querier is a pool or a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func (r *Repo) wallets(ctx context.Context, db querier, qb squirrel.SelectBuilder) ([]entity.Wallet, error) {
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get wallets query", slog.String("query", q))
+
+	rows, err := db.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get wallets", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.Wallet
+	for rows.Next() {
+		var w entity.Wallet
+		if err := rows.Scan(&w.Tenant, &w.Currency, &w.Balance, &w.Accrued, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			slog.Error("failed to scan wallet", slog.String("error", err.Error()))
+			return nil, err
+		}
+		w.Blocked = w.Balance.IsNegative()
+		out = append(out, w)
+	}
+
+	return out, rows.Err()
+}
+
+func (r *Repo) transactions(ctx context.Context, db querier, qb squirrel.SelectBuilder) ([]entity.LedgerTransaction, error) {
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get ledger transactions", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.LedgerTransaction
+	for rows.Next() {
+		var t entity.LedgerTransaction
+		if err := rows.Scan(&t.ID, &t.Tenant, &t.Kind, &t.IdempotencyKey, &t.InvoiceNumber, &t.Description,
+			&t.Amount, &t.Accrued, &t.CreatedAt); err != nil {
+			slog.Error("failed to scan ledger transaction", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, t)
+	}
+
+	return out, rows.Err()
+}
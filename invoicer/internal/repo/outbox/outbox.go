@@ -0,0 +1,235 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+var messageColumns = []string{"id", "topic", "key", "payload", "attempts", "created_at"}
+
+// relayLock is the advisory lock that lets one relay run at a time.
+const relayLock = 7_290_214
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+// Add stores messages for the relay to publish.
+func (r *Repo) Add(ctx context.Context, msgs ...entity.OutboxMessage) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if err := r.add(ctx, tx, msgs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit outbox messages", slog.String("error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// ProcessAction records the action together with the messages it produced.
+// An action recorded before is left alone and its messages are dropped, the
+// bool tells whether it was recorded now.
+func (r *Repo) ProcessAction(ctx context.Context, a *entity.ProcessedAction, msgs ...entity.OutboxMessage) (bool, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return false, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	q, args, err := r.Builder.Insert("processed_actions").
+		Columns("id", "tenant", "pod", "replica", "action", "total_cost", "currency").
+		Values(a.ID, a.Tenant, a.Pod, a.Replica, a.Action, a.TotalCost, a.Currency).
+		Suffix("ON CONFLICT (id) DO NOTHING").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return false, err
+	}
+
+	slog.Debug("process action query", slog.String("query", q))
+
+	tag, err := tx.Exec(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to record action", slog.String("id", a.ID), slog.String("error", err.Error()))
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := r.add(ctx, tx, msgs); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit processed action", slog.String("error", err.Error()))
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Relay takes up to limit messages due at now, oldest first, and hands them
+// to publish. Published messages are deleted. When publish fails every
+// message is kept and tried again retryIn(attempts) later, the error is
+// returned once that is stored. A message waits while an older one with the
+// same topic and key is backing off, so the messages of a tenant are
+// published in order. One relay runs at a time, the relays of other replicas
+// find the lock taken and publish nothing.
+func (r *Repo) Relay(ctx context.Context, now time.Time, limit uint64, publish func([]entity.OutboxMessage) error, retryIn func(attempts int) time.Duration) (int, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", relayLock).Scan(&locked); err != nil {
+		slog.Error("failed to take outbox relay lock", slog.String("error", err.Error()))
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	q, args, err := r.Builder.
+		Select(messageColumns...).
+		From("outbox m").
+		Where(squirrel.LtOrEq{"m.next_attempt_at": now}).
+		Where("NOT EXISTS (SELECT 1 FROM outbox o WHERE o.topic = m.topic AND o.key = m.key AND o.id < m.id AND o.next_attempt_at > ?)", now).
+		OrderBy("m.id").
+		Limit(limit).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get outbox messages", slog.String("error", err.Error()))
+		return 0, err
+	}
+
+	var msgs []entity.OutboxMessage
+	for rows.Next() {
+		var m entity.OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Topic, &m.Key, &m.Payload, &m.Attempts, &m.CreatedAt); err != nil {
+			rows.Close()
+			slog.Error("failed to scan outbox message", slog.String("error", err.Error()))
+			return 0, err
+		}
+		msgs = append(msgs, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+
+	publishErr := publish(msgs)
+	if publishErr == nil {
+		q, args, err = r.Builder.Delete("outbox").Where(squirrel.Eq{"id": ids}).ToSql()
+		if err != nil {
+			slog.Error("failed to build query", slog.String("error", err.Error()))
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, q, args...); err != nil {
+			slog.Error("failed to delete published messages", slog.String("error", err.Error()))
+			return 0, err
+		}
+	} else {
+		batch := &pgx.Batch{}
+		for _, m := range msgs {
+			q, args, err := r.Builder.Update("outbox").
+				Set("attempts", m.Attempts+1).
+				Set("last_error", publishErr.Error()).
+				Set("next_attempt_at", now.Add(retryIn(m.Attempts+1))).
+				Where(squirrel.Eq{"id": m.ID}).
+				ToSql()
+			if err != nil {
+				slog.Error("failed to build query", slog.String("error", err.Error()))
+				return 0, err
+			}
+			batch.Queue(q, args...)
+		}
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			slog.Error("failed to reschedule messages", slog.String("error", err.Error()))
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit outbox relay", slog.String("error", err.Error()))
+		return 0, err
+	}
+	if publishErr != nil {
+		return 0, publishErr
+	}
+
+	return len(msgs), nil
+}
+
+func (r *Repo) add(ctx context.Context, tx pgx.Tx, msgs []entity.OutboxMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	qb := r.Builder.Insert("outbox").Columns("topic", "key", "payload")
+	for _, m := range msgs {
+		qb = qb.Values(m.Topic, m.Key, m.Payload)
+	}
+
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	slog.Debug("add outbox messages query", slog.String("query", q))
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to add outbox messages", slog.String("error", err.Error()))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/budget"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/fx"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/invoice"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/ledger"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/outbox"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/tax"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/usage"
+	"github.com/usamaroman/faas_demo/pkg/clickhouse"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+)
+
+type Usage interface {
+	GetUnbilled(ctx context.Context, tenant string, w entity.UsageWindow) ([]entity.Usage, error)
+	FirstUnbilled(ctx context.Context, tenant string, w entity.UsageWindow) (time.Time, bool, error)
+	Tenants(ctx context.Context) ([]string, error)
+	Report(ctx context.Context, q *entity.UsageQuery) ([]entity.UsageRow, error)
+	// Export streams the usage records of q to fn, see entity.UsageRecord.
+	Export(ctx context.Context, q *entity.UsageExport, fn func(entity.UsageRecord) error) error
+}
+
+type Invoice interface {
+	Create(ctx context.Context, inv *entity.Invoice) (*entity.Invoice, error)
+	Last(ctx context.Context, tenant string) (*entity.InvoiceHeader, error)
+	// LastBefore returns the tenant's latest invoice ending by end.
+	LastBefore(ctx context.Context, tenant string, end time.Time) (*entity.InvoiceHeader, error)
+	// GetPeriod returns every invoice and credit note of the tenant for the
+	// period starting at start.
+	GetPeriod(ctx context.Context, tenant string, start time.Time) ([]entity.InvoiceHeader, error)
+	// Correct voids an issued or overdue invoice and issues the one replacing
+	// it, ErrConflict for other invoices.
+	Correct(ctx context.Context, number int64, inv *entity.Invoice, at time.Time) (*entity.Invoice, error)
+	GetAll(ctx context.Context, filters *entity.InvoiceFilters) ([]entity.InvoiceHeader, error)
+	GetByNumber(ctx context.Context, number int64) (*entity.Invoice, error)
+	// CreditsSpent sums what the tenant's issued invoices took from every credit.
+	CreditsSpent(ctx context.Context, tenant string) (map[int]money.Decimal, error)
+	// AddPayment records the payment once per provider and external id and
+	// marks the invoice paid when the succeeded payments cover the amount
+	// due. The bool tells whether the payment was recorded now.
+	AddPayment(ctx context.Context, p *entity.Payment, at time.Time) (*entity.InvoiceHeader, bool, error)
+	GetPayments(ctx context.Context, number int64) ([]entity.Payment, error)
+	// Void cancels an issued or overdue invoice, ErrConflict for others.
+	Void(ctx context.Context, number int64, at time.Time) (*entity.InvoiceHeader, error)
+	// MarkOverdue turns the issued invoices due before at overdue.
+	MarkOverdue(ctx context.Context, at time.Time) ([]entity.InvoiceHeader, error)
+	// GetOverdue returns the overdue invoices with fewer than maxLevel
+	// reminders, last reminded before dunnedBefore.
+	GetOverdue(ctx context.Context, maxLevel int, dunnedBefore time.Time) ([]entity.InvoiceHeader, error)
+	// Dun takes reminder level+1 of an overdue invoice, ErrConflict if the
+	// invoice moved on meanwhile.
+	Dun(ctx context.Context, number int64, level int, at time.Time) (*entity.InvoiceHeader, error)
+}
+
+type Budget interface {
+	Create(ctx context.Context, b *entity.Budget) (*entity.Budget, error)
+	GetByTenant(ctx context.Context, tenant string) (*entity.Budget, error)
+	GetAll(ctx context.Context) ([]entity.Budget, error)
+	Update(ctx context.Context, b *entity.Budget) (*entity.Budget, error)
+	Delete(ctx context.Context, tenant string) error
+	// AddAlert takes the alert and tells whether it was not taken before.
+	AddAlert(ctx context.Context, a *entity.BudgetAlert) (bool, error)
+	DeleteAlert(ctx context.Context, a *entity.BudgetAlert) error
+	GetAlerts(ctx context.Context, budgetID int, periodStart time.Time) ([]entity.BudgetAlert, error)
+}
+
+type Ledger interface {
+	// Post locks the tenant's wallet and posts the transaction plan returns
+	// for it, nil posts nothing. A non-empty currency opens the wallet if the
+	// tenant has none. A transaction posted before with the same idempotency
+	// key is returned instead. The bool tells whether one was posted.
+	Post(ctx context.Context, tenant, currency, key string, plan func(w *entity.Wallet) (*entity.LedgerTransaction, error)) (*entity.LedgerTransaction, *entity.Wallet, bool, error)
+	// PostInvoice posts the debit of an issued invoice like Post and, in the
+	// same transaction, settles the amount still owed on it from the wallet:
+	// plan gets that amount, which is recorded as a wallet payment and turns
+	// the invoice paid.
+	PostInvoice(ctx context.Context, tenant string, number int64, key string, plan func(w *entity.Wallet, owed money.Decimal) (*entity.LedgerTransaction, error)) (*entity.LedgerTransaction, *entity.Wallet, bool, error)
+	GetWallet(ctx context.Context, tenant string) (*entity.Wallet, error)
+	GetWallets(ctx context.Context) ([]entity.Wallet, error)
+	GetTransactions(ctx context.Context, tenant string, limit, offset uint64) ([]entity.LedgerTransaction, error)
+	// UnpostedInvoices returns the tenant's invoices ending after since that
+	// were not debited yet.
+	UnpostedInvoices(ctx context.Context, tenant string, since time.Time) ([]entity.InvoiceHeader, error)
+	// VoidedInvoices returns the tenant's void invoices that were debited
+	// and not credited back yet.
+	VoidedInvoices(ctx context.Context, tenant string) ([]entity.InvoiceHeader, error)
+}
+
+type Tax interface {
+	// SaveProfile creates or replaces the tenant's billing profile.
+	SaveProfile(ctx context.Context, p *entity.BillingProfile) (*entity.BillingProfile, error)
+	GetProfile(ctx context.Context, tenant string) (*entity.BillingProfile, error)
+	DeleteProfile(ctx context.Context, tenant string) error
+	CreateRule(ctx context.Context, t *entity.TaxRule) (*entity.TaxRule, error)
+	GetRule(ctx context.Context, id int) (*entity.TaxRule, error)
+	// GetRules returns the rules of the country, of every country if empty.
+	GetRules(ctx context.Context, country string) ([]entity.TaxRule, error)
+	UpdateRule(ctx context.Context, t *entity.TaxRule) (*entity.TaxRule, error)
+	DeleteRule(ctx context.Context, id int) error
+}
+
+type FX interface {
+	// Save stores the rates, replacing the ones of the same pair and date.
+	Save(ctx context.Context, rates []entity.FXRate) error
+	// Get returns the rate of the pair in force at the given time.
+	Get(ctx context.Context, base, currency string, at time.Time) (*entity.FXRate, error)
+	GetAll(ctx context.Context, base, currency string) ([]entity.FXRate, error)
+}
+
+type Outbox interface {
+	// Add stores messages for the relay to publish.
+	Add(ctx context.Context, msgs ...entity.OutboxMessage) error
+	// ProcessAction records the action with the messages it produced in one
+	// transaction. The bool is false, and nothing is stored, for an action
+	// recorded before.
+	ProcessAction(ctx context.Context, a *entity.ProcessedAction, msgs ...entity.OutboxMessage) (bool, error)
+	// Relay hands up to limit messages due at now to publish, deletes them
+	// once published and otherwise retries each retryIn(attempts) later. A
+	// message is not due while an older one with its topic and key waits.
+	Relay(ctx context.Context, now time.Time, limit uint64, publish func([]entity.OutboxMessage) error, retryIn func(attempts int) time.Duration) (int, error)
+}
+
+type Repositories struct {
+	Usage
+	Invoice
+	Budget
+	Ledger
+	Tax
+	FX
+	Outbox
+}
+
+func NewRepositories(ch *clickhouse.Client, pg *postgresql.Postgres, sampleIntervalSec int) *Repositories {
+	return &Repositories{
+		Usage:   usage.NewRepo(ch, sampleIntervalSec),
+		Invoice: invoice.NewRepo(pg),
+		Budget:  budget.NewRepo(pg),
+		Ledger:  ledger.NewRepo(pg),
+		Tax:     tax.NewRepo(pg),
+		FX:      fx.NewRepo(pg),
+		Outbox:  outbox.NewRepo(pg),
+	}
+}
@@ -0,0 +1,8 @@
+package repoerrors
+
+import "errors"
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
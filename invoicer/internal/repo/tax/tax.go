@@ -0,0 +1,234 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const uniqueViolation = "23505"
+
+var profileColumns = []string{
+	"tenant", "legal_name", "address", "country", "tax_id", "currency", "created_at", "updated_at",
+}
+
+var ruleColumns = []string{
+	"id", "country", "name", "rate", "reverse_charge", "rounding", "created_at", "updated_at",
+}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+// SaveProfile creates the tenant's billing profile or replaces it.
+func (r *Repo) SaveProfile(ctx context.Context, p *entity.BillingProfile) (*entity.BillingProfile, error) {
+	q, args, err := r.Builder.Insert("billing_profiles").
+		Columns("tenant", "legal_name", "address", "country", "tax_id", "currency").
+		Values(p.Tenant, p.LegalName, p.Address, p.Country, p.TaxID, p.Currency).
+		Suffix(`ON CONFLICT (tenant) DO UPDATE SET
+			legal_name = EXCLUDED.legal_name,
+			address = EXCLUDED.address,
+			country = EXCLUDED.country,
+			tax_id = EXCLUDED.tax_id,
+			currency = EXCLUDED.currency,
+			updated_at = CURRENT_TIMESTAMP
+			RETURNING created_at, updated_at`).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("save billing profile query", slog.String("query", q))
+
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&p.CreatedAt, &p.UpdatedAt); err != nil {
+		slog.Error("failed to save billing profile", slog.String("tenant", p.Tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (r *Repo) GetProfile(ctx context.Context, tenant string) (*entity.BillingProfile, error) {
+	q, args, err := r.Builder.
+		Select(profileColumns...).
+		From("billing_profiles").
+		Where(squirrel.Eq{"tenant": tenant}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var p entity.BillingProfile
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&p.Tenant, &p.LegalName, &p.Address, &p.Country, &p.TaxID, &p.Currency, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to get billing profile", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (r *Repo) DeleteProfile(ctx context.Context, tenant string) error {
+	return r.delete(ctx, "billing_profiles", squirrel.Eq{"tenant": tenant})
+}
+
+func (r *Repo) CreateRule(ctx context.Context, t *entity.TaxRule) (*entity.TaxRule, error) {
+	q, args, err := r.Builder.Insert("tax_rules").
+		Columns("country", "name", "rate", "reverse_charge", "rounding").
+		Values(t.Country, t.Name, t.Rate, t.ReverseCharge, t.Rounding).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("create tax rule query", slog.String("query", q))
+
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, repoerrors.ErrConflict
+		}
+
+		slog.Error("failed to create tax rule", slog.String("country", t.Country), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (r *Repo) GetRule(ctx context.Context, id int) (*entity.TaxRule, error) {
+	rules, err := r.rules(ctx, r.Builder.
+		Select(ruleColumns...).
+		From("tax_rules").
+		Where(squirrel.Eq{"id": id}))
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, repoerrors.ErrNotFound
+	}
+
+	return &rules[0], nil
+}
+
+// GetRules returns the rules of the country, or of every country for an
+// empty one.
+func (r *Repo) GetRules(ctx context.Context, country string) ([]entity.TaxRule, error) {
+	qb := r.Builder.
+		Select(ruleColumns...).
+		From("tax_rules").
+		OrderBy("country", "id")
+	if country != "" {
+		qb = qb.Where(squirrel.Eq{"country": country})
+	}
+
+	return r.rules(ctx, qb)
+}
+
+func (r *Repo) UpdateRule(ctx context.Context, t *entity.TaxRule) (*entity.TaxRule, error) {
+	q, args, err := r.Builder.Update("tax_rules").
+		Set("name", t.Name).
+		Set("rate", t.Rate).
+		Set("reverse_charge", t.ReverseCharge).
+		Set("rounding", t.Rounding).
+		Set("updated_at", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Where(squirrel.Eq{"id": t.ID}).
+		Suffix("RETURNING updated_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("update tax rule query", slog.String("query", q))
+
+	if err := r.Pool.QueryRow(ctx, q, args...).Scan(&t.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, repoerrors.ErrConflict
+		}
+
+		slog.Error("failed to update tax rule", slog.Int("id", t.ID), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (r *Repo) DeleteRule(ctx context.Context, id int) error {
+	return r.delete(ctx, "tax_rules", squirrel.Eq{"id": id})
+}
+
+func (r *Repo) delete(ctx context.Context, table string, where squirrel.Eq) error {
+	q, args, err := r.Builder.Delete(table).
+		Where(where).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return err
+	}
+
+	tag, err := r.Pool.Exec(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to delete", slog.String("table", table), slog.String("error", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrors.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *Repo) rules(ctx context.Context, qb squirrel.SelectBuilder) ([]entity.TaxRule, error) {
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get tax rules query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get tax rules", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []entity.TaxRule
+	for rows.Next() {
+		var t entity.TaxRule
+		if err := rows.Scan(&t.ID, &t.Country, &t.Name, &t.Rate, &t.ReverseCharge, &t.Rounding, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			slog.Error("failed to scan tax rule", slog.String("error", err.Error()))
+			return nil, err
+		}
+		out = append(out, t)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,283 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/clickhouse"
+)
+
+type Repo struct {
+	*clickhouse.Client
+	sampleIntervalSec int
+}
+
+func NewRepo(ch *clickhouse.Client, sampleIntervalSec int) *Repo {
+	if sampleIntervalSec <= 0 {
+		sampleIntervalSec = 1
+	}
+
+	return &Repo{
+		Client:            ch,
+		sampleIntervalSec: sampleIntervalSec,
+	}
+}
+
+// Usage is read from the rollups of function_metrics_local, see the
+// function_metrics_rollups migration. The minute rollup is keyed by the sample
+// and the insertion minute, the hour rollup by the sample hour only.
+const (
+	minuteRollup = "function_metrics_1m"
+	hourRollup   = "function_metrics_1h"
+)
+
+// windowFilter keeps the minute buckets of an aligned entity.UsageWindow,
+// which are exactly the rows its Contains keeps.
+const windowFilter = `
+		tenant = ?
+		AND time_bucket < ?
+		AND inserted_bucket <= ?
+		AND NOT (time_bucket < ? AND inserted_bucket <= ?)
+`
+
+func windowArgs(tenant string, w entity.UsageWindow) []any {
+	return []any{tenant, w.End, w.Cutoff, w.PrevEnd, w.PrevCutoff}
+}
+
+// Rows written before replicas were reported have no replica and are rolled
+// up under the function name. Every raw row is one sample, so memory and CPU
+// are integrated over time by multiplying the sums by the sample interval.
+const usageQuery = `
+	SELECT
+		function_name,
+		replica_name,
+		min(first_seen) AS start_time,
+		max(last_seen) AS end_time,
+		sum(mem_mb) * ? AS memory_mb_sec,
+		sum(cpu_percent) / 100 * ? AS cpu_sec,
+		toFloat64(sum(requests)) AS requests
+	FROM ` + minuteRollup + `
+	WHERE` + windowFilter + `
+	GROUP BY function_name, replica_name
+	ORDER BY function_name, replica_name
+`
+
+func (r *Repo) GetUnbilled(ctx context.Context, tenant string, w entity.UsageWindow) ([]entity.Usage, error) {
+	slog.Debug("unbilled usage query", slog.String("tenant", tenant), slog.Time("end", w.End), slog.Time("cutoff", w.Cutoff))
+
+	args := append([]any{r.sampleIntervalSec, r.sampleIntervalSec}, windowArgs(tenant, w)...)
+	rows, err := r.Query(ctx, usageQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []entity.Usage
+	for rows.Next() {
+		var u entity.Usage
+		if err := rows.Scan(&u.Function, &u.Pod, &u.StartTime, &u.EndTime, &u.MemoryMBSec, &u.CPUSec, &u.Requests); err != nil {
+			return nil, err
+		}
+		result = append(result, u)
+	}
+
+	return result, rows.Err()
+}
+
+const firstUnbilledQuery = `
+	SELECT count(), min(first_seen)
+	FROM ` + minuteRollup + `
+	WHERE` + windowFilter
+
+func (r *Repo) FirstUnbilled(ctx context.Context, tenant string, w entity.UsageWindow) (time.Time, bool, error) {
+	rows, err := r.Query(ctx, firstUnbilledQuery, windowArgs(tenant, w)...)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer rows.Close()
+
+	var (
+		count uint64
+		first time.Time
+	)
+	if rows.Next() {
+		if err := rows.Scan(&count, &first); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	return first, count > 0, rows.Err()
+}
+
+const tenantsQuery = `SELECT DISTINCT tenant FROM ` + minuteRollup + ` WHERE tenant != '' ORDER BY tenant`
+
+func (r *Repo) Tenants(ctx context.Context) ([]string, error) {
+	rows, err := r.Query(ctx, tenantsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, rows.Err()
+}
+
+// usageDimensions and usageMetrics are the only expressions a usage query is
+// built from, user input never reaches the SQL text.
+var usageDimensions = map[string]string{
+	entity.UsageGroupFunction: "function_name",
+	entity.UsageGroupPod:      "replica_name",
+}
+
+var usageBuckets = map[string]string{
+	entity.UsageGroupDay:  "toStartOfDay(time_bucket, 'UTC')",
+	entity.UsageGroupHour: "toStartOfHour(time_bucket, 'UTC')",
+}
+
+// usageMetrics integrate the samples over time like usageQuery does, every
+// sample accounts for the sample interval. Requests are counted as is.
+var usageMetrics = map[string]string{
+	entity.UsageMetricMemory:   "toFloat64(sum(mem_mb)) * ?",
+	entity.UsageMetricCPU:      "toFloat64(sum(cpu_percent)) / 100 * ?",
+	entity.UsageMetricDuration: "toFloat64(sum(samples)) * ?",
+	entity.UsageMetricRequests: "toFloat64(sum(requests))",
+}
+
+// reportRollup picks the coarsest rollup whose buckets the range is made of.
+// The range is expected to be aligned to whole minutes at least.
+func reportRollup(q *entity.UsageQuery) string {
+	if q.From.Equal(q.From.Truncate(time.Hour)) && q.To.Equal(q.To.Truncate(time.Hour)) {
+		return hourRollup
+	}
+	return minuteRollup
+}
+
+// Report aggregates rolled up usage into one row per series and time bucket.
+// When grouped by function or pod the page of series is picked first, so a
+// series always comes back whole.
+func (r *Repo) Report(ctx context.Context, q *entity.UsageQuery) ([]entity.UsageRow, error) {
+	metric, ok := usageMetrics[q.Metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown usage metric %q", q.Metric)
+	}
+
+	function, pod, bucket := "''", "''", "toDateTime(?, 'UTC')"
+	bucketArgs := []any{q.From}
+	var keys []string
+	if q.Has(entity.UsageGroupFunction) {
+		function = usageDimensions[entity.UsageGroupFunction]
+		keys = append(keys, function)
+	}
+	if q.Has(entity.UsageGroupPod) {
+		pod = usageDimensions[entity.UsageGroupPod]
+		keys = append(keys, pod)
+	}
+	for group, expr := range usageBuckets {
+		if q.Has(group) {
+			bucket, bucketArgs = expr, nil
+		}
+	}
+
+	table := reportRollup(q)
+	where := "tenant = ? AND time_bucket >= ? AND time_bucket < ?"
+	whereArgs := []any{q.Tenant, q.From, q.To}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT " + function + " AS series_function, " + pod + " AS series_pod, " +
+		bucket + " AS bucket, " + metric + " AS value FROM " + table + " WHERE " + where)
+
+	args := bucketArgs
+	if strings.Contains(metric, "?") {
+		args = append(args, r.sampleIntervalSec)
+	}
+	args = append(args, whereArgs...)
+
+	if len(keys) > 0 {
+		tuple := "(" + strings.Join(keys, ", ") + ")"
+		sb.WriteString(" AND " + tuple + " IN (SELECT " + strings.Join(keys, ", ") +
+			" FROM " + table + " WHERE " + where +
+			" GROUP BY " + strings.Join(keys, ", ") +
+			" ORDER BY " + strings.Join(keys, ", ") + " LIMIT ? OFFSET ?)")
+		args = append(args, whereArgs...)
+		args = append(args, q.Limit, q.Offset)
+	}
+
+	sb.WriteString(" GROUP BY series_function, series_pod, bucket ORDER BY series_function, series_pod, bucket")
+
+	query := sb.String()
+	slog.Debug("usage report query", slog.String("query", query))
+
+	rows, err := r.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []entity.UsageRow
+	for rows.Next() {
+		var row entity.UsageRow
+		if err := rows.Scan(&row.Function, &row.Pod, &row.Bucket, &row.Value); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+// exportQuery reads the minute rollup merged over insertion minutes, one row
+// per pod and minute, integrated like usageQuery.
+const exportQuery = `
+	SELECT
+		tenant,
+		function_name,
+		replica_name,
+		time_bucket,
+		toFloat64(sum(samples)) * ? AS duration_sec,
+		sum(mem_mb) * ? AS memory_mb_sec,
+		sum(cpu_percent) / 100 * ? AS cpu_sec,
+		sum(requests) AS requests
+	FROM ` + minuteRollup + `
+	WHERE time_bucket >= ? AND time_bucket < ? AND (? = '' OR tenant = ?)
+	GROUP BY tenant, function_name, replica_name, time_bucket
+	ORDER BY tenant, function_name, replica_name, time_bucket
+`
+
+// Export streams the usage records of q to fn in order, without holding them
+// in memory. An error of fn stops the export and is returned.
+func (r *Repo) Export(ctx context.Context, q *entity.UsageExport, fn func(entity.UsageRecord) error) error {
+	slog.Debug("usage export query", slog.String("tenant", q.Tenant), slog.Time("from", q.From), slog.Time("to", q.To))
+
+	rows, err := r.Query(ctx, exportQuery,
+		r.sampleIntervalSec, r.sampleIntervalSec, r.sampleIntervalSec,
+		q.From, q.To, q.Tenant, q.Tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec entity.UsageRecord
+		if err := rows.Scan(&rec.Tenant, &rec.Function, &rec.Pod, &rec.Minute,
+			&rec.DurationSec, &rec.MemoryMBSec, &rec.CPUSec, &rec.Requests); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+// BudgetChecker periodically compares the tenants' budgets with their live
+// usage.
+type BudgetChecker struct {
+	budgets  service.Budget
+	interval time.Duration
+}
+
+func NewBudgetChecker(budgets service.Budget, interval time.Duration) *BudgetChecker {
+	return &BudgetChecker{
+		budgets:  budgets,
+		interval: interval,
+	}
+}
+
+func (b *BudgetChecker) Run(ctx context.Context) {
+	slog.Info("budget checker started", slog.Duration("interval", b.interval))
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.budgets.Check(ctx); err != nil {
+			slog.Error("failed to check some budgets", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("budget checker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
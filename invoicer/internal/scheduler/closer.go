@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+// PeriodCloser periodically issues invoices for billing periods that ended
+// and tells the tenants about them.
+type PeriodCloser struct {
+	billing       service.Billing
+	notifications service.Notification
+	interval      time.Duration
+}
+
+func NewPeriodCloser(billing service.Billing, notifications service.Notification, interval time.Duration) *PeriodCloser {
+	return &PeriodCloser{
+		billing:       billing,
+		notifications: notifications,
+		interval:      interval,
+	}
+}
+
+func (p *PeriodCloser) Run(ctx context.Context) {
+	slog.Info("period closer started", slog.Duration("interval", p.interval))
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.close(ctx)
+
+		select {
+		case <-ctx.Done():
+			slog.Info("period closer stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *PeriodCloser) close(ctx context.Context) {
+	issued, err := p.billing.CloseDue(ctx)
+	if err != nil {
+		slog.Error("failed to close some billing periods", slog.String("error", err.Error()))
+	}
+	if len(issued) > 0 {
+		slog.Info("closed billing periods", slog.Int("invoices", len(issued)))
+	}
+
+	// only the replica that issued an invoice gets it back, so it is
+	// announced once
+	for _, inv := range issued {
+		if err := p.notifications.NotifyInvoice(ctx, inv.Header); err != nil {
+			slog.Error("failed to send invoice notification",
+				slog.String("tenant", inv.Header.TenantID),
+				slog.Int64("number", inv.Header.Number),
+				slog.String("error", err.Error()))
+		}
+	}
+}
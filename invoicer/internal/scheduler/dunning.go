@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+// Dunning periodically marks unpaid invoices overdue and reminds tenants to
+// pay them.
+type Dunning struct {
+	payments service.Payment
+	interval time.Duration
+}
+
+func NewDunning(payments service.Payment, interval time.Duration) *Dunning {
+	return &Dunning{
+		payments: payments,
+		interval: interval,
+	}
+}
+
+func (d *Dunning) Run(ctx context.Context) {
+	slog.Info("dunning started", slog.Duration("interval", d.interval))
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.payments.Dun(ctx); err != nil {
+			slog.Error("failed to dun some invoices", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("dunning stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
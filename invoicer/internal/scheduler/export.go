@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+// UsageExporter periodically writes the usage of completed periods to the
+// export store.
+type UsageExporter struct {
+	export   service.Export
+	interval time.Duration
+}
+
+func NewUsageExporter(export service.Export, interval time.Duration) *UsageExporter {
+	return &UsageExporter{
+		export:   export,
+		interval: interval,
+	}
+}
+
+func (e *UsageExporter) Run(ctx context.Context) {
+	slog.Info("usage exporter started", slog.Duration("interval", e.interval))
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.export.ExportDue(ctx); err != nil {
+			slog.Error("failed to export some usage", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("usage exporter stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+// LedgerSync periodically debits prepaid wallets for issued invoices and
+// live usage.
+type LedgerSync struct {
+	ledger   service.Ledger
+	interval time.Duration
+}
+
+func NewLedgerSync(ledger service.Ledger, interval time.Duration) *LedgerSync {
+	return &LedgerSync{
+		ledger:   ledger,
+		interval: interval,
+	}
+}
+
+func (l *LedgerSync) Run(ctx context.Context) {
+	slog.Info("ledger sync started", slog.Duration("interval", l.interval))
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := l.ledger.Sync(ctx); err != nil {
+			slog.Error("failed to sync some wallets", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("ledger sync stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/service"
+)
+
+// OutboxRelay periodically publishes the outbox to Kafka.
+type OutboxRelay struct {
+	relay    service.Relay
+	interval time.Duration
+}
+
+func NewOutboxRelay(relay service.Relay, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		relay:    relay,
+		interval: interval,
+	}
+}
+
+func (o *OutboxRelay) Run(ctx context.Context) {
+	slog.Info("outbox relay started", slog.Duration("interval", o.interval))
+
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := o.relay.Relay(ctx)
+		if err != nil {
+			slog.Error("failed to publish some outbox messages", slog.String("error", err.Error()))
+		}
+		if n > 0 {
+			slog.Debug("published outbox messages", slog.Int("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// Adjustments lower the usage cost of an invoice. They are applied in a fixed
+// order, each one to what the previous ones left over:
+//
+//  1. Free allowances. Every tariff of the invoice waives its allowance once
+//     per dimension, the earliest charges priced with the tariff first.
+//  2. Discounts. A discount takes its percent off the charges it was in force
+//     for, prorated by time like a plan change.
+//  3. Credits. Credits valid during the invoice period pay what is left, the
+//     one expiring first is spent first.
+//
+// Every adjustment becomes an invoice line of its own.
+
+// chargeRef is a charge of the invoice and what is left of its amount after
+// the free allowances.
+type chargeRef struct {
+	charge *entity.Charge
+	net    money.Decimal
+}
+
+var hundred = money.NewFromInt(100)
+
+// adjust fetches the tenant's discounts and credits for the invoice period
+// and applies every adjustment to the invoice. What replaced, the invoice
+// being recalculated if any, took from the credits is available again.
+func (s *BillingService) adjust(ctx context.Context, inv *entity.Invoice, periods []tariffPeriod, replaced *entity.Invoice) error {
+	h := &inv.Header
+
+	discounts, err := s.tariffs.GetDiscounts(ctx, h.TenantID, h.PeriodStart, h.PeriodEnd)
+	if err != nil {
+		slog.Error("failed to get discounts", slog.String("tenant", h.TenantID), slog.String("error", err.Error()))
+		return err
+	}
+
+	credits, err := s.tariffs.GetCredits(ctx, h.TenantID, h.PeriodStart, h.PeriodEnd)
+	if err != nil {
+		slog.Error("failed to get credits", slog.String("tenant", h.TenantID), slog.String("error", err.Error()))
+		return err
+	}
+
+	var spent map[int]money.Decimal
+	if len(credits) > 0 {
+		spent, err = s.invoiceRepo.CreditsSpent(ctx, h.TenantID)
+		if err != nil {
+			slog.Error("failed to get spent credits", slog.String("tenant", h.TenantID), slog.String("error", err.Error()))
+			return err
+		}
+		if replaced != nil {
+			for _, a := range replaced.Adjustments {
+				if a.Kind == entity.AdjustmentCredit {
+					spent[a.SourceID] = spent[a.SourceID].Add(a.Amount)
+				}
+			}
+		}
+	}
+
+	return applyAdjustments(inv, periods, discounts, credits, spent, s.money)
+}
+
+// applyAdjustments adds the adjustment lines to a built invoice and settles
+// its amount due. spent holds what earlier invoices took from each credit.
+func applyAdjustments(inv *entity.Invoice, periods []tariffPeriod, discounts []entity.Discount, credits []entity.Credit, spent map[int]money.Decimal, policy money.Policy) error {
+	charges := invoiceCharges(inv)
+
+	adjustments := freeTier(charges, periods)
+	adjustments = append(adjustments, discountLines(charges, discounts)...)
+
+	due := inv.Header.Totals.TotalCost
+	for _, a := range adjustments {
+		due = due.Add(a.Amount)
+	}
+	credited, err := creditLines(due, inv.Header.PeriodStart, inv.Header.PeriodEnd, credits, spent, policy)
+	if err != nil {
+		return err
+	}
+	adjustments = append(adjustments, credited...)
+
+	total := money.Zero
+	for _, a := range adjustments {
+		total = total.Add(a.Amount)
+	}
+
+	inv.Adjustments = adjustments
+	inv.Header.AdjustmentTotal = total
+	inv.Header.AmountDue = policy.Round(inv.Header.Totals.TotalCost.Add(total)).Amount
+
+	return nil
+}
+
+// invoiceCharges lists the charges of the invoice oldest first, charges of
+// the same moment in function and pod order.
+func invoiceCharges(inv *entity.Invoice) []*chargeRef {
+	var out []*chargeRef
+	for i := range inv.Functions {
+		for j := range inv.Functions[i].Pods {
+			pod := &inv.Functions[i].Pods[j]
+			for k := range pod.Charges {
+				out = append(out, &chargeRef{charge: &pod.Charges[k], net: pod.Charges[k].Amount})
+			}
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].charge.From.Before(out[j].charge.From)
+	})
+
+	return out
+}
+
+// freeTier waives the free allowances of the invoice's tariffs, one line per
+// tariff and dimension. A price change does not renew the allowance, the
+// earliest version of the tariff in the invoice sets it.
+func freeTier(charges []*chargeRef, periods []tariffPeriod) []entity.Adjustment {
+	out := []entity.Adjustment{}
+	seen := make(map[int]bool)
+	for _, p := range periods {
+		t := p.tariff
+		if seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+
+		allowances := []struct {
+			dimension string
+			free      money.Decimal
+		}{
+			{entity.DimensionExec, t.FreeExecSec},
+			{entity.DimensionMemory, t.FreeMemMBSec},
+			{entity.DimensionCPU, t.FreeCPUSec},
+		}
+		for _, a := range allowances {
+			if !a.free.IsPositive() {
+				continue
+			}
+
+			line := entity.Adjustment{
+				Kind:        entity.AdjustmentFreeTier,
+				SourceID:    t.ID,
+				Dimension:   a.dimension,
+				Description: fmt.Sprintf("free %s allowance of tariff %s", a.dimension, t.Name),
+				Quantity:    money.Zero,
+				Amount:      money.Zero,
+			}
+			left := a.free
+			for _, c := range charges {
+				if !left.IsPositive() {
+					break
+				}
+				if c.charge.TariffID != t.ID || c.charge.Dimension != a.dimension || !c.charge.Quantity.IsPositive() {
+					continue
+				}
+
+				waived := left
+				if c.charge.Quantity.LessThan(waived) {
+					waived = c.charge.Quantity
+				}
+				amount := waived.Mul(c.charge.UnitPrice).Round(money.Scale)
+
+				left = left.Sub(waived)
+				c.net = c.net.Sub(amount)
+				line.Quantity = line.Quantity.Add(waived)
+				line.Unit = c.charge.Unit
+				line.Amount = line.Amount.Sub(amount)
+			}
+
+			if line.Quantity.IsPositive() {
+				out = append(out, line)
+			}
+		}
+	}
+
+	return out
+}
+
+// discountLines takes every discount off the part of the charges it covers.
+func discountLines(charges []*chargeRef, discounts []entity.Discount) []entity.Adjustment {
+	sort.Slice(discounts, func(i, j int) bool {
+		if !discounts[i].ValidFrom.Equal(discounts[j].ValidFrom) {
+			return discounts[i].ValidFrom.Before(discounts[j].ValidFrom)
+		}
+		return discounts[i].ID < discounts[j].ID
+	})
+
+	var out []entity.Adjustment
+	for _, d := range discounts {
+		base := money.Zero
+		for _, c := range charges {
+			base = base.Add(c.net.Mul(coveredShare(c.charge.From, c.charge.To, d.ValidFrom, d.ValidTo)))
+		}
+
+		amount := base.Mul(d.Percent).Div(hundred).Round(money.Scale)
+		if !amount.IsPositive() {
+			continue
+		}
+
+		description := d.Description
+		if description == "" {
+			description = fmt.Sprintf("%s%% discount", d.Percent)
+		}
+		out = append(out, entity.Adjustment{
+			Kind:        entity.AdjustmentDiscount,
+			SourceID:    d.ID,
+			Description: description,
+			Quantity:    d.Percent,
+			Unit:        "%",
+			Amount:      amount.Neg(),
+		})
+	}
+
+	return out
+}
+
+// coveredShare is the part of [from, to) that [validFrom, validTo) covers. A
+// charge of a single instant is covered wholly or not at all.
+func coveredShare(from, to, validFrom time.Time, validTo *time.Time) money.Decimal {
+	if !to.After(from) {
+		if !from.Before(validFrom) && (validTo == nil || from.Before(*validTo)) {
+			return money.NewFromInt(1)
+		}
+		return money.Zero
+	}
+
+	start, end := from, to
+	if validFrom.After(start) {
+		start = validFrom
+	}
+	if validTo != nil && validTo.Before(end) {
+		end = *validTo
+	}
+	if !end.After(start) {
+		return money.Zero
+	}
+
+	return money.NewFromInt(int64(end.Sub(start))).Div(money.NewFromInt(int64(to.Sub(from))))
+}
+
+// creditLines pays the amount due with the credits usable during [from, to),
+// the one expiring first is spent first.
+func creditLines(due money.Decimal, from, to time.Time, credits []entity.Credit, spent map[int]money.Decimal, policy money.Policy) ([]entity.Adjustment, error) {
+	sort.Slice(credits, func(i, j int) bool {
+		a, b := credits[i], credits[j]
+		switch {
+		case a.ExpiresAt == nil && b.ExpiresAt != nil:
+			return false
+		case a.ExpiresAt != nil && b.ExpiresAt == nil:
+			return true
+		case a.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt):
+			return a.ExpiresAt.Before(*b.ExpiresAt)
+		case !a.GrantedAt.Equal(b.GrantedAt):
+			return a.GrantedAt.Before(b.GrantedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	var out []entity.Adjustment
+	for _, c := range credits {
+		if !due.IsPositive() {
+			break
+		}
+		if c.Currency != "" && c.Currency != policy.Currency.Code {
+			return nil, fmt.Errorf("credit %d is in %s, invoices in %s: %w",
+				c.ID, c.Currency, policy.Currency.Code, money.ErrCurrencyMismatch)
+		}
+		if c.ExpiresAt != nil && !c.ExpiresAt.After(from) {
+			continue
+		}
+		if !c.GrantedAt.Before(to) && c.GrantedAt.After(from) {
+			continue
+		}
+
+		left := c.Amount.Sub(spent[c.ID])
+		if !left.IsPositive() {
+			continue
+		}
+		use := left
+		if due.LessThan(use) {
+			use = due
+		}
+		due = due.Sub(use)
+
+		description := c.Description
+		if description == "" {
+			description = fmt.Sprintf("promo credit %d", c.ID)
+		}
+		out = append(out, entity.Adjustment{
+			Kind:        entity.AdjustmentCredit,
+			SourceID:    c.ID,
+			Description: description,
+			Quantity:    use,
+			Unit:        policy.Currency.Code,
+			Amount:      use.Neg(),
+		})
+	}
+
+	return out, nil
+}
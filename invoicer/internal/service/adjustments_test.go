@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// adjustmentUsage runs two pods of hello, b starting 5 seconds after a:
+// 20 exec seconds for 10 and 500000 MB*s for 5000 with testTariff.
+func adjustmentUsage() *fakeUsageRepo {
+	return &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {
+			usageRow("hello", "hello-a", 1760900000, 1760900010, 300000, 0),
+			usageRow("hello", "hello-b", 1760900005, 1760900015, 200000, 0),
+		},
+	}}
+}
+
+func freeTierTariffs(exec, memory string) *fakeTariffs {
+	tariff := testTariff
+	tariff.FreeExecSec = dec(exec)
+	tariff.FreeMemMBSec = dec(memory)
+	return &fakeTariffs{tariffs: map[int]entity.Tariff{1: tariff}}
+}
+
+type wantAdjustment struct {
+	kind      string
+	sourceID  int
+	dimension string
+	quantity  string
+	amount    string
+}
+
+func assertAdjustments(t *testing.T, want []wantAdjustment, got []entity.Adjustment) {
+	t.Helper()
+
+	require.Len(t, got, len(want))
+	for i, w := range want {
+		assert.Equal(t, w.kind, got[i].Kind, "adjustment %d", i)
+		assert.Equal(t, w.sourceID, got[i].SourceID, "adjustment %d", i)
+		assert.Equal(t, w.dimension, got[i].Dimension, "adjustment %d", i)
+		assertDecimal(t, dec(w.quantity), got[i].Quantity, "adjustment %d quantity", i)
+		assertDecimal(t, dec(w.amount), got[i].Amount, "adjustment %d amount", i)
+	}
+}
+
+func Test_AdjustmentsFreeTier(t *testing.T) {
+	s := newTestBilling(adjustmentUsage(), freeTierTariffs("15", "400000"))
+
+	inv, err := s.Draft(context.Background(), "alice")
+	require.NoError(t, err)
+
+	// the earliest charges are waived first: all of a, then what is left of b
+	assertAdjustments(t, []wantAdjustment{
+		{kind: entity.AdjustmentFreeTier, sourceID: 1, dimension: entity.DimensionExec, quantity: "15", amount: "-7.5"},
+		{kind: entity.AdjustmentFreeTier, sourceID: 1, dimension: entity.DimensionMemory, quantity: "400000", amount: "-4000"},
+	}, inv.Adjustments)
+	assert.Equal(t, "s", inv.Adjustments[0].Unit)
+	assert.Equal(t, "MB*s", inv.Adjustments[1].Unit)
+
+	assertDecimal(t, dec("5010"), inv.Header.Totals.TotalCost, "the usage cost stays gross")
+	assertDecimal(t, dec("-4007.5"), inv.Header.AdjustmentTotal)
+	assertDecimal(t, dec("1002.5"), inv.Header.AmountDue)
+
+	t.Run("allowance above usage", func(t *testing.T) {
+		s := newTestBilling(adjustmentUsage(), freeTierTariffs("0", "1000000"))
+
+		inv, err := s.Draft(context.Background(), "alice")
+		require.NoError(t, err)
+
+		assertAdjustments(t, []wantAdjustment{
+			{kind: entity.AdjustmentFreeTier, sourceID: 1, dimension: entity.DimensionMemory, quantity: "500000", amount: "-5000"},
+		}, inv.Adjustments)
+		assertDecimal(t, dec("10"), inv.Header.AmountDue)
+	})
+}
+
+func Test_AdjustmentsOrder(t *testing.T) {
+	expiresSoon := time.Unix(1761000000, 0).UTC()
+	tariffs := freeTierTariffs("0", "400000")
+	tariffs.discounts = []entity.Discount{{ID: 3, Percent: dec("20"), ValidFrom: time.Unix(1760000000, 0).UTC()}}
+	tariffs.credits = []entity.Credit{
+		{ID: 1, Amount: dec("100"), Currency: "USD", GrantedAt: time.Unix(1760000000, 0).UTC()},
+		{ID: 2, Amount: dec("700"), Currency: "USD", GrantedAt: time.Unix(1760500000, 0).UTC(), ExpiresAt: &expiresSoon},
+	}
+	s := newTestBilling(adjustmentUsage(), tariffs)
+
+	inv, err := s.Draft(context.Background(), "alice")
+	require.NoError(t, err)
+
+	// 5010 - 4000 free = 1010, 20% off = 808, credits pay all but 8,
+	// the one expiring first is spent first
+	assertAdjustments(t, []wantAdjustment{
+		{kind: entity.AdjustmentFreeTier, sourceID: 1, dimension: entity.DimensionMemory, quantity: "400000", amount: "-4000"},
+		{kind: entity.AdjustmentDiscount, sourceID: 3, quantity: "20", amount: "-202"},
+		{kind: entity.AdjustmentCredit, sourceID: 2, quantity: "700", amount: "-700"},
+		{kind: entity.AdjustmentCredit, sourceID: 1, quantity: "100", amount: "-100"},
+	}, inv.Adjustments)
+	assert.Equal(t, "20% discount", inv.Adjustments[1].Description)
+	assert.Equal(t, "USD", inv.Adjustments[2].Unit)
+	assertDecimal(t, dec("8"), inv.Header.AmountDue)
+}
+
+func Test_AdjustmentsDiscountProrated(t *testing.T) {
+	tariffs := basicTariffs()
+	// starts when a stops and half way through b
+	tariffs.discounts = []entity.Discount{{ID: 1, Percent: dec("20"), Description: "partner", ValidFrom: time.Unix(1760900010, 0).UTC()}}
+	s := newTestBilling(adjustmentUsage(), tariffs)
+
+	inv, err := s.Draft(context.Background(), "alice")
+	require.NoError(t, err)
+
+	// half of b: 5 exec seconds for 2.5 and 100000 MB*s for 1000
+	assertAdjustments(t, []wantAdjustment{
+		{kind: entity.AdjustmentDiscount, sourceID: 1, quantity: "20", amount: "-200.5"},
+	}, inv.Adjustments)
+	assert.Equal(t, "partner", inv.Adjustments[0].Description)
+	assertDecimal(t, dec("4809.5"), inv.Header.AmountDue)
+}
+
+func Test_AdjustmentsCreditsCarryOver(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	expired := utc(2025, time.August, 20, 0)
+	tariffs := basicTariffs()
+	tariffs.credits = []entity.Credit{
+		{ID: 1, Amount: dec("15"), Currency: "USD", GrantedAt: utc(2025, time.September, 1, 0)},
+		{ID: 2, Amount: dec("100"), Currency: "USD", GrantedAt: utc(2025, time.August, 1, 0), ExpiresAt: &expired},
+	}
+	now := utc(2025, time.October, 1, 2)
+	s := NewBillingService(samples, invoices, newFakeTaxRepo(), newFakeFXRepo(), tariffs, BillingConfig{DefaultTariffID: 1, Period: PeriodMonth, Grace: time.Hour})
+	s.now = func() time.Time { return now }
+
+	// a single sample has no duration, so only its memory is billed: 10
+	samples.add("alice", "hello", utc(2025, time.September, 10, 0), utc(2025, time.September, 10, 0), 1000)
+	samples.add("alice", "hello", utc(2025, time.October, 10, 0), utc(2025, time.October, 10, 0), 1000)
+
+	issued, err := s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+	assertAdjustments(t, []wantAdjustment{
+		{kind: entity.AdjustmentCredit, sourceID: 1, quantity: "10", amount: "-10"},
+	}, issued[0].Adjustments)
+	assertDecimal(t, money.Zero, issued[0].Header.AmountDue)
+
+	// only what is left of the credit is spent
+	now = utc(2025, time.November, 1, 2)
+	issued, err = s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+	assertAdjustments(t, []wantAdjustment{
+		{kind: entity.AdjustmentCredit, sourceID: 1, quantity: "5", amount: "-5"},
+	}, issued[0].Adjustments)
+	assertDecimal(t, dec("5"), issued[0].Header.AmountDue)
+}
+
+func Test_AdjustmentsCreditCurrency(t *testing.T) {
+	tariffs := basicTariffs()
+	tariffs.credits = []entity.Credit{{ID: 1, Amount: dec("10"), Currency: "EUR", GrantedAt: time.Unix(1760000000, 0).UTC()}}
+	s := newTestBilling(adjustmentUsage(), tariffs)
+
+	_, err := s.Draft(context.Background(), "alice")
+	assert.ErrorIs(t, err, money.ErrCurrencyMismatch)
+}
+
+func Test_BillingTax(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+	tariffs := basicTariffs()
+	tariffs.discounts = []entity.Discount{{ID: 1, Percent: dec("10"), ValidFrom: time.Unix(1760000000, 0).UTC()}}
+
+	tests := []struct {
+		rate    string
+		wantTax string
+		wantDue string
+	}{
+		{rate: "0", wantTax: "0", wantDue: "13.5"},
+		{rate: "20", wantTax: "2.7", wantDue: "16.2"},
+		// 1.0125 is rounded on its own
+		{rate: "7.5", wantTax: "1.01", wantDue: "14.51"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rate, func(t *testing.T) {
+			taxes := newFakeTaxRepo()
+			taxes.profiles["alice"] = entity.BillingProfile{Tenant: "alice", Country: "NL"}
+			taxes.rules = []entity.TaxRule{{ID: 1, Country: "NL", Name: "BTW", Rate: dec(tt.rate)}}
+			s := NewBillingService(usage, newFakeInvoiceRepo(), taxes, newFakeFXRepo(), tariffs, BillingConfig{DefaultTariffID: 1})
+
+			inv, err := s.Draft(context.Background(), "alice")
+			require.NoError(t, err)
+
+			// tax is charged on 15 less the 10% discount
+			assertDecimal(t, dec("-1.5"), inv.Header.AdjustmentTotal)
+			assertDecimal(t, dec(tt.rate), inv.Header.TaxRate)
+			assertDecimal(t, dec(tt.wantTax), inv.Header.Tax)
+			assertDecimal(t, dec(tt.wantDue), inv.Header.AmountDue)
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
+)
+
+type BillingService struct {
+	usageRepo       repo.Usage
+	invoiceRepo     repo.Invoice
+	taxRepo         repo.Tax
+	fxRepo          repo.FX
+	tariffs         TariffProvider
+	defaultTariffID int
+	period          Period
+	grace           time.Duration
+	money           money.Policy
+	sellerCountry   string
+	paymentTerms    time.Duration
+	now             func() time.Time
+}
+
+type BillingConfig struct {
+	// DefaultTariffID prices usage not covered by any subscription.
+	DefaultTariffID int
+	Period          Period
+	// Grace is how long a period stays open after its end, so usage still in
+	// flight makes it into the invoice instead of the next one.
+	Grace time.Duration
+	// Money sets the invoice currency and how the amount due is rounded.
+	Money money.Policy
+	// SellerCountry is where the seller is registered for tax, reverse charge
+	// applies to customers of other countries only.
+	SellerCountry string
+	// PaymentTerms is how long tenants have to pay an issued invoice before
+	// it is overdue, zero for invoices that are never overdue.
+	PaymentTerms time.Duration
+}
+
+func NewBillingService(usageRepo repo.Usage, invoiceRepo repo.Invoice, taxRepo repo.Tax, fxRepo repo.FX, tariffs TariffProvider, cfg BillingConfig) *BillingService {
+	slog.Debug("component", slog.String("name", "billing service"))
+
+	if cfg.Period == "" {
+		cfg.Period = PeriodMonth
+	}
+	if cfg.Money.Currency.Code == "" {
+		cfg.Money = money.DefaultPolicy()
+	}
+
+	return &BillingService{
+		usageRepo:       usageRepo,
+		invoiceRepo:     invoiceRepo,
+		taxRepo:         taxRepo,
+		fxRepo:          fxRepo,
+		tariffs:         tariffs,
+		defaultTariffID: cfg.DefaultTariffID,
+		period:          cfg.Period,
+		grace:           cfg.Grace,
+		money:           cfg.Money,
+		sellerCountry:   cfg.SellerCountry,
+		paymentTerms:    cfg.PaymentTerms,
+		now:             time.Now,
+	}
+}
+
+// draftHorizon also takes in samples from agents whose clocks run ahead of ours.
+const draftHorizon = 24 * time.Hour
+
+// Draft prices the tenant's usage that no issued invoice covers yet.
+func (s *BillingService) Draft(ctx context.Context, tenant string) (*entity.Invoice, error) {
+	now := s.now()
+
+	w, err := s.nextWindow(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	w.End = now.Add(draftHorizon)
+	w.Cutoff = now.Add(draftHorizon)
+	w = w.Aligned()
+
+	return s.draft(ctx, tenant, w, now)
+}
+
+// MonthToDate prices the tenant's usage of the current calendar month (UTC)
+// as a single draft, whether invoices billed part of it already or not.
+// Usage sampled before the month is left out however late it arrived.
+func (s *BillingService) MonthToDate(ctx context.Context, tenant string) (*entity.Invoice, error) {
+	now := s.now()
+	horizon := now.Add(draftHorizon)
+
+	w := entity.UsageWindow{
+		End:        horizon,
+		Cutoff:     horizon,
+		PrevEnd:    PeriodMonth.Start(now),
+		PrevCutoff: horizon,
+	}.Aligned()
+
+	return s.draft(ctx, tenant, w, now)
+}
+
+// draft prices the usage of the window without issuing it.
+func (s *BillingService) draft(ctx context.Context, tenant string, w entity.UsageWindow, now time.Time) (*entity.Invoice, error) {
+	usage, err := s.usageRepo.GetUnbilled(ctx, tenant, w)
+	if err != nil {
+		slog.Error("failed to get usage", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if len(usage) == 0 {
+		return nil, ErrNoUsage
+	}
+
+	from, to := usagePeriod(usage)
+	periods, err := s.resolveTariffs(ctx, tenant, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := buildInvoice(tenant, usage, periods, s.money, now)
+	inv.Header.Status = entity.InvoiceStatusDraft
+	if err := s.adjust(ctx, inv, periods, nil); err != nil {
+		return nil, err
+	}
+	if err := s.settle(ctx, inv); err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// CloseDue issues invoices for every period that ended more than the grace
+// period ago. A failing tenant does not hold back the others.
+func (s *BillingService) CloseDue(ctx context.Context) ([]entity.Invoice, error) {
+	tenants, err := s.usageRepo.Tenants(ctx)
+	if err != nil {
+		slog.Error("failed to list tenants", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var (
+		issued []entity.Invoice
+		errs   []error
+	)
+	for _, tenant := range tenants {
+		invoices, err := s.closeTenant(ctx, tenant)
+		issued = append(issued, invoices...)
+		if err != nil {
+			slog.Error("failed to close billing period", slog.String("tenant", tenant), slog.String("error", err.Error()))
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant, err))
+		}
+	}
+
+	return issued, errors.Join(errs...)
+}
+
+func (s *BillingService) closeTenant(ctx context.Context, tenant string) ([]entity.Invoice, error) {
+	var issued []entity.Invoice
+	for {
+		now := s.now()
+
+		w, err := s.nextWindow(ctx, tenant)
+		if err != nil {
+			return issued, err
+		}
+		w.End = now.Add(draftHorizon)
+		w.Cutoff = now
+		w = w.Aligned()
+
+		first, ok, err := s.usageRepo.FirstUnbilled(ctx, tenant, w)
+		if err != nil || !ok {
+			return issued, err
+		}
+
+		// usage that arrived after its period was closed goes to the next one
+		start := s.period.Start(first)
+		if start.Before(w.PrevEnd) {
+			start = w.PrevEnd
+		}
+		end := s.period.Next(start)
+		if end.Add(s.grace).After(now) {
+			return issued, nil
+		}
+		w.End = end
+
+		usage, err := s.usageRepo.GetUnbilled(ctx, tenant, w)
+		if err != nil || len(usage) == 0 {
+			return issued, err
+		}
+
+		from, _ := usagePeriod(usage)
+		if from.After(start) {
+			from = start
+		}
+		periods, err := s.resolveTariffs(ctx, tenant, from, end)
+		if err != nil {
+			return issued, err
+		}
+
+		inv := buildInvoice(tenant, usage, periods, s.money, now)
+		inv.Header.Status = entity.InvoiceStatusIssued
+		inv.Header.PeriodStart = start
+		inv.Header.PeriodEnd = end
+		inv.Header.UsageCutoff = w.Cutoff
+		if err := s.adjust(ctx, inv, periods, nil); err != nil {
+			return issued, err
+		}
+		if err := s.settle(ctx, inv); err != nil {
+			return issued, err
+		}
+		s.terms(inv, now)
+
+		created, err := s.invoiceRepo.Create(ctx, inv)
+		if err != nil {
+			if errors.Is(err, repoerrors.ErrConflict) {
+				// another invoicer replica closed the period first
+				continue
+			}
+			return issued, err
+		}
+
+		slog.Info("issued invoice",
+			slog.Int64("number", created.Header.Number),
+			slog.String("tenant", tenant),
+			slog.Time("period_start", start),
+			slog.String("amount_due", created.Header.AmountDue.String()))
+		issued = append(issued, *created)
+	}
+}
+
+// terms sets when the issued invoice is due. Invoices with nothing to pay are
+// issued paid.
+func (s *BillingService) terms(inv *entity.Invoice, now time.Time) {
+	if !inv.Header.AmountDue.IsPositive() {
+		inv.Header.Status = entity.InvoiceStatusPaid
+		inv.Header.PaidAt = &now
+		return
+	}
+	if s.paymentTerms > 0 {
+		due := now.Add(s.paymentTerms)
+		inv.Header.DueAt = &due
+	}
+}
+
+// nextWindow starts a usage window right after the tenant's last invoice.
+func (s *BillingService) nextWindow(ctx context.Context, tenant string) (entity.UsageWindow, error) {
+	last, err := s.invoiceRepo.Last(ctx, tenant)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return entity.UsageWindow{}, nil
+		}
+		slog.Error("failed to get last invoice", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return entity.UsageWindow{}, err
+	}
+
+	return entity.UsageWindow{PrevEnd: last.PeriodEnd, PrevCutoff: last.UsageCutoff}, nil
+}
+
+func usagePeriod(usage []entity.Usage) (time.Time, time.Time) {
+	from, to := usage[0].StartTime, usage[0].EndTime
+	for _, u := range usage[1:] {
+		if u.StartTime.Before(from) {
+			from = u.StartTime
+		}
+		if u.EndTime.After(to) {
+			to = u.EndTime
+		}
+	}
+	return from, to
+}
+
+// buildInvoice charges every pod on its own, prices the charges of the whole
+// invoice together and rolls the pod totals up into its function and the
+// function totals into the header. Functions and pods are sorted by name so
+// the document is stable between calls.
+func buildInvoice(tenant string, usage []entity.Usage, periods []tariffPeriod, policy money.Policy, now time.Time) *entity.Invoice {
+	from, to := usagePeriod(usage)
+	inv := &entity.Invoice{
+		Header: entity.InvoiceHeader{
+			Kind:         entity.InvoiceKindInvoice,
+			TenantID:     tenant,
+			PeriodStart:  from,
+			PeriodEnd:    to,
+			Tariffs:      make([]entity.TariffRef, 0, len(periods)),
+			Currency:     policy.Currency.Code,
+			CalculatedAt: now,
+		},
+		Functions:   []entity.FunctionLine{},
+		Adjustments: []entity.Adjustment{},
+	}
+
+	for _, p := range periods {
+		inv.Header.Tariffs = append(inv.Header.Tariffs, entity.TariffRef{
+			ID:           p.tariff.ID,
+			Name:         p.tariff.Name,
+			Version:      p.tariff.Version,
+			ExecPrice:    p.tariff.ExecPrice,
+			MemPrice:     p.tariff.MemPrice,
+			CpuPrice:     p.tariff.CpuPrice,
+			RequestPrice: p.tariff.RequestPrice,
+			Tiers:        p.tariff.Tiers,
+			From:         p.from,
+			To:           p.to,
+		})
+	}
+
+	for _, u := range usage {
+		fn := inv.Function(u.Function)
+		if fn == nil {
+			inv.Functions = append(inv.Functions, entity.FunctionLine{Function: u.Function})
+			fn = &inv.Functions[len(inv.Functions)-1]
+		}
+		fn.Pods = append(fn.Pods, podLine(u, split(u, periods)))
+	}
+
+	sort.Slice(inv.Functions, func(i, j int) bool {
+		return inv.Functions[i].Function < inv.Functions[j].Function
+	})
+	for _, fn := range inv.Functions {
+		sort.Slice(fn.Pods, func(i, j int) bool {
+			return fn.Pods[i].Pod < fn.Pods[j].Pod
+		})
+	}
+
+	priceCharges(inv, periods)
+
+	for i := range inv.Functions {
+		fn := &inv.Functions[i]
+		for j := range fn.Pods {
+			pod := &fn.Pods[j]
+			for _, c := range pod.Charges {
+				pod.Totals.AddCharge(c)
+			}
+			fn.Totals.Add(pod.Totals)
+		}
+		inv.Header.Totals.Add(fn.Totals)
+	}
+	inv.Header.AmountDue = policy.Round(inv.Header.Totals.TotalCost).Amount
+
+	return inv
+}
+
+// podLine measures every segment of the pod under the tariff of its period.
+// Memory, CPU and requests are prorated by the share of time the segment
+// covers. The charges are priced later, with the rest of the invoice.
+func podLine(u entity.Usage, segments []segment) entity.PodLine {
+	line := entity.PodLine{
+		Pod:       u.Pod,
+		StartTime: u.StartTime,
+		EndTime:   u.EndTime,
+		Charges:   make([]entity.Charge, 0, 4*len(segments)),
+		Totals: entity.Totals{
+			DurationSec: u.DurationSec(),
+			MemoryMBSec: u.MemoryMBSec,
+			CPUSec:      u.CPUSec,
+			Requests:    u.Requests,
+		},
+	}
+
+	for _, seg := range segments {
+		charges := []entity.Charge{
+			charge(entity.DimensionExec, seg.to.Sub(seg.from).Seconds(), "s"),
+			charge(entity.DimensionMemory, u.MemoryMBSec*seg.fraction, "MB*s"),
+			charge(entity.DimensionCPU, u.CPUSec*seg.fraction, "CPU*s"),
+		}
+		// pods that served no requests keep the three charges they always had
+		if u.Requests > 0 {
+			charges = append(charges, charge(entity.DimensionRequests, u.Requests*seg.fraction, "requests"))
+		}
+
+		for _, c := range charges {
+			c.TariffID = seg.period.tariff.ID
+			c.TariffVersion = seg.period.tariff.Version
+			c.From = seg.from
+			c.To = seg.to
+			line.Charges = append(line.Charges, c)
+		}
+	}
+
+	return line
+}
+
+// charge measures a quantity. The quantity keeps money.Scale digits, the
+// precision it is stored with, so an issued invoice reads back exactly as it
+// was built.
+func charge(dimension string, quantity float64, unit string) entity.Charge {
+	return entity.Charge{
+		Dimension: dimension,
+		Quantity:  money.NewFromFloat(quantity).Round(money.Scale),
+		Unit:      unit,
+	}
+}
+
+// priceCharges prices the charges of every tariff and dimension together, so
+// tiers apply to the tenant's usage over the whole invoice rather than to
+// each pod. Charges fill the tiers oldest first, charges of the same moment
+// in function and pod order. Every version of a tariff has tiers of its own.
+// A charge crossing a graduated tier bound is replaced by one charge per tier.
+func priceCharges(inv *entity.Invoice, periods []tariffPeriod) {
+	type tariffKey struct {
+		id      int
+		version int
+	}
+
+	tariffs := make(map[tariffKey]*entity.Tariff, len(periods))
+	for _, p := range periods {
+		tariffs[tariffKey{p.tariff.ID, p.tariff.Version}] = p.tariff
+	}
+
+	type pricedKey struct {
+		tariff    tariffKey
+		dimension string
+	}
+
+	var keys []pricedKey
+	groups := make(map[pricedKey][]*entity.Charge)
+	for _, c := range invoiceCharges(inv) {
+		k := pricedKey{tariffKey{c.charge.TariffID, c.charge.TariffVersion}, c.charge.Dimension}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], c.charge)
+	}
+
+	priced := make(map[*entity.Charge][]pricing.Allocation)
+	for _, k := range keys {
+		price := pricing.Flat(money.Zero)
+		if t, ok := tariffs[k.tariff]; ok {
+			price = t.Price(k.dimension)
+		}
+
+		charges := groups[k]
+		quantities := make([]money.Decimal, len(charges))
+		for i, c := range charges {
+			quantities[i] = c.Quantity
+		}
+		for i, allocations := range price.Allocate(quantities) {
+			priced[charges[i]] = allocations
+		}
+	}
+
+	for i := range inv.Functions {
+		for j := range inv.Functions[i].Pods {
+			pod := &inv.Functions[i].Pods[j]
+
+			charges := make([]entity.Charge, 0, len(pod.Charges))
+			for k := range pod.Charges {
+				for _, a := range priced[&pod.Charges[k]] {
+					c := pod.Charges[k]
+					c.Tier = a.Tier
+					c.Quantity = a.Quantity
+					c.UnitPrice = a.UnitPrice
+					c.Amount = a.Amount
+					charges = append(charges, c)
+				}
+			}
+			pod.Charges = charges
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+var dec = money.MustParse
+
+type fakeUsageRepo struct {
+	usage map[string][]entity.Usage
+	err   error
+}
+
+func (f *fakeUsageRepo) GetUnbilled(_ context.Context, tenant string, _ entity.UsageWindow) ([]entity.Usage, error) {
+	return f.usage[tenant], f.err
+}
+
+func (f *fakeUsageRepo) FirstUnbilled(context.Context, string, entity.UsageWindow) (time.Time, bool, error) {
+	return time.Time{}, false, f.err
+}
+
+func (f *fakeUsageRepo) Tenants(context.Context) ([]string, error) {
+	return nil, f.err
+}
+
+func (f *fakeUsageRepo) Report(context.Context, *entity.UsageQuery) ([]entity.UsageRow, error) {
+	return nil, f.err
+}
+
+func (f *fakeUsageRepo) Export(context.Context, *entity.UsageExport, func(entity.UsageRecord) error) error {
+	return f.err
+}
+
+// fakeTariffs serves tariffs with a single version unless versions has some.
+type fakeTariffs struct {
+	tariffs       map[int]entity.Tariff
+	versions      map[int][]entity.Tariff
+	subscriptions []entity.Subscription
+	discounts     []entity.Discount
+	credits       []entity.Credit
+}
+
+func (f *fakeTariffs) GetTariffVersions(_ context.Context, id int, _, _ time.Time) ([]entity.Tariff, error) {
+	if v, ok := f.versions[id]; ok {
+		return append([]entity.Tariff(nil), v...), nil
+	}
+	t, ok := f.tariffs[id]
+	if !ok {
+		return nil, errors.New("price service returned status 404")
+	}
+	return []entity.Tariff{t}, nil
+}
+
+func (f *fakeTariffs) GetSubscriptions(_ context.Context, _ string, _, _ time.Time) ([]entity.Subscription, error) {
+	return f.subscriptions, nil
+}
+
+func (f *fakeTariffs) GetDiscounts(_ context.Context, _ string, _, _ time.Time) ([]entity.Discount, error) {
+	return f.discounts, nil
+}
+
+func (f *fakeTariffs) GetCredits(_ context.Context, _ string, _, _ time.Time) ([]entity.Credit, error) {
+	return f.credits, nil
+}
+
+func basicTariffs() *fakeTariffs {
+	return &fakeTariffs{tariffs: map[int]entity.Tariff{1: testTariff}}
+}
+
+var testTariff = entity.Tariff{ID: 1, Name: "basic", ExecPrice: dec("0.5"), MemPrice: dec("0.01"), CpuPrice: dec("0.2")}
+
+func usageRow(function, pod string, start, end int64, memMBSec, cpuSec float64) entity.Usage {
+	return entity.Usage{
+		Function:    function,
+		Pod:         pod,
+		StartTime:   time.Unix(start, 0).UTC(),
+		EndTime:     time.Unix(end, 0).UTC(),
+		MemoryMBSec: memMBSec,
+		CPUSec:      cpuSec,
+	}
+}
+
+type wantPod struct {
+	function string
+	pod      string
+	totals   entity.Totals
+}
+
+func Test_BillingInvoice(t *testing.T) {
+	now := time.Unix(1760900300, 0).UTC()
+
+	tests := []struct {
+		name       string
+		usage      []entity.Usage
+		wantPods   []wantPod
+		wantFuncs  map[string]entity.Totals
+		wantTotals entity.Totals
+		wantStart  int64
+		wantEnd    int64
+	}{
+		{
+			name:  "single pod",
+			usage: []entity.Usage{usageRow("hello", "hello-00001-a", 1760900000, 1760900010, 1000, 0)},
+			wantPods: []wantPod{
+				{function: "hello", pod: "hello-00001-a", totals: entity.Totals{DurationSec: 10, MemoryMBSec: 1000, ExecCost: dec("5"), MemoryCost: dec("10"), TotalCost: dec("15")}},
+			},
+			wantFuncs:  map[string]entity.Totals{"hello": {DurationSec: 10, MemoryMBSec: 1000, ExecCost: dec("5"), MemoryCost: dec("10"), TotalCost: dec("15")}},
+			wantTotals: entity.Totals{DurationSec: 10, MemoryMBSec: 1000, ExecCost: dec("5"), MemoryCost: dec("10"), TotalCost: dec("15")},
+			wantStart:  1760900000,
+			wantEnd:    1760900010,
+		},
+		{
+			// used to come back as two items named after the first pod, the second
+			// one carrying the cumulative totals of both
+			name: "pods are not cumulative",
+			usage: []entity.Usage{
+				usageRow("hello", "hello-00001-a", 1760900000, 1760900010, 1000, 0),
+				usageRow("hello", "hello-00001-b", 1760900005, 1760900025, 500, 0),
+			},
+			wantPods: []wantPod{
+				{function: "hello", pod: "hello-00001-a", totals: entity.Totals{DurationSec: 10, MemoryMBSec: 1000, ExecCost: dec("5"), MemoryCost: dec("10"), TotalCost: dec("15")}},
+				{function: "hello", pod: "hello-00001-b", totals: entity.Totals{DurationSec: 20, MemoryMBSec: 500, ExecCost: dec("10"), MemoryCost: dec("5"), TotalCost: dec("15")}},
+			},
+			wantFuncs:  map[string]entity.Totals{"hello": {DurationSec: 30, MemoryMBSec: 1500, ExecCost: dec("15"), MemoryCost: dec("15"), TotalCost: dec("30")}},
+			wantTotals: entity.Totals{DurationSec: 30, MemoryMBSec: 1500, ExecCost: dec("15"), MemoryCost: dec("15"), TotalCost: dec("30")},
+			wantStart:  1760900000,
+			wantEnd:    1760900025,
+		},
+		{
+			name: "several functions",
+			usage: []entity.Usage{
+				usageRow("resize", "resize-00002-x", 1760900100, 1760900104, 200, 0),
+				usageRow("hello", "hello-00001-a", 1760900000, 1760900010, 1000, 0),
+				usageRow("resize", "resize-00001-y", 1760899990, 1760899992, 0, 0),
+			},
+			wantPods: []wantPod{
+				{function: "hello", pod: "hello-00001-a", totals: entity.Totals{DurationSec: 10, MemoryMBSec: 1000, ExecCost: dec("5"), MemoryCost: dec("10"), TotalCost: dec("15")}},
+				{function: "resize", pod: "resize-00001-y", totals: entity.Totals{DurationSec: 2, ExecCost: dec("1"), TotalCost: dec("1")}},
+				{function: "resize", pod: "resize-00002-x", totals: entity.Totals{DurationSec: 4, MemoryMBSec: 200, ExecCost: dec("2"), MemoryCost: dec("2"), TotalCost: dec("4")}},
+			},
+			wantFuncs: map[string]entity.Totals{
+				"hello":  {DurationSec: 10, MemoryMBSec: 1000, ExecCost: dec("5"), MemoryCost: dec("10"), TotalCost: dec("15")},
+				"resize": {DurationSec: 6, MemoryMBSec: 200, ExecCost: dec("3"), MemoryCost: dec("2"), TotalCost: dec("5")},
+			},
+			wantTotals: entity.Totals{DurationSec: 16, MemoryMBSec: 1200, ExecCost: dec("8"), MemoryCost: dec("12"), TotalCost: dec("20")},
+			wantStart:  1760899990,
+			wantEnd:    1760900104,
+		},
+		{
+			name: "cpu heavy",
+			usage: []entity.Usage{
+				usageRow("encode", "encode-00001-a", 1760900000, 1760900010, 100, 15),
+				usageRow("encode", "encode-00001-b", 1760900000, 1760900010, 100, 5),
+			},
+			wantPods: []wantPod{
+				{function: "encode", pod: "encode-00001-a", totals: entity.Totals{DurationSec: 10, MemoryMBSec: 100, CPUSec: 15, ExecCost: dec("5"), MemoryCost: dec("1"), CPUCost: dec("3"), TotalCost: dec("9")}},
+				{function: "encode", pod: "encode-00001-b", totals: entity.Totals{DurationSec: 10, MemoryMBSec: 100, CPUSec: 5, ExecCost: dec("5"), MemoryCost: dec("1"), CPUCost: dec("1"), TotalCost: dec("7")}},
+			},
+			wantFuncs:  map[string]entity.Totals{"encode": {DurationSec: 20, MemoryMBSec: 200, CPUSec: 20, ExecCost: dec("10"), MemoryCost: dec("2"), CPUCost: dec("4"), TotalCost: dec("16")}},
+			wantTotals: entity.Totals{DurationSec: 20, MemoryMBSec: 200, CPUSec: 20, ExecCost: dec("10"), MemoryCost: dec("2"), CPUCost: dec("4"), TotalCost: dec("16")},
+			wantStart:  1760900000,
+			wantEnd:    1760900010,
+		},
+		{
+			name:  "single sample",
+			usage: []entity.Usage{usageRow("hello", "hello", 1760900000, 1760900000, 64, 0)},
+			wantPods: []wantPod{
+				{function: "hello", pod: "hello", totals: entity.Totals{MemoryMBSec: 64, MemoryCost: dec("0.64"), TotalCost: dec("0.64")}},
+			},
+			wantFuncs:  map[string]entity.Totals{"hello": {MemoryMBSec: 64, MemoryCost: dec("0.64"), TotalCost: dec("0.64")}},
+			wantTotals: entity.Totals{MemoryMBSec: 64, MemoryCost: dec("0.64"), TotalCost: dec("0.64")},
+			wantStart:  1760900000,
+			wantEnd:    1760900000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestBilling(&fakeUsageRepo{usage: map[string][]entity.Usage{"alice": tt.usage}}, basicTariffs())
+			s.now = func() time.Time { return now }
+
+			inv, err := s.Draft(context.Background(), "alice")
+			require.NoError(t, err)
+
+			assert.Equal(t, "alice", inv.Header.TenantID)
+			require.Len(t, inv.Header.Tariffs, 1)
+			assert.Equal(t, "basic", inv.Header.Tariffs[0].Name)
+			assert.Equal(t, now, inv.Header.CalculatedAt)
+			assert.Equal(t, tt.wantStart, inv.Header.PeriodStart.Unix())
+			assert.Equal(t, tt.wantEnd, inv.Header.PeriodEnd.Unix())
+			assertTotals(t, tt.wantTotals, inv.Header.Totals)
+
+			var gotPods []wantPod
+			for _, fn := range inv.Functions {
+				assertTotals(t, tt.wantFuncs[fn.Function], fn.Totals)
+				for _, pod := range fn.Pods {
+					gotPods = append(gotPods, wantPod{function: fn.Function, pod: pod.Pod, totals: pod.Totals})
+
+					require.Len(t, pod.Charges, 3)
+					assert.Equal(t, entity.DimensionExec, pod.Charges[0].Dimension)
+					assertDecimal(t, pod.Totals.ExecCost, pod.Charges[0].Amount)
+					assert.Equal(t, entity.DimensionMemory, pod.Charges[1].Dimension)
+					assertDecimal(t, pod.Totals.MemoryCost, pod.Charges[1].Amount)
+					assert.Equal(t, entity.DimensionCPU, pod.Charges[2].Dimension)
+					assertDecimal(t, pod.Totals.CPUCost, pod.Charges[2].Amount)
+				}
+			}
+			assert.Len(t, inv.Functions, len(tt.wantFuncs))
+
+			require.Len(t, gotPods, len(tt.wantPods))
+			for i, want := range tt.wantPods {
+				assert.Equal(t, want.function, gotPods[i].function)
+				assert.Equal(t, want.pod, gotPods[i].pod)
+				assertTotals(t, want.totals, gotPods[i].totals)
+			}
+		})
+	}
+}
+
+func Test_BillingInvoiceErrors(t *testing.T) {
+	t.Run("no usage", func(t *testing.T) {
+		s := newTestBilling(&fakeUsageRepo{}, basicTariffs())
+
+		_, err := s.Draft(context.Background(), "alice")
+		assert.ErrorIs(t, err, ErrNoUsage)
+	})
+
+	t.Run("clickhouse error", func(t *testing.T) {
+		queryErr := errors.New("code: 60, message: table does not exist")
+		s := newTestBilling(&fakeUsageRepo{err: queryErr}, basicTariffs())
+
+		_, err := s.Draft(context.Background(), "alice")
+		assert.ErrorIs(t, err, queryErr)
+	})
+
+	t.Run("unknown tariff", func(t *testing.T) {
+		usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+			"alice": {usageRow("hello", "hello", 1760900000, 1760900010, 1, 0)},
+		}}
+		s := newTestBilling(usage, &fakeTariffs{})
+
+		_, err := s.Draft(context.Background(), "alice")
+		assert.Error(t, err)
+	})
+
+	t.Run("tariff in another currency", func(t *testing.T) {
+		usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+			"alice": {usageRow("hello", "hello", 1760900000, 1760900010, 1, 0)},
+		}}
+		eur := testTariff
+		eur.Currency = "EUR"
+		s := newTestBilling(usage, &fakeTariffs{tariffs: map[int]entity.Tariff{1: eur}})
+
+		_, err := s.Draft(context.Background(), "alice")
+		assert.ErrorIs(t, err, money.ErrCurrencyMismatch)
+	})
+}
+
+func Test_BillingInvoiceSubCentPrices(t *testing.T) {
+	tariff := entity.Tariff{ID: 1, Name: "per-second", ExecPrice: dec("0.0000021"), MemPrice: dec("0.0000000035"), CpuPrice: dec("0.000024"), Currency: "USD"}
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {
+			usageRow("hello", "hello-00001-a", 1760900000, 1760900010, 1000, 0.1),
+			usageRow("hello", "hello-00001-b", 1760900000, 1760900010, 1000, 0.2),
+		},
+	}}
+
+	tests := []struct {
+		name          string
+		policy        money.Policy
+		wantAmountDue string
+	}{
+		{name: "cents", policy: money.DefaultPolicy(), wantAmountDue: "0"},
+		{name: "micro dollars", policy: money.Policy{Currency: money.USD, Precision: 6, Rounding: money.RoundHalfUp}, wantAmountDue: "0.000056"},
+		{name: "rounded up", policy: money.Policy{Currency: money.USD, Precision: 2, Rounding: money.RoundUp}, wantAmountDue: "0.01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewBillingService(usage, newFakeInvoiceRepo(), newFakeTaxRepo(), newFakeFXRepo(), &fakeTariffs{tariffs: map[int]entity.Tariff{1: tariff}},
+				BillingConfig{DefaultTariffID: 1, Money: tt.policy})
+
+			inv, err := s.Draft(context.Background(), "alice")
+			require.NoError(t, err)
+
+			// 0.1 + 0.2 CPU-seconds is not 0.3 in floats, the charges still add up exactly
+			assertTotals(t, entity.Totals{
+				DurationSec: 20,
+				MemoryMBSec: 2000,
+				CPUSec:      0.30000000000000004,
+				ExecCost:    dec("0.000042"),
+				MemoryCost:  dec("0.000007"),
+				CPUCost:     dec("0.0000072"),
+				TotalCost:   dec("0.0000562"),
+			}, inv.Header.Totals)
+			assert.Equal(t, "USD", inv.Header.Currency)
+			assertDecimal(t, dec(tt.wantAmountDue), inv.Header.AmountDue)
+		})
+	}
+}
+
+func newTestBilling(usage repo.Usage, tariffs TariffProvider) *BillingService {
+	return NewBillingService(usage, newFakeInvoiceRepo(), newFakeTaxRepo(), newFakeFXRepo(), tariffs, BillingConfig{DefaultTariffID: 1})
+}
+
+func assertDecimal(t *testing.T, want, got money.Decimal, msgAndArgs ...any) {
+	t.Helper()
+
+	assert.Equal(t, want.String(), got.String(), msgAndArgs...)
+}
+
+func assertTotals(t *testing.T, want, got entity.Totals) {
+	t.Helper()
+
+	assert.Equal(t, want.DurationSec, got.DurationSec)
+	assert.InDelta(t, want.MemoryMBSec, got.MemoryMBSec, 1e-9)
+	assertDecimal(t, want.ExecCost, got.ExecCost)
+	assertDecimal(t, want.MemoryCost, got.MemoryCost)
+	assert.InDelta(t, want.CPUSec, got.CPUSec, 1e-9)
+	assertDecimal(t, want.CPUCost, got.CPUCost)
+	assert.InDelta(t, want.Requests, got.Requests, 1e-9)
+	assertDecimal(t, want.RequestCost, got.RequestCost)
+	assertDecimal(t, want.TotalCost, got.TotalCost)
+}
+
+func Test_BillingInvoiceSubscriptions(t *testing.T) {
+	pro := entity.Tariff{ID: 2, Name: "pro", ExecPrice: dec("0.25"), MemPrice: dec("0.005"), CpuPrice: dec("0.1")}
+	at := func(ts int64) time.Time { return time.Unix(ts, 0).UTC() }
+	ptr := func(ts int64) *time.Time { t := at(ts); return &t }
+
+	tests := []struct {
+		name          string
+		usage         []entity.Usage
+		subscriptions []entity.Subscription
+		wantTariffs   []int
+		wantCharges   []entity.Charge
+		wantTotals    entity.Totals
+	}{
+		{
+			name:          "whole pod on a subscription",
+			usage:         []entity.Usage{usageRow("hello", "a", 1760900000, 1760900010, 1000, 10)},
+			subscriptions: []entity.Subscription{{TariffID: 2, ValidFrom: at(1760800000)}},
+			wantTariffs:   []int{2},
+			wantCharges: []entity.Charge{
+				{Dimension: entity.DimensionExec, TariffID: 2, Quantity: dec("10"), Amount: dec("2.5")},
+				{Dimension: entity.DimensionMemory, TariffID: 2, Quantity: dec("1000"), Amount: dec("5")},
+				{Dimension: entity.DimensionCPU, TariffID: 2, Quantity: dec("10"), Amount: dec("1")},
+			},
+			wantTotals: entity.Totals{DurationSec: 10, MemoryMBSec: 1000, CPUSec: 10, ExecCost: dec("2.5"), MemoryCost: dec("5"), CPUCost: dec("1"), TotalCost: dec("8.5")},
+		},
+		{
+			name:  "plan change in the middle of a pod",
+			usage: []entity.Usage{usageRow("hello", "a", 1760900000, 1760900010, 1000, 10)},
+			subscriptions: []entity.Subscription{
+				{TariffID: 1, ValidFrom: at(1760800000), ValidTo: ptr(1760900004)},
+				{TariffID: 2, ValidFrom: at(1760900004)},
+			},
+			wantTariffs: []int{1, 2},
+			wantCharges: []entity.Charge{
+				{Dimension: entity.DimensionExec, TariffID: 1, Quantity: dec("4"), Amount: dec("2")},
+				{Dimension: entity.DimensionMemory, TariffID: 1, Quantity: dec("400"), Amount: dec("4")},
+				{Dimension: entity.DimensionCPU, TariffID: 1, Quantity: dec("4"), Amount: dec("0.8")},
+				{Dimension: entity.DimensionExec, TariffID: 2, Quantity: dec("6"), Amount: dec("1.5")},
+				{Dimension: entity.DimensionMemory, TariffID: 2, Quantity: dec("600"), Amount: dec("3")},
+				{Dimension: entity.DimensionCPU, TariffID: 2, Quantity: dec("6"), Amount: dec("0.6")},
+			},
+			wantTotals: entity.Totals{DurationSec: 10, MemoryMBSec: 1000, CPUSec: 10, ExecCost: dec("3.5"), MemoryCost: dec("7"), CPUCost: dec("1.4"), TotalCost: dec("11.9")},
+		},
+		{
+			name:          "gap before the subscription uses the default tariff",
+			usage:         []entity.Usage{usageRow("hello", "a", 1760900000, 1760900010, 1000, 0)},
+			subscriptions: []entity.Subscription{{TariffID: 2, ValidFrom: at(1760900005)}},
+			wantTariffs:   []int{1, 2},
+			wantCharges: []entity.Charge{
+				{Dimension: entity.DimensionExec, TariffID: 1, Quantity: dec("5"), Amount: dec("2.5")},
+				{Dimension: entity.DimensionMemory, TariffID: 1, Quantity: dec("500"), Amount: dec("5")},
+				{Dimension: entity.DimensionCPU, TariffID: 1},
+				{Dimension: entity.DimensionExec, TariffID: 2, Quantity: dec("5"), Amount: dec("1.25")},
+				{Dimension: entity.DimensionMemory, TariffID: 2, Quantity: dec("500"), Amount: dec("2.5")},
+				{Dimension: entity.DimensionCPU, TariffID: 2},
+			},
+			wantTotals: entity.Totals{DurationSec: 10, MemoryMBSec: 1000, ExecCost: dec("3.75"), MemoryCost: dec("7.5"), TotalCost: dec("11.25")},
+		},
+		{
+			name:          "single sample on the boundary belongs to the new plan",
+			usage:         []entity.Usage{usageRow("hello", "a", 1760900005, 1760900005, 100, 1)},
+			subscriptions: []entity.Subscription{{TariffID: 2, ValidFrom: at(1760900005)}},
+			wantTariffs:   []int{2},
+			wantCharges: []entity.Charge{
+				{Dimension: entity.DimensionExec, TariffID: 2},
+				{Dimension: entity.DimensionMemory, TariffID: 2, Quantity: dec("100"), Amount: dec("0.5")},
+				{Dimension: entity.DimensionCPU, TariffID: 2, Quantity: dec("1"), Amount: dec("0.1")},
+			},
+			wantTotals: entity.Totals{MemoryMBSec: 100, CPUSec: 1, MemoryCost: dec("0.5"), CPUCost: dec("0.1"), TotalCost: dec("0.6")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tariffs := &fakeTariffs{
+				tariffs:       map[int]entity.Tariff{1: testTariff, 2: pro},
+				subscriptions: tt.subscriptions,
+			}
+			s := newTestBilling(&fakeUsageRepo{usage: map[string][]entity.Usage{"alice": tt.usage}}, tariffs)
+
+			inv, err := s.Draft(context.Background(), "alice")
+			require.NoError(t, err)
+
+			var gotTariffs []int
+			for _, ref := range inv.Header.Tariffs {
+				gotTariffs = append(gotTariffs, ref.ID)
+			}
+			assert.Equal(t, tt.wantTariffs, gotTariffs)
+
+			require.Len(t, inv.Functions, 1)
+			require.Len(t, inv.Functions[0].Pods, 1)
+			pod := inv.Functions[0].Pods[0]
+
+			require.Len(t, pod.Charges, len(tt.wantCharges))
+			for i, want := range tt.wantCharges {
+				got := pod.Charges[i]
+				assert.Equal(t, want.Dimension, got.Dimension, "charge %d", i)
+				assert.Equal(t, want.TariffID, got.TariffID, "charge %d", i)
+				assertDecimal(t, want.Quantity, got.Quantity, "charge %d", i)
+				assertDecimal(t, want.Amount, got.Amount, "charge %d", i)
+			}
+			assertTotals(t, tt.wantTotals, pod.Totals)
+			assertTotals(t, tt.wantTotals, inv.Header.Totals)
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// maxBudgetThreshold bounds a threshold, alerts past ten times the budget
+// tell nothing new.
+const maxBudgetThreshold = 1000
+
+type BudgetService struct {
+	budgetRepo   repo.Budget
+	billing      Billing
+	notification Notification
+	scaler       FunctionScaler
+	events       Publisher
+	now          func() time.Time
+
+	mu sync.Mutex
+	// published is the capped state last sent for a tenant
+	published map[string]bool
+}
+
+// NewBudgetService creates the budget service. Without a scaler budgets with
+// a hard cap do not stop running functions, control_plane still refuses to
+// start new ones.
+func NewBudgetService(budgetRepo repo.Budget, billing Billing, notification Notification, scaler FunctionScaler, events Publisher) *BudgetService {
+	slog.Debug("component", slog.String("name", "budget service"))
+
+	return &BudgetService{
+		budgetRepo:   budgetRepo,
+		billing:      billing,
+		notification: notification,
+		scaler:       scaler,
+		events:       events,
+		now:          time.Now,
+		published:    make(map[string]bool),
+	}
+}
+
+// BudgetInput creates or changes a budget, nil fields are left as they are.
+// The currency can be omitted, it must be the one the tenant is invoiced in.
+type BudgetInput struct {
+	Amount     *money.Decimal
+	Currency   string
+	Thresholds []int
+	HardCap    *bool
+}
+
+func (s *BudgetService) Create(ctx context.Context, tenant string, in *BudgetInput) (*entity.Budget, error) {
+	currency, err := s.billing.Currency(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &entity.Budget{
+		Tenant:     tenant,
+		Currency:   currency.Code,
+		Thresholds: entity.DefaultBudgetThresholds,
+	}
+	if in.Amount == nil {
+		return nil, fmt.Errorf("%w: amount is required", ErrInvalidBudget)
+	}
+	if err := s.apply(b, in); err != nil {
+		return nil, err
+	}
+
+	created, err := s.budgetRepo.Create(ctx, b)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrConflict) {
+			return nil, ErrBudgetExists
+		}
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// GetStatus returns the tenant's budget with the spend of the current month
+// and the alerts sent for it.
+func (s *BudgetService) GetStatus(ctx context.Context, tenant string) (*entity.BudgetStatus, error) {
+	b, err := s.get(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := s.status(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Alerts, err = s.budgetRepo.GetAlerts(ctx, b.ID, status.PeriodStart)
+	if err != nil {
+		return nil, err
+	}
+	if status.Alerts == nil {
+		status.Alerts = []entity.BudgetAlert{}
+	}
+
+	return status, nil
+}
+
+func (s *BudgetService) Update(ctx context.Context, tenant string, in *BudgetInput) (*entity.Budget, error) {
+	b, err := s.get(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(b, in); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.budgetRepo.Update(ctx, b)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrBudgetNotFound
+		}
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (s *BudgetService) Delete(ctx context.Context, tenant string) error {
+	if err := s.budgetRepo.Delete(ctx, tenant); err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return ErrBudgetNotFound
+		}
+		return err
+	}
+
+	// a deleted budget caps nothing
+	s.publish(ctx, tenant, false)
+	return nil
+}
+
+// Check compares every budget with the spend of the current month. The
+// highest threshold crossed since the last check is sent to the tenant, the
+// ones below it are only recorded. While the spend of a budget with a hard
+// cap is at or over the amount, control_plane is told to refuse running the
+// tenant's functions, and on every check the running ones are taken off the
+// ingress and scaled to zero, so a function started before control_plane
+// heard of the cap is stopped too. The cap lifts with the next month or a
+// larger budget. A failing budget does not hold back the others.
+func (s *BudgetService) Check(ctx context.Context) error {
+	budgets, err := s.budgetRepo.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, b := range budgets {
+		if err := s.check(ctx, &b); err != nil {
+			slog.Error("failed to check budget", slog.String("tenant", b.Tenant), slog.String("error", err.Error()))
+			errs = append(errs, fmt.Errorf("tenant %s: %w", b.Tenant, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *BudgetService) check(ctx context.Context, b *entity.Budget) error {
+	status, err := s.status(ctx, b)
+	if err != nil {
+		return err
+	}
+
+	var send *entity.BudgetAlert
+	for _, threshold := range b.Thresholds {
+		if !reached(status.Spend, b.Amount, threshold) {
+			break
+		}
+
+		alert := budgetAlert(b, status, entity.BudgetAlertThreshold, threshold)
+		taken, err := s.budgetRepo.AddAlert(ctx, &alert)
+		if err != nil {
+			return err
+		}
+		if taken {
+			send = &alert
+		}
+	}
+
+	capped := b.HardCap && reached(status.Spend, b.Amount, 100)
+	s.publish(ctx, b.Tenant, capped)
+	if capped {
+		alert, err := s.capTenant(ctx, b, status)
+		if err != nil {
+			return err
+		}
+		if alert != nil {
+			// the cap notice replaces the threshold one
+			send = alert
+		}
+	}
+
+	if send == nil {
+		return nil
+	}
+	if err := s.notification.NotifyBudget(ctx, *send); err != nil {
+		// release the alert, the next check sends it again
+		if err := s.budgetRepo.DeleteAlert(ctx, send); err != nil {
+			slog.Error("failed to release budget alert", slog.String("tenant", b.Tenant), slog.String("error", err.Error()))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// capTenant stops the tenant's functions and returns the cap alert when this
+// is the first cap of the month.
+func (s *BudgetService) capTenant(ctx context.Context, b *entity.Budget, status *entity.BudgetStatus) (*entity.BudgetAlert, error) {
+	if s.scaler == nil {
+		slog.Warn("budget hard cap reached but no control plane is configured", slog.String("tenant", b.Tenant))
+		return nil, nil
+	}
+
+	if err := s.scaler.ScaleToZero(ctx, b.Tenant); err != nil {
+		slog.Error("failed to scale tenant functions to zero", slog.String("tenant", b.Tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	alert := budgetAlert(b, status, entity.BudgetAlertHardCap, 100)
+	taken, err := s.budgetRepo.AddAlert(ctx, &alert)
+	if err != nil || !taken {
+		return nil, err
+	}
+
+	slog.Info("budget hard cap applied", slog.String("tenant", b.Tenant), slog.String("spend", status.Spend.String()), slog.String("currency", b.Currency))
+	return &alert, nil
+}
+
+// publish sends the tenant's capped state unless it was sent already. The
+// state of every budget is sent once after a restart.
+func (s *BudgetService) publish(ctx context.Context, tenant string, capped bool) {
+	s.mu.Lock()
+	last, ok := s.published[tenant]
+	s.mu.Unlock()
+	if ok && last == capped {
+		return
+	}
+
+	payload, err := json.Marshal(entity.BudgetCapEvent{
+		Tenant:    tenant,
+		Blocked:   capped,
+		Timestamp: s.now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to marshal budget cap event", slog.String("error", err.Error()))
+		return
+	}
+
+	// a lost event is sent again on the next check
+	if err := s.events.WriteMessages(ctx, gokafka.Message{Key: []byte(tenant), Value: payload}); err != nil {
+		slog.Error("failed to publish budget cap event", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	s.published[tenant] = capped
+	s.mu.Unlock()
+
+	slog.Info("published budget cap event", slog.String("tenant", tenant), slog.Bool("blocked", capped))
+}
+
+// status prices the current month of the budget's tenant.
+func (s *BudgetService) status(ctx context.Context, b *entity.Budget) (*entity.BudgetStatus, error) {
+	status := &entity.BudgetStatus{
+		Budget:      *b,
+		PeriodStart: PeriodMonth.Start(s.now()),
+		Spend:       money.Zero,
+	}
+
+	inv, err := s.billing.MonthToDate(ctx, b.Tenant)
+	if err != nil && !errors.Is(err, ErrNoUsage) {
+		return nil, err
+	}
+	if inv != nil {
+		if inv.Header.Currency != b.Currency {
+			return nil, fmt.Errorf("budget is in %s, invoices in %s: %w", b.Currency, inv.Header.Currency, money.ErrCurrencyMismatch)
+		}
+		status.Spend = budgetSpend(inv)
+	}
+	status.Percent = status.Spend.Mul(hundred).Div(b.Amount).Round(2)
+
+	return status, nil
+}
+
+func (s *BudgetService) get(ctx context.Context, tenant string) (*entity.Budget, error) {
+	b, err := s.budgetRepo.GetByTenant(ctx, tenant)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrBudgetNotFound
+		}
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// apply validates the input and copies it onto the budget.
+func (s *BudgetService) apply(b *entity.Budget, in *BudgetInput) error {
+	if in.Amount != nil {
+		if !in.Amount.IsPositive() {
+			return fmt.Errorf("%w: amount must be positive", ErrInvalidBudget)
+		}
+		b.Amount = *in.Amount
+	}
+
+	if in.Currency != "" {
+		currency, err := money.ParseCurrency(in.Currency)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidBudget, err)
+		}
+		if currency.Code != b.Currency {
+			return fmt.Errorf("%w: budgets are in the currency the tenant is invoiced in, %s", ErrInvalidBudget, b.Currency)
+		}
+	}
+
+	if in.Thresholds != nil {
+		thresholds, err := budgetThresholds(in.Thresholds)
+		if err != nil {
+			return err
+		}
+		b.Thresholds = thresholds
+	}
+
+	if in.HardCap != nil {
+		b.HardCap = *in.HardCap
+	}
+
+	return nil
+}
+
+// budgetThresholds sorts the thresholds and drops duplicates.
+func budgetThresholds(in []int) ([]int, error) {
+	if len(in) == 0 {
+		return nil, fmt.Errorf("%w: at least one threshold is required", ErrInvalidBudget)
+	}
+
+	out := make([]int, 0, len(in))
+	seen := make(map[int]bool, len(in))
+	for _, t := range in {
+		if t <= 0 || t > maxBudgetThreshold {
+			return nil, fmt.Errorf("%w: threshold %d is not in [1, %d]", ErrInvalidBudget, t, maxBudgetThreshold)
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Ints(out)
+
+	return out, nil
+}
+
+// budgetSpend is the usage cost after free allowances and discounts. Credits
+// pay for usage but do not make it cheaper, so they are not subtracted.
+func budgetSpend(inv *entity.Invoice) money.Decimal {
+	spend := inv.Header.Totals.TotalCost
+	for _, a := range inv.Adjustments {
+		if a.Kind != entity.AdjustmentCredit {
+			spend = spend.Add(a.Amount)
+		}
+	}
+	return spend
+}
+
+// reached tells whether spend is at least percent of amount.
+func reached(spend, amount money.Decimal, percent int) bool {
+	return spend.Mul(hundred).GreaterThanOrEqual(amount.Mul(money.NewFromInt(int64(percent))))
+}
+
+func budgetAlert(b *entity.Budget, status *entity.BudgetStatus, kind string, threshold int) entity.BudgetAlert {
+	return entity.BudgetAlert{
+		BudgetID:    b.ID,
+		Tenant:      b.Tenant,
+		PeriodStart: status.PeriodStart,
+		Kind:        kind,
+		Threshold:   threshold,
+		Spend:       status.Spend,
+		Amount:      b.Amount,
+		Currency:    b.Currency,
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/types"
+)
+
+type alertKey struct {
+	budgetID    int
+	periodStart time.Time
+	kind        string
+	threshold   int
+}
+
+type fakeBudgetRepo struct {
+	repo.Budget
+	budgets []entity.Budget
+	alerts  map[alertKey]entity.BudgetAlert
+}
+
+func (f *fakeBudgetRepo) Create(_ context.Context, b *entity.Budget) (*entity.Budget, error) {
+	for _, existing := range f.budgets {
+		if existing.Tenant == b.Tenant {
+			return nil, repoerrors.ErrConflict
+		}
+	}
+	b.ID = len(f.budgets) + 1
+	f.budgets = append(f.budgets, *b)
+	return b, nil
+}
+
+func (f *fakeBudgetRepo) Delete(_ context.Context, tenant string) error {
+	for i, b := range f.budgets {
+		if b.Tenant == tenant {
+			f.budgets = append(f.budgets[:i], f.budgets[i+1:]...)
+			return nil
+		}
+	}
+	return repoerrors.ErrNotFound
+}
+
+func (f *fakeBudgetRepo) GetAll(context.Context) ([]entity.Budget, error) {
+	return f.budgets, nil
+}
+
+func (f *fakeBudgetRepo) AddAlert(_ context.Context, a *entity.BudgetAlert) (bool, error) {
+	key := alertKey{a.BudgetID, a.PeriodStart, a.Kind, a.Threshold}
+	if _, ok := f.alerts[key]; ok {
+		return false, nil
+	}
+	f.alerts[key] = *a
+	return true, nil
+}
+
+func (f *fakeBudgetRepo) DeleteAlert(_ context.Context, a *entity.BudgetAlert) error {
+	delete(f.alerts, alertKey{a.BudgetID, a.PeriodStart, a.Kind, a.Threshold})
+	return nil
+}
+
+type fakeNotifier struct {
+	sent   []entity.BudgetAlert
+	dunned []entity.InvoiceHeader
+	err    error
+}
+
+func (f *fakeNotifier) NotifyStop(context.Context, types.Action) error {
+	return nil
+}
+
+func (f *fakeNotifier) NotifyBudget(_ context.Context, alert entity.BudgetAlert) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, alert)
+	return nil
+}
+
+func (f *fakeNotifier) NotifyInvoice(context.Context, entity.InvoiceHeader) error {
+	return nil
+}
+
+func (f *fakeNotifier) NotifyDunning(_ context.Context, h entity.InvoiceHeader, _ money.Decimal) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.dunned = append(f.dunned, h)
+	return nil
+}
+
+type fakeScaler struct {
+	tenants []string
+}
+
+func (f *fakeScaler) ScaleToZero(_ context.Context, tenant string) error {
+	f.tenants = append(f.tenants, tenant)
+	return nil
+}
+
+func newTestBudgets(usage *fakeUsageRepo, tariffs *fakeTariffs, notifier *fakeNotifier, scaler FunctionScaler) (*BudgetService, *fakeBudgetRepo) {
+	now := time.Unix(1760900300, 0).UTC()
+
+	billing := newTestBilling(usage, tariffs)
+	billing.now = func() time.Time { return now }
+
+	budgets := &fakeBudgetRepo{alerts: make(map[alertKey]entity.BudgetAlert)}
+	s := NewBudgetService(budgets, billing, notifier, scaler, &fakePublisher{})
+	s.now = billing.now
+
+	return s, budgets
+}
+
+func Test_BudgetCheck(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+	tariffs := basicTariffs()
+	// credits pay for usage, they do not lower the spend
+	tariffs.credits = []entity.Credit{{ID: 1, Amount: dec("100"), Currency: "USD", GrantedAt: time.Unix(1760000000, 0).UTC()}}
+	notifier := &fakeNotifier{}
+	scaler := &fakeScaler{}
+	s, budgets := newTestBudgets(usage, tariffs, notifier, scaler)
+
+	amount := dec("20")
+	hardCap := true
+	_, err := s.Create(context.Background(), "alice", &BudgetInput{Amount: &amount, HardCap: &hardCap})
+	require.NoError(t, err)
+
+	// 15 of 20 crosses 50%
+	require.NoError(t, s.Check(context.Background()))
+	require.Len(t, notifier.sent, 1)
+	assert.Equal(t, entity.BudgetAlertThreshold, notifier.sent[0].Kind)
+	assert.Equal(t, 50, notifier.sent[0].Threshold)
+	assertDecimal(t, dec("15"), notifier.sent[0].Spend)
+	assert.Equal(t, time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC), notifier.sent[0].PeriodStart)
+	assert.Empty(t, scaler.tenants)
+
+	// nothing new is crossed
+	require.NoError(t, s.Check(context.Background()))
+	assert.Len(t, notifier.sent, 1)
+
+	// 30 of 20 crosses 80% and 100% at once, the cap notice replaces both
+	usage.usage["alice"] = append(usage.usage["alice"], usageRow("hello", "hello-b", 1760900000, 1760900010, 1000, 0))
+	require.NoError(t, s.Check(context.Background()))
+	require.Len(t, notifier.sent, 2)
+	assert.Equal(t, entity.BudgetAlertHardCap, notifier.sent[1].Kind)
+	assertDecimal(t, dec("30"), notifier.sent[1].Spend)
+	assert.Equal(t, []string{"alice"}, scaler.tenants)
+	assert.Len(t, budgets.alerts, 4)
+
+	// functions started after the cap are stopped again, the tenant is told once
+	require.NoError(t, s.Check(context.Background()))
+	assert.Len(t, notifier.sent, 2)
+	assert.Equal(t, []string{"alice", "alice"}, scaler.tenants)
+}
+
+func Test_BudgetCapEvents(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+	s, budgets := newTestBudgets(usage, basicTariffs(), &fakeNotifier{}, &fakeScaler{})
+	events := s.events.(*fakePublisher)
+	ctx := context.Background()
+
+	blocked := func() []bool {
+		var out []bool
+		for _, m := range events.messages {
+			var e entity.BudgetCapEvent
+			require.NoError(t, json.Unmarshal(m.Value, &e))
+			assert.Equal(t, "alice", e.Tenant)
+			assert.Equal(t, e.Tenant, string(m.Key))
+			out = append(out, e.Blocked)
+		}
+		return out
+	}
+
+	amount := dec("10")
+	hardCap := true
+	_, err := s.Create(ctx, "alice", &BudgetInput{Amount: &amount, HardCap: &hardCap})
+	require.NoError(t, err)
+
+	// 15 of 10, control_plane refuses to run alice's functions, told once
+	require.NoError(t, s.Check(ctx))
+	require.NoError(t, s.Check(ctx))
+	assert.Equal(t, []bool{true}, blocked())
+
+	// a larger budget lifts the cap
+	budgets.budgets[0].Amount = dec("100")
+	require.NoError(t, s.Check(ctx))
+	assert.Equal(t, []bool{true, false}, blocked())
+
+	// so does deleting the budget
+	budgets.budgets[0].Amount = dec("10")
+	require.NoError(t, s.Check(ctx))
+	require.NoError(t, s.Delete(ctx, "alice"))
+	assert.Equal(t, []bool{true, false, true, false}, blocked())
+}
+
+func Test_BudgetAlertRetry(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+	notifier := &fakeNotifier{err: errors.New("kafka is down")}
+	s, budgets := newTestBudgets(usage, basicTariffs(), notifier, nil)
+
+	amount := dec("10")
+	hardCap := true
+	_, err := s.Create(context.Background(), "alice", &BudgetInput{Amount: &amount, HardCap: &hardCap})
+	require.NoError(t, err)
+
+	// without a control plane the cap only alerts
+	assert.Error(t, s.Check(context.Background()))
+	assert.Len(t, budgets.alerts, 2)
+
+	notifier.err = nil
+	require.NoError(t, s.Check(context.Background()))
+	require.Len(t, notifier.sent, 1)
+	assert.Equal(t, 100, notifier.sent[0].Threshold)
+
+	// a tenant without usage spent nothing
+	_, err = s.Create(context.Background(), "bob", &BudgetInput{Amount: &amount})
+	require.NoError(t, err)
+	require.NoError(t, s.Check(context.Background()))
+	assert.Len(t, notifier.sent, 1)
+}
+
+func Test_BudgetValidation(t *testing.T) {
+	s, _ := newTestBudgets(&fakeUsageRepo{}, basicTariffs(), &fakeNotifier{}, nil)
+	amount := dec("10")
+
+	b, err := s.Create(context.Background(), "alice", &BudgetInput{Amount: &amount, Currency: "usd", Thresholds: []int{100, 50, 50}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{50, 100}, b.Thresholds)
+	assert.Equal(t, "USD", b.Currency)
+
+	_, err = s.Create(context.Background(), "alice", &BudgetInput{Amount: &amount})
+	assert.ErrorIs(t, err, ErrBudgetExists)
+
+	zero := dec("0")
+	tests := []struct {
+		name string
+		in   BudgetInput
+	}{
+		{name: "no amount", in: BudgetInput{}},
+		{name: "zero amount", in: BudgetInput{Amount: &zero}},
+		{name: "other currency", in: BudgetInput{Amount: &amount, Currency: "EUR"}},
+		{name: "no thresholds", in: BudgetInput{Amount: &amount, Thresholds: []int{}}},
+		{name: "zero threshold", in: BudgetInput{Amount: &amount, Thresholds: []int{0, 50}}},
+		{name: "threshold too high", in: BudgetInput{Amount: &amount, Thresholds: []int{1001}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Create(context.Background(), "bob", &tt.in)
+			assert.ErrorIs(t, err, ErrInvalidBudget)
+		})
+	}
+}
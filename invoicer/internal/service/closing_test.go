@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// sample is a single function_metrics_local row.
+type sample struct {
+	tenant     string
+	pod        string
+	ts         time.Time
+	insertedAt time.Time
+	memMB      float64
+	cpuPercent float64
+	requests   float64
+}
+
+// fakeSamples aggregates rows like the ClickHouse queries do, so the usage
+// windows are exercised for real.
+type fakeSamples struct {
+	rows []sample
+}
+
+func (f *fakeSamples) add(tenant, pod string, ts, insertedAt time.Time, memMB float64) {
+	f.rows = append(f.rows, sample{tenant: tenant, pod: pod, ts: ts, insertedAt: insertedAt, memMB: memMB})
+}
+
+// cpuSec integrates the summed CPU percentages of one second samples.
+func cpuSec(percent float64) float64 {
+	return percent / 100
+}
+
+func (f *fakeSamples) GetUnbilled(_ context.Context, tenant string, w entity.UsageWindow) ([]entity.Usage, error) {
+	byPod := make(map[string]*entity.Usage)
+	for _, r := range f.rows {
+		if r.tenant != tenant || !w.Contains(r.ts, r.insertedAt) {
+			continue
+		}
+		u, ok := byPod[r.pod]
+		if !ok {
+			u = &entity.Usage{Function: r.pod, Pod: r.pod, StartTime: r.ts, EndTime: r.ts}
+			byPod[r.pod] = u
+		}
+		if r.ts.Before(u.StartTime) {
+			u.StartTime = r.ts
+		}
+		if r.ts.After(u.EndTime) {
+			u.EndTime = r.ts
+		}
+		u.MemoryMBSec += r.memMB
+		u.CPUSec += r.cpuPercent
+		u.Requests += r.requests
+	}
+
+	var out []entity.Usage
+	for _, u := range byPod {
+		u.CPUSec = cpuSec(u.CPUSec)
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Pod < out[j].Pod })
+	return out, nil
+}
+
+func (f *fakeSamples) FirstUnbilled(_ context.Context, tenant string, w entity.UsageWindow) (time.Time, bool, error) {
+	var (
+		first time.Time
+		found bool
+	)
+	for _, r := range f.rows {
+		if r.tenant == tenant && w.Contains(r.ts, r.insertedAt) && (!found || r.ts.Before(first)) {
+			first, found = r.ts, true
+		}
+	}
+	return first, found, nil
+}
+
+func (f *fakeSamples) Tenants(context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	var out []string
+	for _, r := range f.rows {
+		if _, ok := seen[r.tenant]; !ok {
+			seen[r.tenant] = struct{}{}
+			out = append(out, r.tenant)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (f *fakeSamples) Report(context.Context, *entity.UsageQuery) ([]entity.UsageRow, error) {
+	return nil, nil
+}
+
+func (f *fakeSamples) Export(context.Context, *entity.UsageExport, func(entity.UsageRecord) error) error {
+	return nil
+}
+
+type fakeInvoiceRepo struct {
+	invoices []entity.Invoice
+	payments []entity.Payment
+}
+
+func newFakeInvoiceRepo() *fakeInvoiceRepo {
+	return &fakeInvoiceRepo{}
+}
+
+func (f *fakeInvoiceRepo) Create(_ context.Context, inv *entity.Invoice) (*entity.Invoice, error) {
+	for _, existing := range f.invoices {
+		if existing.Header.TenantID == inv.Header.TenantID && existing.Header.PeriodStart.Equal(inv.Header.PeriodStart) &&
+			!isNote(existing.Header) && !isNote(inv.Header) &&
+			existing.Header.Status != entity.InvoiceStatusVoid {
+			return nil, repoerrors.ErrConflict
+		}
+	}
+	inv.Header.Number = int64(len(f.invoices) + 1)
+	f.invoices = append(f.invoices, *inv)
+	return inv, nil
+}
+
+func isNote(h entity.InvoiceHeader) bool {
+	return h.Kind == entity.InvoiceKindCreditNote || h.Kind == entity.InvoiceKindDebitNote
+}
+
+func (f *fakeInvoiceRepo) Last(_ context.Context, tenant string) (*entity.InvoiceHeader, error) {
+	var last *entity.InvoiceHeader
+	for i := range f.invoices {
+		h := &f.invoices[i].Header
+		if h.TenantID == tenant && (last == nil || h.PeriodEnd.After(last.PeriodEnd)) {
+			last = h
+		}
+	}
+	if last == nil {
+		return nil, repoerrors.ErrNotFound
+	}
+	return last, nil
+}
+
+func (f *fakeInvoiceRepo) GetAll(context.Context, *entity.InvoiceFilters) ([]entity.InvoiceHeader, error) {
+	var out []entity.InvoiceHeader
+	for _, inv := range f.invoices {
+		out = append(out, inv.Header)
+	}
+	return out, nil
+}
+
+func (f *fakeInvoiceRepo) GetByNumber(_ context.Context, number int64) (*entity.Invoice, error) {
+	for i := range f.invoices {
+		if f.invoices[i].Header.Number == number {
+			return &f.invoices[i], nil
+		}
+	}
+	return nil, repoerrors.ErrNotFound
+}
+
+func (f *fakeInvoiceRepo) CreditsSpent(_ context.Context, tenant string) (map[int]money.Decimal, error) {
+	spent := make(map[int]money.Decimal)
+	for _, inv := range f.invoices {
+		if inv.Header.TenantID != tenant || inv.Header.Status == entity.InvoiceStatusVoid {
+			continue
+		}
+		for _, a := range inv.Adjustments {
+			if a.Kind == entity.AdjustmentCredit {
+				spent[a.SourceID] = spent[a.SourceID].Sub(a.Amount)
+			}
+		}
+	}
+	return spent, nil
+}
+
+func utc(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func newClosingBilling(samples repo.Usage, invoices *fakeInvoiceRepo, now *time.Time) *BillingService {
+	s := NewBillingService(samples, invoices, newFakeTaxRepo(), newFakeFXRepo(), basicTariffs(), BillingConfig{
+		DefaultTariffID: 1,
+		Period:          PeriodMonth,
+		Grace:           time.Hour,
+	})
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func Test_BillingCloseDue(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	now := utc(2025, time.November, 1, 0)
+	s := newClosingBilling(samples, invoices, &now)
+
+	samples.add("alice", "hello", utc(2025, time.September, 30, 10), utc(2025, time.September, 30, 10), 100)
+	samples.add("alice", "hello", utc(2025, time.October, 3, 10), utc(2025, time.October, 3, 10), 10)
+	samples.add("alice", "hello", utc(2025, time.October, 31, 23), utc(2025, time.October, 31, 23), 20)
+	samples.add("bob", "resize", utc(2025, time.October, 15, 0), utc(2025, time.October, 15, 0), 5)
+	// the open period is never closed
+	samples.add("alice", "hello", utc(2025, time.November, 1, 0), utc(2025, time.November, 1, 0), 1000)
+
+	issued, err := s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, issued, 1, "october is still within its grace period")
+	assert.Equal(t, utc(2025, time.September, 1, 0), issued[0].Header.PeriodStart)
+
+	now = utc(2025, time.November, 1, 2)
+	issued, err = s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, issued, 2)
+
+	var numbers []int64
+	for _, inv := range invoices.invoices {
+		numbers = append(numbers, inv.Header.Number)
+		assert.Equal(t, entity.InvoiceStatusIssued, inv.Header.Status)
+	}
+	assert.Equal(t, []int64{1, 2, 3}, numbers)
+
+	october := invoices.invoices[1]
+	assert.Equal(t, "alice", october.Header.TenantID)
+	assert.Equal(t, utc(2025, time.October, 1, 0), october.Header.PeriodStart)
+	assert.Equal(t, utc(2025, time.November, 1, 0), october.Header.PeriodEnd)
+	assert.InDelta(t, 30, october.Header.Totals.MemoryMBSec, 1e-9)
+
+	assert.Equal(t, "bob", invoices.invoices[2].Header.TenantID)
+
+	// nothing else is due
+	issued, err = s.CloseDue(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, issued)
+}
+
+func Test_BillingLateUsageRollsIntoNextPeriod(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	now := utc(2025, time.November, 2, 0)
+	s := newClosingBilling(samples, invoices, &now)
+
+	samples.add("alice", "hello", utc(2025, time.October, 10, 0), utc(2025, time.October, 10, 0), 10)
+
+	_, err := s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, invoices.invoices, 1)
+
+	// a sample from october shows up after its invoice was issued
+	samples.add("alice", "hello", utc(2025, time.October, 31, 12), utc(2025, time.November, 3, 0), 7)
+	samples.add("alice", "hello", utc(2025, time.November, 20, 0), utc(2025, time.November, 20, 0), 3)
+
+	now = utc(2025, time.November, 25, 0)
+	draft, err := s.Draft(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.Equal(t, entity.InvoiceStatusDraft, draft.Header.Status)
+	assert.InDelta(t, 10, draft.Header.Totals.MemoryMBSec, 1e-9, "the draft holds the late and the new sample")
+
+	now = utc(2025, time.December, 2, 0)
+	issued, err := s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+
+	november := issued[0]
+	assert.Equal(t, utc(2025, time.November, 1, 0), november.Header.PeriodStart)
+	assert.InDelta(t, 10, november.Header.Totals.MemoryMBSec, 1e-9)
+
+	october := invoices.invoices[0]
+	assert.InDelta(t, 10, october.Header.Totals.MemoryMBSec, 1e-9, "issued invoices never change")
+
+	_, err = s.Draft(context.Background(), "alice")
+	assert.ErrorIs(t, err, ErrNoUsage)
+}
+
+func Test_PeriodBounds(t *testing.T) {
+	ts := time.Date(2025, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+	tests := []struct {
+		period    Period
+		wantStart time.Time
+		wantNext  time.Time
+	}{
+		{PeriodMonth, utc(2025, time.December, 1, 0), utc(2026, time.January, 1, 0)},
+		{PeriodDay, utc(2025, time.December, 31, 0), utc(2026, time.January, 1, 0)},
+		{PeriodHour, utc(2025, time.December, 31, 23), utc(2026, time.January, 1, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.period), func(t *testing.T) {
+			start := tt.period.Start(ts)
+			assert.Equal(t, tt.wantStart, start)
+			assert.Equal(t, tt.wantNext, tt.period.Next(start))
+		})
+	}
+
+	_, err := ParsePeriod("week")
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package service
+
+import "errors"
+
+var (
+	ErrNoUsage = errors.New("no usage found for tenant")
+	// ErrNoTariffPrices is wrapped with the tariff that has no versions.
+	ErrNoTariffPrices  = errors.New("tariff has no prices in force")
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	// ErrInvalidUsageQuery is wrapped with what is wrong with the query.
+	ErrInvalidUsageQuery = errors.New("invalid usage query")
+	ErrBudgetNotFound    = errors.New("budget not found")
+	ErrBudgetExists      = errors.New("tenant already has a budget")
+	// ErrInvalidBudget is wrapped with what is wrong with the budget.
+	ErrInvalidBudget = errors.New("invalid budget")
+	// ErrInvalidLedgerInput is wrapped with what is wrong with the posting.
+	ErrInvalidLedgerInput  = errors.New("invalid ledger posting")
+	ErrWalletNotFound      = errors.New("wallet not found")
+	ErrInsufficientBalance = errors.New("wallet balance is too low")
+	ErrIdempotencyConflict = errors.New("idempotency key was used for a different posting")
+	ErrProfileNotFound     = errors.New("billing profile not found")
+	// ErrInvalidProfile is wrapped with what is wrong with the profile.
+	ErrInvalidProfile  = errors.New("invalid billing profile")
+	ErrTaxRuleNotFound = errors.New("tax rule not found")
+	ErrTaxRuleExists   = errors.New("country already has a tax of that name")
+	// ErrInvalidTaxRule is wrapped with what is wrong with the rule.
+	ErrInvalidTaxRule = errors.New("invalid tax rule")
+	// ErrInvalidFXRate is wrapped with what is wrong with the rate.
+	ErrInvalidFXRate = errors.New("invalid exchange rate")
+	// ErrNoFXRate is wrapped with the pair and date that have no rate.
+	ErrNoFXRate = errors.New("no exchange rate")
+	// ErrInvoiceNotOpen is returned for paying or voiding invoices that are
+	// paid or void already.
+	ErrInvoiceNotOpen          = errors.New("invoice is not open")
+	ErrPaymentProviderNotFound = errors.New("payment provider not found")
+	// ErrInvalidWebhook is wrapped with why the provider rejected the call.
+	ErrInvalidWebhook = errors.New("invalid payment webhook")
+	// ErrInvalidPayment is wrapped with what is wrong with the payment.
+	ErrInvalidPayment = errors.New("invalid payment")
+	// ErrInvalidRecalc is wrapped with what is wrong with the recalculation.
+	ErrInvalidRecalc   = errors.New("invalid recalculation")
+	ErrNothingToCredit = errors.New("invoice was not overcharged")
+	ErrNothingToDebit  = errors.New("invoice was not undercharged")
+	// ErrInvoiceCredited is returned for correcting invoices that have
+	// credit or debit notes, the difference is settled with another note.
+	ErrInvoiceCredited = errors.New("invoice has credit or debit notes")
+	// ErrInvalidExport is wrapped with what is wrong with the usage export.
+	ErrInvalidExport = errors.New("invalid usage export")
+)
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/export"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+)
+
+// exportBacklog is how many past periods the scheduled export catches up
+// on, older periods missing from the store are left alone.
+const exportBacklog = 7
+
+type ExportService struct {
+	usageRepo repo.Usage
+	// store receives the scheduled exports, nil turns them off
+	store  ExportStore
+	format string
+	period Period
+	delay  time.Duration
+	now    func() time.Time
+}
+
+type ExportConfig struct {
+	// Format of the scheduled exports.
+	Format string
+	// Period is the span of usage in one scheduled export file.
+	Period Period
+	// Delay keeps a period from being exported until usage still in flight
+	// has landed.
+	Delay time.Duration
+}
+
+func NewExportService(usageRepo repo.Usage, store ExportStore, cfg ExportConfig) *ExportService {
+	slog.Debug("component", slog.String("name", "export service"))
+
+	return &ExportService{
+		usageRepo: usageRepo,
+		store:     store,
+		format:    cfg.Format,
+		period:    cfg.Period,
+		delay:     cfg.Delay,
+		now:       time.Now,
+	}
+}
+
+// Export streams the usage records of q to w. Nothing is written when the
+// query is invalid; once records are written an error leaves w truncated.
+func (s *ExportService) Export(ctx context.Context, q *entity.UsageExport, w io.Writer) error {
+	if err := checkExport(q); err != nil {
+		return err
+	}
+
+	ew, err := export.NewWriter(q.Format, w)
+	if err != nil {
+		return err
+	}
+	if err := s.usageRepo.Export(ctx, q, ew.Write); err != nil {
+		slog.Error("failed to export usage", slog.String("tenant", q.Tenant), slog.String("format", q.Format), slog.String("error", err.Error()))
+		return err
+	}
+
+	return ew.Close()
+}
+
+func checkExport(q *entity.UsageExport) error {
+	if export.ContentType(q.Format) == "" {
+		return fmt.Errorf("%w: unknown format %q, expected csv, jsonl or parquet", ErrInvalidExport, q.Format)
+	}
+	if q.From.IsZero() || q.To.IsZero() {
+		return fmt.Errorf("%w: from and to are required", ErrInvalidExport)
+	}
+	if !q.To.After(q.From) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidExport)
+	}
+	// the export is read from the minute rollup
+	q.From = q.From.Truncate(entity.RollupStep)
+	if to := q.To.Truncate(entity.RollupStep); to.Before(q.To) {
+		q.To = to.Add(entity.RollupStep)
+	}
+
+	return nil
+}
+
+// ExportDue writes the usage of every tenant for each completed period to
+// the store, one file per period, and returns the names written. Periods
+// already in the store are skipped, so a failed export is retried on the
+// next call and replicas write the same file at worst twice.
+func (s *ExportService) ExportDue(ctx context.Context) ([]string, error) {
+	if s.store == nil {
+		return nil, nil
+	}
+
+	var starts []time.Time
+	end := s.period.Start(s.now().UTC().Add(-s.delay))
+	for start := s.period.Start(end.Add(-time.Nanosecond)); len(starts) < exportBacklog; start = s.period.Start(start.Add(-time.Nanosecond)) {
+		starts = append(starts, start)
+	}
+
+	var (
+		written []string
+		errs    []error
+	)
+	// oldest first, so the files appear in order
+	for i := len(starts) - 1; i >= 0; i-- {
+		q := &entity.UsageExport{From: starts[i], To: s.period.Next(starts[i]), Format: s.format}
+		name := exportName(q)
+
+		exists, err := s.store.Exists(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if exists {
+			continue
+		}
+
+		if err := s.put(ctx, name, q); err != nil {
+			slog.Error("failed to write usage export", slog.String("name", name), slog.String("error", err.Error()))
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		slog.Info("exported usage", slog.String("name", name), slog.Time("from", q.From), slog.Time("to", q.To))
+		written = append(written, name)
+	}
+
+	return written, errors.Join(errs...)
+}
+
+// put streams the export into the store through a pipe, the records are
+// encoded while the store uploads them.
+func (s *ExportService) put(ctx context.Context, name string, q *entity.UsageExport) error {
+	pr, pw := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		err := s.Export(ctx, q, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err := s.store.Put(ctx, name, export.ContentType(q.Format), pr)
+	// stops the export when the store gave up before reading it all
+	pr.CloseWithError(io.ErrClosedPipe)
+	exportErr := <-done
+	if err != nil {
+		return err
+	}
+
+	return exportErr
+}
+
+// exportName names the file of a period by its start, e.g.
+// usage-20251001T0000Z.parquet.
+func exportName(q *entity.UsageExport) string {
+	return "usage-" + q.From.UTC().Format("20060102T1504Z") + "." + q.Format
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/export"
+)
+
+// fakeExportRepo serves a record per hour of the range and remembers the
+// queries it was asked.
+type fakeExportRepo struct {
+	fakeUsageRepo
+	queries []entity.UsageExport
+}
+
+func (f *fakeExportRepo) Export(_ context.Context, q *entity.UsageExport, fn func(entity.UsageRecord) error) error {
+	f.queries = append(f.queries, *q)
+	for t := q.From; t.Before(q.To); t = t.Add(time.Hour) {
+		if err := fn(entity.UsageRecord{Tenant: "alice", Function: "hello", Pod: "hello-1", Minute: t, Requests: 1}); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+// fakeStore keeps files in memory, failing the puts of the names in fail.
+type fakeStore struct {
+	files map[string][]byte
+	fail  map[string]bool
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{files: map[string][]byte{}, fail: map[string]bool{}}
+}
+
+func (f *fakeStore) Exists(_ context.Context, name string) (bool, error) {
+	_, ok := f.files[name]
+	return ok, nil
+}
+
+func (f *fakeStore) Put(_ context.Context, name, _ string, r io.Reader) error {
+	if f.fail[name] {
+		// read a little, like an upload dropped midway
+		_, _ = r.Read(make([]byte, 16))
+		return errors.New("connection reset")
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.files[name] = b
+	return nil
+}
+
+func Test_Export(t *testing.T) {
+	usage := &fakeExportRepo{}
+	s := NewExportService(usage, nil, ExportConfig{})
+
+	var buf bytes.Buffer
+	err := s.Export(context.Background(), &entity.UsageExport{
+		Tenant: "alice",
+		From:   utc(2025, time.October, 1, 0).Add(30 * time.Second),
+		To:     utc(2025, time.October, 1, 2).Add(-30 * time.Second),
+		Format: export.FormatCSV,
+	}, &buf)
+	require.NoError(t, err)
+
+	require.Len(t, usage.queries, 1)
+	assert.Equal(t, "alice", usage.queries[0].Tenant)
+	assert.Equal(t, utc(2025, time.October, 1, 0), usage.queries[0].From, "widened to whole minutes")
+	assert.Equal(t, utc(2025, time.October, 1, 2), usage.queries[0].To)
+	assert.Equal(t, "tenant,function,pod,minute,duration_sec,mem_mb_sec,cpu_sec,requests\n"+
+		"alice,hello,hello-1,2025-10-01T00:00:00Z,0,0,0,1\n"+
+		"alice,hello,hello-1,2025-10-01T01:00:00Z,0,0,0,1\n", buf.String())
+}
+
+func Test_ExportInvalid(t *testing.T) {
+	usage := &fakeExportRepo{}
+	s := NewExportService(usage, nil, ExportConfig{})
+	from := utc(2025, time.October, 1, 0)
+
+	tests := []struct {
+		name string
+		q    entity.UsageExport
+	}{
+		{name: "unknown format", q: entity.UsageExport{From: from, To: from.Add(time.Hour), Format: "xlsx"}},
+		{name: "no range", q: entity.UsageExport{Format: export.FormatJSONL}},
+		{name: "reversed range", q: entity.UsageExport{From: from, To: from.Add(-time.Hour), Format: export.FormatJSONL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := s.Export(context.Background(), &tt.q, &buf)
+			assert.ErrorIs(t, err, ErrInvalidExport)
+			assert.Zero(t, buf.Len(), "nothing is written")
+		})
+	}
+	assert.Empty(t, usage.queries)
+}
+
+func Test_ExportDue(t *testing.T) {
+	usage := &fakeExportRepo{}
+	store := newFakeStore()
+	s := NewExportService(usage, store, ExportConfig{Format: export.FormatJSONL, Period: PeriodDay, Delay: time.Hour})
+	now := utc(2025, time.October, 10, 0).Add(30 * time.Minute)
+	s.now = func() time.Time { return now }
+
+	store.files["usage-20251005T0000Z.jsonl"] = []byte("exported before")
+	store.fail["usage-20251007T0000Z.jsonl"] = true
+
+	// October 9 is still within the delay
+	written, err := s.ExportDue(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, []string{
+		"usage-20251002T0000Z.jsonl",
+		"usage-20251003T0000Z.jsonl",
+		"usage-20251004T0000Z.jsonl",
+		"usage-20251006T0000Z.jsonl",
+		"usage-20251008T0000Z.jsonl",
+	}, written)
+	assert.NotContains(t, store.files, "usage-20251007T0000Z.jsonl")
+	assert.Equal(t, "exported before", string(store.files["usage-20251005T0000Z.jsonl"]))
+	assert.Equal(t, 24, bytes.Count(store.files["usage-20251002T0000Z.jsonl"], []byte("\n")))
+	assert.Empty(t, usage.queries[0].Tenant, "every tenant is exported")
+
+	// the failed day is retried, the next day waits for the delay
+	delete(store.fail, "usage-20251007T0000Z.jsonl")
+	now = now.Add(30 * time.Minute)
+	written, err = s.ExportDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"usage-20251007T0000Z.jsonl", "usage-20251009T0000Z.jsonl"}, written)
+
+	written, err = s.ExportDue(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, written)
+}
+
+func Test_ExportDueRepoError(t *testing.T) {
+	usage := &fakeExportRepo{fakeUsageRepo: fakeUsageRepo{err: errors.New("clickhouse is down")}}
+	store := newFakeStore()
+	s := NewExportService(usage, store, ExportConfig{Format: export.FormatParquet, Period: PeriodHour})
+
+	written, err := s.ExportDue(context.Background())
+	assert.ErrorContains(t, err, "clickhouse is down")
+	assert.Empty(t, written)
+	assert.Empty(t, store.files, "a failed export leaves no file")
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// FXService keeps the rates tenants' invoices are converted from the billing
+// currency with.
+type FXService struct {
+	fxRepo repo.FX
+	money  money.Policy
+}
+
+func NewFXService(fxRepo repo.FX, policy money.Policy) *FXService {
+	slog.Debug("component", slog.String("name", "fx service"))
+
+	return &FXService{
+		fxRepo: fxRepo,
+		money:  policy,
+	}
+}
+
+// FXRateInput is what one unit of the billing currency is worth in Currency
+// from Date on.
+type FXRateInput struct {
+	Currency string
+	Rate     money.Decimal
+	Date     time.Time
+}
+
+// SaveRates validates and stores the rates, replacing the ones of the same
+// currency and date.
+func (s *FXService) SaveRates(ctx context.Context, in []FXRateInput) ([]entity.FXRate, error) {
+	if len(in) == 0 {
+		return nil, fmt.Errorf("%w: no rates", ErrInvalidFXRate)
+	}
+
+	rates := make([]entity.FXRate, 0, len(in))
+	for _, r := range in {
+		currency, err := money.ParseCurrency(r.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFXRate, err)
+		}
+		if currency.Code == s.money.Currency.Code {
+			return nil, fmt.Errorf("%w: %s is the billing currency", ErrInvalidFXRate, currency.Code)
+		}
+		if !r.Rate.IsPositive() {
+			return nil, fmt.Errorf("%w: rate of %s must be positive", ErrInvalidFXRate, currency.Code)
+		}
+		if r.Date.IsZero() {
+			return nil, fmt.Errorf("%w: date of %s is required", ErrInvalidFXRate, currency.Code)
+		}
+
+		y, m, d := r.Date.Date()
+		rates = append(rates, entity.FXRate{
+			Base:     s.money.Currency.Code,
+			Currency: currency.Code,
+			Rate:     r.Rate,
+			Date:     time.Date(y, m, d, 0, 0, 0, 0, time.UTC),
+		})
+	}
+
+	if err := s.fxRepo.Save(ctx, rates); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// Load stores the rates of a CSV file with date (YYYY-MM-DD), currency and
+// rate columns. The first row is a header.
+func (s *FXService) Load(ctx context.Context, r io.Reader) (int, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidFXRate, err)
+	}
+	if len(records) < 2 {
+		return 0, nil
+	}
+
+	in := make([]FXRateInput, 0, len(records)-1)
+	for i, rec := range records[1:] {
+		if len(rec) != 3 {
+			return 0, fmt.Errorf("%w: line %d: expected date, currency and rate", ErrInvalidFXRate, i+2)
+		}
+		date, err := time.Parse(time.DateOnly, strings.TrimSpace(rec[0]))
+		if err != nil {
+			return 0, fmt.Errorf("%w: line %d: %w", ErrInvalidFXRate, i+2, err)
+		}
+		rate, err := money.Parse(strings.TrimSpace(rec[2]))
+		if err != nil {
+			return 0, fmt.Errorf("%w: line %d: %w", ErrInvalidFXRate, i+2, err)
+		}
+		in = append(in, FXRateInput{Currency: rec[1], Rate: rate, Date: date})
+	}
+
+	rates, err := s.SaveRates(ctx, in)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rates), nil
+}
+
+// GetRates returns the stored rates, of a single currency if it is set.
+func (s *FXService) GetRates(ctx context.Context, currency string) ([]entity.FXRate, error) {
+	if currency != "" {
+		c, err := money.ParseCurrency(currency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFXRate, err)
+		}
+		currency = c.Code
+	}
+
+	rates, err := s.fxRepo.GetAll(ctx, s.money.Currency.Code, currency)
+	if err != nil {
+		return nil, err
+	}
+	if rates == nil {
+		rates = []entity.FXRate{}
+	}
+
+	return rates, nil
+}
+
+// Currency returns the currency the tenant is invoiced in.
+func (s *BillingService) Currency(ctx context.Context, tenant string) (money.Currency, error) {
+	profile, err := s.profile(ctx, tenant)
+	if err != nil {
+		return money.Currency{}, err
+	}
+	if profile == nil || profile.Currency == "" {
+		return s.money.Currency, nil
+	}
+
+	return money.ParseCurrency(profile.Currency)
+}
+
+// settle converts the invoice to the tenant's currency and charges its taxes.
+func (s *BillingService) settle(ctx context.Context, inv *entity.Invoice) error {
+	profile, err := s.profile(ctx, inv.Header.TenantID)
+	if err != nil {
+		return err
+	}
+
+	policy, err := s.convert(ctx, inv, profile)
+	if err != nil {
+		return err
+	}
+
+	return s.tax(ctx, inv, profile, policy)
+}
+
+// profile returns the tenant's billing profile, nil for tenants without one.
+func (s *BillingService) profile(ctx context.Context, tenant string) (*entity.BillingProfile, error) {
+	profile, err := s.taxRepo.GetProfile(ctx, tenant)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, nil
+		}
+		slog.Error("failed to get billing profile", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// convert converts the invoice priced in the billing currency to the
+// currency of the tenant's profile with the rate in force when the invoice is
+// calculated. It returns the policy the converted amounts are rounded by.
+func (s *BillingService) convert(ctx context.Context, inv *entity.Invoice, profile *entity.BillingProfile) (money.Policy, error) {
+	if profile == nil || profile.Currency == "" || profile.Currency == s.money.Currency.Code {
+		return s.money, nil
+	}
+
+	currency, err := money.ParseCurrency(profile.Currency)
+	if err != nil {
+		return money.Policy{}, err
+	}
+
+	at := inv.Header.CalculatedAt
+	rate, err := s.fxRepo.Get(ctx, s.money.Currency.Code, currency.Code, at)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return money.Policy{}, fmt.Errorf("%w: %s to %s on %s", ErrNoFXRate, s.money.Currency.Code, currency.Code, at.UTC().Format(time.DateOnly))
+		}
+		return money.Policy{}, err
+	}
+
+	convertInvoice(inv, rate.Rate)
+	inv.Header.Currency = currency.Code
+	inv.Header.FX = rate
+
+	return s.money.In(currency), nil
+}
+
+// convertInvoice multiplies every amount of the invoice by the rate. Amounts
+// stay exact, so lines still sum up to the totals.
+func convertInvoice(inv *entity.Invoice, rate money.Decimal) {
+	h := &inv.Header
+	convertTotals(&h.Totals, rate)
+	h.AdjustmentTotal = h.AdjustmentTotal.Mul(rate)
+
+	for i := range inv.Functions {
+		fn := &inv.Functions[i]
+		convertTotals(&fn.Totals, rate)
+		for j := range fn.Pods {
+			pod := &fn.Pods[j]
+			convertTotals(&pod.Totals, rate)
+			for k := range pod.Charges {
+				c := &pod.Charges[k]
+				c.UnitPrice = c.UnitPrice.Mul(rate)
+				c.Amount = c.Amount.Mul(rate)
+			}
+		}
+	}
+
+	for i := range inv.Adjustments {
+		inv.Adjustments[i].Amount = inv.Adjustments[i].Amount.Mul(rate)
+	}
+}
+
+func convertTotals(t *entity.Totals, rate money.Decimal) {
+	t.ExecCost = t.ExecCost.Mul(rate)
+	t.MemoryCost = t.MemoryCost.Mul(rate)
+	t.CPUCost = t.CPUCost.Mul(rate)
+	t.RequestCost = t.RequestCost.Mul(rate)
+	t.TotalCost = t.TotalCost.Mul(rate)
+}
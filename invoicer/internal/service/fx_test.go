@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type fakeFXRepo struct {
+	rates []entity.FXRate
+}
+
+func newFakeFXRepo() *fakeFXRepo {
+	return &fakeFXRepo{}
+}
+
+func (f *fakeFXRepo) Save(_ context.Context, rates []entity.FXRate) error {
+	for _, r := range rates {
+		replaced := false
+		for i, existing := range f.rates {
+			if existing.Base == r.Base && existing.Currency == r.Currency && existing.Date.Equal(r.Date) {
+				f.rates[i] = r
+				replaced = true
+			}
+		}
+		if !replaced {
+			f.rates = append(f.rates, r)
+		}
+	}
+	return nil
+}
+
+func (f *fakeFXRepo) Get(_ context.Context, base, currency string, at time.Time) (*entity.FXRate, error) {
+	var found *entity.FXRate
+	for i, r := range f.rates {
+		if r.Base == base && r.Currency == currency && !r.Date.After(at) && (found == nil || r.Date.After(found.Date)) {
+			found = &f.rates[i]
+		}
+	}
+	if found == nil {
+		return nil, repoerrors.ErrNotFound
+	}
+	rate := *found
+	return &rate, nil
+}
+
+func (f *fakeFXRepo) GetAll(_ context.Context, base, currency string) ([]entity.FXRate, error) {
+	var out []entity.FXRate
+	for _, r := range f.rates {
+		if r.Base == base && (currency == "" || r.Currency == currency) {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Date.After(out[j].Date) })
+	return out, nil
+}
+
+func day(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func Test_BillingFX(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+	fx := newFakeFXRepo()
+	fx.rates = []entity.FXRate{
+		{Base: "USD", Currency: "EUR", Rate: dec("0.9"), Date: day("2025-10-01")},
+		{Base: "USD", Currency: "EUR", Rate: dec("0.8"), Date: day("2025-10-20")},
+		{Base: "USD", Currency: "EUR", Rate: dec("0.7"), Date: day("2025-11-01")},
+		{Base: "USD", Currency: "JPY", Rate: dec("151.237"), Date: day("2025-10-01")},
+	}
+
+	tests := []struct {
+		name     string
+		currency string
+		wantRate string
+		wantCost string
+		wantTax  string
+		wantDue  string
+	}{
+		{name: "billing currency", currency: "", wantCost: "15", wantTax: "3", wantDue: "18"},
+		{name: "profile in the billing currency", currency: "USD", wantCost: "15", wantTax: "3", wantDue: "18"},
+		// the rate in force on the day the invoice is calculated
+		{name: "euro", currency: "EUR", wantRate: "0.8", wantCost: "12", wantTax: "2.4", wantDue: "14.4"},
+		// 2268.555 rounded to whole yen, the tax on the rounded amount
+		{name: "yen", currency: "JPY", wantRate: "151.237", wantCost: "2268.555", wantTax: "454", wantDue: "2723"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxes := newFakeTaxRepo()
+			taxes.rules = taxRules()
+			taxes.profiles["alice"] = entity.BillingProfile{Tenant: "alice", LegalName: "Alice", Country: "RU", Currency: tt.currency}
+
+			s := NewBillingService(usage, newFakeInvoiceRepo(), taxes, fx, basicTariffs(), BillingConfig{DefaultTariffID: 1})
+			s.now = func() time.Time { return time.Date(2025, 10, 25, 12, 0, 0, 0, time.UTC) }
+
+			inv, err := s.Draft(context.Background(), "alice")
+			require.NoError(t, err)
+
+			h := inv.Header
+			if tt.wantRate == "" {
+				assert.Equal(t, "USD", h.Currency)
+				assert.Nil(t, h.FX)
+			} else {
+				assert.Equal(t, tt.currency, h.Currency)
+				require.NotNil(t, h.FX)
+				assert.Equal(t, "USD", h.FX.Base)
+				assertDecimal(t, dec(tt.wantRate), h.FX.Rate)
+			}
+
+			assertDecimal(t, dec(tt.wantCost), h.Totals.TotalCost)
+			assertDecimal(t, dec(tt.wantCost), inv.Functions[0].Totals.TotalCost)
+			sum := money.Zero
+			for _, c := range inv.Functions[0].Pods[0].Charges {
+				assertDecimal(t, c.Quantity.Mul(c.UnitPrice), c.Amount, c.Dimension)
+				sum = sum.Add(c.Amount)
+			}
+			assertDecimal(t, dec(tt.wantCost), sum)
+			assertDecimal(t, dec(tt.wantTax), h.Tax)
+			assertDecimal(t, dec(tt.wantDue), h.AmountDue)
+
+			currency, err := s.Currency(context.Background(), "alice")
+			require.NoError(t, err)
+			assert.Equal(t, h.Currency, currency.Code)
+		})
+	}
+
+	// no rate in force yet
+	taxes := newFakeTaxRepo()
+	taxes.profiles["alice"] = entity.BillingProfile{Tenant: "alice", Country: "RU", Currency: "RUB"}
+	s := NewBillingService(usage, newFakeInvoiceRepo(), taxes, fx, basicTariffs(), BillingConfig{DefaultTariffID: 1})
+	_, err := s.Draft(context.Background(), "alice")
+	assert.ErrorIs(t, err, ErrNoFXRate)
+}
+
+func Test_FXServiceLoad(t *testing.T) {
+	ctx := context.Background()
+	fx := newFakeFXRepo()
+	s := NewFXService(fx, money.DefaultPolicy())
+
+	n, err := s.Load(ctx, strings.NewReader("date,currency,rate\n2025-10-01,eur,0.9\n2025-10-02, EUR ,0.91\n2025-10-01,JPY,151.2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	rates, err := s.GetRates(ctx, "EUR")
+	require.NoError(t, err)
+	require.Len(t, rates, 2)
+	assert.Equal(t, entity.FXRate{Base: "USD", Currency: "EUR", Rate: dec("0.91"), Date: day("2025-10-02")}, rates[0])
+
+	// a rate of the same day replaces the stored one
+	_, err = s.SaveRates(ctx, []FXRateInput{{Currency: "EUR", Rate: dec("0.95"), Date: time.Date(2025, 10, 2, 15, 0, 0, 0, time.UTC)}})
+	require.NoError(t, err)
+	rates, err = s.GetRates(ctx, "EUR")
+	require.NoError(t, err)
+	require.Len(t, rates, 2)
+	assertDecimal(t, dec("0.95"), rates[0].Rate)
+
+	for _, file := range []string{
+		"date,currency,rate\n2025-10-01,USD,1\n",
+		"date,currency,rate\n2025-10-01,XXX,1\n",
+		"date,currency,rate\n2025-10-01,EUR,0\n",
+		"date,currency,rate\n10/01/2025,EUR,0.9\n",
+		"date,currency,rate\n2025-10-01,EUR\n",
+	} {
+		_, err := s.Load(ctx, strings.NewReader(file))
+		assert.ErrorIs(t, err, ErrInvalidFXRate, file)
+	}
+}
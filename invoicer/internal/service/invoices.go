@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/render"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+)
+
+type InvoiceService struct {
+	invoiceRepo repo.Invoice
+	renderer    *render.Renderer
+}
+
+func NewInvoiceService(invoiceRepo repo.Invoice, renderer *render.Renderer) *InvoiceService {
+	slog.Debug("component", slog.String("name", "invoice service"))
+
+	return &InvoiceService{
+		invoiceRepo: invoiceRepo,
+		renderer:    renderer,
+	}
+}
+
+func (s *InvoiceService) GetByNumber(ctx context.Context, number int64) (*entity.Invoice, error) {
+	inv, err := s.invoiceRepo.GetByNumber(ctx, number)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+func (s *InvoiceService) GetAll(ctx context.Context, filters *entity.InvoiceFilters) ([]entity.InvoiceHeader, error) {
+	return s.invoiceRepo.GetAll(ctx, filters)
+}
+
+// Document renders an issued invoice as an HTML page or a PDF.
+func (s *InvoiceService) Document(ctx context.Context, number int64, format string) ([]byte, error) {
+	inv, err := s.GetByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := s.renderer.Render(inv, format)
+	if err != nil {
+		slog.Error("failed to render invoice",
+			slog.Int64("number", number),
+			slog.String("format", format),
+			slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// The ledger keeps the prepaid balance of tenants in double entry: every
+// transaction moves an amount between the tenant's wallet account and one of
+// its counter accounts, so the balances of a tenant always sum to zero.
+//
+//   - top_up: funding -> wallet
+//   - refund: wallet -> funding, never more than the balance
+//   - usage: wallet -> revenue
+//   - adjustment: adjustments -> wallet, either way
+//
+// Usage is debited twice over. Sync accrues the draft of the open period, so
+// the balance follows live usage, and once an invoice is issued it debits what
+// is still owed on the invoice in place of everything accrued so far. Only
+// invoices ending after the wallet was opened are debited. The debit settles
+// the invoice: it is recorded as a wallet payment and the invoice turns paid
+// in the same transaction, so it is never dunned or paid twice. A balance the
+// debit takes below zero blocks the tenant until a top-up covers it. A
+// debited invoice that is voided later is credited back.
+
+// counterAccounts is the account a transaction kind moves money against.
+var counterAccounts = map[string]string{
+	entity.LedgerTopUp:      entity.AccountFunding,
+	entity.LedgerRefund:     entity.AccountFunding,
+	entity.LedgerUsage:      entity.AccountRevenue,
+	entity.LedgerAdjustment: entity.AccountAdjustments,
+}
+
+type LedgerService struct {
+	ledgerRepo repo.Ledger
+	billing    Billing
+	events     Publisher
+	now        func() time.Time
+
+	mu sync.Mutex
+	// published is the blocked state last sent for a tenant
+	published map[string]bool
+}
+
+func NewLedgerService(ledgerRepo repo.Ledger, billing Billing, events Publisher) *LedgerService {
+	slog.Debug("component", slog.String("name", "ledger service"))
+
+	return &LedgerService{
+		ledgerRepo: ledgerRepo,
+		billing:    billing,
+		events:     events,
+		now:        time.Now,
+		published:  make(map[string]bool),
+	}
+}
+
+// LedgerInput is a manual posting. Retrying it with the same idempotency key
+// returns the transaction posted the first time.
+type LedgerInput struct {
+	Amount         money.Decimal
+	IdempotencyKey string
+	Description    string
+}
+
+// TopUp adds money to the tenant's wallet, opening the wallet in the
+// currency the tenant is invoiced in on the first top-up. The bool tells
+// whether it was posted now.
+func (s *LedgerService) TopUp(ctx context.Context, tenant string, in *LedgerInput) (*entity.LedgerTransaction, bool, error) {
+	if !in.Amount.IsPositive() {
+		return nil, false, fmt.Errorf("%w: amount must be positive", ErrInvalidLedgerInput)
+	}
+
+	currency, err := s.billing.Currency(ctx, tenant)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return s.post(ctx, tenant, currency.Code, entity.LedgerTopUp, in.Amount, in, nil)
+}
+
+// Refund pays money from the wallet back to the tenant.
+func (s *LedgerService) Refund(ctx context.Context, tenant string, in *LedgerInput) (*entity.LedgerTransaction, bool, error) {
+	if !in.Amount.IsPositive() {
+		return nil, false, fmt.Errorf("%w: amount must be positive", ErrInvalidLedgerInput)
+	}
+
+	return s.post(ctx, tenant, "", entity.LedgerRefund, in.Amount.Neg(), in, func(w *entity.Wallet) error {
+		if w.Balance.LessThan(in.Amount) {
+			return ErrInsufficientBalance
+		}
+		return nil
+	})
+}
+
+// Adjust corrects the wallet balance by a positive or negative amount.
+func (s *LedgerService) Adjust(ctx context.Context, tenant string, in *LedgerInput) (*entity.LedgerTransaction, bool, error) {
+	if in.Amount.IsZero() {
+		return nil, false, fmt.Errorf("%w: amount must not be zero", ErrInvalidLedgerInput)
+	}
+
+	return s.post(ctx, tenant, "", entity.LedgerAdjustment, in.Amount, in, nil)
+}
+
+func (s *LedgerService) GetWallet(ctx context.Context, tenant string) (*entity.Wallet, error) {
+	w, err := s.ledgerRepo.GetWallet(ctx, tenant)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrWalletNotFound
+		}
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (s *LedgerService) GetTransactions(ctx context.Context, tenant string, limit, offset uint64) ([]entity.LedgerTransaction, error) {
+	if _, err := s.GetWallet(ctx, tenant); err != nil {
+		return nil, err
+	}
+
+	return s.ledgerRepo.GetTransactions(ctx, tenant, limit, offset)
+}
+
+// Sync debits the issued invoices of every wallet, accrues the usage of the
+// open period and tells control_plane about wallets that went negative or
+// were paid up. The state of every wallet is sent once after a restart. A
+// failing wallet does not hold back the others.
+func (s *LedgerService) Sync(ctx context.Context) error {
+	wallets, err := s.ledgerRepo.GetWallets(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, w := range wallets {
+		if err := s.sync(ctx, w); err != nil {
+			slog.Error("failed to sync wallet", slog.String("tenant", w.Tenant), slog.String("error", err.Error()))
+			errs = append(errs, fmt.Errorf("tenant %s: %w", w.Tenant, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *LedgerService) sync(ctx context.Context, w entity.Wallet) error {
+	invoices, err := s.ledgerRepo.UnpostedInvoices(ctx, w.Tenant, w.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range invoices {
+		if inv.Currency != w.Currency {
+			return fmt.Errorf("wallet is in %s, invoice %d in %s: %w", w.Currency, inv.Number, inv.Currency, money.ErrCurrencyMismatch)
+		}
+		number := inv.Number
+		_, _, posted, err := s.ledgerRepo.PostInvoice(ctx, w.Tenant, number, fmt.Sprintf("invoice:%d", number), func(w *entity.Wallet, owed money.Decimal) (*entity.LedgerTransaction, error) {
+			// the invoice replaces everything accrued, the draft is accrued again below
+			t := ledgerTransaction(entity.LedgerUsage, w.Accrued.Sub(owed), w.Accrued.Neg(), fmt.Sprintf("invoice %d", number))
+			t.InvoiceNumber = &number
+			return t, nil
+		})
+		if posted {
+			slog.Info("settled invoice from wallet", slog.Int64("number", number), slog.String("tenant", w.Tenant))
+		}
+		if err != nil && !errors.Is(err, repoerrors.ErrConflict) {
+			return err
+		}
+	}
+
+	voided, err := s.ledgerRepo.VoidedInvoices(ctx, w.Tenant)
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range voided {
+		t := ledgerTransaction(entity.LedgerUsage, inv.AmountDue, money.Zero, fmt.Sprintf("invoice %d voided", inv.Number))
+		_, _, _, err := s.ledgerRepo.Post(ctx, w.Tenant, "", fmt.Sprintf("void:%d", inv.Number), func(*entity.Wallet) (*entity.LedgerTransaction, error) {
+			return t, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	due := money.Zero
+	draft, err := s.billing.Draft(ctx, w.Tenant)
+	if err != nil && !errors.Is(err, ErrNoUsage) {
+		return err
+	}
+	if draft != nil {
+		if draft.Header.Currency != w.Currency {
+			return fmt.Errorf("wallet is in %s, invoices in %s: %w", w.Currency, draft.Header.Currency, money.ErrCurrencyMismatch)
+		}
+		due = draft.Header.AmountDue
+	}
+
+	key := fmt.Sprintf("accrual:%d", s.now().UnixNano())
+	_, wallet, _, err := s.ledgerRepo.Post(ctx, w.Tenant, "", key, func(w *entity.Wallet) (*entity.LedgerTransaction, error) {
+		diff := due.Sub(w.Accrued)
+		if diff.IsZero() {
+			return nil, nil
+		}
+		return ledgerTransaction(entity.LedgerUsage, diff.Neg(), diff, "usage since the last invoice"), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.publish(ctx, wallet)
+	return nil
+}
+
+// post posts a manual transaction. check vets the locked wallet first.
+func (s *LedgerService) post(ctx context.Context, tenant, currency, kind string, amount money.Decimal, in *LedgerInput, check func(w *entity.Wallet) error) (*entity.LedgerTransaction, bool, error) {
+	key := strings.TrimSpace(in.IdempotencyKey)
+	if key == "" {
+		return nil, false, fmt.Errorf("%w: idempotency key is required", ErrInvalidLedgerInput)
+	}
+
+	description := in.Description
+	if description == "" {
+		description = strings.ReplaceAll(kind, "_", "-")
+	}
+
+	t, w, posted, err := s.ledgerRepo.Post(ctx, tenant, currency, key, func(w *entity.Wallet) (*entity.LedgerTransaction, error) {
+		if check != nil {
+			if err := check(w); err != nil {
+				return nil, err
+			}
+		}
+		return ledgerTransaction(kind, amount, money.Zero, description), nil
+	})
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, false, ErrWalletNotFound
+		}
+		return nil, false, err
+	}
+
+	if !posted && (t.Kind != kind || !t.Amount.Equal(amount)) {
+		return nil, false, ErrIdempotencyConflict
+	}
+	if posted {
+		slog.Info("posted ledger transaction",
+			slog.String("tenant", tenant),
+			slog.String("kind", kind),
+			slog.String("amount", amount.String()),
+			slog.String("balance", w.Balance.String()))
+		s.publish(ctx, w)
+	}
+
+	return t, posted, nil
+}
+
+// publish sends the wallet's blocked state unless it was sent already.
+// Events are keyed by tenant, so a tenant's events stay in order.
+func (s *LedgerService) publish(ctx context.Context, w *entity.Wallet) {
+	s.mu.Lock()
+	last, ok := s.published[w.Tenant]
+	s.mu.Unlock()
+	if ok && last == w.Blocked {
+		return
+	}
+
+	payload, err := json.Marshal(entity.BalanceEvent{
+		Tenant:    w.Tenant,
+		Balance:   w.Balance,
+		Currency:  w.Currency,
+		Blocked:   w.Blocked,
+		Timestamp: s.now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to marshal balance event", slog.String("error", err.Error()))
+		return
+	}
+
+	// a lost event is sent again on the next sync
+	if err := s.events.WriteMessages(ctx, gokafka.Message{Key: []byte(w.Tenant), Value: payload}); err != nil {
+		slog.Error("failed to publish balance event", slog.String("tenant", w.Tenant), slog.String("error", err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	s.published[w.Tenant] = w.Blocked
+	s.mu.Unlock()
+
+	slog.Info("published balance event", slog.String("tenant", w.Tenant), slog.Bool("blocked", w.Blocked))
+}
+
+// ledgerTransaction moves amount into the wallet from the kind's counter
+// account, a negative amount moves it out.
+func ledgerTransaction(kind string, amount, accrued money.Decimal, description string) *entity.LedgerTransaction {
+	return &entity.LedgerTransaction{
+		Kind:        kind,
+		Description: description,
+		Amount:      amount,
+		Accrued:     accrued,
+		Entries: []entity.LedgerEntry{
+			{Account: entity.AccountWallet, Amount: amount},
+			{Account: counterAccounts[kind], Amount: amount.Neg()},
+		},
+	}
+}
@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// fakeLedgerRepo keeps the ledger in memory, balances are derived from the
+// entries like the account rows are.
+type fakeLedgerRepo struct {
+	wallets      map[string]*entity.Wallet
+	balances     map[string]map[string]money.Decimal
+	transactions []entity.LedgerTransaction
+	invoices     []entity.InvoiceHeader
+	// payments receives the wallet payments of settled invoices when set
+	payments *fakeInvoiceRepo
+}
+
+func newFakeLedgerRepo() *fakeLedgerRepo {
+	return &fakeLedgerRepo{
+		wallets:  make(map[string]*entity.Wallet),
+		balances: make(map[string]map[string]money.Decimal),
+	}
+}
+
+func (f *fakeLedgerRepo) Post(_ context.Context, tenant, currency, key string, plan func(w *entity.Wallet) (*entity.LedgerTransaction, error)) (*entity.LedgerTransaction, *entity.Wallet, bool, error) {
+	if _, ok := f.wallets[tenant]; !ok && currency != "" {
+		f.wallets[tenant] = &entity.Wallet{Tenant: tenant, Currency: currency, Accrued: money.Zero, Balance: money.Zero}
+		f.balances[tenant] = make(map[string]money.Decimal)
+	}
+	w, ok := f.wallets[tenant]
+	if !ok {
+		return nil, nil, false, repoerrors.ErrNotFound
+	}
+	snapshot := *w
+
+	for _, t := range f.transactions {
+		if t.Tenant == tenant && t.IdempotencyKey == key {
+			return &t, &snapshot, false, nil
+		}
+	}
+
+	t, err := plan(&snapshot)
+	if err != nil || t == nil {
+		return nil, &snapshot, false, err
+	}
+	for _, posted := range f.transactions {
+		if t.InvoiceNumber != nil && posted.InvoiceNumber != nil && *posted.InvoiceNumber == *t.InvoiceNumber {
+			return nil, nil, false, repoerrors.ErrConflict
+		}
+	}
+
+	t.ID = int64(len(f.transactions) + 1)
+	t.Tenant = tenant
+	t.IdempotencyKey = key
+	for i := range t.Entries {
+		e := &t.Entries[i]
+		f.balances[tenant][e.Account] = f.balances[tenant][e.Account].Add(e.Amount)
+		e.Balance = f.balances[tenant][e.Account]
+	}
+	f.transactions = append(f.transactions, *t)
+
+	w.Balance = f.balances[tenant][entity.AccountWallet]
+	w.Accrued = w.Accrued.Add(t.Accrued)
+	w.Blocked = w.Balance.IsNegative()
+	snapshot = *w
+
+	return t, &snapshot, true, nil
+}
+
+func (f *fakeLedgerRepo) PostInvoice(ctx context.Context, tenant string, number int64, key string, plan func(w *entity.Wallet, owed money.Decimal) (*entity.LedgerTransaction, error)) (*entity.LedgerTransaction, *entity.Wallet, bool, error) {
+	var h *entity.InvoiceHeader
+	for i := range f.invoices {
+		if f.invoices[i].Number == number {
+			h = &f.invoices[i]
+		}
+	}
+	if h == nil {
+		return nil, nil, false, repoerrors.ErrNotFound
+	}
+	owed := h.AmountDue
+	if h.Status == entity.InvoiceStatusPaid {
+		owed = money.Zero
+	}
+
+	t, w, posted, err := f.Post(ctx, tenant, "", key, func(w *entity.Wallet) (*entity.LedgerTransaction, error) {
+		return plan(w, owed)
+	})
+	if err != nil || !posted || !owed.IsPositive() {
+		return t, w, posted, err
+	}
+
+	h.Status = entity.InvoiceStatusPaid
+	if f.payments != nil {
+		if _, _, err := f.payments.AddPayment(ctx, &entity.Payment{
+			InvoiceNumber: number,
+			Provider:      entity.PaymentProviderWallet,
+			ExternalID:    key,
+			Status:        entity.PaymentSucceeded,
+			Amount:        owed,
+			Currency:      h.Currency,
+		}, time.Now()); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	return t, w, posted, nil
+}
+
+func (f *fakeLedgerRepo) GetWallet(_ context.Context, tenant string) (*entity.Wallet, error) {
+	w, ok := f.wallets[tenant]
+	if !ok {
+		return nil, repoerrors.ErrNotFound
+	}
+	return w, nil
+}
+
+func (f *fakeLedgerRepo) GetWallets(context.Context) ([]entity.Wallet, error) {
+	var out []entity.Wallet
+	for _, w := range f.wallets {
+		out = append(out, *w)
+	}
+	return out, nil
+}
+
+func (f *fakeLedgerRepo) GetTransactions(_ context.Context, tenant string, _, _ uint64) ([]entity.LedgerTransaction, error) {
+	var out []entity.LedgerTransaction
+	for _, t := range f.transactions {
+		if t.Tenant == tenant {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeLedgerRepo) UnpostedInvoices(_ context.Context, tenant string, _ time.Time) ([]entity.InvoiceHeader, error) {
+	var out []entity.InvoiceHeader
+	for _, h := range f.invoices {
+		posted := false
+		for _, t := range f.transactions {
+			posted = posted || (t.InvoiceNumber != nil && *t.InvoiceNumber == h.Number)
+		}
+		if h.TenantID == tenant && h.Status != entity.InvoiceStatusVoid && !posted {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeLedgerRepo) VoidedInvoices(_ context.Context, tenant string) ([]entity.InvoiceHeader, error) {
+	var out []entity.InvoiceHeader
+	for _, h := range f.invoices {
+		posted, credited := false, false
+		for _, t := range f.transactions {
+			posted = posted || (t.InvoiceNumber != nil && *t.InvoiceNumber == h.Number)
+			credited = credited || t.IdempotencyKey == fmt.Sprintf("void:%d", h.Number)
+		}
+		if h.TenantID == tenant && h.Status == entity.InvoiceStatusVoid && posted && !credited {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+type fakePublisher struct {
+	messages []gokafka.Message
+}
+
+func (f *fakePublisher) WriteMessages(_ context.Context, msgs ...gokafka.Message) error {
+	f.messages = append(f.messages, msgs...)
+	return nil
+}
+
+func (f *fakePublisher) events(t *testing.T) []entity.BalanceEvent {
+	t.Helper()
+
+	var out []entity.BalanceEvent
+	for _, m := range f.messages {
+		var e entity.BalanceEvent
+		require.NoError(t, json.Unmarshal(m.Value, &e))
+		assert.Equal(t, e.Tenant, string(m.Key))
+		out = append(out, e)
+	}
+	return out
+}
+
+func newTestLedger(usage *fakeUsageRepo) (*LedgerService, *fakeLedgerRepo, *fakePublisher) {
+	ledger := newFakeLedgerRepo()
+	events := &fakePublisher{}
+	billing := newTestBilling(usage, basicTariffs())
+	return NewLedgerService(ledger, billing, events), ledger, events
+}
+
+func assertBalanced(t *testing.T, tx *entity.LedgerTransaction) {
+	t.Helper()
+
+	sum := money.Zero
+	for _, e := range tx.Entries {
+		sum = sum.Add(e.Amount)
+	}
+	assert.True(t, sum.IsZero(), "transaction %d is not balanced", tx.ID)
+}
+
+func Test_LedgerPostings(t *testing.T) {
+	s, _, _ := newTestLedger(&fakeUsageRepo{})
+	ctx := context.Background()
+
+	_, _, err := s.Adjust(ctx, "alice", &LedgerInput{Amount: dec("5"), IdempotencyKey: "a-1"})
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+
+	tx, posted, err := s.TopUp(ctx, "alice", &LedgerInput{Amount: dec("100"), IdempotencyKey: "top-1"})
+	require.NoError(t, err)
+	assert.True(t, posted)
+	assert.Equal(t, "top-up", tx.Description)
+	assertBalanced(t, tx)
+
+	// a retry returns the first posting
+	again, posted, err := s.TopUp(ctx, "alice", &LedgerInput{Amount: dec("100"), IdempotencyKey: "top-1"})
+	require.NoError(t, err)
+	assert.False(t, posted)
+	assert.Equal(t, tx.ID, again.ID)
+
+	_, _, err = s.TopUp(ctx, "alice", &LedgerInput{Amount: dec("50"), IdempotencyKey: "top-1"})
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	_, _, err = s.Refund(ctx, "alice", &LedgerInput{Amount: dec("100.01"), IdempotencyKey: "refund-1"})
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+
+	tx, _, err = s.Refund(ctx, "alice", &LedgerInput{Amount: dec("30"), IdempotencyKey: "refund-1"})
+	require.NoError(t, err)
+	assertBalanced(t, tx)
+	assertDecimal(t, dec("-30"), tx.Amount)
+	assert.Equal(t, entity.AccountFunding, tx.Entries[1].Account)
+	assertDecimal(t, dec("-70"), tx.Entries[1].Balance)
+
+	w, err := s.GetWallet(ctx, "alice")
+	require.NoError(t, err)
+	assertDecimal(t, dec("70"), w.Balance)
+	assert.Equal(t, "USD", w.Currency)
+
+	for _, in := range []LedgerInput{
+		{Amount: dec("0"), IdempotencyKey: "top-2"},
+		{Amount: dec("-5"), IdempotencyKey: "top-2"},
+		{Amount: dec("5")},
+	} {
+		_, _, err = s.TopUp(ctx, "alice", &in)
+		assert.ErrorIs(t, err, ErrInvalidLedgerInput)
+	}
+}
+
+func Test_LedgerSync(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+	s, ledger, events := newTestLedger(usage)
+	ctx := context.Background()
+
+	_, _, err := s.TopUp(ctx, "alice", &LedgerInput{Amount: dec("20"), IdempotencyKey: "top-1"})
+	require.NoError(t, err)
+
+	// live usage of 15 is accrued once
+	require.NoError(t, s.Sync(ctx))
+	require.NoError(t, s.Sync(ctx))
+	require.Len(t, ledger.transactions, 2)
+	accrual := ledger.transactions[1]
+	assertBalanced(t, &accrual)
+	assert.Equal(t, entity.LedgerUsage, accrual.Kind)
+	assertDecimal(t, dec("-15"), accrual.Amount)
+	assertDecimal(t, dec("5"), ledger.wallets["alice"].Balance)
+	assertDecimal(t, dec("15"), ledger.wallets["alice"].Accrued)
+
+	// the invoice of 18 replaces the accrual, later usage is accrued again
+	ledger.invoices = []entity.InvoiceHeader{{Number: 7, TenantID: "alice", Currency: "USD", AmountDue: dec("18")}}
+	usage.usage["alice"] = []entity.Usage{usageRow("hello", "hello-b", 1760903600, 1760903602, 200, 0)}
+	require.NoError(t, s.Sync(ctx))
+	require.Len(t, ledger.transactions, 4)
+
+	invoice := ledger.transactions[2]
+	require.NotNil(t, invoice.InvoiceNumber)
+	assert.Equal(t, int64(7), *invoice.InvoiceNumber)
+	assertDecimal(t, dec("-3"), invoice.Amount)
+	assertDecimal(t, dec("-15"), invoice.Accrued)
+	assertDecimal(t, dec("-3"), ledger.transactions[3].Amount)
+	assertDecimal(t, dec("-1"), ledger.wallets["alice"].Balance)
+	assertDecimal(t, dec("3"), ledger.wallets["alice"].Accrued)
+
+	// the wallet went negative, control_plane blocks the tenant until a top-up
+	_, _, err = s.TopUp(ctx, "alice", &LedgerInput{Amount: dec("10"), IdempotencyKey: "top-2"})
+	require.NoError(t, err)
+
+	got := events.events(t)
+	require.Len(t, got, 3)
+	assert.False(t, got[0].Blocked)
+	assert.True(t, got[1].Blocked)
+	assertDecimal(t, dec("-1"), got[1].Balance)
+	assert.False(t, got[2].Blocked)
+
+	// a voided invoice is credited back once
+	ledger.invoices[0].Status = entity.InvoiceStatusVoid
+	require.NoError(t, s.Sync(ctx))
+	require.NoError(t, s.Sync(ctx))
+	require.Len(t, ledger.transactions, 6)
+	credit := ledger.transactions[5]
+	assertBalanced(t, &credit)
+	assert.Equal(t, "void:7", credit.IdempotencyKey)
+	assertDecimal(t, dec("18"), credit.Amount)
+	assertDecimal(t, dec("27"), ledger.wallets["alice"].Balance)
+	assertDecimal(t, dec("3"), ledger.wallets["alice"].Accrued)
+}
+
+func Test_LedgerSettlesInvoices(t *testing.T) {
+	s, ledger, _ := newTestLedger(&fakeUsageRepo{})
+	ctx := context.Background()
+
+	due := utc(2025, time.November, 15, 0)
+	invoices := newFakeInvoiceRepo()
+	invoices.invoices = []entity.Invoice{issuedInvoice(7, "18", &due)}
+	ledger.invoices = []entity.InvoiceHeader{invoices.invoices[0].Header}
+	ledger.payments = invoices
+
+	_, _, err := s.TopUp(ctx, "alice", &LedgerInput{Amount: dec("20"), IdempotencyKey: "top-1"})
+	require.NoError(t, err)
+	require.NoError(t, s.Sync(ctx))
+	require.NoError(t, s.Sync(ctx))
+
+	// the wallet covered the invoice, it is paid once
+	assertDecimal(t, dec("2"), ledger.wallets["alice"].Balance)
+	assert.Equal(t, entity.InvoiceStatusPaid, invoices.invoices[0].Header.Status)
+	require.Len(t, invoices.payments, 1)
+	assert.Equal(t, entity.PaymentProviderWallet, invoices.payments[0].Provider)
+	assertDecimal(t, dec("18"), invoices.payments[0].Amount)
+
+	// so it is neither dunned nor paid again
+	notifier := &fakeNotifier{}
+	now := utc(2025, time.November, 20, 0)
+	payments, _ := newTestPayments(invoices, notifier, &now)
+	require.NoError(t, payments.Dun(ctx))
+	assert.Empty(t, notifier.dunned)
+	assert.Equal(t, entity.InvoiceStatusPaid, invoices.invoices[0].Header.Status)
+
+	_, err = payments.Checkout(ctx, 7, "")
+	assert.ErrorIs(t, err, ErrInvoiceNotOpen)
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/types"
+)
+
+// NotificationService puts notifications in the outbox, the relay publishes
+// them to the notify topic.
+type NotificationService struct {
+	billing Billing
+	outbox  repo.Outbox
+	topic   string
+	money   money.Policy
+}
+
+func NewNotificationService(billing Billing, outbox repo.Outbox, topic string, policy money.Policy) *NotificationService {
+	slog.Debug("component", slog.String("name", "notification service"))
+
+	return &NotificationService{
+		billing: billing,
+		outbox:  outbox,
+		topic:   topic,
+		money:   policy,
+	}
+}
+
+// NotifyStop sends the cost of the stopped pod to the notifier. When the
+// action carries no replica the whole function is reported. The cost is
+// recorded with the notification, so an action delivered again is not
+// notified twice.
+func (s *NotificationService) NotifyStop(ctx context.Context, action types.Action) error {
+	inv, err := s.billing.Draft(ctx, action.Tenant)
+	if err != nil {
+		return err
+	}
+
+	policy := s.policy(inv.Header.Currency)
+	totals, ok := stoppedTotals(inv, action)
+	if !ok {
+		slog.Warn("no usage found for stopped pod",
+			slog.String("tenant", action.Tenant),
+			slog.String("pod", action.Pod),
+			slog.String("replica", action.Replica))
+	}
+
+	notification := entity.Notification{
+		Kind:      entity.NotificationStop,
+		TenantID:  action.Tenant,
+		Email:     action.Tenant,
+		MemoryMB:  totals.MemoryMBSec,
+		CPUSec:    totals.CPUSec,
+		CPUCost:   totals.CPUCost,
+		TotalCost: totals.TotalCost,
+		Currency:  policy.Currency.Code,
+		Precision: policy.Precision,
+		Rounding:  string(policy.Rounding),
+		PodName:   action.Pod,
+		Timestamp: time.Now().Unix(),
+	}
+
+	msg, err := s.message(notification)
+	if err != nil {
+		return err
+	}
+
+	processed, err := s.outbox.ProcessAction(ctx, &entity.ProcessedAction{
+		ID:        actionID(action),
+		Tenant:    action.Tenant,
+		Pod:       action.Pod,
+		Replica:   action.Replica,
+		Action:    string(action.Action),
+		TotalCost: totals.TotalCost,
+		Currency:  policy.Currency.Code,
+	}, msg)
+	if err != nil {
+		return err
+	}
+	if !processed {
+		slog.Info("stop action was processed before",
+			slog.String("tenant", action.Tenant),
+			slog.String("pod", action.Pod),
+			slog.String("replica", action.Replica))
+		return nil
+	}
+
+	slog.Info("sent stop notification",
+		slog.String("pod", action.Pod),
+		slog.String("tenant", action.Tenant),
+		slog.Float64("memory_mb", totals.MemoryMBSec),
+		slog.Float64("cpu_sec", totals.CPUSec),
+		slog.String("total_cost", policy.Format(totals.TotalCost)))
+
+	return nil
+}
+
+// NotifyBudget tells the tenant their budget crossed a threshold or was
+// capped.
+func (s *NotificationService) NotifyBudget(ctx context.Context, alert entity.BudgetAlert) error {
+	policy := s.policy(alert.Currency)
+	notification := entity.Notification{
+		Kind:      entity.NotificationBudget,
+		TenantID:  alert.Tenant,
+		Email:     alert.Tenant,
+		TotalCost: alert.Spend,
+		Currency:  policy.Currency.Code,
+		Precision: policy.Precision,
+		Rounding:  string(policy.Rounding),
+		Timestamp: time.Now().Unix(),
+		Budget:    &alert,
+	}
+
+	if err := s.publish(ctx, notification); err != nil {
+		return err
+	}
+
+	slog.Info("sent budget notification",
+		slog.String("tenant", alert.Tenant),
+		slog.String("kind", alert.Kind),
+		slog.Int("threshold", alert.Threshold),
+		slog.String("spend", policy.Format(alert.Spend)))
+
+	return nil
+}
+
+// NotifyInvoice tells the tenant an invoice was issued.
+func (s *NotificationService) NotifyInvoice(ctx context.Context, h entity.InvoiceHeader) error {
+	policy := s.policy(h.Currency)
+	notification := entity.Notification{
+		Kind:      entity.NotificationInvoice,
+		TenantID:  h.TenantID,
+		Email:     h.TenantID,
+		TotalCost: h.AmountDue,
+		Currency:  policy.Currency.Code,
+		Precision: policy.Precision,
+		Rounding:  string(policy.Rounding),
+		Timestamp: time.Now().Unix(),
+		Invoice:   &h,
+	}
+
+	if err := s.publish(ctx, notification); err != nil {
+		return err
+	}
+
+	slog.Info("sent invoice notification",
+		slog.String("tenant", h.TenantID),
+		slog.Int64("number", h.Number),
+		slog.String("amount_due", policy.Format(h.AmountDue)))
+
+	return nil
+}
+
+// NotifyDunning reminds the tenant of an overdue invoice and what is still
+// owed on it.
+func (s *NotificationService) NotifyDunning(ctx context.Context, h entity.InvoiceHeader, outstanding money.Decimal) error {
+	policy := s.policy(h.Currency)
+	notification := entity.Notification{
+		Kind:      entity.NotificationDunning,
+		TenantID:  h.TenantID,
+		Email:     h.TenantID,
+		TotalCost: outstanding,
+		Currency:  policy.Currency.Code,
+		Precision: policy.Precision,
+		Rounding:  string(policy.Rounding),
+		Timestamp: time.Now().Unix(),
+		Invoice:   &h,
+	}
+
+	if err := s.publish(ctx, notification); err != nil {
+		return err
+	}
+
+	slog.Info("sent dunning notification",
+		slog.String("tenant", h.TenantID),
+		slog.Int64("number", h.Number),
+		slog.Int("level", h.DunningLevel),
+		slog.String("outstanding", policy.Format(outstanding)))
+
+	return nil
+}
+
+func (s *NotificationService) publish(ctx context.Context, notification entity.Notification) error {
+	msg, err := s.message(notification)
+	if err != nil {
+		return err
+	}
+
+	return s.outbox.Add(ctx, msg)
+}
+
+// message keys the notification by tenant, so a tenant's notifications stay
+// in order.
+func (s *NotificationService) message(notification entity.Notification) (entity.OutboxMessage, error) {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return entity.OutboxMessage{}, err
+	}
+
+	return entity.OutboxMessage{Topic: s.topic, Key: notification.TenantID, Payload: payload}, nil
+}
+
+// actionID identifies an action however often meter_agent or the meter
+// deliver it: a replica emits each kind of action once per second at most.
+func actionID(a types.Action) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%d", a.Tenant, a.Pod, a.Replica, a.Action, a.Timestamp)
+}
+
+// policy rounds amounts in the given currency, the billing one if empty.
+func (s *NotificationService) policy(currency string) money.Policy {
+	c, err := money.ParseCurrency(currency)
+	if err != nil {
+		return s.money
+	}
+	return s.money.In(c)
+}
+
+func stoppedTotals(inv *entity.Invoice, action types.Action) (entity.Totals, bool) {
+	fn := inv.Function(action.Pod)
+	if fn == nil {
+		return entity.Totals{}, false
+	}
+	if action.Replica == "" {
+		return fn.Totals, true
+	}
+
+	for _, pod := range fn.Pods {
+		if pod.Pod == action.Replica {
+			return pod.Totals, true
+		}
+	}
+
+	return entity.Totals{}, false
+}
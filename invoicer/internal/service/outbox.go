@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+const (
+	// relayBatch is how many outbox messages are published at once.
+	relayBatch = 100
+	// relayMaxBackoff caps the wait between attempts to publish a message.
+	relayMaxBackoff = 5 * time.Minute
+)
+
+// OutboxRelay publishes the messages stored in the outbox to Kafka. A message
+// is published at least once: when the relay dies after writing to Kafka but
+// before deleting the message, it is published again. Messages with the same
+// topic and key, those of a tenant, are published in order: while a failed
+// one backs off the later ones wait for it.
+type OutboxRelay struct {
+	outbox repo.Outbox
+	// publishers are the writers of every topic messages are stored for
+	publishers map[string]Publisher
+	now        func() time.Time
+}
+
+func NewOutboxRelay(outbox repo.Outbox, publishers map[string]Publisher) *OutboxRelay {
+	slog.Debug("component", slog.String("name", "outbox relay"))
+
+	return &OutboxRelay{
+		outbox:     outbox,
+		publishers: publishers,
+		now:        time.Now,
+	}
+}
+
+// Relay publishes every message that is due until none is left and returns
+// how many it published. A batch that fails to publish is retried with
+// exponential backoff on later runs.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := r.outbox.Relay(ctx, r.now(), relayBatch, func(msgs []entity.OutboxMessage) error {
+			return r.publish(ctx, msgs)
+		}, retryIn)
+		total += n
+		if err != nil || n < relayBatch {
+			return total, err
+		}
+	}
+}
+
+// publish writes the messages of each topic in their outbox order.
+func (r *OutboxRelay) publish(ctx context.Context, msgs []entity.OutboxMessage) error {
+	var (
+		topics  []string
+		byTopic = make(map[string][]gokafka.Message)
+	)
+	for _, m := range msgs {
+		if _, ok := byTopic[m.Topic]; !ok {
+			topics = append(topics, m.Topic)
+		}
+		byTopic[m.Topic] = append(byTopic[m.Topic], gokafka.Message{Key: []byte(m.Key), Value: m.Payload})
+	}
+
+	for _, topic := range topics {
+		p, ok := r.publishers[topic]
+		if !ok {
+			return fmt.Errorf("no publisher for topic %s", topic)
+		}
+		if err := p.WriteMessages(ctx, byTopic[topic]...); err != nil {
+			return fmt.Errorf("publish to %s: %w", topic, err)
+		}
+	}
+
+	return nil
+}
+
+// retryIn doubles the wait with every failed attempt, from a second up to
+// relayMaxBackoff.
+func retryIn(attempts int) time.Duration {
+	if attempts > 16 {
+		return relayMaxBackoff
+	}
+	return min(time.Second<<(attempts-1), relayMaxBackoff)
+}
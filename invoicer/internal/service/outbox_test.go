@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/types"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+// fakeOutboxRepo keeps the outbox in memory with the due time of every
+// message.
+type fakeOutboxRepo struct {
+	messages  []entity.OutboxMessage
+	due       map[int64]time.Time
+	processed map[string]entity.ProcessedAction
+	lastID    int64
+}
+
+func newFakeOutboxRepo() *fakeOutboxRepo {
+	return &fakeOutboxRepo{
+		due:       make(map[int64]time.Time),
+		processed: make(map[string]entity.ProcessedAction),
+	}
+}
+
+func (f *fakeOutboxRepo) Add(_ context.Context, msgs ...entity.OutboxMessage) error {
+	for _, m := range msgs {
+		f.lastID++
+		m.ID = f.lastID
+		f.messages = append(f.messages, m)
+		f.due[m.ID] = time.Time{}
+	}
+	return nil
+}
+
+func (f *fakeOutboxRepo) ProcessAction(ctx context.Context, a *entity.ProcessedAction, msgs ...entity.OutboxMessage) (bool, error) {
+	if _, ok := f.processed[a.ID]; ok {
+		return false, nil
+	}
+	f.processed[a.ID] = *a
+	return true, f.Add(ctx, msgs...)
+}
+
+func (f *fakeOutboxRepo) Relay(_ context.Context, now time.Time, limit uint64, publish func([]entity.OutboxMessage) error, retryIn func(attempts int) time.Duration) (int, error) {
+	var batch []entity.OutboxMessage
+	// topics and keys with an older message backing off
+	waiting := make(map[[2]string]bool)
+	for _, m := range f.messages {
+		key := [2]string{m.Topic, m.Key}
+		if f.due[m.ID].After(now) {
+			waiting[key] = true
+			continue
+		}
+		if uint64(len(batch)) < limit && !waiting[key] {
+			batch = append(batch, m)
+		}
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if err := publish(batch); err != nil {
+		for i := range f.messages {
+			m := &f.messages[i]
+			for _, b := range batch {
+				if b.ID == m.ID {
+					m.Attempts++
+					f.due[m.ID] = now.Add(retryIn(m.Attempts))
+				}
+			}
+		}
+		return 0, err
+	}
+
+	published := make(map[int64]bool, len(batch))
+	for _, b := range batch {
+		published[b.ID] = true
+	}
+	var kept []entity.OutboxMessage
+	for _, m := range f.messages {
+		if !published[m.ID] {
+			kept = append(kept, m)
+		}
+	}
+	f.messages = kept
+	return len(batch), nil
+}
+
+type failingPublisher struct {
+	fakePublisher
+	err error
+}
+
+func (f *failingPublisher) WriteMessages(ctx context.Context, msgs ...gokafka.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.fakePublisher.WriteMessages(ctx, msgs...)
+}
+
+func Test_NotifyStopOnce(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+	outbox := newFakeOutboxRepo()
+	s := NewNotificationService(newTestBilling(usage, basicTariffs()), outbox, "notify", money.DefaultPolicy())
+	ctx := context.Background()
+
+	action := types.Action{Pod: "hello", Replica: "hello-a", Tenant: "alice", Action: types.ActionStop, Timestamp: 1760900010}
+	require.NoError(t, s.NotifyStop(ctx, action))
+	// a redelivered stop records and notifies nothing
+	require.NoError(t, s.NotifyStop(ctx, action))
+
+	require.Len(t, outbox.messages, 1)
+	msg := outbox.messages[0]
+	assert.Equal(t, "notify", msg.Topic)
+	assert.Equal(t, "alice", msg.Key)
+
+	var n entity.Notification
+	require.NoError(t, json.Unmarshal(msg.Payload, &n))
+	assert.Equal(t, entity.NotificationStop, n.Kind)
+	assertDecimal(t, dec("15"), n.TotalCost)
+
+	require.Len(t, outbox.processed, 1)
+	processed := outbox.processed[actionID(action)]
+	assert.Equal(t, "hello-a", processed.Replica)
+	assertDecimal(t, dec("15"), processed.TotalCost)
+	assert.Equal(t, "USD", processed.Currency)
+
+	// another stop of the replica is another action
+	action.Timestamp++
+	require.NoError(t, s.NotifyStop(ctx, action))
+	assert.Len(t, outbox.messages, 2)
+}
+
+func Test_OutboxRelay(t *testing.T) {
+	outbox := newFakeOutboxRepo()
+	notify := &failingPublisher{}
+	relay := NewOutboxRelay(outbox, map[string]Publisher{"notify": notify})
+	now := utc(2025, time.November, 1, 0)
+	relay.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, tenant := range []string{"alice", "bob"} {
+		require.NoError(t, outbox.Add(ctx, entity.OutboxMessage{Topic: "notify", Key: tenant, Payload: []byte(`{}`)}))
+	}
+
+	// a failed publish keeps the messages and backs off
+	notify.err = errors.New("broker not available")
+	n, err := relay.Relay(ctx)
+	assert.Error(t, err)
+	assert.Zero(t, n)
+	require.Len(t, outbox.messages, 2)
+	assert.Equal(t, 1, outbox.messages[0].Attempts)
+
+	notify.err = nil
+	n, err = relay.Relay(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "the messages are not due yet")
+
+	now = now.Add(time.Second)
+	n, err = relay.Relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Empty(t, outbox.messages)
+	require.Len(t, notify.messages, 2)
+	assert.Equal(t, "alice", string(notify.messages[0].Key))
+	assert.Equal(t, "bob", string(notify.messages[1].Key))
+
+	// messages for a topic without a publisher wait
+	require.NoError(t, outbox.Add(ctx, entity.OutboxMessage{Topic: "unknown", Key: "alice", Payload: []byte(`{}`)}))
+	_, err = relay.Relay(ctx)
+	assert.Error(t, err)
+	assert.Len(t, outbox.messages, 1)
+}
+
+func Test_OutboxRelayKeepsTenantOrder(t *testing.T) {
+	outbox := newFakeOutboxRepo()
+	notify := &failingPublisher{}
+	relay := NewOutboxRelay(outbox, map[string]Publisher{"notify": notify})
+	now := utc(2025, time.November, 1, 0)
+	relay.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, outbox.Add(ctx, entity.OutboxMessage{Topic: "notify", Key: "alice", Payload: []byte(`{"n":1}`)}))
+	notify.err = errors.New("broker not available")
+	_, err := relay.Relay(ctx)
+	require.Error(t, err)
+
+	// alice's next message waits for the one backing off, bob's goes out
+	notify.err = nil
+	require.NoError(t, outbox.Add(ctx,
+		entity.OutboxMessage{Topic: "notify", Key: "alice", Payload: []byte(`{"n":2}`)},
+		entity.OutboxMessage{Topic: "notify", Key: "bob", Payload: []byte(`{"n":1}`)}))
+	n, err := relay.Relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, notify.messages, 1)
+	assert.Equal(t, "bob", string(notify.messages[0].Key))
+
+	now = now.Add(time.Second)
+	n, err = relay.Relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, notify.messages, 3)
+	assert.JSONEq(t, `{"n":1}`, string(notify.messages[1].Value))
+	assert.JSONEq(t, `{"n":2}`, string(notify.messages[2].Value))
+}
+
+func Test_RelayBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, retryIn(1))
+	assert.Equal(t, 2*time.Second, retryIn(2))
+	assert.Equal(t, 8*time.Second, retryIn(4))
+	assert.Equal(t, relayMaxBackoff, retryIn(10))
+	assert.Equal(t, relayMaxBackoff, retryIn(100))
+}
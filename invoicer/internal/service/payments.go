@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// Issued invoices go through a small lifecycle:
+//
+//   - issued: waiting for payment until the due date
+//   - overdue: past the due date and not paid, reminders are sent
+//   - paid: succeeded payments cover the amount due
+//   - void: cancelled, nothing is owed
+//
+// Payments come in through provider webhooks. A provider may deliver the same
+// payment more than once, it is recorded once by its external id.
+
+type PaymentService struct {
+	invoiceRepo  repo.Invoice
+	notification Notification
+	providers    map[string]PaymentProvider
+	// fallback is the provider of checkouts that name none
+	fallback         string
+	reminderInterval time.Duration
+	maxReminders     int
+	now              func() time.Time
+}
+
+type PaymentConfig struct {
+	// ReminderInterval is the time between reminders of an overdue invoice.
+	ReminderInterval time.Duration
+	// MaxReminders is how many reminders an overdue invoice gets at most.
+	MaxReminders int
+}
+
+func NewPaymentService(invoiceRepo repo.Invoice, notification Notification, providers []PaymentProvider, cfg PaymentConfig) *PaymentService {
+	slog.Debug("component", slog.String("name", "payment service"))
+
+	s := &PaymentService{
+		invoiceRepo:      invoiceRepo,
+		notification:     notification,
+		providers:        make(map[string]PaymentProvider, len(providers)),
+		reminderInterval: cfg.ReminderInterval,
+		maxReminders:     cfg.MaxReminders,
+		now:              time.Now,
+	}
+	for _, p := range providers {
+		if s.fallback == "" {
+			s.fallback = p.Name()
+		}
+		s.providers[p.Name()] = p
+	}
+
+	return s
+}
+
+// Checkout starts paying what is still owed on an open invoice with the
+// provider, the default one if empty.
+func (s *PaymentService) Checkout(ctx context.Context, number int64, provider string) (*entity.Checkout, error) {
+	if provider == "" {
+		provider = s.fallback
+	}
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, ErrPaymentProviderNotFound
+	}
+
+	h, err := s.header(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	if !payable(h) {
+		return nil, ErrInvoiceNotOpen
+	}
+
+	outstanding, err := s.outstanding(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Checkout(ctx, h, outstanding)
+}
+
+// HandleWebhook records the payment a provider reports. The bool tells
+// whether the payment is new, a delivery seen before changes nothing.
+func (s *PaymentService) HandleWebhook(ctx context.Context, provider string, header http.Header, body []byte) (*entity.Payment, bool, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, false, ErrPaymentProviderNotFound
+	}
+
+	payment, err := p.ParseWebhook(header, body)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	payment.Provider = provider
+
+	h, err := s.header(ctx, payment.InvoiceNumber)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := checkPayment(payment, h); err != nil {
+		return nil, false, err
+	}
+
+	updated, recorded, err := s.invoiceRepo.AddPayment(ctx, payment, s.now())
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, false, ErrInvoiceNotFound
+		}
+		return nil, false, err
+	}
+	if !recorded {
+		slog.Info("payment was recorded before",
+			slog.String("provider", provider),
+			slog.String("external_id", payment.ExternalID))
+		return payment, false, nil
+	}
+
+	slog.Info("recorded payment",
+		slog.Int64("number", payment.InvoiceNumber),
+		slog.String("provider", provider),
+		slog.String("external_id", payment.ExternalID),
+		slog.String("status", payment.Status),
+		slog.String("amount", payment.Amount.String()))
+	if updated.Status == entity.InvoiceStatusPaid && h.Status != entity.InvoiceStatusPaid {
+		slog.Info("invoice paid", slog.Int64("number", updated.Number), slog.String("tenant", updated.TenantID))
+	}
+
+	return payment, true, nil
+}
+
+func (s *PaymentService) GetPayments(ctx context.Context, number int64) ([]entity.Payment, error) {
+	if _, err := s.header(ctx, number); err != nil {
+		return nil, err
+	}
+
+	return s.invoiceRepo.GetPayments(ctx, number)
+}
+
+// Void cancels an issued or overdue invoice.
+func (s *PaymentService) Void(ctx context.Context, number int64) (*entity.InvoiceHeader, error) {
+	h, err := s.invoiceRepo.Void(ctx, number, s.now())
+	if err != nil {
+		switch {
+		case errors.Is(err, repoerrors.ErrNotFound):
+			return nil, ErrInvoiceNotFound
+		case errors.Is(err, repoerrors.ErrConflict):
+			return nil, ErrInvoiceNotOpen
+		}
+		return nil, err
+	}
+
+	slog.Info("voided invoice", slog.Int64("number", h.Number), slog.String("tenant", h.TenantID))
+
+	return h, nil
+}
+
+// Dun turns invoices past their due date overdue and reminds the tenants of
+// overdue invoices, every reminder interval up to the maximum number of
+// reminders. A reminder is taken before it is sent, so replicas never send
+// the same one twice; a reminder that failed to send is not retried.
+func (s *PaymentService) Dun(ctx context.Context) error {
+	now := s.now()
+
+	overdue, err := s.invoiceRepo.MarkOverdue(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, h := range overdue {
+		slog.Info("invoice is overdue", slog.Int64("number", h.Number), slog.String("tenant", h.TenantID))
+	}
+
+	if s.maxReminders <= 0 {
+		return nil
+	}
+
+	due, err := s.invoiceRepo.GetOverdue(ctx, s.maxReminders, now.Add(-s.reminderInterval))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, h := range due {
+		dunned, err := s.invoiceRepo.Dun(ctx, h.Number, h.DunningLevel, now)
+		if err != nil {
+			if errors.Is(err, repoerrors.ErrConflict) {
+				// another replica sent it or the invoice was paid meanwhile
+				continue
+			}
+			errs = append(errs, fmt.Errorf("invoice %d: %w", h.Number, err))
+			continue
+		}
+
+		outstanding, err := s.outstanding(ctx, dunned)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invoice %d: %w", h.Number, err))
+			continue
+		}
+		if err := s.notification.NotifyDunning(ctx, *dunned, outstanding); err != nil {
+			slog.Error("failed to send dunning notification", slog.Int64("number", h.Number), slog.String("error", err.Error()))
+			errs = append(errs, fmt.Errorf("invoice %d: %w", h.Number, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *PaymentService) header(ctx context.Context, number int64) (*entity.InvoiceHeader, error) {
+	inv, err := s.invoiceRepo.GetByNumber(ctx, number)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+
+	return &inv.Header, nil
+}
+
+// outstanding is the amount due less the succeeded payments.
+func (s *PaymentService) outstanding(ctx context.Context, h *entity.InvoiceHeader) (money.Decimal, error) {
+	payments, err := s.invoiceRepo.GetPayments(ctx, h.Number)
+	if err != nil {
+		return money.Zero, err
+	}
+
+	out := h.AmountDue
+	for _, p := range payments {
+		if p.Status == entity.PaymentSucceeded {
+			out = out.Sub(p.Amount)
+		}
+	}
+	if out.IsNegative() {
+		return money.Zero, nil
+	}
+
+	return out, nil
+}
+
+func payable(h *entity.InvoiceHeader) bool {
+	return h.Status == entity.InvoiceStatusIssued || h.Status == entity.InvoiceStatusOverdue
+}
+
+func checkPayment(p *entity.Payment, h *entity.InvoiceHeader) error {
+	switch {
+	case p.ExternalID == "":
+		return fmt.Errorf("%w: external id is required", ErrInvalidPayment)
+	case p.Status != entity.PaymentSucceeded && p.Status != entity.PaymentFailed:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidPayment, p.Status)
+	case !p.Amount.IsPositive():
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidPayment)
+	case p.Currency != h.Currency:
+		return fmt.Errorf("%w: invoice %d is in %s, payment in %s", ErrInvalidPayment, h.Number, h.Currency, p.Currency)
+	}
+
+	return nil
+}
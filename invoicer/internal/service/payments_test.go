@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/invoicer/internal/webapi/payment"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+func (f *fakeInvoiceRepo) header(number int64) *entity.InvoiceHeader {
+	for i := range f.invoices {
+		if f.invoices[i].Header.Number == number {
+			return &f.invoices[i].Header
+		}
+	}
+	return nil
+}
+
+func (f *fakeInvoiceRepo) AddPayment(_ context.Context, p *entity.Payment, at time.Time) (*entity.InvoiceHeader, bool, error) {
+	h := f.header(p.InvoiceNumber)
+	if h == nil {
+		return nil, false, repoerrors.ErrNotFound
+	}
+	for _, existing := range f.payments {
+		if existing.Provider == p.Provider && existing.ExternalID == p.ExternalID {
+			return h, false, nil
+		}
+	}
+	p.ID = int64(len(f.payments) + 1)
+	f.payments = append(f.payments, *p)
+
+	if p.Status == entity.PaymentSucceeded && (h.Status == entity.InvoiceStatusIssued || h.Status == entity.InvoiceStatusOverdue) {
+		paid := money.Zero
+		for _, existing := range f.payments {
+			if existing.InvoiceNumber == h.Number && existing.Status == entity.PaymentSucceeded {
+				paid = paid.Add(existing.Amount)
+			}
+		}
+		if paid.GreaterThanOrEqual(h.AmountDue) {
+			h.Status = entity.InvoiceStatusPaid
+			h.PaidAt = &at
+		}
+	}
+	copied := *h
+	return &copied, true, nil
+}
+
+func (f *fakeInvoiceRepo) GetPayments(_ context.Context, number int64) ([]entity.Payment, error) {
+	out := []entity.Payment{}
+	for _, p := range f.payments {
+		if p.InvoiceNumber == number {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeInvoiceRepo) Void(_ context.Context, number int64, at time.Time) (*entity.InvoiceHeader, error) {
+	h := f.header(number)
+	switch {
+	case h == nil:
+		return nil, repoerrors.ErrNotFound
+	case h.Status != entity.InvoiceStatusIssued && h.Status != entity.InvoiceStatusOverdue:
+		return nil, repoerrors.ErrConflict
+	}
+	h.Status = entity.InvoiceStatusVoid
+	h.VoidedAt = &at
+	copied := *h
+	return &copied, nil
+}
+
+func (f *fakeInvoiceRepo) MarkOverdue(_ context.Context, at time.Time) ([]entity.InvoiceHeader, error) {
+	var out []entity.InvoiceHeader
+	for i := range f.invoices {
+		h := &f.invoices[i].Header
+		if h.Status == entity.InvoiceStatusIssued && h.DueAt != nil && h.DueAt.Before(at) {
+			h.Status = entity.InvoiceStatusOverdue
+			out = append(out, *h)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeInvoiceRepo) GetOverdue(_ context.Context, maxLevel int, dunnedBefore time.Time) ([]entity.InvoiceHeader, error) {
+	var out []entity.InvoiceHeader
+	for _, inv := range f.invoices {
+		h := inv.Header
+		if h.Status == entity.InvoiceStatusOverdue && h.DunningLevel < maxLevel && (h.DunnedAt == nil || h.DunnedAt.Before(dunnedBefore)) {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeInvoiceRepo) Dun(_ context.Context, number int64, level int, at time.Time) (*entity.InvoiceHeader, error) {
+	h := f.header(number)
+	switch {
+	case h == nil:
+		return nil, repoerrors.ErrNotFound
+	case h.Status != entity.InvoiceStatusOverdue || h.DunningLevel != level:
+		return nil, repoerrors.ErrConflict
+	}
+	h.DunningLevel = level + 1
+	h.DunnedAt = &at
+	copied := *h
+	return &copied, nil
+}
+
+func issuedInvoice(number int64, amountDue string, dueAt *time.Time) entity.Invoice {
+	return entity.Invoice{Header: entity.InvoiceHeader{
+		Number:    number,
+		Status:    entity.InvoiceStatusIssued,
+		TenantID:  "alice",
+		Currency:  "USD",
+		AmountDue: dec(amountDue),
+		DueAt:     dueAt,
+	}}
+}
+
+func newTestPayments(invoices *fakeInvoiceRepo, notifier *fakeNotifier, now *time.Time) (*PaymentService, *payment.Local) {
+	local := payment.NewLocal("secret", "http://localhost:8080")
+	s := NewPaymentService(invoices, notifier, []PaymentProvider{local}, PaymentConfig{
+		ReminderInterval: 7 * 24 * time.Hour,
+		MaxReminders:     2,
+	})
+	s.now = func() time.Time { return *now }
+	return s, local
+}
+
+// webhook signs the payment like the local provider's webhooks are signed.
+func webhook(t *testing.T, local *payment.Local, w payment.Webhook) (http.Header, []byte) {
+	t.Helper()
+
+	body, err := json.Marshal(w)
+	require.NoError(t, err)
+
+	header := http.Header{}
+	header.Set(payment.SignatureHeader, local.Sign(body))
+	return header, body
+}
+
+func Test_PaymentWebhook(t *testing.T) {
+	invoices := newFakeInvoiceRepo()
+	invoices.invoices = []entity.Invoice{issuedInvoice(1, "22.2", nil)}
+	now := utc(2025, time.November, 5, 0)
+	s, local := newTestPayments(invoices, &fakeNotifier{}, &now)
+	ctx := context.Background()
+
+	checkout, err := s.Checkout(ctx, 1, "")
+	require.NoError(t, err)
+	assert.Equal(t, "local", checkout.Provider)
+	assertDecimal(t, dec("22.2"), checkout.Amount)
+
+	// a partial payment is recorded once however often it is delivered
+	header, body := webhook(t, local, payment.Webhook{ID: "p-1", InvoiceNumber: 1, Status: entity.PaymentSucceeded, Amount: dec("10"), Currency: "USD"})
+	for i, want := range []bool{true, false} {
+		_, recorded, err := s.HandleWebhook(ctx, "local", header, body)
+		require.NoError(t, err)
+		assert.Equal(t, want, recorded, "delivery %d", i)
+	}
+	assert.Equal(t, entity.InvoiceStatusIssued, invoices.invoices[0].Header.Status)
+
+	checkout, err = s.Checkout(ctx, 1, "local")
+	require.NoError(t, err)
+	assertDecimal(t, dec("12.2"), checkout.Amount)
+
+	// failed payments count for nothing
+	header, body = webhook(t, local, payment.Webhook{ID: "p-2", InvoiceNumber: 1, Status: entity.PaymentFailed, Amount: dec("12.2"), Currency: "USD"})
+	_, _, err = s.HandleWebhook(ctx, "local", header, body)
+	require.NoError(t, err)
+	assert.Equal(t, entity.InvoiceStatusIssued, invoices.invoices[0].Header.Status)
+
+	header, body = webhook(t, local, payment.Webhook{ID: "p-3", InvoiceNumber: 1, Status: entity.PaymentSucceeded, Amount: dec("12.2"), Currency: "USD"})
+	_, recorded, err := s.HandleWebhook(ctx, "local", header, body)
+	require.NoError(t, err)
+	assert.True(t, recorded)
+	assert.Equal(t, entity.InvoiceStatusPaid, invoices.invoices[0].Header.Status)
+	require.NotNil(t, invoices.invoices[0].Header.PaidAt)
+	assert.Equal(t, now, *invoices.invoices[0].Header.PaidAt)
+
+	payments, err := s.GetPayments(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, payments, 3)
+
+	_, err = s.Checkout(ctx, 1, "")
+	assert.ErrorIs(t, err, ErrInvoiceNotOpen)
+	_, err = s.Void(ctx, 1)
+	assert.ErrorIs(t, err, ErrInvoiceNotOpen)
+}
+
+func Test_PaymentWebhookRejected(t *testing.T) {
+	invoices := newFakeInvoiceRepo()
+	invoices.invoices = []entity.Invoice{issuedInvoice(1, "22.2", nil)}
+	now := utc(2025, time.November, 5, 0)
+	s, local := newTestPayments(invoices, &fakeNotifier{}, &now)
+	ctx := context.Background()
+
+	valid := payment.Webhook{ID: "p-1", InvoiceNumber: 1, Status: entity.PaymentSucceeded, Amount: dec("22.2"), Currency: "USD"}
+
+	header, body := webhook(t, payment.NewLocal("other", ""), valid)
+	_, _, err := s.HandleWebhook(ctx, "local", header, body)
+	assert.ErrorIs(t, err, ErrInvalidWebhook)
+
+	header, body = webhook(t, local, valid)
+	_, _, err = s.HandleWebhook(ctx, "stripe", header, body)
+	assert.ErrorIs(t, err, ErrPaymentProviderNotFound)
+
+	for _, w := range []payment.Webhook{
+		{ID: "p-1", InvoiceNumber: 1, Status: entity.PaymentSucceeded, Amount: dec("22.2"), Currency: "EUR"},
+		{ID: "p-1", InvoiceNumber: 1, Status: entity.PaymentSucceeded, Amount: dec("0"), Currency: "USD"},
+		{ID: "p-1", InvoiceNumber: 1, Status: "pending", Amount: dec("22.2"), Currency: "USD"},
+		{ID: "", InvoiceNumber: 1, Status: entity.PaymentSucceeded, Amount: dec("22.2"), Currency: "USD"},
+	} {
+		header, body := webhook(t, local, w)
+		_, _, err := s.HandleWebhook(ctx, "local", header, body)
+		assert.ErrorIs(t, err, ErrInvalidPayment)
+	}
+
+	header, body = webhook(t, local, payment.Webhook{ID: "p-1", InvoiceNumber: 9, Status: entity.PaymentSucceeded, Amount: dec("1"), Currency: "USD"})
+	_, _, err = s.HandleWebhook(ctx, "local", header, body)
+	assert.ErrorIs(t, err, ErrInvoiceNotFound)
+
+	assert.Empty(t, invoices.payments)
+}
+
+func Test_PaymentVoid(t *testing.T) {
+	invoices := newFakeInvoiceRepo()
+	invoices.invoices = []entity.Invoice{issuedInvoice(1, "22.2", nil)}
+	now := utc(2025, time.November, 5, 0)
+	s, _ := newTestPayments(invoices, &fakeNotifier{}, &now)
+	ctx := context.Background()
+
+	h, err := s.Void(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, entity.InvoiceStatusVoid, h.Status)
+	require.NotNil(t, h.VoidedAt)
+
+	_, err = s.Void(ctx, 1)
+	assert.ErrorIs(t, err, ErrInvoiceNotOpen)
+	_, err = s.Checkout(ctx, 1, "")
+	assert.ErrorIs(t, err, ErrInvoiceNotOpen)
+	_, err = s.Void(ctx, 2)
+	assert.ErrorIs(t, err, ErrInvoiceNotFound)
+}
+
+func Test_PaymentDunning(t *testing.T) {
+	invoices := newFakeInvoiceRepo()
+	novemberDue, decemberDue := utc(2025, time.November, 15, 0), utc(2025, time.December, 1, 0)
+	paid := issuedInvoice(3, "5", &novemberDue)
+	paid.Header.Status = entity.InvoiceStatusPaid
+	invoices.invoices = []entity.Invoice{
+		issuedInvoice(1, "22.2", &novemberDue),
+		issuedInvoice(2, "7", &decemberDue),
+		paid,
+		// issued before payment terms, never overdue
+		issuedInvoice(4, "9", nil),
+	}
+	notifier := &fakeNotifier{}
+	now := utc(2025, time.November, 20, 0)
+	s, local := newTestPayments(invoices, notifier, &now)
+	ctx := context.Background()
+
+	dunned := func() []int64 {
+		var out []int64
+		for _, h := range notifier.dunned {
+			out = append(out, h.Number)
+		}
+		return out
+	}
+
+	// the first reminder goes out once the invoice is overdue, only once
+	require.NoError(t, s.Dun(ctx))
+	require.NoError(t, s.Dun(ctx))
+	assert.Equal(t, entity.InvoiceStatusOverdue, invoices.invoices[0].Header.Status)
+	assert.Equal(t, entity.InvoiceStatusIssued, invoices.invoices[1].Header.Status)
+	assert.Equal(t, []int64{1}, dunned())
+	assert.Equal(t, 1, notifier.dunned[0].DunningLevel)
+
+	// the next one a reminder interval later
+	now = utc(2025, time.November, 26, 0)
+	require.NoError(t, s.Dun(ctx))
+	assert.Equal(t, []int64{1}, dunned())
+	now = utc(2025, time.November, 28, 0)
+	require.NoError(t, s.Dun(ctx))
+	assert.Equal(t, []int64{1, 1}, dunned())
+
+	// no more than the maximum number of reminders
+	now = utc(2025, time.December, 6, 0)
+	require.NoError(t, s.Dun(ctx))
+	assert.Equal(t, []int64{1, 1, 2}, dunned())
+	assert.Equal(t, entity.InvoiceStatusPaid, invoices.invoices[2].Header.Status)
+	assert.Equal(t, entity.InvoiceStatusIssued, invoices.invoices[3].Header.Status)
+
+	// paying an overdue invoice ends its reminders
+	header, body := webhook(t, local, payment.Webhook{ID: "p-1", InvoiceNumber: 2, Status: entity.PaymentSucceeded, Amount: dec("7"), Currency: "USD"})
+	_, _, err := s.HandleWebhook(ctx, "local", header, body)
+	require.NoError(t, err)
+	assert.Equal(t, entity.InvoiceStatusPaid, invoices.invoices[1].Header.Status)
+
+	now = utc(2025, time.December, 20, 0)
+	require.NoError(t, s.Dun(ctx))
+	assert.Equal(t, []int64{1, 1, 2}, dunned())
+}
+
+func Test_PaymentDunningNotifyFails(t *testing.T) {
+	invoices := newFakeInvoiceRepo()
+	due := utc(2025, time.November, 15, 0)
+	invoices.invoices = []entity.Invoice{issuedInvoice(1, "22.2", &due)}
+	notifier := &fakeNotifier{err: errors.New("kafka is down")}
+	now := utc(2025, time.November, 20, 0)
+	s, _ := newTestPayments(invoices, notifier, &now)
+
+	assert.Error(t, s.Dun(context.Background()))
+	// the reminder was taken, it is not sent again before the next interval
+	notifier.err = nil
+	require.NoError(t, s.Dun(context.Background()))
+	assert.Empty(t, notifier.dunned)
+	assert.Equal(t, 1, invoices.invoices[0].Header.DunningLevel)
+}
+
+func Test_BillingPaymentTerms(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	now := utc(2025, time.November, 2, 0)
+	s := newClosingBilling(samples, invoices, &now)
+	s.paymentTerms = 14 * 24 * time.Hour
+
+	samples.add("alice", "hello", utc(2025, time.October, 10, 0), utc(2025, time.October, 10, 0), 10)
+
+	issued, err := s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+	assert.Equal(t, entity.InvoiceStatusIssued, issued[0].Header.Status)
+	require.NotNil(t, issued[0].Header.DueAt)
+	assert.Equal(t, utc(2025, time.November, 16, 0), *issued[0].Header.DueAt)
+}
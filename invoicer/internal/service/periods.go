@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// Period is the length of a billing period. Periods are aligned to calendar
+// boundaries in UTC.
+type Period string
+
+const (
+	PeriodMonth Period = "month"
+	PeriodDay   Period = "day"
+	PeriodHour  Period = "hour"
+)
+
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case PeriodMonth, PeriodDay, PeriodHour:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown billing period %q", s)
+}
+
+// Start returns the start of the period containing t.
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch p {
+	case PeriodDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case PeriodHour:
+		return t.Truncate(time.Hour)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Next returns the start of the period following the one starting at start.
+func (p Period) Next(start time.Time) time.Time {
+	switch p {
+	case PeriodDay:
+		return start.AddDate(0, 0, 1)
+	case PeriodHour:
+		return start.Add(time.Hour)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
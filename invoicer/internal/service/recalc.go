@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// Recalculation bills the usage of an issued invoice again, after a pricing
+// bug was fixed or metrics were backfilled. It reads the same usage window the
+// invoice was closed with, so usage that arrived after the invoice was issued
+// stays in the invoice that billed it. The invoice keeps the exchange rate it
+// was converted with; taxes follow the tenant's current billing profile.
+//
+// What changed is settled with a credit note when the tenant was overcharged.
+// An undercharge is settled with a corrected invoice that voids the open
+// invoice it replaces or, once the invoice is paid or has notes, with a debit
+// note charging the difference.
+
+// RecalcInput selects the tenant's invoice of the period starting at
+// PeriodStart. TariffID prices its usage of that tariff with TariffVersion,
+// the latest version if zero, instead of the versions in force at the time;
+// zero keeps every tariff. Mode is a dry run if empty.
+type RecalcInput struct {
+	Tenant        string
+	PeriodStart   time.Time
+	TariffID      int
+	TariffVersion int
+	Mode          string
+}
+
+// farFuture bounds the lookup of every version of a tariff.
+var farFuture = time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Recalc re-runs billing for an issued invoice, compares the result with it
+// and, unless it is a dry run, issues the note or corrected invoice settling
+// the difference. Nothing is issued for an invoice that was right.
+func (s *BillingService) Recalc(ctx context.Context, in *RecalcInput) (*entity.Recalc, error) {
+	mode := in.Mode
+	if mode == "" {
+		mode = entity.RecalcDryRun
+	}
+	switch {
+	case in.Tenant == "":
+		return nil, fmt.Errorf("%w: tenant is required", ErrInvalidRecalc)
+	case in.PeriodStart.IsZero():
+		return nil, fmt.Errorf("%w: period start is required", ErrInvalidRecalc)
+	case in.TariffID == 0 && in.TariffVersion != 0:
+		return nil, fmt.Errorf("%w: tariff version needs a tariff", ErrInvalidRecalc)
+	case mode != entity.RecalcDryRun && mode != entity.RecalcCreditNote && mode != entity.RecalcDebitNote &&
+		mode != entity.RecalcCorrect:
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidRecalc, mode)
+	}
+
+	docs, err := s.invoiceRepo.GetPeriod(ctx, in.Tenant, in.PeriodStart.UTC())
+	if err != nil {
+		slog.Error("failed to get period invoices", slog.String("tenant", in.Tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var (
+		target *entity.InvoiceHeader
+		notes  []entity.InvoiceHeader
+	)
+	for i := range docs {
+		if docs[i].Kind == entity.InvoiceKindInvoice && docs[i].Status != entity.InvoiceStatusVoid {
+			target = &docs[i]
+		}
+	}
+	if target == nil {
+		return nil, ErrInvoiceNotFound
+	}
+	for _, d := range docs {
+		if d.Kind != entity.InvoiceKindInvoice && d.Corrects != nil && *d.Corrects == target.Number {
+			notes = append(notes, d)
+		}
+	}
+
+	issued, err := s.invoiceRepo.GetByNumber(ctx, target.Number)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+
+	inv, err := s.rebill(ctx, issued, in)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := compareInvoices(issued, notes, inv)
+	rc.Mode = mode
+	if mode == entity.RecalcDryRun || rc.Delta.IsZero() {
+		return rc, nil
+	}
+
+	now := s.now()
+	switch mode {
+	case entity.RecalcCreditNote:
+		if !rc.Delta.IsNegative() {
+			return nil, ErrNothingToCredit
+		}
+
+		created, err := s.issueNote(ctx, entity.InvoiceKindCreditNote, issued, notes, inv, now)
+		if err != nil {
+			return nil, err
+		}
+		rc.Document = &created.Header
+
+	case entity.RecalcDebitNote:
+		if !rc.Delta.IsPositive() {
+			return nil, ErrNothingToDebit
+		}
+
+		created, err := s.issueNote(ctx, entity.InvoiceKindDebitNote, issued, notes, inv, now)
+		if err != nil {
+			return nil, err
+		}
+		rc.Document = &created.Header
+
+	case entity.RecalcCorrect:
+		if len(notes) > 0 {
+			return nil, ErrInvoiceCredited
+		}
+
+		inv.Header.Status = entity.InvoiceStatusIssued
+		inv.Header.CalculatedAt = now
+		s.terms(inv, now)
+		created, err := s.invoiceRepo.Correct(ctx, issued.Header.Number, inv, now)
+		if err != nil {
+			switch {
+			case errors.Is(err, repoerrors.ErrNotFound):
+				return nil, ErrInvoiceNotFound
+			case errors.Is(err, repoerrors.ErrConflict):
+				if rc.Delta.IsPositive() {
+					return nil, fmt.Errorf("%w, settle it with a debit note", ErrInvoiceNotOpen)
+				}
+				return nil, ErrInvoiceNotOpen
+			}
+			return nil, err
+		}
+		rc.Document = &created.Header
+	}
+
+	slog.Info("settled recalculated invoice",
+		slog.Int64("number", issued.Header.Number),
+		slog.String("tenant", in.Tenant),
+		slog.String("mode", mode),
+		slog.Int64("document", rc.Document.Number),
+		slog.String("delta", rc.Delta.String()))
+
+	return rc, nil
+}
+
+// issueNote issues the credit or debit note settling the difference, a debit
+// note is due like an invoice.
+func (s *BillingService) issueNote(ctx context.Context, kind string, issued *entity.Invoice, notes []entity.InvoiceHeader, inv *entity.Invoice, now time.Time) (*entity.Invoice, error) {
+	n := note(kind, issued, notes, inv, now)
+	s.terms(n, now)
+
+	return s.invoiceRepo.Create(ctx, n)
+}
+
+// rebill bills the usage window of the issued invoice again.
+func (s *BillingService) rebill(ctx context.Context, issued *entity.Invoice, in *RecalcInput) (*entity.Invoice, error) {
+	h := issued.Header
+
+	w := entity.UsageWindow{End: h.PeriodEnd, Cutoff: h.UsageCutoff}
+	prev, err := s.invoiceRepo.LastBefore(ctx, h.TenantID, h.PeriodStart)
+	switch {
+	case err == nil:
+		w.PrevEnd, w.PrevCutoff = prev.PeriodEnd, prev.UsageCutoff
+	case !errors.Is(err, repoerrors.ErrNotFound):
+		slog.Error("failed to get previous invoice", slog.String("tenant", h.TenantID), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	usage, err := s.usageRepo.GetUnbilled(ctx, h.TenantID, w)
+	if err != nil {
+		slog.Error("failed to get usage", slog.String("tenant", h.TenantID), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if len(usage) == 0 {
+		return nil, ErrNoUsage
+	}
+
+	from, _ := usagePeriod(usage)
+	if from.After(h.PeriodStart) {
+		from = h.PeriodStart
+	}
+	periods, err := s.resolveTariffs(ctx, h.TenantID, from, h.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+	if in.TariffID != 0 {
+		periods, err = s.pinTariff(ctx, periods, in.TariffID, in.TariffVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the rate of the issued invoice's day converts the recalculated one
+	inv := buildInvoice(h.TenantID, usage, periods, s.money, h.CalculatedAt)
+	inv.Header.Status = entity.InvoiceStatusDraft
+	inv.Header.PeriodStart = h.PeriodStart
+	inv.Header.PeriodEnd = h.PeriodEnd
+	inv.Header.UsageCutoff = h.UsageCutoff
+	if err := s.adjust(ctx, inv, periods, issued); err != nil {
+		return nil, err
+	}
+	if err := s.settle(ctx, inv); err != nil {
+		return nil, err
+	}
+	if inv.Header.Currency != h.Currency {
+		return nil, fmt.Errorf("%w: invoice %d is in %s, the tenant is invoiced in %s now",
+			ErrInvalidRecalc, h.Number, h.Currency, inv.Header.Currency)
+	}
+
+	return inv, nil
+}
+
+// pinTariff prices the periods of the tariff with a single version of it,
+// the latest if version is zero.
+func (s *BillingService) pinTariff(ctx context.Context, periods []tariffPeriod, id, version int) ([]tariffPeriod, error) {
+	versions, err := s.tariffs.GetTariffVersions(ctx, id, time.Time{}, farFuture)
+	if err != nil {
+		slog.Error("failed to get tariff versions", slog.Int("tariff_id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var pinned *entity.Tariff
+	for i := range versions {
+		v := &versions[i]
+		if v.Version == version || (version == 0 && (pinned == nil || v.Version > pinned.Version)) {
+			pinned = v
+		}
+	}
+	if pinned == nil {
+		return nil, fmt.Errorf("%w: tariff %d has no version %d", ErrInvalidRecalc, id, version)
+	}
+	if pinned.Currency != "" && pinned.Currency != s.money.Currency.Code {
+		return nil, fmt.Errorf("tariff %d is priced in %s, invoices in %s: %w",
+			id, pinned.Currency, s.money.Currency.Code, money.ErrCurrencyMismatch)
+	}
+
+	out := make([]tariffPeriod, len(periods))
+	used := false
+	for i, p := range periods {
+		if p.tariff.ID == id {
+			p.tariff = pinned
+			used = true
+		}
+		out[i] = p
+	}
+	if !used {
+		return nil, fmt.Errorf("%w: the invoice is not priced with tariff %d", ErrInvalidRecalc, id)
+	}
+
+	return out, nil
+}
+
+// compareInvoices lists what changed between the issued invoice and its
+// recalculation: the cost of every dimension of every function, then the
+// adjustments, the tax and the amount due of the whole invoice. The lines
+// compare the invoices themselves, the totals of the comparison also count
+// the notes issued for the invoice.
+func compareInvoices(issued *entity.Invoice, notes []entity.InvoiceHeader, inv *entity.Invoice) *entity.Recalc {
+	h := issued.Header
+	rc := &entity.Recalc{
+		Tenant:       h.TenantID,
+		Invoice:      h.Number,
+		PeriodStart:  h.PeriodStart,
+		PeriodEnd:    h.PeriodEnd,
+		Currency:     h.Currency,
+		CreditNotes:  []int64{},
+		DebitNotes:   []int64{},
+		Issued:       h.AmountDue,
+		Recalculated: inv.Header.AmountDue,
+		Lines:        []entity.RecalcLine{},
+	}
+	for _, n := range notes {
+		if n.Kind == entity.InvoiceKindDebitNote {
+			rc.DebitNotes = append(rc.DebitNotes, n.Number)
+		} else {
+			rc.CreditNotes = append(rc.CreditNotes, n.Number)
+		}
+		rc.Issued = rc.Issued.Add(n.AmountDue)
+	}
+	rc.Delta = rc.Recalculated.Sub(rc.Issued)
+
+	// an invoice is only replaced while nothing was paid or noted against it
+	open := h.Status == entity.InvoiceStatusIssued || h.Status == entity.InvoiceStatusOverdue
+	switch {
+	case rc.Delta.IsNegative():
+		rc.Settle = entity.RecalcCreditNote
+	case !rc.Delta.IsPositive():
+	case open && len(notes) == 0:
+		rc.Settle = entity.RecalcCorrect
+	default:
+		rc.Settle = entity.RecalcDebitNote
+	}
+
+	add := func(function, item string, before, after money.Decimal) {
+		if before.Equal(after) {
+			return
+		}
+		rc.Lines = append(rc.Lines, entity.RecalcLine{
+			Function:     function,
+			Item:         item,
+			Issued:       before,
+			Recalculated: after,
+			Delta:        after.Sub(before),
+		})
+	}
+
+	functions := make(map[string]struct{})
+	for _, fn := range issued.Functions {
+		functions[fn.Function] = struct{}{}
+	}
+	for _, fn := range inv.Functions {
+		functions[fn.Function] = struct{}{}
+	}
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var before, after entity.Totals
+		if fn := issued.Function(name); fn != nil {
+			before = fn.Totals
+		}
+		if fn := inv.Function(name); fn != nil {
+			after = fn.Totals
+		}
+		add(name, entity.DimensionExec, before.ExecCost, after.ExecCost)
+		add(name, entity.DimensionMemory, before.MemoryCost, after.MemoryCost)
+		add(name, entity.DimensionCPU, before.CPUCost, after.CPUCost)
+		add(name, entity.DimensionRequests, before.RequestCost, after.RequestCost)
+	}
+
+	add("", "adjustments", h.AdjustmentTotal, inv.Header.AdjustmentTotal)
+	add("", "tax", h.Tax, inv.Header.Tax)
+	add("", "amount_due", h.AmountDue, inv.Header.AmountDue)
+
+	return rc
+}
+
+// note gives back what the invoice and its earlier notes charged over the
+// recalculated invoice, or charges what they charged under it. It bills no
+// usage, only the amounts of the difference.
+func note(kind string, issued *entity.Invoice, notes []entity.InvoiceHeader, inv *entity.Invoice, now time.Time) *entity.Invoice {
+	h := issued.Header
+	totalCost, adjustments, tax, due := h.Totals.TotalCost, h.AdjustmentTotal, h.Tax, h.AmountDue
+	for _, n := range notes {
+		totalCost = totalCost.Add(n.Totals.TotalCost)
+		adjustments = adjustments.Add(n.AdjustmentTotal)
+		tax = tax.Add(n.Tax)
+		due = due.Add(n.AmountDue)
+	}
+
+	number := h.Number
+	return &entity.Invoice{
+		Header: entity.InvoiceHeader{
+			Kind:            kind,
+			Status:          entity.InvoiceStatusIssued,
+			TenantID:        h.TenantID,
+			PeriodStart:     h.PeriodStart,
+			PeriodEnd:       h.PeriodEnd,
+			UsageCutoff:     h.UsageCutoff,
+			Tariffs:         inv.Header.Tariffs,
+			Totals:          entity.Totals{TotalCost: inv.Header.Totals.TotalCost.Sub(totalCost)},
+			Currency:        h.Currency,
+			AdjustmentTotal: inv.Header.AdjustmentTotal.Sub(adjustments),
+			TaxRate:         inv.Header.TaxRate,
+			Tax:             inv.Header.Tax.Sub(tax),
+			AmountDue:       inv.Header.AmountDue.Sub(due),
+			CalculatedAt:    now,
+			Customer:        inv.Header.Customer,
+			FX:              inv.Header.FX,
+			Corrects:        &number,
+		},
+		Functions:   []entity.FunctionLine{},
+		Adjustments: []entity.Adjustment{},
+		Taxes:       []entity.TaxLine{},
+	}
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+)
+
+func (f *fakeInvoiceRepo) LastBefore(_ context.Context, tenant string, end time.Time) (*entity.InvoiceHeader, error) {
+	var last *entity.InvoiceHeader
+	for i := range f.invoices {
+		h := &f.invoices[i].Header
+		if h.TenantID == tenant && !isNote(*h) && !h.PeriodEnd.After(end) &&
+			(last == nil || h.PeriodEnd.After(last.PeriodEnd)) {
+			last = h
+		}
+	}
+	if last == nil {
+		return nil, repoerrors.ErrNotFound
+	}
+	return last, nil
+}
+
+func (f *fakeInvoiceRepo) GetPeriod(_ context.Context, tenant string, start time.Time) ([]entity.InvoiceHeader, error) {
+	var out []entity.InvoiceHeader
+	for _, inv := range f.invoices {
+		if inv.Header.TenantID == tenant && inv.Header.PeriodStart.Equal(start) {
+			out = append(out, inv.Header)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeInvoiceRepo) Correct(ctx context.Context, number int64, inv *entity.Invoice, at time.Time) (*entity.Invoice, error) {
+	if _, err := f.Void(ctx, number, at); err != nil {
+		return nil, err
+	}
+	inv.Header.Corrects = &number
+	return f.Create(ctx, inv)
+}
+
+// closedOctober issues alice's October invoice of 1000 MB·s, 10 USD under
+// the first version of the tariff, and returns the time after closing it.
+func closedOctober(t *testing.T, samples *fakeSamples, invoices *fakeInvoiceRepo, tariffs *fakeTariffs) (*BillingService, *time.Time) {
+	t.Helper()
+
+	v2 := testTariff
+	v2.Version = 2
+	v2.EffectiveFrom = utc(2025, time.November, 5, 0)
+	v2.MemPrice = dec("0.008")
+	v1 := testTariff
+	v1.Version = 1
+	tariffs.versions = map[int][]entity.Tariff{1: {v1, v2}}
+
+	now := utc(2025, time.November, 1, 2)
+	s := newClosingBilling(samples, invoices, &now)
+	s.tariffs = tariffs
+
+	samples.add("alice", "hello", utc(2025, time.October, 3, 10), utc(2025, time.October, 3, 10), 1000)
+
+	issued, err := s.CloseDue(context.Background())
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+	assertDecimal(t, dec("10"), issued[0].Header.AmountDue)
+
+	now = utc(2025, time.November, 10, 0)
+	return s, &now
+}
+
+func Test_RecalcDryRun(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	s, _ := closedOctober(t, samples, invoices, basicTariffs())
+
+	// usage arriving after the invoice was issued belongs to the next one
+	samples.add("alice", "hello", utc(2025, time.October, 20, 0), utc(2025, time.November, 3, 0), 500)
+
+	rc, err := s.Recalc(context.Background(), &RecalcInput{Tenant: "alice", PeriodStart: utc(2025, time.October, 1, 0)})
+	require.NoError(t, err)
+	assert.Equal(t, entity.RecalcDryRun, rc.Mode)
+	assert.Equal(t, int64(1), rc.Invoice)
+	assert.Empty(t, rc.Lines)
+	assert.True(t, rc.Delta.IsZero())
+	assert.Empty(t, rc.Settle)
+
+	rc, err = s.Recalc(context.Background(), &RecalcInput{Tenant: "alice", PeriodStart: utc(2025, time.October, 1, 0), TariffID: 1, TariffVersion: 2})
+	require.NoError(t, err)
+	assertDecimal(t, dec("10"), rc.Issued)
+	assertDecimal(t, dec("8"), rc.Recalculated)
+	assertDecimal(t, dec("-2"), rc.Delta)
+	assert.Equal(t, entity.RecalcCreditNote, rc.Settle)
+	require.Len(t, rc.Lines, 2)
+	assert.Equal(t, "hello", rc.Lines[0].Function)
+	assert.Equal(t, entity.DimensionMemory, rc.Lines[0].Item)
+	assertDecimal(t, dec("-2"), rc.Lines[0].Delta)
+	assert.Equal(t, "amount_due", rc.Lines[1].Item)
+	assert.Nil(t, rc.Document)
+	assert.Len(t, invoices.invoices, 1, "a dry run issues nothing")
+}
+
+func Test_RecalcCreditNote(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	s, now := closedOctober(t, samples, invoices, basicTariffs())
+	in := &RecalcInput{Tenant: "alice", PeriodStart: utc(2025, time.October, 1, 0), TariffID: 1, Mode: entity.RecalcCreditNote}
+
+	rc, err := s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	require.NotNil(t, rc.Document)
+
+	require.Len(t, invoices.invoices, 2)
+	note := invoices.invoices[1].Header
+	assert.Equal(t, entity.InvoiceKindCreditNote, note.Kind)
+	assert.Equal(t, int64(2), note.Number)
+	require.NotNil(t, note.Corrects)
+	assert.Equal(t, int64(1), *note.Corrects)
+	assertDecimal(t, dec("-2"), note.AmountDue)
+	assertDecimal(t, dec("-2"), note.Totals.TotalCost)
+	assert.Equal(t, entity.InvoiceStatusPaid, note.Status, "nothing is owed on a credit note")
+	assert.Equal(t, *now, note.CalculatedAt)
+	assert.Equal(t, entity.InvoiceStatusIssued, invoices.invoices[0].Header.Status)
+
+	// the credit note settled the difference
+	rc, err = s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2}, rc.CreditNotes)
+	assertDecimal(t, dec("8"), rc.Issued)
+	assert.True(t, rc.Delta.IsZero())
+	assert.Nil(t, rc.Document)
+	assert.Len(t, invoices.invoices, 2)
+
+	in.Mode = entity.RecalcCorrect
+	in.TariffVersion = 1
+	_, err = s.Recalc(context.Background(), in)
+	assert.ErrorIs(t, err, ErrInvoiceCredited)
+}
+
+func Test_RecalcCorrect(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	tariffs := basicTariffs()
+	s, now := closedOctober(t, samples, invoices, tariffs)
+
+	v3 := testTariff
+	v3.Version = 3
+	v3.EffectiveFrom = utc(2025, time.November, 8, 0)
+	v3.MemPrice = dec("0.012")
+	tariffs.versions[1] = append(tariffs.versions[1], v3)
+
+	in := &RecalcInput{Tenant: "alice", PeriodStart: utc(2025, time.October, 1, 0), TariffID: 1, Mode: entity.RecalcCreditNote}
+	_, err := s.Recalc(context.Background(), in)
+	assert.ErrorIs(t, err, ErrNothingToCredit)
+
+	in.Mode = entity.RecalcCorrect
+	rc, err := s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	assertDecimal(t, dec("2"), rc.Delta)
+	require.NotNil(t, rc.Document)
+
+	require.Len(t, invoices.invoices, 2)
+	original, corrected := invoices.invoices[0].Header, invoices.invoices[1].Header
+	assert.Equal(t, entity.InvoiceStatusVoid, original.Status)
+	assert.Equal(t, entity.InvoiceKindInvoice, corrected.Kind)
+	assert.Equal(t, entity.InvoiceStatusIssued, corrected.Status)
+	require.NotNil(t, corrected.Corrects)
+	assert.Equal(t, int64(1), *corrected.Corrects)
+	assertDecimal(t, dec("12"), corrected.AmountDue)
+	assert.Equal(t, original.PeriodEnd, corrected.PeriodEnd)
+	assert.Equal(t, original.UsageCutoff, corrected.UsageCutoff)
+	assert.Equal(t, *now, corrected.CalculatedAt)
+	assert.Equal(t, 3, corrected.Tariffs[0].Version)
+
+	// the corrected invoice is the one recalculated now
+	rc, err = s.Recalc(context.Background(), &RecalcInput{Tenant: "alice", PeriodStart: utc(2025, time.October, 1, 0), TariffID: 1, Mode: entity.RecalcCorrect})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rc.Invoice)
+	assert.True(t, rc.Delta.IsZero())
+	assert.Nil(t, rc.Document)
+
+	// a paid invoice is settled with a credit note instead
+	paid := invoices.header(2)
+	paid.Status = entity.InvoiceStatusPaid
+	_, err = s.Recalc(context.Background(), &RecalcInput{Tenant: "alice", PeriodStart: utc(2025, time.October, 1, 0), TariffID: 1, TariffVersion: 1, Mode: entity.RecalcCorrect})
+	assert.ErrorIs(t, err, ErrInvoiceNotOpen)
+}
+
+func Test_RecalcDebitNote(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	tariffs := basicTariffs()
+	s, now := closedOctober(t, samples, invoices, tariffs)
+
+	v3 := testTariff
+	v3.Version = 3
+	v3.EffectiveFrom = utc(2025, time.November, 8, 0)
+	v3.MemPrice = dec("0.012")
+	tariffs.versions[1] = append(tariffs.versions[1], v3)
+
+	october := utc(2025, time.October, 1, 0)
+	in := &RecalcInput{Tenant: "alice", PeriodStart: october, TariffID: 1}
+
+	// an open invoice is corrected
+	rc, err := s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, entity.RecalcCorrect, rc.Settle)
+
+	// once paid, the undercharge can only be settled with a debit note
+	invoices.header(1).Status = entity.InvoiceStatusPaid
+	rc, err = s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	assertDecimal(t, dec("2"), rc.Delta)
+	assert.Equal(t, entity.RecalcDebitNote, rc.Settle)
+
+	in.Mode = entity.RecalcCorrect
+	_, err = s.Recalc(context.Background(), in)
+	assert.ErrorIs(t, err, ErrInvoiceNotOpen)
+
+	in.Mode = entity.RecalcDebitNote
+	rc, err = s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	require.NotNil(t, rc.Document)
+
+	require.Len(t, invoices.invoices, 2)
+	note := invoices.invoices[1].Header
+	assert.Equal(t, entity.InvoiceKindDebitNote, note.Kind)
+	require.NotNil(t, note.Corrects)
+	assert.Equal(t, int64(1), *note.Corrects)
+	assertDecimal(t, dec("2"), note.AmountDue)
+	assertDecimal(t, dec("2"), note.Totals.TotalCost)
+	assert.Equal(t, entity.InvoiceStatusIssued, note.Status, "a debit note is paid like an invoice")
+	assert.Equal(t, *now, note.CalculatedAt)
+	assert.Equal(t, entity.InvoiceStatusPaid, invoices.invoices[0].Header.Status)
+
+	// the debit note settled the difference
+	rc, err = s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2}, rc.DebitNotes)
+	assertDecimal(t, dec("12"), rc.Issued)
+	assert.True(t, rc.Delta.IsZero())
+	assert.Empty(t, rc.Settle)
+	assert.Nil(t, rc.Document)
+
+	// going back to the first version credits what the note charged and more
+	in.TariffVersion = 1
+	_, err = s.Recalc(context.Background(), in)
+	assert.ErrorIs(t, err, ErrNothingToDebit)
+
+	in.Mode = entity.RecalcDryRun
+	rc, err = s.Recalc(context.Background(), in)
+	require.NoError(t, err)
+	assertDecimal(t, dec("-2"), rc.Delta)
+	assert.Equal(t, entity.RecalcCreditNote, rc.Settle)
+}
+
+func Test_RecalcInvalid(t *testing.T) {
+	samples := &fakeSamples{}
+	invoices := newFakeInvoiceRepo()
+	tariffs := basicTariffs()
+	tariffs.tariffs[2] = entity.Tariff{ID: 2, Name: "pro", MemPrice: dec("0.02")}
+	s, _ := closedOctober(t, samples, invoices, tariffs)
+	october := utc(2025, time.October, 1, 0)
+
+	tests := []struct {
+		name string
+		in   RecalcInput
+		want error
+	}{
+		{name: "no tenant", in: RecalcInput{PeriodStart: october}, want: ErrInvalidRecalc},
+		{name: "no period", in: RecalcInput{Tenant: "alice"}, want: ErrInvalidRecalc},
+		{name: "unknown mode", in: RecalcInput{Tenant: "alice", PeriodStart: october, Mode: "refund"}, want: ErrInvalidRecalc},
+		{name: "version without tariff", in: RecalcInput{Tenant: "alice", PeriodStart: october, TariffVersion: 2}, want: ErrInvalidRecalc},
+		{name: "unknown version", in: RecalcInput{Tenant: "alice", PeriodStart: october, TariffID: 1, TariffVersion: 7}, want: ErrInvalidRecalc},
+		{name: "tariff not on the invoice", in: RecalcInput{Tenant: "alice", PeriodStart: october, TariffID: 2}, want: ErrInvalidRecalc},
+		{name: "no invoice", in: RecalcInput{Tenant: "alice", PeriodStart: utc(2025, time.September, 1, 0)}, want: ErrInvoiceNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Recalc(context.Background(), &tt.in)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+	assert.Len(t, invoices.invoices, 1)
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+)
+
+type bucketKey struct {
+	tenant         string
+	pod            string
+	timeBucket     time.Time
+	insertedBucket time.Time
+}
+
+// bucket is a single function_metrics_1m row.
+type bucket struct {
+	bucketKey
+	memMB      float64
+	cpuPercent float64
+	requests   float64
+	firstSeen  time.Time
+	lastSeen   time.Time
+}
+
+// fakeRollup rolls the raw rows up into minute buckets like the materialized
+// view does and filters the buckets like the ClickHouse queries do.
+type fakeRollup struct {
+	raw *fakeSamples
+}
+
+func (f *fakeRollup) buckets() []bucket {
+	byKey := make(map[bucketKey]*bucket)
+	var keys []bucketKey
+	for _, r := range f.raw.rows {
+		k := bucketKey{
+			tenant:         r.tenant,
+			pod:            r.pod,
+			timeBucket:     r.ts.Truncate(entity.RollupStep),
+			insertedBucket: r.insertedAt.Truncate(entity.RollupStep),
+		}
+		b, ok := byKey[k]
+		if !ok {
+			b = &bucket{bucketKey: k, firstSeen: r.ts, lastSeen: r.ts}
+			byKey[k] = b
+			keys = append(keys, k)
+		}
+		b.memMB += r.memMB
+		b.cpuPercent += r.cpuPercent
+		b.requests += r.requests
+		if r.ts.Before(b.firstSeen) {
+			b.firstSeen = r.ts
+		}
+		if r.ts.After(b.lastSeen) {
+			b.lastSeen = r.ts
+		}
+	}
+
+	out := make([]bucket, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *byKey[k])
+	}
+	return out
+}
+
+func (f *fakeRollup) GetUnbilled(_ context.Context, tenant string, w entity.UsageWindow) ([]entity.Usage, error) {
+	byPod := make(map[string]*entity.Usage)
+	for _, b := range f.buckets() {
+		if b.tenant != tenant || !w.Contains(b.timeBucket, b.insertedBucket) {
+			continue
+		}
+		u, ok := byPod[b.pod]
+		if !ok {
+			u = &entity.Usage{Function: b.pod, Pod: b.pod, StartTime: b.firstSeen, EndTime: b.lastSeen}
+			byPod[b.pod] = u
+		}
+		if b.firstSeen.Before(u.StartTime) {
+			u.StartTime = b.firstSeen
+		}
+		if b.lastSeen.After(u.EndTime) {
+			u.EndTime = b.lastSeen
+		}
+		u.MemoryMBSec += b.memMB
+		u.CPUSec += b.cpuPercent
+		u.Requests += b.requests
+	}
+
+	var out []entity.Usage
+	for _, u := range byPod {
+		u.CPUSec = cpuSec(u.CPUSec)
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Pod < out[j].Pod })
+	return out, nil
+}
+
+func (f *fakeRollup) FirstUnbilled(_ context.Context, tenant string, w entity.UsageWindow) (time.Time, bool, error) {
+	var (
+		first time.Time
+		found bool
+	)
+	for _, b := range f.buckets() {
+		if b.tenant == tenant && w.Contains(b.timeBucket, b.insertedBucket) && (!found || b.firstSeen.Before(first)) {
+			first, found = b.firstSeen, true
+		}
+	}
+	return first, found, nil
+}
+
+func (f *fakeRollup) Tenants(ctx context.Context) ([]string, error) {
+	return f.raw.Tenants(ctx)
+}
+
+func (f *fakeRollup) Report(context.Context, *entity.UsageQuery) ([]entity.UsageRow, error) {
+	return nil, nil
+}
+
+func (f *fakeRollup) Export(context.Context, *entity.UsageExport, func(entity.UsageRecord) error) error {
+	return nil
+}
+
+// Test_BillingRollupMatchesRaw bills the same rows once from the raw table and
+// once from the minute rollup. Samples land at odd seconds, some of them late,
+// and the periods are closed at odd times, yet the invoices must not differ,
+// nor the memory, CPU and requests of any aligned window.
+func Test_BillingRollupMatchesRaw(t *testing.T) {
+	raw := &fakeSamples{}
+	rawInvoices, rollupInvoices := newFakeInvoiceRepo(), newFakeInvoiceRepo()
+	now := utc(2025, time.October, 20, 0)
+	rawBilling := newClosingBilling(raw, rawInvoices, &now)
+	rollupBilling := newClosingBilling(&fakeRollup{raw: raw}, rollupInvoices, &now)
+
+	rnd := rand.New(rand.NewSource(1))
+	insert := func(insertedAt time.Time, late bool) {
+		ts := insertedAt.Add(-time.Duration(rnd.Intn(3600)) * time.Second)
+		if late {
+			// delivered days late, possibly after its period was closed
+			ts = ts.Add(-time.Duration(rnd.Intn(72)) * time.Hour)
+		}
+		raw.rows = append(raw.rows, sample{
+			tenant:     []string{"alice", "bob"}[rnd.Intn(2)],
+			pod:        fmt.Sprintf("hello-%d", rnd.Intn(3)),
+			ts:         ts,
+			insertedAt: insertedAt,
+			memMB:      float64(1 + rnd.Intn(100)),
+			cpuPercent: float64(rnd.Intn(200)),
+			requests:   float64(rnd.Intn(10)),
+		})
+	}
+
+	for step := 0; step < 24*16; step++ {
+		now = utc(2025, time.October, 20, 0).Add(time.Duration(step)*time.Hour + time.Duration(rnd.Intn(3600))*time.Second)
+		for i := 0; i < 5; i++ {
+			insert(now.Add(-time.Duration(rnd.Intn(5))*time.Second), rnd.Intn(50) == 0)
+		}
+
+		if step%7 == 0 {
+			rawIssued, err := rawBilling.CloseDue(context.Background())
+			require.NoError(t, err)
+			rollupIssued, err := rollupBilling.CloseDue(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, rawIssued, rollupIssued, "invoices issued at %s", now)
+
+			for _, tenant := range []string{"alice", "bob"} {
+				rawDraft, rawErr := rawBilling.Draft(context.Background(), tenant)
+				rollupDraft, rollupErr := rollupBilling.Draft(context.Background(), tenant)
+				require.Equal(t, rawErr, rollupErr)
+				require.Equal(t, rawDraft, rollupDraft, "%s draft at %s", tenant, now)
+			}
+		}
+
+		// late rows inserted right after a close share its minute
+		insert(now.Add(time.Duration(1+rnd.Intn(59))*time.Second), true)
+	}
+
+	require.NotEmpty(t, rawInvoices.invoices)
+	assert.Equal(t, rawInvoices.invoices, rollupInvoices.invoices)
+
+	var billed entity.Totals
+	for _, inv := range rawInvoices.invoices {
+		billed.MemoryMBSec += inv.Header.Totals.MemoryMBSec
+		billed.CPUSec += inv.Header.Totals.CPUSec
+		billed.Requests += inv.Header.Totals.Requests
+	}
+	assert.Positive(t, billed.MemoryMBSec)
+	assert.Positive(t, billed.CPUSec)
+	assert.Positive(t, billed.Requests)
+
+	// every usage metric read from the rollup matches the raw rows, also for
+	// windows the invoices did not use
+	rollup := &fakeRollup{raw: raw}
+	for i := 0; i < 100; i++ {
+		end := utc(2025, time.October, 20, 0).Add(time.Duration(rnd.Intn(24*16)) * time.Hour)
+		prev := entity.UsageWindow{
+			End:    end.Add(-time.Duration(1+rnd.Intn(24*7)) * time.Hour),
+			Cutoff: end.Add(-time.Duration(rnd.Intn(3600)) * time.Second),
+		}.Aligned()
+		w := entity.UsageWindow{
+			End:        end,
+			Cutoff:     end.Add(time.Duration(rnd.Intn(3600)) * time.Second),
+			PrevEnd:    prev.End,
+			PrevCutoff: prev.Cutoff,
+		}.Aligned()
+		for _, tenant := range []string{"alice", "bob"} {
+			want, err := raw.GetUnbilled(context.Background(), tenant, w)
+			require.NoError(t, err)
+			got, err := rollup.GetUnbilled(context.Background(), tenant, w)
+			require.NoError(t, err)
+			require.Equal(t, want, got, "%s usage in %+v", tenant, w)
+		}
+	}
+}
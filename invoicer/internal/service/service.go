@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/render"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/types"
+
+	gokafka "github.com/segmentio/kafka-go"
+)
+
+type Billing interface {
+	Draft(ctx context.Context, tenant string) (*entity.Invoice, error)
+	CloseDue(ctx context.Context) ([]entity.Invoice, error)
+	MonthToDate(ctx context.Context, tenant string) (*entity.Invoice, error)
+	// Currency returns the currency the tenant is invoiced in.
+	Currency(ctx context.Context, tenant string) (money.Currency, error)
+	// Recalc bills an issued invoice again and settles the difference.
+	Recalc(ctx context.Context, in *RecalcInput) (*entity.Recalc, error)
+}
+
+type Invoice interface {
+	GetByNumber(ctx context.Context, number int64) (*entity.Invoice, error)
+	GetAll(ctx context.Context, filters *entity.InvoiceFilters) ([]entity.InvoiceHeader, error)
+	Document(ctx context.Context, number int64, format string) ([]byte, error)
+}
+
+type Usage interface {
+	Report(ctx context.Context, q *entity.UsageQuery) (*entity.UsageReport, error)
+}
+
+type Export interface {
+	// Export streams the usage records of q to w in q.Format.
+	Export(ctx context.Context, q *entity.UsageExport, w io.Writer) error
+	// ExportDue writes the completed periods missing from the export store.
+	ExportDue(ctx context.Context) ([]string, error)
+}
+
+type Notification interface {
+	NotifyStop(ctx context.Context, action types.Action) error
+	NotifyBudget(ctx context.Context, alert entity.BudgetAlert) error
+	NotifyInvoice(ctx context.Context, h entity.InvoiceHeader) error
+	NotifyDunning(ctx context.Context, h entity.InvoiceHeader, outstanding money.Decimal) error
+}
+
+type Budget interface {
+	Create(ctx context.Context, tenant string, in *BudgetInput) (*entity.Budget, error)
+	GetStatus(ctx context.Context, tenant string) (*entity.BudgetStatus, error)
+	Update(ctx context.Context, tenant string, in *BudgetInput) (*entity.Budget, error)
+	Delete(ctx context.Context, tenant string) error
+	Check(ctx context.Context) error
+}
+
+type Tax interface {
+	SaveProfile(ctx context.Context, tenant string, in *BillingProfileInput) (*entity.BillingProfile, error)
+	GetProfile(ctx context.Context, tenant string) (*entity.BillingProfile, error)
+	DeleteProfile(ctx context.Context, tenant string) error
+	CreateRule(ctx context.Context, in *TaxRuleInput) (*entity.TaxRule, error)
+	GetRule(ctx context.Context, id int) (*entity.TaxRule, error)
+	GetRules(ctx context.Context, country string) ([]entity.TaxRule, error)
+	UpdateRule(ctx context.Context, id int, in *TaxRuleInput) (*entity.TaxRule, error)
+	DeleteRule(ctx context.Context, id int) error
+}
+
+type FX interface {
+	SaveRates(ctx context.Context, in []FXRateInput) ([]entity.FXRate, error)
+	Load(ctx context.Context, r io.Reader) (int, error)
+	GetRates(ctx context.Context, currency string) ([]entity.FXRate, error)
+}
+
+type Payment interface {
+	Checkout(ctx context.Context, number int64, provider string) (*entity.Checkout, error)
+	HandleWebhook(ctx context.Context, provider string, header http.Header, body []byte) (*entity.Payment, bool, error)
+	GetPayments(ctx context.Context, number int64) ([]entity.Payment, error)
+	Void(ctx context.Context, number int64) (*entity.InvoiceHeader, error)
+	Dun(ctx context.Context) error
+}
+
+type Relay interface {
+	Relay(ctx context.Context) (int, error)
+}
+
+type Ledger interface {
+	TopUp(ctx context.Context, tenant string, in *LedgerInput) (*entity.LedgerTransaction, bool, error)
+	Refund(ctx context.Context, tenant string, in *LedgerInput) (*entity.LedgerTransaction, bool, error)
+	Adjust(ctx context.Context, tenant string, in *LedgerInput) (*entity.LedgerTransaction, bool, error)
+	GetWallet(ctx context.Context, tenant string) (*entity.Wallet, error)
+	GetTransactions(ctx context.Context, tenant string, limit, offset uint64) ([]entity.LedgerTransaction, error)
+	Sync(ctx context.Context) error
+}
+
+// TariffProvider resolves tariffs, tenant subscriptions, discounts and
+// credits, implemented by the price_service client.
+type TariffProvider interface {
+	// GetTariffVersions returns the versions of the tariff's prices in force
+	// at some point of [from, to).
+	GetTariffVersions(ctx context.Context, id int, from, to time.Time) ([]entity.Tariff, error)
+	GetSubscriptions(ctx context.Context, tenant string, from, to time.Time) ([]entity.Subscription, error)
+	GetDiscounts(ctx context.Context, tenant string, from, to time.Time) ([]entity.Discount, error)
+	GetCredits(ctx context.Context, tenant string, from, to time.Time) ([]entity.Credit, error)
+}
+
+// PaymentProvider takes payments of invoices, implemented by the clients in
+// webapi/payment.
+type PaymentProvider interface {
+	// Name identifies the provider in webhook routes and stored payments.
+	Name() string
+	// Checkout starts the payment of amount on the invoice.
+	Checkout(ctx context.Context, h *entity.InvoiceHeader, amount money.Decimal) (*entity.Checkout, error)
+	// ParseWebhook verifies a webhook call of the provider and returns the
+	// payment it reports.
+	ParseWebhook(header http.Header, body []byte) (*entity.Payment, error)
+}
+
+// FunctionScaler stops a tenant's running functions, implemented by the
+// control_plane client.
+type FunctionScaler interface {
+	ScaleToZero(ctx context.Context, tenant string) error
+}
+
+// ExportStore keeps the scheduled usage exports, implemented by the local
+// and S3 stores of the storage package.
+type ExportStore interface {
+	Exists(ctx context.Context, name string) (bool, error)
+	// Put writes the file from r, a failed write leaves no file.
+	Put(ctx context.Context, name, contentType string, r io.Reader) error
+}
+
+// Publisher is the subset of a kafka writer the notifications need.
+type Publisher interface {
+	WriteMessages(ctx context.Context, msgs ...gokafka.Message) error
+}
+
+type Dependencies struct {
+	Repos   *repo.Repositories
+	Tariffs TariffProvider
+	Billing BillingConfig
+	// Brand is the issuer printed on invoice documents.
+	Brand render.Brand
+	// Notify receives the notifications for the notifier, through the
+	// outbox.
+	Notify      Publisher
+	NotifyTopic string
+	// Balance receives the wallet balance events for control_plane.
+	Balance Publisher
+	// BudgetCaps receives the budget hard cap events for control_plane.
+	BudgetCaps Publisher
+	// Scaler stops the running functions of capped tenants, nil leaves them
+	// running.
+	Scaler FunctionScaler
+	// Providers take invoice payments, the first one is the default.
+	Providers []PaymentProvider
+	Payment   PaymentConfig
+	// ExportStore receives the scheduled usage exports, nil turns them off.
+	ExportStore ExportStore
+	Export      ExportConfig
+}
+
+type Services struct {
+	Billing      Billing
+	Invoice      Invoice
+	Usage        Usage
+	Export       Export
+	Notification Notification
+	Budget       Budget
+	Ledger       Ledger
+	Tax          Tax
+	FX           FX
+	Payment      Payment
+	Outbox       Relay
+}
+
+func NewServices(deps *Dependencies) *Services {
+	billing := NewBillingService(deps.Repos.Usage, deps.Repos.Invoice, deps.Repos.Tax, deps.Repos.FX, deps.Tariffs, deps.Billing)
+
+	notification := NewNotificationService(billing, deps.Repos.Outbox, deps.NotifyTopic, billing.money)
+
+	return &Services{
+		Billing:      billing,
+		Invoice:      NewInvoiceService(deps.Repos.Invoice, render.New(deps.Brand, billing.money)),
+		Usage:        NewUsageService(deps.Repos.Usage),
+		Export:       NewExportService(deps.Repos.Usage, deps.ExportStore, deps.Export),
+		Notification: notification,
+		Budget:       NewBudgetService(deps.Repos.Budget, billing, notification, deps.Scaler, deps.BudgetCaps),
+		Ledger:       NewLedgerService(deps.Repos.Ledger, billing, deps.Balance),
+		Tax:          NewTaxService(deps.Repos.Tax),
+		FX:           NewFXService(deps.Repos.FX, billing.money),
+		Payment:      NewPaymentService(deps.Repos.Invoice, notification, deps.Providers, deps.Payment),
+		Outbox:       NewOutboxRelay(deps.Repos.Outbox, map[string]Publisher{deps.NotifyTopic: deps.Notify}),
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// tariffPeriod is a tariff in force during [from, to).
+type tariffPeriod struct {
+	tariff *entity.Tariff
+	from   time.Time
+	to     time.Time
+}
+
+// segment is the share of a pod's usage that falls into one tariff period.
+type segment struct {
+	period   *tariffPeriod
+	from     time.Time
+	to       time.Time
+	fraction float64
+}
+
+// resolveTariffs returns the tariffs covering [from, to] for the tenant. Time
+// not covered by any subscription is billed with the default tariff. A
+// subscription spanning a price change of its tariff gets a period per
+// version of the tariff.
+func (s *BillingService) resolveTariffs(ctx context.Context, tenant string, from, to time.Time) ([]tariffPeriod, error) {
+	subs, err := s.tariffs.GetSubscriptions(ctx, tenant, from, to)
+	if err != nil {
+		slog.Error("failed to get subscriptions", slog.String("tenant", tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	cache := make(map[int][]entity.Tariff)
+	versions := func(id int) ([]entity.Tariff, error) {
+		if v, ok := cache[id]; ok {
+			return v, nil
+		}
+		// the filter is half-open, so include a version starting exactly at to
+		v, err := s.tariffs.GetTariffVersions(ctx, id, from, to.Add(time.Second))
+		if err != nil {
+			slog.Error("failed to get tariff versions", slog.Int("tariff_id", id), slog.String("error", err.Error()))
+			return nil, err
+		}
+		if len(v) == 0 {
+			return nil, fmt.Errorf("%w: tariff %d", ErrNoTariffPrices, id)
+		}
+		for _, t := range v {
+			if t.Currency != "" && t.Currency != s.money.Currency.Code {
+				return nil, fmt.Errorf("tariff %d is priced in %s, invoices in %s: %w",
+					id, t.Currency, s.money.Currency.Code, money.ErrCurrencyMismatch)
+			}
+		}
+		sort.Slice(v, func(i, j int) bool {
+			return v[i].Version < v[j].Version
+		})
+		cache[id] = v
+		return v, nil
+	}
+
+	windows := timeline(subs, from, to, s.defaultTariffID)
+	periods := make([]tariffPeriod, 0, len(windows))
+	for _, w := range windows {
+		v, err := versions(w.tariffID)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, versionPeriods(v, w)...)
+	}
+
+	return periods, nil
+}
+
+// versionPeriods splits a window at the price changes of its tariff. Each
+// version is in force from its EffectiveFrom until the next one, the first
+// version also before that.
+func versionPeriods(versions []entity.Tariff, w window) []tariffPeriod {
+	starts := func(i int) time.Time {
+		if i == 0 {
+			return time.Time{}
+		}
+		return versions[i].EffectiveFrom
+	}
+
+	// all usage happened at a single instant
+	if !w.to.After(w.from) {
+		i := 0
+		for i+1 < len(versions) && !starts(i+1).After(w.from) {
+			i++
+		}
+		return []tariffPeriod{{tariff: &versions[i], from: w.from, to: w.to}}
+	}
+
+	var out []tariffPeriod
+	for i := range versions {
+		from, to := w.from, w.to
+		if start := starts(i); start.After(from) {
+			from = start
+		}
+		if i+1 < len(versions) && starts(i+1).Before(to) {
+			to = starts(i + 1)
+		}
+		if !to.After(from) {
+			continue
+		}
+		out = append(out, tariffPeriod{tariff: &versions[i], from: from, to: to})
+	}
+
+	return out
+}
+
+type window struct {
+	tariffID int
+	from     time.Time
+	to       time.Time
+}
+
+// timeline lays the subscriptions over [from, to] and fills the gaps with the
+// default tariff. Subscriptions never overlap, price_service enforces it.
+func timeline(subs []entity.Subscription, from, to time.Time, defaultTariffID int) []window {
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].ValidFrom.Before(subs[j].ValidFrom)
+	})
+
+	// all usage happened at a single instant
+	if !to.After(from) {
+		for _, sub := range subs {
+			if !sub.ValidFrom.After(from) && (sub.ValidTo == nil || sub.ValidTo.After(from)) {
+				return []window{{tariffID: sub.TariffID, from: from, to: to}}
+			}
+		}
+		return []window{{tariffID: defaultTariffID, from: from, to: to}}
+	}
+
+	var out []window
+	cursor := from
+	for _, sub := range subs {
+		start := sub.ValidFrom
+		if start.Before(cursor) {
+			start = cursor
+		}
+		end := to
+		if sub.ValidTo != nil && sub.ValidTo.Before(end) {
+			end = *sub.ValidTo
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		if start.After(cursor) {
+			out = append(out, window{tariffID: defaultTariffID, from: cursor, to: start})
+		}
+		out = append(out, window{tariffID: sub.TariffID, from: start, to: end})
+		cursor = end
+	}
+	if cursor.Before(to) {
+		out = append(out, window{tariffID: defaultTariffID, from: cursor, to: to})
+	}
+
+	return out
+}
+
+// split prorates a pod's usage over the tariff periods by the time it ran in
+// each of them. A pod with a single sample belongs to the period it was
+// sampled in.
+func split(u entity.Usage, periods []tariffPeriod) []segment {
+	if !u.EndTime.After(u.StartTime) {
+		for i := range periods {
+			p := &periods[i]
+			if !u.StartTime.Before(p.from) && (u.StartTime.Before(p.to) || i == len(periods)-1) {
+				return []segment{{period: p, from: u.StartTime, to: u.EndTime, fraction: 1}}
+			}
+		}
+		return nil
+	}
+
+	total := u.EndTime.Sub(u.StartTime)
+	var out []segment
+	for i := range periods {
+		p := &periods[i]
+		from, to := u.StartTime, u.EndTime
+		if p.from.After(from) {
+			from = p.from
+		}
+		if p.to.Before(to) {
+			to = p.to
+		}
+		if !to.After(from) {
+			continue
+		}
+		out = append(out, segment{
+			period:   p,
+			from:     from,
+			to:       to,
+			fraction: float64(to.Sub(from)) / float64(total),
+		})
+	}
+
+	return out
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type TaxService struct {
+	taxRepo repo.Tax
+}
+
+func NewTaxService(taxRepo repo.Tax) *TaxService {
+	slog.Debug("component", slog.String("name", "tax service"))
+
+	return &TaxService{
+		taxRepo: taxRepo,
+	}
+}
+
+// BillingProfileInput replaces the tenant's billing profile. The tax ID is
+// optional, without it reverse charge never applies. Without a currency the
+// tenant is invoiced in the billing currency.
+type BillingProfileInput struct {
+	LegalName string
+	Address   string
+	Country   string
+	TaxID     string
+	Currency  string
+}
+
+// TaxRuleInput creates or changes a tax rule, nil fields are left as they
+// are. The country of a rule does not change once created.
+type TaxRuleInput struct {
+	Country       string
+	Name          *string
+	Rate          *money.Decimal
+	ReverseCharge *bool
+	Rounding      *string
+}
+
+func (s *TaxService) SaveProfile(ctx context.Context, tenant string, in *BillingProfileInput) (*entity.BillingProfile, error) {
+	p := &entity.BillingProfile{
+		Tenant:    tenant,
+		LegalName: strings.TrimSpace(in.LegalName),
+		Address:   strings.TrimSpace(in.Address),
+		TaxID:     strings.TrimSpace(in.TaxID),
+	}
+	if p.LegalName == "" {
+		return nil, fmt.Errorf("%w: legal name is required", ErrInvalidProfile)
+	}
+	if p.Address == "" {
+		return nil, fmt.Errorf("%w: address is required", ErrInvalidProfile)
+	}
+	country, err := parseCountry(in.Country)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProfile, err)
+	}
+	p.Country = country
+
+	if in.Currency != "" {
+		currency, err := money.ParseCurrency(in.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidProfile, err)
+		}
+		p.Currency = currency.Code
+	}
+
+	return s.taxRepo.SaveProfile(ctx, p)
+}
+
+func (s *TaxService) GetProfile(ctx context.Context, tenant string) (*entity.BillingProfile, error) {
+	p, err := s.taxRepo.GetProfile(ctx, tenant)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrProfileNotFound
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s *TaxService) DeleteProfile(ctx context.Context, tenant string) error {
+	if err := s.taxRepo.DeleteProfile(ctx, tenant); err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return ErrProfileNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *TaxService) CreateRule(ctx context.Context, in *TaxRuleInput) (*entity.TaxRule, error) {
+	country, err := parseCountry(in.Country)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTaxRule, err)
+	}
+	if in.Name == nil {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidTaxRule)
+	}
+	if in.Rate == nil {
+		return nil, fmt.Errorf("%w: rate is required", ErrInvalidTaxRule)
+	}
+
+	t := &entity.TaxRule{Country: country}
+	if err := applyTaxRule(t, in); err != nil {
+		return nil, err
+	}
+
+	created, err := s.taxRepo.CreateRule(ctx, t)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrConflict) {
+			return nil, ErrTaxRuleExists
+		}
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (s *TaxService) GetRule(ctx context.Context, id int) (*entity.TaxRule, error) {
+	t, err := s.taxRepo.GetRule(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrTaxRuleNotFound
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// GetRules returns the rules of the country, of every country if it is empty.
+func (s *TaxService) GetRules(ctx context.Context, country string) ([]entity.TaxRule, error) {
+	if country != "" {
+		c, err := parseCountry(country)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidTaxRule, err)
+		}
+		country = c
+	}
+
+	rules, err := s.taxRepo.GetRules(ctx, country)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []entity.TaxRule{}
+	}
+
+	return rules, nil
+}
+
+func (s *TaxService) UpdateRule(ctx context.Context, id int, in *TaxRuleInput) (*entity.TaxRule, error) {
+	t, err := s.GetRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTaxRule(t, in); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.taxRepo.UpdateRule(ctx, t)
+	if err != nil {
+		switch {
+		case errors.Is(err, repoerrors.ErrNotFound):
+			return nil, ErrTaxRuleNotFound
+		case errors.Is(err, repoerrors.ErrConflict):
+			return nil, ErrTaxRuleExists
+		}
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (s *TaxService) DeleteRule(ctx context.Context, id int) error {
+	if err := s.taxRepo.DeleteRule(ctx, id); err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return ErrTaxRuleNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func applyTaxRule(t *entity.TaxRule, in *TaxRuleInput) error {
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if name == "" {
+			return fmt.Errorf("%w: name is required", ErrInvalidTaxRule)
+		}
+		t.Name = name
+	}
+
+	if in.Rate != nil {
+		if in.Rate.IsNegative() || in.Rate.GreaterThan(hundred) {
+			return fmt.Errorf("%w: rate must be between 0 and 100", ErrInvalidTaxRule)
+		}
+		t.Rate = *in.Rate
+	}
+
+	if in.ReverseCharge != nil {
+		t.ReverseCharge = *in.ReverseCharge
+	}
+
+	if in.Rounding != nil {
+		t.Rounding = ""
+		if *in.Rounding != "" {
+			r, err := money.ParseRounding(*in.Rounding)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidTaxRule, err)
+			}
+			t.Rounding = string(r)
+		}
+	}
+
+	return nil
+}
+
+// parseCountry normalizes an ISO 3166 alpha-2 country code.
+func parseCountry(s string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(s))
+	if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+		return "", fmt.Errorf("country %q is not a two-letter code", s)
+	}
+	return c, nil
+}
+
+// tax charges the taxes of the tenant's billing profile on the invoice.
+// Tenants without a profile pay no tax.
+func (s *BillingService) tax(ctx context.Context, inv *entity.Invoice, profile *entity.BillingProfile, policy money.Policy) error {
+	var rules []entity.TaxRule
+	if profile != nil {
+		var err error
+		rules, err = s.taxRepo.GetRules(ctx, profile.Country)
+		if err != nil {
+			slog.Error("failed to get tax rules", slog.String("country", profile.Country), slog.String("error", err.Error()))
+			return err
+		}
+	}
+
+	applyTaxes(inv, profile, rules, s.sellerCountry, policy)
+
+	return nil
+}
+
+// applyTaxes adds a tax line per rule, charged on the adjusted amount, and
+// adds their sum to the amount due. A rule with reverse charge charges
+// nothing to customers with a tax ID registered in another country than the
+// seller, they account for the tax themselves.
+func applyTaxes(inv *entity.Invoice, profile *entity.BillingProfile, rules []entity.TaxRule, seller string, policy money.Policy) {
+	h := &inv.Header
+	net := policy.Round(h.Totals.TotalCost.Add(h.AdjustmentTotal)).Amount
+
+	h.Customer = profile
+	h.TaxRate = money.Zero
+	h.Tax = money.Zero
+	inv.Taxes = make([]entity.TaxLine, 0, len(rules))
+
+	for _, rule := range rules {
+		line := entity.TaxLine{
+			RuleID:  rule.ID,
+			Name:    rule.Name,
+			Country: rule.Country,
+			Rate:    rule.Rate,
+			Base:    net,
+			Amount:  money.Zero,
+		}
+		line.ReverseCharge = rule.ReverseCharge && profile != nil &&
+			profile.TaxID != "" && profile.Country != seller
+
+		if !line.ReverseCharge {
+			rounding := policy.Rounding
+			if rule.Rounding != "" {
+				rounding = money.Rounding(rule.Rounding)
+			}
+			line.Amount = rounding.Round(net.Mul(rule.Rate).Div(hundred), policy.Precision)
+			h.TaxRate = h.TaxRate.Add(rule.Rate)
+			h.Tax = h.Tax.Add(line.Amount)
+		}
+
+		inv.Taxes = append(inv.Taxes, line)
+	}
+
+	h.AmountDue = net.Add(h.Tax)
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo/repoerrors"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+type fakeTaxRepo struct {
+	profiles map[string]entity.BillingProfile
+	rules    []entity.TaxRule
+}
+
+func newFakeTaxRepo() *fakeTaxRepo {
+	return &fakeTaxRepo{profiles: map[string]entity.BillingProfile{}}
+}
+
+func (f *fakeTaxRepo) SaveProfile(_ context.Context, p *entity.BillingProfile) (*entity.BillingProfile, error) {
+	f.profiles[p.Tenant] = *p
+	return p, nil
+}
+
+func (f *fakeTaxRepo) GetProfile(_ context.Context, tenant string) (*entity.BillingProfile, error) {
+	p, ok := f.profiles[tenant]
+	if !ok {
+		return nil, repoerrors.ErrNotFound
+	}
+	return &p, nil
+}
+
+func (f *fakeTaxRepo) DeleteProfile(_ context.Context, tenant string) error {
+	if _, ok := f.profiles[tenant]; !ok {
+		return repoerrors.ErrNotFound
+	}
+	delete(f.profiles, tenant)
+	return nil
+}
+
+func (f *fakeTaxRepo) CreateRule(_ context.Context, t *entity.TaxRule) (*entity.TaxRule, error) {
+	for _, r := range f.rules {
+		if r.Country == t.Country && r.Name == t.Name {
+			return nil, repoerrors.ErrConflict
+		}
+	}
+	t.ID = len(f.rules) + 1
+	f.rules = append(f.rules, *t)
+	return t, nil
+}
+
+func (f *fakeTaxRepo) GetRule(_ context.Context, id int) (*entity.TaxRule, error) {
+	for _, r := range f.rules {
+		if r.ID == id {
+			return &r, nil
+		}
+	}
+	return nil, repoerrors.ErrNotFound
+}
+
+func (f *fakeTaxRepo) GetRules(_ context.Context, country string) ([]entity.TaxRule, error) {
+	var out []entity.TaxRule
+	for _, r := range f.rules {
+		if country == "" || r.Country == country {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeTaxRepo) UpdateRule(_ context.Context, t *entity.TaxRule) (*entity.TaxRule, error) {
+	for i, r := range f.rules {
+		if r.ID == t.ID {
+			f.rules[i] = *t
+			return t, nil
+		}
+	}
+	return nil, repoerrors.ErrNotFound
+}
+
+func (f *fakeTaxRepo) DeleteRule(_ context.Context, id int) error {
+	for i, r := range f.rules {
+		if r.ID == id {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+	return repoerrors.ErrNotFound
+}
+
+// taxRules are the rules of the countries the tests bill to.
+func taxRules() []entity.TaxRule {
+	return []entity.TaxRule{
+		{ID: 1, Country: "DE", Name: "USt", Rate: dec("19"), ReverseCharge: true},
+		{ID: 2, Country: "FR", Name: "TVA", Rate: dec("20"), ReverseCharge: true},
+		{ID: 3, Country: "RU", Name: "НДС", Rate: dec("20")},
+		{ID: 4, Country: "CA", Name: "GST", Rate: dec("5")},
+		{ID: 5, Country: "CA", Name: "QST", Rate: dec("9.975")},
+		{ID: 6, Country: "NL", Name: "BTW", Rate: dec("7.5")},
+		{ID: 7, Country: "AT", Name: "USt", Rate: dec("7.5"), Rounding: string(money.RoundHalfUp)},
+	}
+}
+
+func Test_BillingTaxLines(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900010, 1000, 0)},
+	}}
+
+	type line struct {
+		name          string
+		amount        string
+		reverseCharge bool
+	}
+
+	tests := []struct {
+		name      string
+		seller    string
+		profile   *entity.BillingProfile
+		wantLines []line
+		wantRate  string
+		wantTax   string
+		wantDue   string
+	}{
+		{
+			name:      "domestic vat",
+			seller:    "DE",
+			profile:   &entity.BillingProfile{Country: "DE", TaxID: "DE123456789"},
+			wantLines: []line{{name: "USt", amount: "2.85"}},
+			wantRate:  "19", wantTax: "2.85", wantDue: "17.85",
+		},
+		{
+			name:      "business in another country is reverse charged",
+			seller:    "DE",
+			profile:   &entity.BillingProfile{Country: "FR", TaxID: "FR12345678901"},
+			wantLines: []line{{name: "TVA", amount: "0", reverseCharge: true}},
+			wantRate:  "0", wantTax: "0", wantDue: "15",
+		},
+		{
+			name:      "consumer in another country pays the vat",
+			seller:    "DE",
+			profile:   &entity.BillingProfile{Country: "FR"},
+			wantLines: []line{{name: "TVA", amount: "3"}},
+			wantRate:  "20", wantTax: "3", wantDue: "18",
+		},
+		{
+			name:      "russian vat",
+			seller:    "RU",
+			profile:   &entity.BillingProfile{Country: "RU", TaxID: "7707083893"},
+			wantLines: []line{{name: "НДС", amount: "3"}},
+			wantRate:  "20", wantTax: "3", wantDue: "18",
+		},
+		{
+			name:      "no rules for the country",
+			seller:    "DE",
+			profile:   &entity.BillingProfile{Country: "US", TaxID: "12-3456789"},
+			wantLines: []line{},
+			wantRate:  "0", wantTax: "0", wantDue: "15",
+		},
+		{
+			// 0.75 and 1.49625 are rounded on their own
+			name:      "a line per rule",
+			seller:    "CA",
+			profile:   &entity.BillingProfile{Country: "CA"},
+			wantLines: []line{{name: "GST", amount: "0.75"}, {name: "QST", amount: "1.5"}},
+			wantRate:  "14.975", wantTax: "2.25", wantDue: "17.25",
+		},
+		{
+			// 1.125 rounded half-even like the billing policy
+			name:      "policy rounding",
+			profile:   &entity.BillingProfile{Country: "NL"},
+			wantLines: []line{{name: "BTW", amount: "1.12"}},
+			wantRate:  "7.5", wantTax: "1.12", wantDue: "16.12",
+		},
+		{
+			name:      "rule rounding",
+			profile:   &entity.BillingProfile{Country: "AT"},
+			wantLines: []line{{name: "USt", amount: "1.13"}},
+			wantRate:  "7.5", wantTax: "1.13", wantDue: "16.13",
+		},
+		{
+			name:      "no tax without a profile",
+			wantLines: []line{},
+			wantRate:  "0", wantTax: "0", wantDue: "15",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxes := newFakeTaxRepo()
+			taxes.rules = taxRules()
+			if tt.profile != nil {
+				p := *tt.profile
+				p.Tenant = "alice"
+				p.LegalName = "Alice GmbH"
+				taxes.profiles["alice"] = p
+			}
+
+			s := NewBillingService(usage, newFakeInvoiceRepo(), taxes, newFakeFXRepo(), basicTariffs(), BillingConfig{
+				DefaultTariffID: 1,
+				SellerCountry:   tt.seller,
+			})
+
+			inv, err := s.Draft(context.Background(), "alice")
+			require.NoError(t, err)
+
+			got := make([]line, 0, len(inv.Taxes))
+			for _, l := range inv.Taxes {
+				assertDecimal(t, dec("15"), l.Base, l.Name)
+				got = append(got, line{name: l.Name, amount: l.Amount.String(), reverseCharge: l.ReverseCharge})
+			}
+			assert.Equal(t, tt.wantLines, got)
+
+			assertDecimal(t, dec(tt.wantRate), inv.Header.TaxRate)
+			assertDecimal(t, dec(tt.wantTax), inv.Header.Tax)
+			assertDecimal(t, dec(tt.wantDue), inv.Header.AmountDue)
+			if tt.profile != nil {
+				require.NotNil(t, inv.Header.Customer)
+				assert.Equal(t, "Alice GmbH", inv.Header.Customer.LegalName)
+			} else {
+				assert.Nil(t, inv.Header.Customer)
+			}
+		})
+	}
+}
+
+func Test_TaxService(t *testing.T) {
+	ctx := context.Background()
+	s := NewTaxService(newFakeTaxRepo())
+
+	name, rate := "VAT", dec("20")
+	rule, err := s.CreateRule(ctx, &TaxRuleInput{Country: " gb", Name: &name, Rate: &rate})
+	require.NoError(t, err)
+	assert.Equal(t, "GB", rule.Country)
+
+	_, err = s.CreateRule(ctx, &TaxRuleInput{Country: "GB", Name: &name, Rate: &rate})
+	assert.ErrorIs(t, err, ErrTaxRuleExists)
+
+	invalid := []struct {
+		name string
+		in   TaxRuleInput
+	}{
+		{name: "country", in: TaxRuleInput{Country: "GBR", Name: &name, Rate: &rate}},
+		{name: "missing rate", in: TaxRuleInput{Country: "GB", Name: &name}},
+		{name: "rate", in: TaxRuleInput{Country: "GB", Name: &name, Rate: ptr(dec("100.5"))}},
+		{name: "rounding", in: TaxRuleInput{Country: "GB", Name: &name, Rate: &rate, Rounding: ptr("ceil")}},
+	}
+	for _, tt := range invalid {
+		_, err := s.CreateRule(ctx, &tt.in)
+		assert.ErrorIs(t, err, ErrInvalidTaxRule, tt.name)
+	}
+
+	updated, err := s.UpdateRule(ctx, rule.ID, &TaxRuleInput{Rate: ptr(dec("5")), Rounding: ptr("half_up")})
+	require.NoError(t, err)
+	assertDecimal(t, dec("5"), updated.Rate)
+	assert.Equal(t, "half_up", updated.Rounding)
+	assert.Equal(t, "VAT", updated.Name)
+
+	_, err = s.UpdateRule(ctx, 42, &TaxRuleInput{})
+	assert.ErrorIs(t, err, ErrTaxRuleNotFound)
+
+	_, err = s.SaveProfile(ctx, "alice", &BillingProfileInput{LegalName: "Alice Ltd", Address: "London", Country: "UK1"})
+	assert.ErrorIs(t, err, ErrInvalidProfile)
+
+	profile, err := s.SaveProfile(ctx, "alice", &BillingProfileInput{LegalName: "Alice Ltd", Address: "London", Country: "gb"})
+	require.NoError(t, err)
+	assert.Equal(t, "GB", profile.Country)
+
+	require.NoError(t, s.DeleteProfile(ctx, "alice"))
+	_, err = s.GetProfile(ctx, "alice")
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
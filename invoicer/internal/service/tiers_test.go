@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
+)
+
+// tieredTariff charges memory graduated, the first 1000 MB*s at 0.01 and the
+// rest at 0.005, CPU by volume, 0.2 up to 10 CPU*s and 0.1 above, and
+// requests flat.
+func tieredTariff() entity.Tariff {
+	bound := func(s string) *money.Decimal { d := dec(s); return &d }
+
+	return entity.Tariff{
+		ID:           1,
+		Name:         "tiered",
+		ExecPrice:    dec("0.5"),
+		RequestPrice: dec("0.001"),
+		Tiers: pricing.Schedule{
+			entity.DimensionMemory: {Model: pricing.ModelGraduated, Tiers: []pricing.Tier{
+				{UpTo: bound("1000"), UnitPrice: dec("0.01")},
+				{UnitPrice: dec("0.005")},
+			}},
+			entity.DimensionCPU: {Model: pricing.ModelVolume, Tiers: []pricing.Tier{
+				{UpTo: bound("10"), UnitPrice: dec("0.2")},
+				{UnitPrice: dec("0.1")},
+			}},
+		},
+	}
+}
+
+func Test_BillingTieredPricing(t *testing.T) {
+	hello := usageRow("hello", "hello-a", 1760900000, 1760900010, 600, 4)
+	hello.Requests = 100
+	resize := usageRow("resize", "resize-x", 1760900000, 1760900010, 600, 8)
+
+	tariffs := &fakeTariffs{tariffs: map[int]entity.Tariff{1: tieredTariff()}}
+	s := newTestBilling(&fakeUsageRepo{usage: map[string][]entity.Usage{"alice": {resize, hello}}}, tariffs)
+
+	inv, err := s.Draft(context.Background(), "alice")
+	require.NoError(t, err)
+	require.Len(t, inv.Functions, 2)
+
+	tests := []struct {
+		pod    entity.PodLine
+		want   []entity.Charge
+		totals entity.Totals
+	}{
+		{
+			// the first pod fills the first memory tier
+			pod: inv.Functions[0].Pods[0],
+			want: []entity.Charge{
+				{Dimension: entity.DimensionExec, Quantity: dec("10"), UnitPrice: dec("0.5"), Amount: dec("5")},
+				{Dimension: entity.DimensionMemory, Quantity: dec("600"), UnitPrice: dec("0.01"), Amount: dec("6")},
+				{Dimension: entity.DimensionCPU, Tier: 1, Quantity: dec("4"), UnitPrice: dec("0.1"), Amount: dec("0.4")},
+				{Dimension: entity.DimensionRequests, Quantity: dec("100"), UnitPrice: dec("0.001"), Amount: dec("0.1")},
+			},
+			totals: entity.Totals{DurationSec: 10, MemoryMBSec: 600, CPUSec: 4, Requests: 100,
+				ExecCost: dec("5"), MemoryCost: dec("6"), CPUCost: dec("0.4"), RequestCost: dec("0.1"), TotalCost: dec("11.5")},
+		},
+		{
+			// the second one crosses the bound, its memory is split per tier,
+			// and 12 CPU*s in total put all of it in the cheaper volume tier
+			pod: inv.Functions[1].Pods[0],
+			want: []entity.Charge{
+				{Dimension: entity.DimensionExec, Quantity: dec("10"), UnitPrice: dec("0.5"), Amount: dec("5")},
+				{Dimension: entity.DimensionMemory, Quantity: dec("400"), UnitPrice: dec("0.01"), Amount: dec("4")},
+				{Dimension: entity.DimensionMemory, Tier: 1, Quantity: dec("200"), UnitPrice: dec("0.005"), Amount: dec("1")},
+				{Dimension: entity.DimensionCPU, Tier: 1, Quantity: dec("8"), UnitPrice: dec("0.1"), Amount: dec("0.8")},
+			},
+			totals: entity.Totals{DurationSec: 10, MemoryMBSec: 600, CPUSec: 8,
+				ExecCost: dec("5"), MemoryCost: dec("5"), CPUCost: dec("0.8"), TotalCost: dec("10.8")},
+		},
+	}
+
+	for _, tt := range tests {
+		require.Len(t, tt.pod.Charges, len(tt.want), tt.pod.Pod)
+		for i, want := range tt.want {
+			got := tt.pod.Charges[i]
+			assert.Equal(t, want.Dimension, got.Dimension, "%s charge %d", tt.pod.Pod, i)
+			assert.Equal(t, want.Tier, got.Tier, "%s charge %d", tt.pod.Pod, i)
+			assertDecimal(t, want.Quantity, got.Quantity, "%s charge %d", tt.pod.Pod, i)
+			assertDecimal(t, want.UnitPrice, got.UnitPrice, "%s charge %d", tt.pod.Pod, i)
+			assertDecimal(t, want.Amount, got.Amount, "%s charge %d", tt.pod.Pod, i)
+		}
+		assertTotals(t, tt.totals, tt.pod.Totals)
+	}
+
+	assertTotals(t, entity.Totals{DurationSec: 20, MemoryMBSec: 1200, CPUSec: 12, Requests: 100,
+		ExecCost: dec("10"), MemoryCost: dec("11"), CPUCost: dec("1.2"), RequestCost: dec("0.1"), TotalCost: dec("22.3")},
+		inv.Header.Totals)
+	assertDecimal(t, dec("22.3"), inv.Header.AmountDue)
+	require.Len(t, inv.Header.Tariffs, 1)
+	assert.Len(t, inv.Header.Tariffs[0].Tiers, 2)
+}
+
+func Test_BillingTieredFreeTier(t *testing.T) {
+	tariff := tieredTariff()
+	tariff.FreeMemMBSec = dec("1100")
+
+	usage := []entity.Usage{
+		usageRow("hello", "a", 1760900000, 1760900010, 600, 0),
+		usageRow("hello", "b", 1760900020, 1760900030, 900, 0),
+	}
+	tariffs := &fakeTariffs{tariffs: map[int]entity.Tariff{1: tariff}}
+	s := newTestBilling(&fakeUsageRepo{usage: map[string][]entity.Usage{"alice": usage}}, tariffs)
+
+	inv, err := s.Draft(context.Background(), "alice")
+	require.NoError(t, err)
+
+	// the allowance waives the earliest, dearest units: 1000 MB*s of the
+	// first tier and 100 of the second
+	require.Len(t, inv.Adjustments, 1)
+	assertDecimal(t, dec("1100"), inv.Adjustments[0].Quantity)
+	assertDecimal(t, dec("-10.5"), inv.Adjustments[0].Amount)
+
+	// 1500 MB*s are 1000 at 0.01 and 500 at 0.005, plus 20 s of execution
+	assertDecimal(t, dec("12.5"), inv.Header.Totals.MemoryCost)
+	assertDecimal(t, dec("22.5"), inv.Header.Totals.TotalCost)
+	assertDecimal(t, dec("12"), inv.Header.AmountDue)
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/invoicer/internal/repo"
+)
+
+const (
+	defaultUsageRange = 24 * time.Hour
+	maxUsageSeries    = 100
+	// maxUsagePoints bounds a series, an hourly chart over a month fits
+	maxUsagePoints = 1000
+)
+
+var usageUnits = map[string]string{
+	entity.UsageMetricMemory:   "MB*s",
+	entity.UsageMetricCPU:      "CPU*s",
+	entity.UsageMetricDuration: "s",
+	entity.UsageMetricRequests: "requests",
+}
+
+var usageGranularities = map[string]Period{
+	entity.UsageGroupDay:  PeriodDay,
+	entity.UsageGroupHour: PeriodHour,
+}
+
+type UsageService struct {
+	usageRepo repo.Usage
+	now       func() time.Time
+}
+
+func NewUsageService(usageRepo repo.Usage) *UsageService {
+	slog.Debug("component", slog.String("name", "usage service"))
+
+	return &UsageService{
+		usageRepo: usageRepo,
+		now:       time.Now,
+	}
+}
+
+// Report aggregates the tenant's usage into series ready to be charted.
+// Usage is reported as metered, whether it was invoiced yet or not.
+func (s *UsageService) Report(ctx context.Context, q *entity.UsageQuery) (*entity.UsageReport, error) {
+	granularity, err := s.normalize(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.usageRepo.Report(ctx, q)
+	if err != nil {
+		slog.Error("failed to query usage", slog.String("tenant", q.Tenant), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	report := &entity.UsageReport{
+		Tenant:  q.Tenant,
+		From:    q.From,
+		To:      q.To,
+		Metric:  q.Metric,
+		Unit:    usageUnits[q.Metric],
+		GroupBy: q.GroupBy,
+		Series:  []entity.UsageSeries{},
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+	}
+	if granularity != "" {
+		report.Granularity = string(granularity)
+	}
+
+	grouped := q.Has(entity.UsageGroupFunction) || q.Has(entity.UsageGroupPod)
+	// without dimensions there is exactly one series, even if it is all zeros
+	if !grouped && len(rows) == 0 {
+		rows = []entity.UsageRow{{Bucket: q.From}}
+	}
+
+	buckets := usageBuckets(q, granularity)
+	for i := 0; i < len(rows); {
+		j := i
+		for j < len(rows) && rows[j].Function == rows[i].Function && rows[j].Pod == rows[i].Pod {
+			j++
+		}
+		report.Series = append(report.Series, usageSeries(rows[i:j], buckets))
+		i = j
+	}
+
+	return report, nil
+}
+
+// normalize fills in the defaults and rejects queries that are not answerable.
+func (s *UsageService) normalize(q *entity.UsageQuery) (Period, error) {
+	if q.Tenant == "" {
+		return "", fmt.Errorf("%w: tenant is required", ErrInvalidUsageQuery)
+	}
+
+	if q.Metric == "" {
+		q.Metric = entity.UsageMetricMemory
+	}
+	if _, ok := usageUnits[q.Metric]; !ok {
+		return "", fmt.Errorf("%w: unknown metric %q", ErrInvalidUsageQuery, q.Metric)
+	}
+
+	if q.To.IsZero() {
+		q.To = s.now().UTC()
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-defaultUsageRange)
+	}
+	if !q.To.After(q.From) {
+		return "", fmt.Errorf("%w: from must be before to", ErrInvalidUsageQuery)
+	}
+	// usage is read from rollups, so the range is widened to whole buckets
+	q.From = q.From.Truncate(entity.RollupStep)
+	if to := q.To.Truncate(entity.RollupStep); to.Before(q.To) {
+		q.To = to.Add(entity.RollupStep)
+	}
+
+	if q.Limit == 0 || q.Limit > maxUsageSeries {
+		q.Limit = maxUsageSeries
+	}
+
+	var granularity Period
+	seen := make(map[string]bool)
+	for _, g := range q.GroupBy {
+		if seen[g] {
+			return "", fmt.Errorf("%w: %s is grouped by twice", ErrInvalidUsageQuery, g)
+		}
+		seen[g] = true
+
+		switch g {
+		case entity.UsageGroupFunction, entity.UsageGroupPod:
+		case entity.UsageGroupDay, entity.UsageGroupHour:
+			if granularity != "" {
+				return "", fmt.Errorf("%w: only one of day and hour can be grouped by", ErrInvalidUsageQuery)
+			}
+			granularity = usageGranularities[g]
+		default:
+			return "", fmt.Errorf("%w: unknown group_by %q", ErrInvalidUsageQuery, g)
+		}
+	}
+
+	if granularity != "" {
+		points := 0
+		for t := granularity.Start(q.From); t.Before(q.To); t = granularity.Next(t) {
+			if points++; points > maxUsagePoints {
+				return "", fmt.Errorf("%w: more than %d %s points, narrow the range", ErrInvalidUsageQuery, maxUsagePoints, granularity)
+			}
+		}
+	}
+
+	return granularity, nil
+}
+
+// usageBuckets lists the starts of every bucket in the range, so gaps in the
+// usage show up as zeros instead of missing points.
+func usageBuckets(q *entity.UsageQuery, granularity Period) []time.Time {
+	if granularity == "" {
+		return []time.Time{q.From}
+	}
+
+	var buckets []time.Time
+	for t := granularity.Start(q.From); t.Before(q.To); t = granularity.Next(t) {
+		buckets = append(buckets, t)
+	}
+	return buckets
+}
+
+// usageSeries lays the rows of one series, ordered by bucket, over the buckets.
+func usageSeries(rows []entity.UsageRow, buckets []time.Time) entity.UsageSeries {
+	series := entity.UsageSeries{
+		Function: rows[0].Function,
+		Pod:      rows[0].Pod,
+		Points:   make([]entity.UsagePoint, 0, len(buckets)),
+	}
+
+	i := 0
+	for _, b := range buckets {
+		point := entity.UsagePoint{Time: b}
+		for i < len(rows) && !rows[i].Bucket.After(b) {
+			if rows[i].Bucket.Equal(b) {
+				point.Value += rows[i].Value
+			}
+			i++
+		}
+		series.Points = append(series.Points, point)
+		series.Total += point.Value
+	}
+
+	return series
+}
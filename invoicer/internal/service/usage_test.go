@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+)
+
+// fakeReportRepo returns canned rows and remembers the query it was asked.
+type fakeReportRepo struct {
+	fakeUsageRepo
+	rows  []entity.UsageRow
+	query *entity.UsageQuery
+}
+
+func (f *fakeReportRepo) Report(_ context.Context, q *entity.UsageQuery) ([]entity.UsageRow, error) {
+	f.query = q
+	return f.rows, nil
+}
+
+func Test_UsageReport(t *testing.T) {
+	day := func(d int) time.Time { return utc(2025, time.October, d, 0) }
+
+	usage := &fakeReportRepo{rows: []entity.UsageRow{
+		{Function: "hello", Bucket: day(1), Value: 10},
+		{Function: "hello", Bucket: day(3), Value: 5},
+		{Function: "resize", Bucket: day(2), Value: 1.5},
+	}}
+	s := NewUsageService(usage)
+
+	report, err := s.Report(context.Background(), &entity.UsageQuery{
+		Tenant:  "alice",
+		From:    day(1).Add(6 * time.Hour),
+		To:      day(4),
+		GroupBy: []string{entity.UsageGroupFunction, entity.UsageGroupDay},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, entity.UsageMetricMemory, usage.query.Metric, "memory is the default metric")
+	assert.Equal(t, uint64(maxUsageSeries), usage.query.Limit)
+	assert.Equal(t, "MB*s", report.Unit)
+	assert.Equal(t, "day", report.Granularity)
+
+	require.Len(t, report.Series, 2)
+	hello := report.Series[0]
+	assert.Equal(t, "hello", hello.Function)
+	assert.Equal(t, []entity.UsagePoint{
+		{Time: day(1), Value: 10},
+		{Time: day(2), Value: 0},
+		{Time: day(3), Value: 5},
+	}, hello.Points)
+	assert.InDelta(t, 15, hello.Total, 1e-9)
+
+	resize := report.Series[1]
+	assert.Equal(t, "resize", resize.Function)
+	assert.Equal(t, []float64{0, 1.5, 0}, []float64{resize.Points[0].Value, resize.Points[1].Value, resize.Points[2].Value})
+}
+
+func Test_UsageReportRange(t *testing.T) {
+	usage := &fakeReportRepo{}
+	s := NewUsageService(usage)
+
+	from := utc(2025, time.October, 1, 10)
+	_, err := s.Report(context.Background(), &entity.UsageQuery{
+		Tenant: "alice",
+		From:   from.Add(30 * time.Second),
+		To:     from.Add(75*time.Minute + 10*time.Second),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, from, usage.query.From, "the range is widened to whole rollup buckets")
+	assert.Equal(t, from.Add(76*time.Minute), usage.query.To)
+}
+
+func Test_UsageReportTotal(t *testing.T) {
+	s := NewUsageService(&fakeReportRepo{})
+	s.now = func() time.Time { return utc(2025, time.October, 2, 0) }
+
+	report, err := s.Report(context.Background(), &entity.UsageQuery{
+		Tenant:  "alice",
+		Metric:  entity.UsageMetricRequests,
+		GroupBy: []string{entity.UsageGroupHour},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, utc(2025, time.October, 1, 0), report.From, "the last day is the default range")
+	require.Len(t, report.Series, 1, "an ungrouped report always has its series")
+	assert.Len(t, report.Series[0].Points, 24)
+	assert.Zero(t, report.Series[0].Total)
+}
+
+func Test_UsageReportInvalid(t *testing.T) {
+	from := utc(2025, time.October, 1, 0)
+
+	tests := []struct {
+		name  string
+		query entity.UsageQuery
+	}{
+		{"no tenant", entity.UsageQuery{}},
+		{"unknown metric", entity.UsageQuery{Tenant: "alice", Metric: "gpu_sec"}},
+		{"unknown group", entity.UsageQuery{Tenant: "alice", GroupBy: []string{"revision"}}},
+		{"two granularities", entity.UsageQuery{Tenant: "alice", GroupBy: []string{"day", "hour"}}},
+		{"grouped twice", entity.UsageQuery{Tenant: "alice", GroupBy: []string{"pod", "pod"}}},
+		{"empty range", entity.UsageQuery{Tenant: "alice", From: from, To: from}},
+		{"too many points", entity.UsageQuery{Tenant: "alice", From: from, To: from.AddDate(1, 0, 0), GroupBy: []string{"hour"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := &fakeReportRepo{}
+			s := NewUsageService(usage)
+
+			_, err := s.Report(context.Background(), &tt.query)
+			assert.ErrorIs(t, err, ErrInvalidUsageQuery)
+			assert.Nil(t, usage.query, "invalid queries never reach ClickHouse")
+		})
+	}
+}
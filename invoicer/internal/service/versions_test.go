@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+)
+
+// repriced is tariff 1 before and after a price change at 1760900010.
+func repriced() []entity.Tariff {
+	return []entity.Tariff{
+		{ID: 1, Name: "basic", Version: 1, EffectiveFrom: time.Unix(1760000000, 0).UTC(),
+			ExecPrice: dec("0.5"), MemPrice: dec("0.01"), FreeExecSec: dec("5")},
+		{ID: 1, Name: "basic", Version: 2, EffectiveFrom: time.Unix(1760900010, 0).UTC(),
+			ExecPrice: dec("1"), MemPrice: dec("0.02"), FreeExecSec: dec("100")},
+	}
+}
+
+func Test_BillingTariffVersions(t *testing.T) {
+	usage := &fakeUsageRepo{usage: map[string][]entity.Usage{
+		"alice": {usageRow("hello", "hello-a", 1760900000, 1760900020, 2000, 0)},
+	}}
+	s := newTestBilling(usage, &fakeTariffs{versions: map[int][]entity.Tariff{1: repriced()}})
+
+	inv, err := s.Draft(context.Background(), "alice")
+	require.NoError(t, err)
+
+	require.Len(t, inv.Header.Tariffs, 2)
+	for i, ref := range inv.Header.Tariffs {
+		assert.Equal(t, 1, ref.ID)
+		assert.Equal(t, i+1, ref.Version)
+	}
+	assert.Equal(t, time.Unix(1760900010, 0).UTC(), inv.Header.Tariffs[0].To)
+	assert.Equal(t, time.Unix(1760900010, 0).UTC(), inv.Header.Tariffs[1].From)
+
+	// each half of the pod is priced with the version in force at the time
+	var exec []entity.Charge
+	for _, c := range inv.Functions[0].Pods[0].Charges {
+		if c.Dimension == entity.DimensionExec {
+			exec = append(exec, c)
+		}
+	}
+	require.Len(t, exec, 2)
+	assert.Equal(t, 1, exec[0].TariffVersion)
+	assertDecimal(t, dec("5"), exec[0].Amount)
+	assert.Equal(t, 2, exec[1].TariffVersion)
+	assertDecimal(t, dec("10"), exec[1].Amount)
+
+	assertTotals(t, entity.Totals{
+		DurationSec: 20,
+		MemoryMBSec: 2000,
+		ExecCost:    dec("15"),
+		MemoryCost:  dec("30"),
+		TotalCost:   dec("45"),
+	}, inv.Header.Totals)
+
+	// the allowance is not renewed by the price change
+	require.Len(t, inv.Adjustments, 1)
+	assertDecimal(t, dec("5"), inv.Adjustments[0].Quantity)
+	assertDecimal(t, dec("-2.5"), inv.Adjustments[0].Amount)
+	assertDecimal(t, dec("42.5"), inv.Header.AmountDue)
+}
+
+func Test_VersionPeriods(t *testing.T) {
+	versions := repriced()
+	at := func(sec int64) time.Time { return time.Unix(sec, 0).UTC() }
+
+	tests := []struct {
+		name string
+		w    window
+		want []int
+	}{
+		{name: "before the first version", w: window{from: at(1750000000), to: at(1750000100)}, want: []int{1}},
+		{name: "across the change", w: window{from: at(1760900000), to: at(1760900020)}, want: []int{1, 2}},
+		{name: "ending at the change", w: window{from: at(1760900000), to: at(1760900010)}, want: []int{1}},
+		{name: "after the change", w: window{from: at(1760900010), to: at(1760900020)}, want: []int{2}},
+		{name: "instant before the change", w: window{from: at(1760900009), to: at(1760900009)}, want: []int{1}},
+		{name: "instant at the change", w: window{from: at(1760900010), to: at(1760900010)}, want: []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, p := range versionPeriods(versions, tt.w) {
+				got = append(got, p.tariff.Version)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
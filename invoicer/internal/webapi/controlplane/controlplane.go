@@ -0,0 +1,51 @@
+package controlplane
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Client asks control_plane to act on a tenant's functions.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		// patching every service of a tenant takes a few API server round trips
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ScaleToZero takes every function of the tenant off the ingress and lets it
+// scale down to no replicas.
+func (c *Client) ScaleToZero(ctx context.Context, tenant string) error {
+	body, err := json.Marshal(map[string]string{"tenant": tenant})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/functions/scale-to-zero", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("control plane returned status %d for %s", resp.StatusCode, req.URL.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the webhook body.
+const SignatureHeader = "X-Signature"
+
+var (
+	ErrNoSecret         = errors.New("webhook secret is not configured")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Local is a payment provider with no payment service behind it, for tests
+// and local setups. Its checkout URL is only informational: payments are
+// reported by posting a webhook signed with the shared secret.
+type Local struct {
+	secret  []byte
+	baseURL string
+	now     func() time.Time
+}
+
+func NewLocal(secret, baseURL string) *Local {
+	return &Local{
+		secret:  []byte(secret),
+		baseURL: baseURL,
+		now:     time.Now,
+	}
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+// Checkout makes up a payment id, the webhook of the payment must report it.
+func (l *Local) Checkout(_ context.Context, h *entity.InvoiceHeader, amount money.Decimal) (*entity.Checkout, error) {
+	id := fmt.Sprintf("local-%d-%d", h.Number, l.now().UnixNano())
+
+	q := url.Values{}
+	q.Set("invoice", strconv.FormatInt(h.Number, 10))
+	q.Set("amount", amount.String())
+	q.Set("currency", h.Currency)
+
+	return &entity.Checkout{
+		InvoiceNumber: h.Number,
+		Provider:      l.Name(),
+		ExternalID:    id,
+		URL:           l.baseURL + "/checkout/" + id + "?" + q.Encode(),
+		Amount:        amount,
+		Currency:      h.Currency,
+	}, nil
+}
+
+// Webhook is the body of a local payment webhook.
+type Webhook struct {
+	ID            string        `json:"id"`
+	InvoiceNumber int64         `json:"invoice_number"`
+	Status        string        `json:"status"`
+	Amount        money.Decimal `json:"amount"`
+	Currency      string        `json:"currency"`
+}
+
+// ParseWebhook checks the body against its signature before trusting it.
+func (l *Local) ParseWebhook(header http.Header, body []byte) (*entity.Payment, error) {
+	if len(l.secret) == 0 {
+		return nil, ErrNoSecret
+	}
+
+	got, err := hex.DecodeString(header.Get(SignatureHeader))
+	if err != nil || !hmac.Equal(got, l.mac(body)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var w Webhook
+	if err := json.Unmarshal(body, &w); err != nil {
+		return nil, fmt.Errorf("decode webhook: %w", err)
+	}
+
+	return &entity.Payment{
+		InvoiceNumber: w.InvoiceNumber,
+		Provider:      l.Name(),
+		ExternalID:    w.ID,
+		Status:        w.Status,
+		Amount:        w.Amount,
+		Currency:      w.Currency,
+	}, nil
+}
+
+// Sign returns the signature header value of a webhook body.
+func (l *Local) Sign(body []byte) string {
+	return hex.EncodeToString(l.mac(body))
+}
+
+func (l *Local) mac(body []byte) []byte {
+	m := hmac.New(sha256.New, l.secret)
+	m.Write(body)
+	return m.Sum(nil)
+}
@@ -0,0 +1,55 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+func Test_LocalWebhook(t *testing.T) {
+	l := NewLocal("secret", "http://localhost:8080")
+	body := []byte(`{"id":"local-7-1","invoice_number":7,"status":"succeeded","amount":"22.2","currency":"USD"}`)
+
+	header := http.Header{}
+	header.Set(SignatureHeader, l.Sign(body))
+	p, err := l.ParseWebhook(header, body)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), p.InvoiceNumber)
+	assert.Equal(t, "local", p.Provider)
+	assert.Equal(t, "local-7-1", p.ExternalID)
+	assert.Equal(t, entity.PaymentSucceeded, p.Status)
+	assert.True(t, money.MustParse("22.2").Equal(p.Amount))
+
+	// another secret, a tampered body or no signature are rejected
+	header.Set(SignatureHeader, NewLocal("other", "").Sign(body))
+	_, err = l.ParseWebhook(header, body)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	header.Set(SignatureHeader, l.Sign(body))
+	_, err = l.ParseWebhook(header, append(body, ' '))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = l.ParseWebhook(http.Header{}, body)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = NewLocal("", "").ParseWebhook(header, body)
+	assert.ErrorIs(t, err, ErrNoSecret)
+}
+
+func Test_LocalCheckout(t *testing.T) {
+	l := NewLocal("secret", "http://localhost:8080")
+	h := &entity.InvoiceHeader{Number: 7, Currency: "EUR"}
+
+	c, err := l.Checkout(context.Background(), h, money.MustParse("10.5"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), c.InvoiceNumber)
+	assert.Equal(t, "EUR", c.Currency)
+	assert.Contains(t, c.URL, "/checkout/"+c.ExternalID)
+	assert.Contains(t, c.URL, "amount=10.5")
+}
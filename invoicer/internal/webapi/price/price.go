@@ -0,0 +1,134 @@
+package price
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/usamaroman/faas_demo/invoicer/internal/entity"
+)
+
+// Client talks to price_service over its public v1 API.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *Client) GetTariff(ctx context.Context, id int) (*entity.Tariff, error) {
+	var response struct {
+		Tariff entity.Tariff `json:"tariff"`
+	}
+	if err := c.get(ctx, fmt.Sprintf("%s/v1/tariff/%d", c.baseURL, id), &response); err != nil {
+		return nil, err
+	}
+
+	return &response.Tariff, nil
+}
+
+// GetTariffVersions returns the versions of the tariff's prices in force at
+// some point of [from, to), oldest first.
+func (c *Client) GetTariffVersions(ctx context.Context, id int, from, to time.Time) ([]entity.Tariff, error) {
+	tariff, err := c.GetTariff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("from", from.UTC().Format(time.RFC3339))
+	query.Set("to", to.UTC().Format(time.RFC3339))
+
+	var response struct {
+		Versions []entity.Tariff `json:"versions"`
+	}
+	if err := c.get(ctx, fmt.Sprintf("%s/v1/tariff/%d/versions?%s", c.baseURL, id, query.Encode()), &response); err != nil {
+		return nil, err
+	}
+
+	// versions carry the prices only
+	for i := range response.Versions {
+		response.Versions[i].ID = tariff.ID
+		response.Versions[i].Name = tariff.Name
+	}
+
+	return response.Versions, nil
+}
+
+// maxSubscriptions bounds a single lookup, a tenant changes plans a handful
+// of times per billing period at most. Discounts and credits are as rare.
+const maxSubscriptions = 1000
+
+// GetSubscriptions returns the tenant's subscriptions overlapping [from, to].
+func (c *Client) GetSubscriptions(ctx context.Context, tenant string, from, to time.Time) ([]entity.Subscription, error) {
+	var response struct {
+		Subscriptions []entity.Subscription `json:"subscriptions"`
+	}
+	if err := c.get(ctx, c.tenantURL("/v1/subscription/", tenant, from, to), &response); err != nil {
+		return nil, err
+	}
+
+	return response.Subscriptions, nil
+}
+
+// GetDiscounts returns the tenant's discounts overlapping [from, to].
+func (c *Client) GetDiscounts(ctx context.Context, tenant string, from, to time.Time) ([]entity.Discount, error) {
+	var response struct {
+		Discounts []entity.Discount `json:"discounts"`
+	}
+	if err := c.get(ctx, c.tenantURL("/v1/discount/", tenant, from, to), &response); err != nil {
+		return nil, err
+	}
+
+	return response.Discounts, nil
+}
+
+// GetCredits returns the tenant's credits valid at some point of [from, to].
+func (c *Client) GetCredits(ctx context.Context, tenant string, from, to time.Time) ([]entity.Credit, error) {
+	var response struct {
+		Credits []entity.Credit `json:"credits"`
+	}
+	if err := c.get(ctx, c.tenantURL("/v1/credit/", tenant, from, to), &response); err != nil {
+		return nil, err
+	}
+
+	return response.Credits, nil
+}
+
+func (c *Client) tenantURL(path, tenant string, from, to time.Time) string {
+	query := url.Values{}
+	query.Set("tenant", tenant)
+	query.Set("from", from.UTC().Format(time.RFC3339))
+	// the filter is half-open, so include anything starting exactly at to
+	query.Set("to", to.Add(time.Second).UTC().Format(time.RFC3339))
+	query.Set("limit", fmt.Sprint(maxSubscriptions))
+
+	return c.baseURL + path + "?" + query.Encode()
+}
+
+func (c *Client) get(ctx context.Context, target string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("price service returned status %d for %s", resp.StatusCode, req.URL.Path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
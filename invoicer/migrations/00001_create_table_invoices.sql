@@ -0,0 +1,75 @@
+-- +goose Up
+-- +goose StatementBegin
+-- invoice numbers must have no gaps, so they come from a row locked for the
+-- duration of the issuing transaction instead of a sequence
+CREATE TABLE invoice_counters (
+     name VARCHAR(50) PRIMARY KEY,
+     last_number BIGINT NOT NULL
+);
+
+INSERT INTO invoice_counters (name, last_number) VALUES ('invoice', 0);
+
+CREATE TABLE invoices (
+     id BIGSERIAL PRIMARY KEY,
+     number BIGINT NOT NULL UNIQUE,
+     tenant VARCHAR(255) NOT NULL,
+     period_start TIMESTAMPTZ NOT NULL,
+     period_end TIMESTAMPTZ NOT NULL,
+     -- usage inserted after the cutoff is billed in the next period
+     usage_cutoff TIMESTAMPTZ NOT NULL,
+     status VARCHAR(20) NOT NULL,
+     tariffs JSONB NOT NULL,
+     duration_sec BIGINT NOT NULL,
+     memory_mb_sec NUMERIC(30, 10) NOT NULL,
+     cpu_sec NUMERIC(30, 10) NOT NULL,
+     exec_cost NUMERIC(30, 10) NOT NULL,
+     memory_cost NUMERIC(30, 10) NOT NULL,
+     cpu_cost NUMERIC(30, 10) NOT NULL,
+     total_cost NUMERIC(30, 10) NOT NULL,
+     issued_at TIMESTAMPTZ NOT NULL,
+     UNIQUE (tenant, period_start),
+     CHECK (period_end > period_start)
+);
+
+CREATE TABLE invoice_lines (
+     id BIGSERIAL PRIMARY KEY,
+     invoice_id BIGINT NOT NULL REFERENCES invoices (id),
+     function VARCHAR(255) NOT NULL,
+     pod VARCHAR(255) NOT NULL,
+     pod_start TIMESTAMPTZ NOT NULL,
+     pod_end TIMESTAMPTZ NOT NULL,
+     dimension VARCHAR(20) NOT NULL,
+     tariff_id INT NOT NULL,
+     charge_from TIMESTAMPTZ NOT NULL,
+     charge_to TIMESTAMPTZ NOT NULL,
+     quantity NUMERIC(30, 10) NOT NULL,
+     unit VARCHAR(20) NOT NULL,
+     unit_price NUMERIC(30, 10) NOT NULL,
+     amount NUMERIC(30, 10) NOT NULL
+);
+
+CREATE INDEX invoice_lines_invoice_id_idx ON invoice_lines (invoice_id);
+
+CREATE OR REPLACE FUNCTION reject_issued_invoice_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'issued invoices are immutable (%)', TG_TABLE_NAME;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER invoices_immutable BEFORE UPDATE OR DELETE ON invoices
+    FOR EACH ROW EXECUTE FUNCTION reject_issued_invoice_change();
+
+CREATE TRIGGER invoice_lines_immutable BEFORE UPDATE OR DELETE ON invoice_lines
+    FOR EACH ROW EXECUTE FUNCTION reject_issued_invoice_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS invoice_lines_immutable ON invoice_lines;
+DROP TRIGGER IF EXISTS invoices_immutable ON invoices;
+DROP FUNCTION IF EXISTS reject_issued_invoice_change();
+DROP TABLE invoice_lines;
+DROP TABLE invoices;
+DROP TABLE invoice_counters;
+-- +goose StatementEnd
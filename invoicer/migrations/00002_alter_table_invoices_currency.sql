@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- invoices issued so far were all in US dollars, their amount due is the
+-- total rounded to cents
+ALTER TABLE invoices
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN amount_due NUMERIC(30, 10);
+
+ALTER TABLE invoices DISABLE TRIGGER invoices_immutable;
+UPDATE invoices SET amount_due = round(total_cost, 2);
+ALTER TABLE invoices ENABLE TRIGGER invoices_immutable;
+
+ALTER TABLE invoices
+    ALTER COLUMN currency DROP DEFAULT,
+    ALTER COLUMN amount_due SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE invoices
+    DROP COLUMN amount_due,
+    DROP COLUMN currency;
+-- +goose StatementEnd
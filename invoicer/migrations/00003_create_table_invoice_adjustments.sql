@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE invoices
+    ADD COLUMN adjustment_total NUMERIC(30, 10) NOT NULL DEFAULT 0;
+
+ALTER TABLE invoices
+    ALTER COLUMN adjustment_total DROP DEFAULT;
+
+-- free allowances, discounts and credits, one row per invoice line in the
+-- order they were applied
+CREATE TABLE invoice_adjustments (
+     id BIGSERIAL PRIMARY KEY,
+     invoice_id BIGINT NOT NULL REFERENCES invoices (id),
+     kind VARCHAR(20) NOT NULL,
+     source_id INT NOT NULL,
+     dimension VARCHAR(20) NOT NULL,
+     description VARCHAR(255) NOT NULL,
+     quantity NUMERIC(30, 10) NOT NULL,
+     unit VARCHAR(20) NOT NULL,
+     amount NUMERIC(30, 10) NOT NULL
+);
+
+CREATE INDEX invoice_adjustments_invoice_id_idx ON invoice_adjustments (invoice_id);
+
+CREATE TRIGGER invoice_adjustments_immutable BEFORE UPDATE OR DELETE ON invoice_adjustments
+    FOR EACH ROW EXECUTE FUNCTION reject_issued_invoice_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS invoice_adjustments_immutable ON invoice_adjustments;
+DROP TABLE invoice_adjustments;
+
+ALTER TABLE invoices
+    DROP COLUMN adjustment_total;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE invoices
+    ADD COLUMN requests NUMERIC(30, 10) NOT NULL DEFAULT 0,
+    ADD COLUMN request_cost NUMERIC(30, 10) NOT NULL DEFAULT 0;
+
+ALTER TABLE invoices
+    ALTER COLUMN requests DROP DEFAULT,
+    ALTER COLUMN request_cost DROP DEFAULT;
+
+-- a charge crossing a graduated tier bound is stored as one line per tier,
+-- tier is the index of the tier in the tariff's price of the dimension
+ALTER TABLE invoice_lines
+    ADD COLUMN tier SMALLINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE invoice_lines
+    DROP COLUMN tier;
+
+ALTER TABLE invoices
+    DROP COLUMN request_cost,
+    DROP COLUMN requests;
+-- +goose StatementEnd
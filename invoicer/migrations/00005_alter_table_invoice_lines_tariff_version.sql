@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the version of the tariff's prices a line is priced with. Lines issued
+-- before price_service kept versions were priced with the only version there
+-- was, which became version 1.
+ALTER TABLE invoice_lines
+    ADD COLUMN tariff_version INT NOT NULL DEFAULT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE invoice_lines
+    DROP COLUMN tariff_version;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- one monthly budget per tenant, thresholds are percents of amount
+CREATE TABLE budgets (
+     id SERIAL PRIMARY KEY,
+     tenant VARCHAR(255) NOT NULL UNIQUE,
+     amount NUMERIC(20, 10) NOT NULL CHECK (amount > 0),
+     currency CHAR(3) NOT NULL,
+     thresholds INT[] NOT NULL DEFAULT '{50,80,100}',
+     hard_cap BOOLEAN NOT NULL DEFAULT FALSE,
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- alerts sent for a budget, at most one per threshold and kind in a month.
+-- A row is taken before the alert goes out, so invoicer replicas never send
+-- the same alert twice.
+CREATE TABLE budget_alerts (
+     budget_id INT NOT NULL REFERENCES budgets (id) ON DELETE CASCADE,
+     period_start TIMESTAMPTZ NOT NULL,
+     kind VARCHAR(20) NOT NULL,
+     threshold INT NOT NULL,
+     spend NUMERIC(30, 10) NOT NULL,
+     amount NUMERIC(20, 10) NOT NULL,
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     PRIMARY KEY (budget_id, period_start, kind, threshold)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE budget_alerts;
+DROP TABLE budgets;
+-- +goose StatementEnd
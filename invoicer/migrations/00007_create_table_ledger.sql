@@ -0,0 +1,93 @@
+-- +goose Up
+-- +goose StatementBegin
+-- prepaid tenants. The row is locked while a transaction is posted, so the
+-- postings of a tenant are serialized. accrued is the usage already debited
+-- but not invoiced yet.
+CREATE TABLE wallets (
+     tenant VARCHAR(255) PRIMARY KEY,
+     currency CHAR(3) NOT NULL,
+     accrued NUMERIC(30, 10) NOT NULL DEFAULT 0,
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- every wallet has its own set of accounts: wallet holds the tenant's money,
+-- funding is what came in and went back out, revenue is usage, adjustments
+-- are manual corrections. Balances always sum to zero.
+CREATE TABLE ledger_accounts (
+     id SERIAL PRIMARY KEY,
+     tenant VARCHAR(255) NOT NULL REFERENCES wallets (tenant),
+     kind VARCHAR(20) NOT NULL,
+     balance NUMERIC(30, 10) NOT NULL DEFAULT 0,
+     UNIQUE (tenant, kind)
+);
+
+CREATE TABLE ledger_transactions (
+     id BIGSERIAL PRIMARY KEY,
+     tenant VARCHAR(255) NOT NULL REFERENCES wallets (tenant),
+     kind VARCHAR(20) NOT NULL,
+     idempotency_key VARCHAR(255) NOT NULL,
+     -- set for the debit of an issued invoice, at most one per invoice
+     invoice_number BIGINT UNIQUE REFERENCES invoices (number),
+     description VARCHAR(255) NOT NULL,
+     -- change of the wallet balance and of wallets.accrued
+     amount NUMERIC(30, 10) NOT NULL,
+     accrued NUMERIC(30, 10) NOT NULL,
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     UNIQUE (tenant, idempotency_key)
+);
+
+CREATE INDEX ledger_transactions_tenant_id_idx ON ledger_transactions (tenant, id);
+
+-- balance is the balance of the account after the entry
+CREATE TABLE ledger_entries (
+     id BIGSERIAL PRIMARY KEY,
+     transaction_id BIGINT NOT NULL REFERENCES ledger_transactions (id),
+     account_id INT NOT NULL REFERENCES ledger_accounts (id),
+     amount NUMERIC(30, 10) NOT NULL,
+     balance NUMERIC(30, 10) NOT NULL
+);
+
+CREATE INDEX ledger_entries_transaction_id_idx ON ledger_entries (transaction_id);
+
+CREATE OR REPLACE FUNCTION check_ledger_transaction_balanced()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+-- checked at commit, when every entry of the transaction is in
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced AFTER INSERT ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_transaction_balanced();
+
+CREATE OR REPLACE FUNCTION reject_ledger_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only (%), post a correcting transaction instead', TG_TABLE_NAME;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER ledger_transactions_immutable BEFORE UPDATE OR DELETE ON ledger_transactions
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_change();
+
+CREATE TRIGGER ledger_entries_immutable BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS ledger_entries_immutable ON ledger_entries;
+DROP TRIGGER IF EXISTS ledger_transactions_immutable ON ledger_transactions;
+DROP TRIGGER IF EXISTS ledger_entries_balanced ON ledger_entries;
+DROP FUNCTION IF EXISTS reject_ledger_change();
+DROP FUNCTION IF EXISTS check_ledger_transaction_balanced();
+DROP TABLE ledger_entries;
+DROP TABLE ledger_transactions;
+DROP TABLE ledger_accounts;
+DROP TABLE wallets;
+-- +goose StatementEnd
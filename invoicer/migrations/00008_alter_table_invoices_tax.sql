@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- tax_rate is a percent, tax is charged on the amount after adjustments.
+-- Invoices issued before had no tax.
+ALTER TABLE invoices
+    ADD COLUMN tax_rate NUMERIC(7, 4) NOT NULL DEFAULT 0,
+    ADD COLUMN tax NUMERIC(30, 10) NOT NULL DEFAULT 0;
+
+ALTER TABLE invoices
+    ALTER COLUMN tax_rate DROP DEFAULT,
+    ALTER COLUMN tax DROP DEFAULT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE invoices
+    DROP COLUMN tax,
+    DROP COLUMN tax_rate;
+-- +goose StatementEnd
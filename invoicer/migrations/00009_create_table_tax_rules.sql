@@ -0,0 +1,62 @@
+-- +goose Up
+-- +goose StatementBegin
+-- legal details of a tenant, country picks the tax rules of its invoices
+CREATE TABLE billing_profiles (
+     tenant VARCHAR(255) PRIMARY KEY,
+     legal_name VARCHAR(255) NOT NULL,
+     address TEXT NOT NULL,
+     country CHAR(2) NOT NULL,
+     tax_id VARCHAR(50) NOT NULL DEFAULT '',
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- every rule of the tenant's country becomes a tax line. rounding is empty
+-- to round like the billing policy.
+CREATE TABLE tax_rules (
+     id SERIAL PRIMARY KEY,
+     country CHAR(2) NOT NULL,
+     name VARCHAR(50) NOT NULL,
+     rate NUMERIC(7, 4) NOT NULL CHECK (rate >= 0 AND rate <= 100),
+     reverse_charge BOOLEAN NOT NULL DEFAULT FALSE,
+     rounding VARCHAR(20) NOT NULL DEFAULT '',
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     UNIQUE (country, name)
+);
+
+-- the billing profile the invoice was issued to
+ALTER TABLE invoices
+    ADD COLUMN customer JSONB;
+
+-- tax lines of an invoice in the order they were applied, rule_id is 0 for
+-- the default tax
+CREATE TABLE invoice_taxes (
+     id BIGSERIAL PRIMARY KEY,
+     invoice_id BIGINT NOT NULL REFERENCES invoices (id),
+     rule_id INT NOT NULL,
+     name VARCHAR(50) NOT NULL,
+     country VARCHAR(2) NOT NULL,
+     rate NUMERIC(7, 4) NOT NULL,
+     base NUMERIC(30, 10) NOT NULL,
+     amount NUMERIC(30, 10) NOT NULL,
+     reverse_charge BOOLEAN NOT NULL
+);
+
+CREATE INDEX invoice_taxes_invoice_id_idx ON invoice_taxes (invoice_id);
+
+CREATE TRIGGER invoice_taxes_immutable BEFORE UPDATE OR DELETE ON invoice_taxes
+    FOR EACH ROW EXECUTE FUNCTION reject_issued_invoice_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS invoice_taxes_immutable ON invoice_taxes;
+DROP TABLE invoice_taxes;
+
+ALTER TABLE invoices
+    DROP COLUMN customer;
+
+DROP TABLE tax_rules;
+DROP TABLE billing_profiles;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+-- what one unit of base is worth in currency from date until the next rate
+CREATE TABLE fx_rates (
+     base CHAR(3) NOT NULL,
+     currency CHAR(3) NOT NULL,
+     date DATE NOT NULL,
+     rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     PRIMARY KEY (base, currency, date)
+);
+
+-- empty to invoice the tenant in the billing currency
+ALTER TABLE billing_profiles
+    ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT '';
+
+-- the rate the invoice was converted with
+ALTER TABLE invoices
+    ADD COLUMN fx JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE invoices
+    DROP COLUMN fx;
+
+ALTER TABLE billing_profiles
+    DROP COLUMN currency;
+
+DROP TABLE fx_rates;
+-- +goose StatementEnd
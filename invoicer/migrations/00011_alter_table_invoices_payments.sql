@@ -0,0 +1,74 @@
+-- +goose Up
+-- +goose StatementBegin
+-- invoices issued before payment terms existed have no due date and are never
+-- overdue
+ALTER TABLE invoices
+    ADD COLUMN due_at TIMESTAMPTZ,
+    ADD COLUMN paid_at TIMESTAMPTZ,
+    ADD COLUMN voided_at TIMESTAMPTZ,
+    ADD COLUMN dunning_level INT NOT NULL DEFAULT 0,
+    ADD COLUMN dunned_at TIMESTAMPTZ,
+    ADD CONSTRAINT invoices_status_check
+        CHECK (status IN ('issued', 'paid', 'overdue', 'void'));
+
+CREATE INDEX invoices_open_due_at_idx ON invoices (due_at)
+    WHERE status IN ('issued', 'overdue');
+
+-- everything but the lifecycle of an issued invoice stays immutable
+CREATE OR REPLACE FUNCTION guard_invoice_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    lifecycle TEXT[] := ARRAY['status', 'paid_at', 'voided_at', 'dunning_level', 'dunned_at'];
+BEGIN
+    IF TG_OP = 'UPDATE' AND (to_jsonb(NEW) - lifecycle) = (to_jsonb(OLD) - lifecycle) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'issued invoices are immutable (%)', TG_TABLE_NAME;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER invoices_immutable ON invoices;
+
+CREATE TRIGGER invoices_immutable BEFORE UPDATE OR DELETE ON invoices
+    FOR EACH ROW EXECUTE FUNCTION guard_invoice_change();
+
+CREATE TABLE invoice_payments (
+     id BIGSERIAL PRIMARY KEY,
+     invoice_id BIGINT NOT NULL REFERENCES invoices (id),
+     provider VARCHAR(50) NOT NULL,
+     -- the provider's id of the payment, webhooks may be delivered twice
+     external_id VARCHAR(255) NOT NULL,
+     status VARCHAR(20) NOT NULL,
+     amount NUMERIC(30, 10) NOT NULL,
+     currency VARCHAR(3) NOT NULL,
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     UNIQUE (provider, external_id)
+);
+
+CREATE INDEX invoice_payments_invoice_id_idx ON invoice_payments (invoice_id);
+
+CREATE TRIGGER invoice_payments_immutable BEFORE UPDATE OR DELETE ON invoice_payments
+    FOR EACH ROW EXECUTE FUNCTION reject_issued_invoice_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE invoice_payments;
+
+DROP TRIGGER invoices_immutable ON invoices;
+
+CREATE TRIGGER invoices_immutable BEFORE UPDATE OR DELETE ON invoices
+    FOR EACH ROW EXECUTE FUNCTION reject_issued_invoice_change();
+
+DROP FUNCTION IF EXISTS guard_invoice_change();
+
+DROP INDEX IF EXISTS invoices_open_due_at_idx;
+
+ALTER TABLE invoices
+    DROP CONSTRAINT invoices_status_check,
+    DROP COLUMN dunned_at,
+    DROP COLUMN dunning_level,
+    DROP COLUMN voided_at,
+    DROP COLUMN paid_at,
+    DROP COLUMN due_at;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- pod actions already billed. An action is recorded in the same transaction
+-- as the messages it produced, so a redelivered action produces nothing.
+CREATE TABLE processed_actions (
+     id VARCHAR(512) PRIMARY KEY,
+     tenant VARCHAR(255) NOT NULL,
+     pod VARCHAR(255) NOT NULL,
+     replica VARCHAR(255) NOT NULL,
+     action VARCHAR(20) NOT NULL,
+     total_cost NUMERIC(30, 10) NOT NULL,
+     currency VARCHAR(3) NOT NULL,
+     processed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- kafka messages waiting to be published by the relay, deleted once they are
+CREATE TABLE outbox (
+     id BIGSERIAL PRIMARY KEY,
+     topic VARCHAR(255) NOT NULL,
+     key VARCHAR(255) NOT NULL,
+     payload JSONB NOT NULL,
+     attempts INT NOT NULL DEFAULT 0,
+     last_error TEXT NOT NULL DEFAULT '',
+     next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX outbox_next_attempt_at_idx ON outbox (next_attempt_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE outbox;
+DROP TABLE processed_actions;
+-- +goose StatementEnd
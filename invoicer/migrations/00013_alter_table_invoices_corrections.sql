@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- recalculation issues credit notes and corrected invoices for the period of
+-- an issued invoice. A period has a single invoice that is not void, the
+-- corrected one voids the invoice it replaces.
+ALTER TABLE invoices
+    ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'invoice',
+    ADD COLUMN corrects BIGINT REFERENCES invoices (number),
+    ADD CONSTRAINT invoices_kind_check
+        CHECK (kind IN ('invoice', 'credit_note')),
+    ADD CONSTRAINT invoices_credit_note_corrects_check
+        CHECK (kind <> 'credit_note' OR corrects IS NOT NULL);
+
+ALTER TABLE invoices
+    ALTER COLUMN kind DROP DEFAULT,
+    DROP CONSTRAINT invoices_tenant_period_start_key;
+
+CREATE UNIQUE INDEX invoices_tenant_period_start_key ON invoices (tenant, period_start)
+    WHERE kind = 'invoice' AND status <> 'void';
+
+CREATE INDEX invoices_corrects_idx ON invoices (corrects)
+    WHERE corrects IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS invoices_corrects_idx;
+
+DROP INDEX IF EXISTS invoices_tenant_period_start_key;
+
+ALTER TABLE invoices
+    ADD CONSTRAINT invoices_tenant_period_start_key UNIQUE (tenant, period_start),
+    DROP CONSTRAINT invoices_credit_note_corrects_check,
+    DROP CONSTRAINT invoices_kind_check,
+    DROP COLUMN corrects,
+    DROP COLUMN kind;
+-- +goose StatementEnd
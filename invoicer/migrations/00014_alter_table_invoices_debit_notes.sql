@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- recalculation settles an undercharged invoice that is paid or has notes
+-- with a debit note, paid like an invoice
+ALTER TABLE invoices
+    DROP CONSTRAINT invoices_kind_check,
+    DROP CONSTRAINT invoices_credit_note_corrects_check,
+    ADD CONSTRAINT invoices_kind_check
+        CHECK (kind IN ('invoice', 'credit_note', 'debit_note')),
+    ADD CONSTRAINT invoices_note_corrects_check
+        CHECK (kind = 'invoice' OR corrects IS NOT NULL);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE invoices
+    DROP CONSTRAINT invoices_note_corrects_check,
+    DROP CONSTRAINT invoices_kind_check,
+    ADD CONSTRAINT invoices_kind_check
+        CHECK (kind IN ('invoice', 'credit_note')),
+    ADD CONSTRAINT invoices_credit_note_corrects_check
+        CHECK (kind <> 'credit_note' OR corrects IS NOT NULL);
+-- +goose StatementEnd
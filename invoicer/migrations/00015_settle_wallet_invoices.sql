@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- open invoices the ledger debited before debits settled them are paid from
+-- the wallet, the payment is keyed like the debit
+INSERT INTO invoice_payments (invoice_id, provider, external_id, status, amount, currency)
+SELECT i.id, 'wallet', t.idempotency_key, 'succeeded', i.amount_due - COALESCE(p.paid, 0), i.currency
+FROM invoices i
+JOIN ledger_transactions t ON t.invoice_number = i.number
+LEFT JOIN (
+    SELECT invoice_id, sum(amount) AS paid
+    FROM invoice_payments
+    WHERE status = 'succeeded'
+    GROUP BY invoice_id
+) p ON p.invoice_id = i.id
+WHERE i.status IN ('issued', 'overdue')
+  AND i.amount_due > COALESCE(p.paid, 0);
+
+UPDATE invoices i
+SET status = 'paid', paid_at = CURRENT_TIMESTAMP
+WHERE i.status IN ('issued', 'overdue')
+  AND i.amount_due > 0
+  AND EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.invoice_number = i.number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- payments are immutable, the wallet payments stay
+SELECT 1;
+-- +goose StatementEnd
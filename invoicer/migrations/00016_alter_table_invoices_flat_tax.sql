@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the flat tax is gone, every tax line comes from a tax rule. Invoices issued
+-- with the flat tax keep their lines with rule_id 0. tax_rate and tax stay,
+-- they sum the rates and amounts of the tax lines.
+ALTER TABLE invoice_taxes
+    ADD CONSTRAINT invoice_taxes_rule_id_check CHECK (rule_id > 0) NOT VALID;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE invoice_taxes
+    DROP CONSTRAINT invoice_taxes_rule_id_check;
+-- +goose StatementEnd
@@ -2,92 +2,146 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/usamaroman/faas_demo/meter/internal/config"
+	"github.com/usamaroman/faas_demo/meter/internal/meter"
 	"github.com/usamaroman/faas_demo/pkg/kafka"
 	"github.com/usamaroman/faas_demo/pkg/logger"
-	"github.com/usamaroman/faas_demo/pkg/types"
-
-	kafkago "github.com/segmentio/kafka-go"
+	"github.com/usamaroman/faas_demo/pkg/observability"
 )
 
 func main() {
 	logger.NewLogger()
 	slog.Info("Meter")
 
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%s", os.Getenv("UDP_PORT")))
-	if err != nil {
-		slog.Error("failed to resolve udp address", slog.String("error", err.Error()))
+	cfg := config.Load()
+	if len(cfg.Kafka.Brokers) == 0 {
+		slog.Error("provide KAFKA_ADDRS env var")
 		os.Exit(1)
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%s", cfg.UDP.Port))
 	if err != nil {
 		slog.Error("failed to listen udp", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
-	defer func() {
-		if err = conn.Close(); err != nil {
-			slog.Error("failed to close udp connection", slog.String("error", err.Error()))
+
+	metricsProducer := kafka.NewProducer(kafka.ProducerConfig{
+		Topic:        cfg.Kafka.MetricsTopic,
+		Addrs:        cfg.Kafka.Brokers,
+		BatchSize:    cfg.Pipeline.BatchSize,
+		BatchTimeout: cfg.Pipeline.BatchTimeout,
+	})
+	actionsProducer := kafka.NewProducer(kafka.ProducerConfig{
+		Topic:        cfg.Kafka.ActionsTopic,
+		Addrs:        cfg.Kafka.Brokers,
+		BatchSize:    cfg.Pipeline.BatchSize,
+		BatchTimeout: cfg.Pipeline.BatchTimeout,
+	})
+
+	deadLetterProducer := kafka.NewProducer(kafka.ProducerConfig{
+		Topic:        cfg.Kafka.DeadLetterTopic,
+		Addrs:        cfg.Kafka.Brokers,
+		BatchSize:    cfg.Pipeline.BatchSize,
+		BatchTimeout: cfg.Pipeline.BatchTimeout,
+	})
+
+	validator := meter.NewValidator(meter.ValidatorConfig{
+		Tenants:       meter.NewStaticTenants(cfg.Validation.Tenants),
+		MaxMemMB:      cfg.Validation.MaxMemMB,
+		MaxCPUPercent: cfg.Validation.MaxCPUPercent,
+		MaxClockSkew:  cfg.Validation.MaxClockSkew,
+		MaxEventAge:   cfg.Validation.MaxEventAge,
+	})
+
+	server := meter.New(meter.Config{
+		Workers:      cfg.Pipeline.Workers,
+		QueueSize:    cfg.Pipeline.QueueSize,
+		BatchSize:    cfg.Pipeline.BatchSize,
+		BatchTimeout: cfg.Pipeline.BatchTimeout,
+		Validator:    validator,
+	}, meter.Sinks{
+		Metrics:    metricsProducer,
+		Actions:    actionsProducer,
+		DeadLetter: deadLetterProducer,
+	})
+	server.Start()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(cfg.Pipeline.StatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				server.Stats().Log("meter stats")
+			}
 		}
 	}()
 
-	addresses, ok := os.LookupEnv("KAFKA_ADDRS")
-	if !ok {
-		slog.Error("provide KAFKA_ADDRS env var")
-		os.Exit(1)
-	}
+	observability.Register(observability.NewSnapshotCollector(
+		"meter_events_total",
+		"Meter pipeline counters: received, dropped, written and rejected payloads.",
+		"counter",
+		server.Stats().Snapshot,
+	))
 
-	metricsTopic := os.Getenv("FUNCTION_METRICS_TOPIC")
-	if metricsTopic == "" {
-		metricsTopic = "function_metrics"
-	}
-	actionsTopic := os.Getenv("FUNCTION_ACTIONS_TOPIC")
-	if actionsTopic == "" {
-		actionsTopic = "function_actions"
+	health := observability.NewHealth()
+	health.Add("kafka", observability.TCPCheck(cfg.Kafka.Brokers...))
+
+	mux := http.NewServeMux()
+	health.Register(mux)
+	meter.NewHTTPHandler(server, meter.HTTPConfig{
+		Tokens:       cfg.HTTP.Tokens,
+		MaxBodyBytes: cfg.HTTP.MaxBodyBytes,
+	}).Register(mux)
+
+	httpServer := &http.Server{
+		Addr:    cfg.HTTP.Addr,
+		Handler: observability.Middleware(mux),
 	}
+	go func() {
+		slog.Info("listening http", slog.String("addr", cfg.HTTP.Addr), slog.Int("tenants", len(cfg.HTTP.Tokens)))
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("http server error", slog.String("error", err.Error()))
+			cancel()
+		}
+	}()
 
-	brokers := strings.Split(addresses, ",")
+	slog.Info("listening udp",
+		slog.String("port", cfg.UDP.Port),
+		slog.Int("workers", cfg.Pipeline.Workers),
+		slog.Int("queue_size", cfg.Pipeline.QueueSize),
+		slog.Int("batch_size", cfg.Pipeline.BatchSize))
 
-	metricsProducer := kafka.NewProducer(kafka.ProducerConfig{Topic: metricsTopic, Addrs: brokers})
-	actionsProducer := kafka.NewProducer(kafka.ProducerConfig{Topic: actionsTopic, Addrs: brokers})
-	ctx := context.Background()
+	if err := server.ServeUDP(ctx, conn); err != nil {
+		slog.Error("udp server error", slog.String("error", err.Error()))
+	}
 
-	buf := make([]byte, 1024)
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			slog.Error("failed to read data", slog.String("error", err.Error()))
-			continue
-		}
+	slog.Info("shutting down, flushing pending batches")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Pipeline.ShutdownTimeout)
+	defer shutdownCancel()
 
-		var env types.Envelope
-		if err := json.Unmarshal(buf[:n], &env); err != nil {
-			slog.Error("failed to unmarshal envelope", slog.String("error", err.Error()))
-			continue
-		}
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shutdown http server", slog.String("error", err.Error()))
+	}
 
-		switch env.Type {
-		case "metadata":
-			slog.Debug("routing to function_metrics", slog.Int("bytes", len(env.Payload)))
-			if err := metricsProducer.WriteMessages(ctx, kafkago.Message{Value: env.Payload}); err != nil {
-				slog.Error("failed to write metadata to kafka", slog.String("error", err.Error()))
-				continue
-			}
-		case "action":
-			slog.Debug("routing to function_actions", slog.Int("bytes", len(env.Payload)))
-			if err := actionsProducer.WriteMessages(ctx, kafkago.Message{Value: env.Payload}); err != nil {
-				slog.Error("failed to write action to kafka", slog.String("error", err.Error()))
-				continue
-			}
-		default:
-			slog.Error("unknown envelope type", slog.String("type", env.Type))
-			continue
-		}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shutdown meter", slog.String("error", err.Error()))
 	}
+
+	server.Stats().Log("meter stopped")
 }
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type UDPConfig struct {
+	Port string
+}
+
+type HTTPConfig struct {
+	Addr string
+	// Tokens maps bearer tokens to tenants for the HTTP ingestion endpoints.
+	Tokens       map[string]string
+	MaxBodyBytes int64
+}
+
+type KafkaConfig struct {
+	Brokers         []string
+	MetricsTopic    string
+	ActionsTopic    string
+	DeadLetterTopic string
+}
+
+type ValidationConfig struct {
+	// Tenants is the allow-list of tenants, empty accepts any tenant.
+	Tenants       []string
+	MaxMemMB      float64
+	MaxCPUPercent float64
+	MaxClockSkew  time.Duration
+	MaxEventAge   time.Duration
+}
+
+type PipelineConfig struct {
+	Workers      int
+	QueueSize    int
+	BatchSize    int
+	BatchTimeout time.Duration
+	// ShutdownTimeout bounds how long pending batches are flushed on exit.
+	ShutdownTimeout time.Duration
+	StatsInterval   time.Duration
+}
+
+type Config struct {
+	UDP        UDPConfig
+	HTTP       HTTPConfig
+	Kafka      KafkaConfig
+	Pipeline   PipelineConfig
+	Validation ValidationConfig
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+func getEnvFloat(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			return f
+		}
+	}
+	return def
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
+func Load() Config {
+	return Config{
+		UDP: UDPConfig{
+			Port: getEnv("UDP_PORT", "5461"),
+		},
+		HTTP: HTTPConfig{
+			Addr:         getEnv("HTTP_ADDR", ":8086"),
+			Tokens:       parseTokens(os.Getenv("METER_TENANT_TOKENS")),
+			MaxBodyBytes: int64(getEnvInt("METER_MAX_BODY_BYTES", 5<<20)),
+		},
+		Kafka: KafkaConfig{
+			Brokers:         splitAndTrim(os.Getenv("KAFKA_ADDRS")),
+			MetricsTopic:    getEnv("FUNCTION_METRICS_TOPIC", "function_metrics"),
+			ActionsTopic:    getEnv("FUNCTION_ACTIONS_TOPIC", "function_actions"),
+			DeadLetterTopic: getEnv("FUNCTION_METRICS_DLQ_TOPIC", "function_metrics_dlq"),
+		},
+		Pipeline: PipelineConfig{
+			Workers:         getEnvInt("METER_WORKERS", runtime.NumCPU()),
+			QueueSize:       getEnvInt("METER_QUEUE_SIZE", 10000),
+			BatchSize:       getEnvInt("METER_BATCH_SIZE", 500),
+			BatchTimeout:    getEnvDuration("METER_BATCH_TIMEOUT", 50*time.Millisecond),
+			ShutdownTimeout: getEnvDuration("METER_SHUTDOWN_TIMEOUT", 10*time.Second),
+			StatsInterval:   getEnvDuration("METER_STATS_INTERVAL", time.Minute),
+		},
+		Validation: ValidationConfig{
+			Tenants:       splitAndTrim(os.Getenv("METER_TENANTS")),
+			MaxMemMB:      getEnvFloat("METER_MAX_MEM_MB", 1024*1024),
+			MaxCPUPercent: getEnvFloat("METER_MAX_CPU_PERCENT", 100*256),
+			MaxClockSkew:  getEnvDuration("METER_MAX_CLOCK_SKEW", 5*time.Minute),
+			MaxEventAge:   getEnvDuration("METER_MAX_EVENT_AGE", 7*24*time.Hour),
+		},
+	}
+}
+
+func splitAndTrim(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		t := strings.TrimSpace(p)
+		if t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// parseTokens reads "tenant=token" pairs separated by commas.
+func parseTokens(s string) map[string]string {
+	out := make(map[string]string)
+	for _, pair := range splitAndTrim(s) {
+		tenant, token, ok := strings.Cut(pair, "=")
+		if !ok || tenant == "" || token == "" {
+			continue
+		}
+		out[token] = tenant
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package meter
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// batcher accumulates messages for a single sink and writes them in batches
+// of up to size messages, or whatever is pending after timeout elapses.
+type batcher struct {
+	route   string
+	sink    Sink
+	queue   chan kafka.Message
+	size    int
+	timeout time.Duration
+	stats   *Stats
+	// used counts the queue slots taken, messages are only sent into a slot
+	// reserved before, so a send never blocks
+	used atomic.Int64
+}
+
+func newBatcher(route string, sink Sink, queueSize, size int, timeout time.Duration, stats *Stats) *batcher {
+	return &batcher{
+		route:   route,
+		sink:    sink,
+		queue:   make(chan kafka.Message, queueSize),
+		size:    size,
+		timeout: timeout,
+		stats:   stats,
+	}
+}
+
+// enqueue never blocks, a full queue drops the message and counts it.
+func (b *batcher) enqueue(msg kafka.Message) bool {
+	return b.enqueueAll([]kafka.Message{msg})
+}
+
+// enqueueAll queues every message or, when the queue has no room for all of
+// them, none and counts them dropped.
+func (b *batcher) enqueueAll(msgs []kafka.Message) bool {
+	if !b.reserve(len(msgs)) {
+		b.stats.Add(routeCounter(b.route, counterRouteDropped), uint64(len(msgs)))
+		return false
+	}
+
+	for _, msg := range msgs {
+		b.queue <- msg
+	}
+	return true
+}
+
+func (b *batcher) reserve(n int) bool {
+	for {
+		used := b.used.Load()
+		if used+int64(n) > int64(cap(b.queue)) {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+int64(n)) {
+			return true
+		}
+	}
+}
+
+// run drains the queue until it is closed, flushing what is left before
+// returning. Cancelling ctx aborts the write in flight.
+func (b *batcher) run(ctx context.Context) {
+	batch := make([]kafka.Message, 0, b.size)
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg, ok := <-b.queue:
+			if !ok {
+				b.flush(ctx, batch)
+				return
+			}
+			b.used.Add(-1)
+
+			batch = append(batch, msg)
+			if len(batch) >= b.size {
+				b.flush(ctx, batch)
+				batch = batch[:0]
+				resetTimer(timer, b.timeout)
+			}
+		case <-timer.C:
+			if len(batch) > 0 {
+				b.flush(ctx, batch)
+				batch = batch[:0]
+			}
+			timer.Reset(b.timeout)
+		}
+	}
+}
+
+func (b *batcher) flush(ctx context.Context, batch []kafka.Message) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := b.sink.WriteMessages(ctx, batch...); err != nil {
+		slog.Error("failed to write batch",
+			slog.String("route", b.route),
+			slog.Int("size", len(batch)),
+			slog.String("error", err.Error()))
+		b.stats.Add(routeCounter(b.route, counterRouteWriteErrors), uint64(len(batch)))
+		return
+	}
+
+	b.stats.Add(routeCounter(b.route, counterRouteWritten), uint64(len(batch)))
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
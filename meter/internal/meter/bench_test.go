@@ -0,0 +1,76 @@
+package meter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// loadTraffic reads recorded agent envelopes, one JSON document per line.
+func loadTraffic(tb testing.TB) [][]byte {
+	tb.Helper()
+
+	data, err := os.ReadFile("testdata/traffic.jsonl")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var lines [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			lines = append(lines, bytes.Clone(line))
+		}
+	}
+
+	return lines
+}
+
+// BenchmarkServerReplay replays testdata/traffic.jsonl through the full
+// pipeline (queue, workers, validation, batchers) into discarding in-memory sinks.
+// Run with: go test -bench=Replay -benchmem ./internal/meter
+func BenchmarkServerReplay(b *testing.B) {
+	traffic := loadTraffic(b)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			s := New(Config{
+				Workers:      workers,
+				QueueSize:    len(traffic) * 64,
+				BatchSize:    500,
+				BatchTimeout: 10 * time.Millisecond,
+				Validator:    trafficValidator(),
+			}, Sinks{
+				Metrics:    NewDiscardSink(),
+				Actions:    NewDiscardSink(),
+				DeadLetter: NewDiscardSink(),
+			})
+			s.Start()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				packet := traffic[i%len(traffic)]
+				for !s.Enqueue(packet) {
+					// the queue is full, back off and retry so every packet is delivered
+					time.Sleep(time.Microsecond)
+				}
+			}
+			if err := s.Shutdown(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+
+			stats := s.Stats()
+			written := stats.Get("metadata.written") + stats.Get("action.written")
+			b.ReportMetric(float64(stats.Get(CounterDropped))/float64(b.N), "retries/op")
+			if written != uint64(b.N) {
+				b.Fatalf("written %d messages, want %d", written, b.N)
+			}
+		})
+	}
+}
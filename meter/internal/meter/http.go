@@ -0,0 +1,223 @@
+package meter
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const defaultMaxBodyBytes = 5 << 20
+
+type HTTPConfig struct {
+	// Tokens maps a bearer token to the tenant it authenticates.
+	Tokens       map[string]string
+	MaxBodyBytes int64
+}
+
+// HTTPHandler accepts usage from producers that cannot run the meter_agent
+// sidecar. Payloads use the same types.Metric and types.Action schema as the
+// UDP path and go through the same validation and batchers. There is no
+// idempotency key: a batch resent after its 202 was lost is counted twice.
+type HTTPHandler struct {
+	server  *Server
+	tokens  map[[sha256.Size]byte]string
+	maxBody int64
+}
+
+func NewHTTPHandler(server *Server, cfg HTTPConfig) *HTTPHandler {
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = defaultMaxBodyBytes
+	}
+
+	// tokens are kept hashed so the lookup does not leak them through timing
+	tokens := make(map[[sha256.Size]byte]string, len(cfg.Tokens))
+	for token, tenant := range cfg.Tokens {
+		tokens[sha256.Sum256([]byte(token))] = tenant
+	}
+
+	return &HTTPHandler{
+		server:  server,
+		tokens:  tokens,
+		maxBody: cfg.MaxBodyBytes,
+	}
+}
+
+func (h *HTTPHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/metrics", h.handle(TypeMetadata))
+	mux.HandleFunc("/v1/actions", h.handle(TypeAction))
+}
+
+type ItemError struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type IngestResponse struct {
+	Accepted int         `json:"accepted"`
+	Rejected []ItemError `json:"rejected,omitempty"`
+}
+
+func (h *HTTPHandler) handle(envType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		tenant, ok := h.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="meter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		items, err := decodeItems(http.MaxBytesReader(w, r.Body, h.maxBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(items) == 0 {
+			http.Error(w, "empty body", http.StatusBadRequest)
+			return
+		}
+
+		payloads := make([][]byte, len(items))
+		for i, item := range items {
+			payloads[i] = item
+		}
+
+		var resp IngestResponse
+		rejections, err := h.server.IngestBatch(envType, tenant, payloads)
+		for i, rej := range rejections {
+			if rej != nil {
+				resp.Rejected = append(resp.Rejected, ItemError{Index: i, Reason: rej.Reason, Detail: rej.Detail})
+			}
+		}
+
+		switch {
+		case errors.Is(err, ErrBatchTooLarge):
+			http.Error(w, "too many items in one request, split the batch", http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			// nothing of the batch was queued, the client retries all of it
+			// once the queue drains
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		resp.Accepted = len(payloads) - len(resp.Rejected)
+		status := http.StatusAccepted
+		if resp.Accepted == 0 {
+			status = http.StatusBadRequest
+		}
+
+		writeJSON(w, status, resp)
+	}
+}
+
+func (h *HTTPHandler) authenticate(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	tenant, ok := h.tokens[sha256.Sum256([]byte(token))]
+	return tenant, ok
+}
+
+// decodeItems accepts a single JSON object, a JSON array of objects, or
+// newline-delimited JSON objects.
+func decodeItems(body io.Reader) ([]json.RawMessage, error) {
+	br := bufio.NewReader(body)
+
+	first, err := peekNonSpace(br)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		var items []json.RawMessage
+		if err := dec.Decode(&items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	var items []json.RawMessage
+	for {
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			if errors.Is(err, io.EOF) {
+				return items, nil
+			}
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// withTenant fills in the authenticated tenant, or refuses items that claim
+// to belong to somebody else.
+func withTenant(item json.RawMessage, tenant string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(item, &fields); err != nil {
+		// leave it to the validator to report the malformed payload
+		return item, nil
+	}
+
+	if raw, ok := fields["tenant"]; ok {
+		var claimed string
+		if err := json.Unmarshal(raw, &claimed); err == nil && claimed != "" {
+			if claimed != tenant {
+				return nil, errors.New("tenant does not match the token")
+			}
+			return item, nil
+		}
+	}
+
+	encoded, err := json.Marshal(tenant)
+	if err != nil {
+		return nil, err
+	}
+	fields["tenant"] = encoded
+
+	return json.Marshal(fields)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", slog.String("error", err.Error()))
+	}
+}
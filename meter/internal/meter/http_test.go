@@ -0,0 +1,173 @@
+package meter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/pkg/types"
+)
+
+func Test_HTTPIngest(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		body     string
+		status   int
+		accepted int
+		reasons  []string
+	}{
+		{
+			name:     "single metric",
+			path:     "/v1/metrics",
+			token:    "alice-token",
+			body:     `{"pod":"batch-job","mem_mb":128,"timestamp":1760899990}`,
+			status:   http.StatusAccepted,
+			accepted: 1,
+		},
+		{
+			name:     "array",
+			path:     "/v1/metrics",
+			token:    "alice-token",
+			body:     `[{"pod":"a","timestamp":1760899990},{"pod":"b","tenant":"alice","timestamp":1760899991}]`,
+			status:   http.StatusAccepted,
+			accepted: 2,
+		},
+		{
+			name:     "ndjson with one invalid line",
+			path:     "/v1/metrics",
+			token:    "alice-token",
+			body:     "{\"pod\":\"a\",\"timestamp\":1760899990}\n{\"pod\":\"b\",\"mem_mb\":-3,\"timestamp\":1760899990}\n",
+			status:   http.StatusAccepted,
+			accepted: 1,
+			reasons:  []string{ReasonNegativeValue},
+		},
+		{
+			name:     "action",
+			path:     "/v1/actions",
+			token:    "alice-token",
+			body:     `{"pod":"a","action":"stop","timestamp":1760899990}`,
+			status:   http.StatusAccepted,
+			accepted: 1,
+		},
+		{
+			name:    "foreign tenant",
+			path:    "/v1/metrics",
+			token:   "alice-token",
+			body:    `{"pod":"a","tenant":"bob","timestamp":1760899990}`,
+			status:  http.StatusBadRequest,
+			reasons: []string{ReasonTenantMismatch},
+		},
+		{
+			name:     "foreign tenant among valid items",
+			path:     "/v1/metrics",
+			token:    "alice-token",
+			body:     `[{"pod":"a","timestamp":1760899990},{"pod":"b","tenant":"bob","timestamp":1760899990},{"pod":"c","mem_mb":-3,"timestamp":1760899990}]`,
+			status:   http.StatusAccepted,
+			accepted: 1,
+			reasons:  []string{ReasonTenantMismatch, ReasonNegativeValue},
+		},
+		{
+			name:   "bad token",
+			path:   "/v1/metrics",
+			token:  "nope",
+			body:   `{"pod":"a"}`,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "broken json",
+			path:   "/v1/metrics",
+			token:  "alice-token",
+			body:   `{"pod":`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, actions, dlq := NewMemorySink(), NewMemorySink(), NewMemorySink()
+			s := New(Config{Workers: 1, QueueSize: 10, BatchSize: 10, Validator: trafficValidator()}, Sinks{
+				Metrics:    metrics,
+				Actions:    actions,
+				DeadLetter: dlq,
+			})
+			s.Start()
+
+			mux := http.NewServeMux()
+			NewHTTPHandler(s, HTTPConfig{Tokens: map[string]string{"alice-token": "alice"}}).Register(mux)
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			require.NoError(t, s.Shutdown(context.Background()))
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if rec.Header().Get("Content-Type") != "application/json" {
+				return
+			}
+
+			var resp IngestResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, tt.accepted, resp.Accepted)
+
+			var reasons []string
+			for _, r := range resp.Rejected {
+				reasons = append(reasons, r.Reason)
+			}
+			assert.Equal(t, tt.reasons, reasons)
+			assert.Len(t, dlq.Messages(), len(tt.reasons))
+			assert.EqualValues(t, tt.accepted+len(tt.reasons), s.Stats().Get(CounterReceived))
+
+			written := append(metrics.Messages(), actions.Messages()...)
+			require.Len(t, written, tt.accepted)
+			for _, msg := range written {
+				var m types.Metric
+				require.NoError(t, json.Unmarshal(msg.Value, &m))
+				assert.Equal(t, "alice", m.Tenant)
+			}
+		})
+	}
+}
+
+func Test_HTTPIngestIsAllOrNothing(t *testing.T) {
+	metrics, dlq := NewMemorySink(), NewMemorySink()
+	s := New(Config{Workers: 1, QueueSize: 3, BatchSize: 10, Validator: trafficValidator()}, Sinks{
+		Metrics:    metrics,
+		Actions:    NewMemorySink(),
+		DeadLetter: dlq,
+	})
+
+	mux := http.NewServeMux()
+	NewHTTPHandler(s, HTTPConfig{Tokens: map[string]string{"alice-token": "alice"}}).Register(mux)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer alice-token")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	batch := `[{"pod":"a","timestamp":1760899990},{"pod":"b","timestamp":1760899990},{"pod":"c","mem_mb":-3,"timestamp":1760899990}]`
+
+	// batchers are not started, so the queue does not drain
+	assert.Equal(t, http.StatusAccepted, post(batch).Code)
+	for range 2 {
+		rec := post(batch)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "one slot is left for two items")
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(`[{"pod":"a"},{"pod":"b"},{"pod":"c"},{"pod":"d"}]`).Code)
+
+	s.Start()
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Len(t, metrics.Messages(), 2, "the refused batches left nothing behind")
+	assert.Len(t, dlq.Messages(), 1, "the invalid item is dead-lettered with the batch that was taken")
+	assert.EqualValues(t, 1, s.Stats().Get("rejected."+ReasonNegativeValue))
+}
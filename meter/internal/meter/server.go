@@ -0,0 +1,398 @@
+package meter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/usamaroman/faas_demo/pkg/types"
+)
+
+// Envelope types accepted from meter agents.
+const (
+	TypeMetadata = "metadata"
+	TypeAction   = "action"
+
+	routeDeadLetter = "dead_letter"
+)
+
+var (
+	ErrClosed    = errors.New("meter is shutting down")
+	ErrQueueFull = errors.New("queue is full")
+	// ErrBatchTooLarge is returned for batches that would not fit in the
+	// queue even when it is empty.
+	ErrBatchTooLarge = errors.New("batch is larger than the queue")
+)
+
+// maxDatagramSize is the largest payload a single UDP datagram can carry.
+const maxDatagramSize = 64 * 1024
+
+type Config struct {
+	Workers      int
+	QueueSize    int
+	BatchSize    int
+	BatchTimeout time.Duration
+	// Validator checks payloads before they are routed, nil accepts any
+	// tenant with the default limits.
+	Validator *Validator
+}
+
+type Sinks struct {
+	Metrics Sink
+	Actions Sink
+	// DeadLetter receives everything the validator rejected.
+	DeadLetter Sink
+}
+
+// DeadLetter is the message written to the dead-letter topic. Payload holds the
+// original bytes as text since they are not guaranteed to be valid JSON.
+type DeadLetter struct {
+	Reason     string `json:"reason"`
+	Detail     string `json:"detail"`
+	Type       string `json:"type,omitempty"`
+	Payload    string `json:"payload"`
+	ReceivedAt int64  `json:"received_at"`
+}
+
+// Server decodes envelopes received from meter agents and routes their payloads
+// to per-type sinks. Datagrams are handed to a pool of workers through a bounded
+// queue, and every sink is fed by its own batcher, so a slow Kafka broker never
+// blocks the socket reader: excess traffic is dropped and counted instead.
+type Server struct {
+	cfg   Config
+	stats *Stats
+
+	mu      sync.RWMutex
+	closed  bool
+	packets chan []byte
+
+	validator  *Validator
+	routes     map[string]*batcher
+	deadLetter *batcher
+	sinks      []Sink
+	workers    sync.WaitGroup
+	batchers   sync.WaitGroup
+	// flushCtx is passed to the sink writes, it is cancelled when Shutdown
+	// gives up so a hung write does not outlive it
+	flushCtx  context.Context
+	stopFlush context.CancelFunc
+}
+
+func New(cfg Config, sinks Sinks) *Server {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = 50 * time.Millisecond
+	}
+	if cfg.Validator == nil {
+		cfg.Validator = NewValidator(ValidatorConfig{})
+	}
+
+	s := &Server{
+		cfg:       cfg,
+		stats:     &Stats{},
+		packets:   make(chan []byte, cfg.QueueSize),
+		validator: cfg.Validator,
+	}
+	s.flushCtx, s.stopFlush = context.WithCancel(context.Background())
+
+	s.routes = map[string]*batcher{
+		TypeMetadata: newBatcher(TypeMetadata, sinks.Metrics, cfg.QueueSize, cfg.BatchSize, cfg.BatchTimeout, s.stats),
+		TypeAction:   newBatcher(TypeAction, sinks.Actions, cfg.QueueSize, cfg.BatchSize, cfg.BatchTimeout, s.stats),
+	}
+	s.deadLetter = newBatcher(routeDeadLetter, sinks.DeadLetter, cfg.QueueSize, cfg.BatchSize, cfg.BatchTimeout, s.stats)
+	s.sinks = []Sink{sinks.Metrics, sinks.Actions, sinks.DeadLetter}
+
+	return s
+}
+
+func (s *Server) Stats() *Stats {
+	return s.stats
+}
+
+func (s *Server) batcherList() []*batcher {
+	out := []*batcher{s.deadLetter}
+	for _, b := range s.routes {
+		out = append(out, b)
+	}
+	return out
+}
+
+// Start launches the batchers and the worker pool.
+func (s *Server) Start() {
+	for _, b := range s.batcherList() {
+		s.batchers.Add(1)
+		go func() {
+			defer s.batchers.Done()
+			b.run(s.flushCtx)
+		}()
+	}
+
+	for range s.cfg.Workers {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			for packet := range s.packets {
+				s.handle(packet)
+			}
+		}()
+	}
+}
+
+// Enqueue hands a raw envelope to the worker pool without blocking. It reports
+// false when the queue is full or the server is shutting down.
+func (s *Server) Enqueue(packet []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.stats.Inc(CounterReceived)
+	if s.closed {
+		s.stats.Inc(CounterDropped)
+		return false
+	}
+
+	select {
+	case s.packets <- packet:
+		return true
+	default:
+		s.stats.Inc(CounterDropped)
+		return false
+	}
+}
+
+// IngestBatch validates and routes payloads that tenant sent together outside
+// of the UDP path, e.g. over HTTP. Payloads without a tenant get it, those
+// claiming another one are rejected. Rejections are returned at their index.
+// The valid payloads are queued all or none: when the queue has no room for
+// every one of them, nothing is queued and ErrQueueFull is returned, so a
+// client retrying the batch never sends a payload twice. The rejected payloads
+// are dead-lettered only once the batch is taken, so a retried batch does not
+// dead-letter them again.
+func (s *Server) IngestBatch(envType, tenant string, payloads [][]byte) ([]*Rejection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.stats.Add(CounterReceived, uint64(len(payloads)))
+	if s.closed {
+		s.stats.Add(CounterDropped, uint64(len(payloads)))
+		return nil, ErrClosed
+	}
+
+	rejections := make([]*Rejection, len(payloads))
+	rejected := make([][]byte, len(payloads))
+	var (
+		b    *batcher
+		msgs []kafka.Message
+	)
+	for i, raw := range payloads {
+		owned, err := withTenant(raw, tenant)
+		if err != nil {
+			rejections[i], rejected[i] = reject(ReasonTenantMismatch, "%s", err.Error()), raw
+			continue
+		}
+
+		route, payload, r := s.validate(envType, owned)
+		if r != nil {
+			rejections[i], rejected[i] = r, owned
+			continue
+		}
+		b = route
+		msgs = append(msgs, kafka.Message{Value: payload})
+	}
+
+	if len(msgs) > 0 {
+		if len(msgs) > cap(b.queue) {
+			s.stats.Add(CounterDropped, uint64(len(msgs)))
+			return rejections, ErrBatchTooLarge
+		}
+		if !b.enqueueAll(msgs) {
+			return rejections, ErrQueueFull
+		}
+	}
+
+	for i, r := range rejections {
+		if r != nil {
+			s.reject(envType, rejected[i], r)
+		}
+	}
+
+	return rejections, nil
+}
+
+// ServeUDP reads datagrams from conn until ctx is cancelled. The caller owns
+// conn, but ServeUDP closes it on cancellation to unblock the pending read.
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.Error("failed to close udp connection", slog.String("error", err.Error()))
+		}
+	})
+	defer stop()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			slog.Error("failed to read data", slog.String("error", err.Error()))
+			continue
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		s.Enqueue(packet)
+	}
+}
+
+// Shutdown stops accepting packets, waits for the workers to drain the queue
+// and for the batchers to flush, then closes the sinks. When ctx is done first
+// the writes are cancelled, so the batchers fail what is left fast and return,
+// and the sinks are closed all the same. What was lost that way is logged and
+// ctx.Err() returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.packets)
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		for _, b := range s.batcherList() {
+			close(b.queue)
+		}
+		s.batchers.Wait()
+		close(done)
+	}()
+
+	var errs []error
+	select {
+	case <-done:
+		s.stopFlush()
+	case <-ctx.Done():
+		failed := s.writeErrors()
+		s.stopFlush()
+		<-done
+		slog.Error("shutdown deadline exceeded, pending messages dropped",
+			slog.Uint64("dropped", s.writeErrors()-failed))
+		errs = append(errs, ctx.Err())
+	}
+
+	for _, sink := range s.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeErrors counts the messages every batcher failed to write so far.
+func (s *Server) writeErrors() uint64 {
+	var n uint64
+	for _, b := range s.batcherList() {
+		n += s.stats.Get(routeCounter(b.route, counterRouteWriteErrors))
+	}
+	return n
+}
+
+func (s *Server) handle(packet []byte) {
+	var env types.Envelope
+	if err := json.Unmarshal(packet, &env); err != nil {
+		s.reject("", packet, reject(ReasonMalformedEnvelope, "%s", err.Error()))
+		return
+	}
+
+	s.route(env.Type, env.Payload)
+}
+
+// route validates a payload of the given envelope type and hands it to the
+// matching batcher, or to the dead-letter batcher when it is rejected.
+// It returns the rejection, if any, and whether the payload was queued.
+func (s *Server) route(envType string, raw []byte) (*Rejection, bool) {
+	b, payload, r := s.validate(envType, raw)
+	if r != nil {
+		s.reject(envType, raw, r)
+		return r, false
+	}
+
+	return nil, b.enqueue(kafka.Message{Value: payload})
+}
+
+// validate checks a payload of the given envelope type and returns the
+// batcher it goes to with the normalized payload. Dead-lettering a rejected
+// payload is left to the caller.
+func (s *Server) validate(envType string, raw []byte) (*batcher, []byte, *Rejection) {
+	b, ok := s.routes[envType]
+	if !ok {
+		return nil, nil, reject(ReasonUnknownType, "type %q", envType)
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+	switch envType {
+	case TypeMetadata:
+		payload, err = s.validator.Metric(raw)
+	case TypeAction:
+		payload, err = s.validator.Action(raw)
+	}
+	if err != nil {
+		var r *Rejection
+		if !errors.As(err, &r) {
+			r = reject(ReasonMalformedPayload, "%s", err.Error())
+		}
+		return nil, nil, r
+	}
+
+	return b, payload, nil
+}
+
+func (s *Server) reject(envType string, payload []byte, r *Rejection) {
+	slog.Debug("rejected payload",
+		slog.String("type", envType),
+		slog.String("reason", r.Reason),
+		slog.String("detail", r.Detail))
+	s.stats.Inc(rejectedCounter(r.Reason))
+
+	value, err := json.Marshal(DeadLetter{
+		Reason:     r.Reason,
+		Detail:     r.Detail,
+		Type:       envType,
+		Payload:    string(payload),
+		ReceivedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to marshal dead letter", slog.String("error", err.Error()))
+		return
+	}
+
+	s.deadLetter.enqueue(kafka.Message{
+		Value:   value,
+		Headers: []kafka.Header{{Key: "reason", Value: []byte(r.Reason)}},
+	})
+}
@@ -0,0 +1,143 @@
+package meter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// trafficValidator accepts the recorded traffic, whose timestamps are fixed.
+func trafficValidator() *Validator {
+	v := NewValidator(ValidatorConfig{})
+	v.now = func() time.Time { return time.Unix(1760900300, 0) }
+	return v
+}
+
+func Test_ServerRoutesAndFlushesOnShutdown(t *testing.T) {
+	metrics, actions, dlq := NewMemorySink(), NewMemorySink(), NewMemorySink()
+	s := New(Config{Workers: 4, QueueSize: 1000, BatchSize: 50, BatchTimeout: time.Hour, Validator: trafficValidator()}, Sinks{
+		Metrics:    metrics,
+		Actions:    actions,
+		DeadLetter: dlq,
+	})
+	s.Start()
+
+	traffic := loadTraffic(t)
+	for _, line := range traffic {
+		require.True(t, s.Enqueue(line))
+	}
+	assert.True(t, s.Enqueue([]byte(`{"type":"bogus","payload":{}}`)))
+	assert.True(t, s.Enqueue([]byte(`not json`)))
+
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	assert.Len(t, metrics.Messages(), 228)
+	assert.Len(t, actions.Messages(), 12)
+	assert.Len(t, dlq.Messages(), 2)
+	assert.True(t, metrics.Closed())
+	assert.True(t, actions.Closed())
+	assert.True(t, dlq.Closed())
+
+	stats := s.Stats()
+	assert.EqualValues(t, len(traffic)+2, stats.Get(CounterReceived))
+	assert.EqualValues(t, 1, stats.Get("rejected."+ReasonUnknownType))
+	assert.EqualValues(t, 1, stats.Get("rejected."+ReasonMalformedEnvelope))
+	assert.EqualValues(t, 228, stats.Get("metadata.written"))
+}
+
+func Test_ServerDeadLettersInvalidPayloads(t *testing.T) {
+	metrics, dlq := NewMemorySink(), NewMemorySink()
+	s := New(Config{Workers: 1, QueueSize: 10, BatchSize: 10, Validator: trafficValidator()}, Sinks{
+		Metrics:    metrics,
+		Actions:    NewMemorySink(),
+		DeadLetter: dlq,
+	})
+	s.Start()
+
+	s.Enqueue([]byte(`{"type":"metadata","payload":{"pod":"p","tenant":"t","mem_mb":-1,"timestamp":1760900000}}`))
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	assert.Empty(t, metrics.Messages())
+	require.Len(t, dlq.Messages(), 1)
+
+	msg := dlq.Messages()[0]
+	var dl DeadLetter
+	require.NoError(t, json.Unmarshal(msg.Value, &dl))
+	assert.Equal(t, ReasonNegativeValue, dl.Reason)
+	assert.Equal(t, TypeMetadata, dl.Type)
+	assert.JSONEq(t, `{"pod":"p","tenant":"t","mem_mb":-1,"timestamp":1760900000}`, dl.Payload)
+	assert.Equal(t, "reason", msg.Headers[0].Key)
+	assert.Equal(t, ReasonNegativeValue, string(msg.Headers[0].Value))
+	assert.EqualValues(t, 1, s.Stats().Get("rejected."+ReasonNegativeValue))
+}
+
+func Test_ServerDropsWhenQueueIsFull(t *testing.T) {
+	s := New(Config{Workers: 1, QueueSize: 2, BatchSize: 1}, Sinks{
+		Metrics:    NewMemorySink(),
+		Actions:    NewMemorySink(),
+		DeadLetter: NewMemorySink(),
+	})
+
+	// workers are not started, so the queue fills up
+	assert.True(t, s.Enqueue([]byte(`{}`)))
+	assert.True(t, s.Enqueue([]byte(`{}`)))
+	assert.False(t, s.Enqueue([]byte(`{}`)))
+	assert.EqualValues(t, 1, s.Stats().Get(CounterDropped))
+
+	s.Start()
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.False(t, s.Enqueue([]byte(`{}`)))
+}
+
+func Test_BatcherFlushesOnTimeout(t *testing.T) {
+	metrics := NewMemorySink()
+	s := New(Config{Workers: 1, QueueSize: 10, BatchSize: 100, BatchTimeout: 10 * time.Millisecond, Validator: trafficValidator()}, Sinks{
+		Metrics:    metrics,
+		Actions:    NewMemorySink(),
+		DeadLetter: NewMemorySink(),
+	})
+	s.Start()
+	defer s.Shutdown(context.Background())
+
+	s.Enqueue([]byte(`{"type":"metadata","payload":{"pod":"p","tenant":"t","timestamp":1760900000}}`))
+
+	assert.Eventually(t, func() bool { return len(metrics.Messages()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+// hangingSink blocks every write until its context is cancelled.
+type hangingSink struct {
+	*MemorySink
+}
+
+func (s hangingSink) WriteMessages(ctx context.Context, _ ...kafka.Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func Test_ShutdownCancelsHungWrites(t *testing.T) {
+	metrics := hangingSink{NewMemorySink()}
+	s := New(Config{Workers: 1, QueueSize: 10, BatchSize: 1, Validator: trafficValidator()}, Sinks{
+		Metrics:    metrics,
+		Actions:    NewMemorySink(),
+		DeadLetter: NewMemorySink(),
+	})
+	s.Start()
+
+	s.Enqueue([]byte(`{"type":"metadata","payload":{"pod":"p","tenant":"t","timestamp":1760900000}}`))
+	s.Enqueue([]byte(`{"type":"metadata","payload":{"pod":"q","tenant":"t","timestamp":1760900000}}`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	// the hung write and the one queued behind it fail instead of outliving
+	// the shutdown, and the sinks are closed anyway
+	assert.EqualValues(t, 2, s.Stats().Get("metadata.write_errors"))
+	assert.True(t, metrics.Closed())
+}
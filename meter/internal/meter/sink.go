@@ -0,0 +1,81 @@
+package meter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Sink is a destination for routed messages. *kafka.Writer satisfies it.
+type Sink interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// MemorySink keeps written messages in memory, it is used by tests and benchmarks
+// in place of a Kafka writer.
+type MemorySink struct {
+	mu      sync.Mutex
+	msgs    []kafka.Message
+	batches int
+	closed  bool
+	discard bool
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// NewDiscardSink returns a MemorySink that only counts messages, so long
+// benchmark runs do not grow the heap.
+func NewDiscardSink() *MemorySink {
+	return &MemorySink{discard: true}
+}
+
+func (s *MemorySink) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches++
+	if s.discard {
+		s.msgs = s.msgs[:0]
+		return nil
+	}
+	s.msgs = append(s.msgs, msgs...)
+
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+// Messages returns a copy of everything written so far.
+func (s *MemorySink) Messages() []kafka.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]kafka.Message, len(s.msgs))
+	copy(out, s.msgs)
+	return out
+}
+
+// Batches returns the number of WriteMessages calls.
+func (s *MemorySink) Batches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.batches
+}
+
+func (s *MemorySink) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
@@ -0,0 +1,84 @@
+package meter
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter names. Per-route counters are prefixed with the envelope type,
+// e.g. "metadata.written", rejections with "rejected.", e.g. "rejected.unknown_tenant".
+const (
+	CounterReceived = "received"
+	CounterDropped  = "dropped"
+
+	counterRouteDropped     = "dropped"
+	counterRouteWritten     = "written"
+	counterRouteWriteErrors = "write_errors"
+)
+
+// Stats is a set of monotonic counters safe for concurrent use.
+type Stats struct {
+	counters sync.Map
+}
+
+func routeCounter(route, name string) string {
+	return route + "." + name
+}
+
+func rejectedCounter(reason string) string {
+	return "rejected." + reason
+}
+
+func (s *Stats) counter(name string) *atomic.Uint64 {
+	if c, ok := s.counters.Load(name); ok {
+		return c.(*atomic.Uint64)
+	}
+
+	c, _ := s.counters.LoadOrStore(name, new(atomic.Uint64))
+	return c.(*atomic.Uint64)
+}
+
+func (s *Stats) Inc(name string) {
+	s.counter(name).Add(1)
+}
+
+func (s *Stats) Add(name string, n uint64) {
+	s.counter(name).Add(n)
+}
+
+func (s *Stats) Get(name string) uint64 {
+	if c, ok := s.counters.Load(name); ok {
+		return c.(*atomic.Uint64).Load()
+	}
+	return 0
+}
+
+// Snapshot returns the current value of every counter that was touched.
+func (s *Stats) Snapshot() map[string]uint64 {
+	out := make(map[string]uint64)
+	s.counters.Range(func(k, v any) bool {
+		out[k.(string)] = v.(*atomic.Uint64).Load()
+		return true
+	})
+	return out
+}
+
+// Log writes the snapshot as a single log line with sorted keys.
+func (s *Stats) Log(msg string) {
+	snap := s.Snapshot()
+
+	keys := make([]string, 0, len(snap))
+	for k := range snap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, slog.Uint64(k, snap[k]))
+	}
+
+	slog.Info(msg, attrs...)
+}
@@ -0,0 +1,217 @@
+package meter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/types"
+)
+
+// Rejection reasons, each one gets its own "rejected.<reason>" counter and is
+// attached to the dead-lettered message.
+const (
+	ReasonMalformedEnvelope = "malformed_envelope"
+	ReasonUnknownType       = "unknown_type"
+	ReasonMalformedPayload  = "malformed_payload"
+	ReasonMissingField      = "missing_field"
+	ReasonNegativeValue     = "negative_value"
+	ReasonAbsurdValue       = "absurd_value"
+	ReasonUnknownTenant     = "unknown_tenant"
+	ReasonTenantMismatch    = "tenant_mismatch"
+	ReasonUnknownAction     = "unknown_action"
+	ReasonBadTimestamp      = "bad_timestamp"
+)
+
+// Rejection is returned by the validator when a payload must not reach the
+// downstream topics.
+type Rejection struct {
+	Reason string
+	Detail string
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason + ": " + r.Detail
+}
+
+func reject(reason, format string, args ...any) *Rejection {
+	return &Rejection{Reason: reason, Detail: fmt.Sprintf(format, args...)}
+}
+
+// TenantRegistry tells whether a tenant is allowed to report usage.
+type TenantRegistry interface {
+	Known(tenant string) bool
+}
+
+// StaticTenants is a fixed allow-list of tenants. An empty list accepts any
+// non-empty tenant.
+type StaticTenants map[string]struct{}
+
+func NewStaticTenants(tenants []string) StaticTenants {
+	out := make(StaticTenants, len(tenants))
+	for _, t := range tenants {
+		out[t] = struct{}{}
+	}
+	return out
+}
+
+func (s StaticTenants) Known(tenant string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	_, ok := s[tenant]
+	return ok
+}
+
+type ValidatorConfig struct {
+	Tenants       TenantRegistry
+	MaxMemMB      float64
+	MaxCPUPercent float64
+	// MaxClockSkew is how far in the future an event may be, MaxEventAge how
+	// far in the past. Both are measured against the meter's clock.
+	MaxClockSkew time.Duration
+	MaxEventAge  time.Duration
+}
+
+// Validator checks agent payloads against the types.Metric and types.Action
+// schemas and returns them re-encoded with normalized timestamps.
+type Validator struct {
+	cfg ValidatorConfig
+	now func() time.Time
+}
+
+func NewValidator(cfg ValidatorConfig) *Validator {
+	if cfg.Tenants == nil {
+		cfg.Tenants = StaticTenants(nil)
+	}
+	if cfg.MaxMemMB <= 0 {
+		cfg.MaxMemMB = 1024 * 1024 // 1 TiB
+	}
+	if cfg.MaxCPUPercent <= 0 {
+		cfg.MaxCPUPercent = 100 * 256 // 256 fully loaded cores
+	}
+	if cfg.MaxClockSkew <= 0 {
+		cfg.MaxClockSkew = 5 * time.Minute
+	}
+	if cfg.MaxEventAge <= 0 {
+		cfg.MaxEventAge = 7 * 24 * time.Hour
+	}
+
+	return &Validator{cfg: cfg, now: time.Now}
+}
+
+func (v *Validator) Metric(payload []byte) ([]byte, error) {
+	var m types.Metric
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, reject(ReasonMalformedPayload, "%s", err.Error())
+	}
+
+	if err := v.identity(m.Pod, m.Tenant); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case m.MemMB < 0:
+		return nil, reject(ReasonNegativeValue, "mem_mb is %g", m.MemMB)
+	case m.CPUPercent < 0:
+		return nil, reject(ReasonNegativeValue, "cpu_percent is %g", m.CPUPercent)
+	case m.MemMB > v.cfg.MaxMemMB:
+		return nil, reject(ReasonAbsurdValue, "mem_mb %g exceeds %g", m.MemMB, v.cfg.MaxMemMB)
+	case m.CPUPercent > v.cfg.MaxCPUPercent:
+		return nil, reject(ReasonAbsurdValue, "cpu_percent %g exceeds %g", m.CPUPercent, v.cfg.MaxCPUPercent)
+	}
+
+	ts, err := v.timestamp("timestamp", m.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	m.Timestamp = ts
+
+	return json.Marshal(m)
+}
+
+func (v *Validator) Action(payload []byte) ([]byte, error) {
+	var a types.Action
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return nil, reject(ReasonMalformedPayload, "%s", err.Error())
+	}
+
+	if err := v.identity(a.Pod, a.Tenant); err != nil {
+		return nil, err
+	}
+
+	switch a.Action {
+	case types.ActionStart, types.ActionHeartbeat, types.ActionOOMKilled, types.ActionRestart, types.ActionStop:
+	case "":
+		return nil, reject(ReasonMissingField, "action is empty")
+	default:
+		return nil, reject(ReasonUnknownAction, "action %q", a.Action)
+	}
+
+	ts, err := v.timestamp("timestamp", a.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	a.Timestamp = ts
+
+	if a.StartedAt != 0 {
+		startedAt, err := v.timestamp("started_at", a.StartedAt)
+		if err != nil {
+			return nil, err
+		}
+		if startedAt > a.Timestamp {
+			return nil, reject(ReasonBadTimestamp, "started_at %d is after timestamp %d", startedAt, a.Timestamp)
+		}
+		a.StartedAt = startedAt
+	}
+
+	return json.Marshal(a)
+}
+
+func (v *Validator) identity(pod, tenant string) error {
+	if pod == "" {
+		return reject(ReasonMissingField, "pod is empty")
+	}
+	if tenant == "" {
+		return reject(ReasonMissingField, "tenant is empty")
+	}
+	if !v.cfg.Tenants.Known(tenant) {
+		return reject(ReasonUnknownTenant, "tenant %q", tenant)
+	}
+
+	return nil
+}
+
+// timestamp converts ts to unix seconds. Agents in other languages tend to send
+// milliseconds or nanoseconds, so the unit is inferred from the magnitude.
+// A zero timestamp is replaced with the time of receipt.
+func (v *Validator) timestamp(field string, ts int64) (int64, error) {
+	now := v.now()
+	if ts == 0 {
+		return now.Unix(), nil
+	}
+	if ts < 0 {
+		return 0, reject(ReasonBadTimestamp, "%s %d is negative", field, ts)
+	}
+
+	var t time.Time
+	switch {
+	case ts >= 1e17:
+		t = time.Unix(0, ts)
+	case ts >= 1e14:
+		t = time.UnixMicro(ts)
+	case ts >= 1e11:
+		t = time.UnixMilli(ts)
+	default:
+		t = time.Unix(ts, 0)
+	}
+
+	if t.After(now.Add(v.cfg.MaxClockSkew)) {
+		return 0, reject(ReasonBadTimestamp, "%s %d is in the future", field, ts)
+	}
+	if t.Before(now.Add(-v.cfg.MaxEventAge)) {
+		return 0, reject(ReasonBadTimestamp, "%s %d is too old", field, ts)
+	}
+
+	return t.Unix(), nil
+}
@@ -0,0 +1,93 @@
+package meter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/pkg/types"
+)
+
+func Test_ValidatorMetric(t *testing.T) {
+	now := time.Unix(1760900000, 0)
+	v := NewValidator(ValidatorConfig{
+		Tenants:  NewStaticTenants([]string{"alice"}),
+		MaxMemMB: 4096,
+	})
+	v.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		payload string
+		reason  string
+		wantTS  int64
+	}{
+		{name: "valid", payload: `{"pod":"p","tenant":"alice","mem_mb":12.5,"timestamp":1760899990}`, wantTS: 1760899990},
+		{name: "milliseconds", payload: `{"pod":"p","tenant":"alice","timestamp":1760899990123}`, wantTS: 1760899990},
+		{name: "microseconds", payload: `{"pod":"p","tenant":"alice","timestamp":1760899990123456}`, wantTS: 1760899990},
+		{name: "nanoseconds", payload: `{"pod":"p","tenant":"alice","timestamp":1760899990123456789}`, wantTS: 1760899990},
+		{name: "missing timestamp", payload: `{"pod":"p","tenant":"alice"}`, wantTS: 1760900000},
+		{name: "not json", payload: `[1,2]`, reason: ReasonMalformedPayload},
+		{name: "missing pod", payload: `{"tenant":"alice","timestamp":1760899990}`, reason: ReasonMissingField},
+		{name: "missing tenant", payload: `{"pod":"p","timestamp":1760899990}`, reason: ReasonMissingField},
+		{name: "unknown tenant", payload: `{"pod":"p","tenant":"mallory","timestamp":1760899990}`, reason: ReasonUnknownTenant},
+		{name: "negative memory", payload: `{"pod":"p","tenant":"alice","mem_mb":-1,"timestamp":1760899990}`, reason: ReasonNegativeValue},
+		{name: "negative cpu", payload: `{"pod":"p","tenant":"alice","cpu_percent":-0.5,"timestamp":1760899990}`, reason: ReasonNegativeValue},
+		{name: "absurd memory", payload: `{"pod":"p","tenant":"alice","mem_mb":5000,"timestamp":1760899990}`, reason: ReasonAbsurdValue},
+		{name: "absurd cpu", payload: `{"pod":"p","tenant":"alice","cpu_percent":1e9,"timestamp":1760899990}`, reason: ReasonAbsurdValue},
+		{name: "future", payload: `{"pod":"p","tenant":"alice","timestamp":1760990000}`, reason: ReasonBadTimestamp},
+		{name: "too old", payload: `{"pod":"p","tenant":"alice","timestamp":1700000000}`, reason: ReasonBadTimestamp},
+		{name: "negative timestamp", payload: `{"pod":"p","tenant":"alice","timestamp":-5}`, reason: ReasonBadTimestamp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := v.Metric([]byte(tt.payload))
+			if tt.reason != "" {
+				var r *Rejection
+				require.ErrorAs(t, err, &r)
+				assert.Equal(t, tt.reason, r.Reason)
+				return
+			}
+
+			require.NoError(t, err)
+			var m types.Metric
+			require.NoError(t, json.Unmarshal(out, &m))
+			assert.Equal(t, tt.wantTS, m.Timestamp)
+		})
+	}
+}
+
+func Test_ValidatorAction(t *testing.T) {
+	v := NewValidator(ValidatorConfig{})
+	v.now = func() time.Time { return time.Unix(1760900000, 0) }
+
+	tests := []struct {
+		name    string
+		payload string
+		reason  string
+	}{
+		{name: "stop", payload: `{"pod":"p","tenant":"t","action":"stop","timestamp":1760899990,"started_at":1760899000}`},
+		{name: "heartbeat", payload: `{"pod":"p","tenant":"t","action":"heartbeat","timestamp":1760899990}`},
+		{name: "empty action", payload: `{"pod":"p","tenant":"t","timestamp":1760899990}`, reason: ReasonMissingField},
+		{name: "unknown action", payload: `{"pod":"p","tenant":"t","action":"explode","timestamp":1760899990}`, reason: ReasonUnknownAction},
+		{name: "started after stop", payload: `{"pod":"p","tenant":"t","action":"stop","timestamp":1760899000,"started_at":1760899990}`, reason: ReasonBadTimestamp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Action([]byte(tt.payload))
+			if tt.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var r *Rejection
+			require.ErrorAs(t, err, &r)
+			assert.Equal(t, tt.reason, r.Reason)
+		})
+	}
+}
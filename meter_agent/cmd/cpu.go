@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// cpuRate turns the cumulative CPU counter of the user container into the
+// percentage of one core used since the previous scrape. Multiplied by the
+// time it is reported for it gives the CPU-seconds invoicer bills.
+type cpuRate struct {
+	lastSeconds float64
+	lastAt      time.Time
+}
+
+func (c *cpuRate) percent(seconds float64, ok bool, now time.Time) float64 {
+	if !ok {
+		return 0
+	}
+
+	prevSeconds, prevAt := c.lastSeconds, c.lastAt
+	c.lastSeconds, c.lastAt = seconds, now
+
+	// the first scrape has nothing to compare with, and a counter that went
+	// backwards means the user container restarted
+	if prevAt.IsZero() || seconds < prevSeconds {
+		return 0
+	}
+
+	elapsed := now.Sub(prevAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return (seconds - prevSeconds) / elapsed * 100
+}
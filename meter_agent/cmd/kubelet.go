@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/usamaroman/faas_demo/pkg/k8s"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// containerCPU reads the cumulative CPU time of the user container from the
+// kubelet stats summary of the node, proxied by the API server. The queue-proxy
+// metrics only cover the sidecar's own process, the kubelet reports the cgroup
+// of every container.
+type containerCPU struct {
+	cli       kubernetes.Interface
+	node      string
+	namespace string
+	pod       string
+	container string
+}
+
+// statsSummary is the part of the kubelet /stats/summary response the agent
+// reads.
+type statsSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name string `json:"name"`
+			CPU  *struct {
+				UsageCoreNanoSeconds *uint64 `json:"usageCoreNanoSeconds"`
+			} `json:"cpu"`
+		} `json:"containers"`
+	} `json:"pods"`
+}
+
+func newContainerCPU(node, namespace, pod, container string) (*containerCPU, error) {
+	if node == "" || namespace == "" || pod == "" {
+		return nil, errors.New("NODE_NAME, POD_NAMESPACE and REPLICA_ID must be set")
+	}
+
+	cli, err := k8s.NewClient(k8s.Config{InCluster: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &containerCPU{
+		cli:       cli,
+		node:      node,
+		namespace: namespace,
+		pod:       pod,
+		container: container,
+	}, nil
+}
+
+// seconds returns the CPU-seconds the user container has used since it
+// started, false when the kubelet has no stats for it yet.
+func (c *containerCPU) seconds(ctx context.Context) (float64, bool) {
+	body, err := c.cli.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", c.node, "proxy", "stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		slog.Error("failed to get kubelet stats summary", slog.String("node", c.node), slog.String("error", err.Error()))
+		return 0, false
+	}
+
+	return containerSeconds(body, c.namespace, c.pod, c.container)
+}
+
+func containerSeconds(body []byte, namespace, pod, container string) (float64, bool) {
+	var summary statsSummary
+	if err := json.Unmarshal(body, &summary); err != nil {
+		slog.Error("failed to decode kubelet stats summary", slog.String("error", err.Error()))
+		return 0, false
+	}
+
+	for _, p := range summary.Pods {
+		if p.PodRef.Name != pod || p.PodRef.Namespace != namespace {
+			continue
+		}
+		for _, c := range p.Containers {
+			if c.Name != container || c.CPU == nil || c.CPU.UsageCoreNanoSeconds == nil {
+				continue
+			}
+			return float64(*c.CPU.UsageCoreNanoSeconds) / 1e9, true
+		}
+	}
+
+	return 0, false
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/usamaroman/faas_demo/pkg/logger"
+	"github.com/usamaroman/faas_demo/pkg/observability"
 	"github.com/usamaroman/faas_demo/pkg/types"
 )
 
@@ -43,7 +44,17 @@ func main() {
 			podName = hn
 		}
 	}
-	slog.Info("meter_agent pod identity", slog.String("podName", podName), slog.String("tenant", tenant))
+	id := identity{
+		pod:      podName,
+		tenant:   tenant,
+		revision: os.Getenv("REVISION"),
+		replica:  os.Getenv("REPLICA_ID"),
+	}
+	slog.Info("meter_agent pod identity",
+		slog.String("podName", podName),
+		slog.String("tenant", tenant),
+		slog.String("revision", id.revision),
+		slog.String("replica", id.replica))
 
 	conn, err = net.Dial("udp", meterURL)
 	if err != nil {
@@ -58,9 +69,9 @@ func main() {
 		}
 	}()
 
-	ctx := context.Background()
-	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGTTIN, syscall.SIGTERM)
-	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
 	metricsURL := os.Getenv("KNATIVE_METRICS_URL")
 	if metricsURL == "" {
@@ -74,53 +85,212 @@ func main() {
 		}
 	}
 
+	heartbeatSec := 30
+	if v := os.Getenv("HEARTBEAT_INTERVAL_SEC"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			heartbeatSec = n
+		}
+	}
+
+	startedAt := time.Now().Unix()
+	sendAction(id.action(types.ActionStart, startedAt))
+
+	health := observability.NewHealth()
+	health.Add("knative_metrics", observability.HTTPCheck(metricsURL))
+
+	mux := http.NewServeMux()
+	health.Register(mux)
+	go func() {
+		addr := getEnv("METRICS_ADDR", ":9465")
+		slog.Info("listening http", slog.String("addr", addr))
+		if err := http.ListenAndServe(addr, observability.Middleware(mux)); err != nil {
+			slog.Error("http server error", slog.String("error", err.Error()))
+		}
+	}()
+
+	var (
+		cpu        cpuRate
+		cpuPercent float64
+		requests   requestCount
+	)
+
 	ticker := time.NewTicker(time.Duration(intervalSec) * time.Second)
 	defer ticker.Stop()
 
+	heartbeat := time.NewTicker(time.Duration(heartbeatSec) * time.Second)
+	defer heartbeat.Stop()
+
+	// the pod watcher needs in-cluster API access (get on pods), so it is opt-in
+	var (
+		watcher   *podWatcher
+		podEvents <-chan time.Time
+	)
+	if os.Getenv("POD_WATCH_ENABLED") == "true" {
+		watcher, err = newPodWatcher(os.Getenv("POD_NAMESPACE"), id.replica, getEnv("USER_CONTAINER_NAME", "user-container"))
+		if err != nil {
+			slog.Error("failed to init pod watcher, restarts will not be reported", slog.String("error", err.Error()))
+		} else {
+			podTicker := time.NewTicker(5 * time.Second)
+			defer podTicker.Stop()
+			podEvents = podTicker.C
+		}
+	}
+
+	// the function's CPU is read per container from the kubelet, which needs
+	// in-cluster API access (get on nodes/proxy), so it is opt-in as well.
+	// Without it the agent reports no CPU rather than the queue-proxy's own.
+	var (
+		cpuSource *containerCPU
+		cpuEvents <-chan time.Time
+	)
+	if os.Getenv("CPU_STATS_ENABLED") == "true" {
+		cpuSource, err = newContainerCPU(os.Getenv("NODE_NAME"), os.Getenv("POD_NAMESPACE"), id.replica, getEnv("USER_CONTAINER_NAME", "user-container"))
+		if err != nil {
+			slog.Error("failed to init container cpu stats, cpu will not be reported", slog.String("error", err.Error()))
+		} else {
+			cpuSec := 10
+			if v := os.Getenv("CPU_STATS_INTERVAL_SEC"); v != "" {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 {
+					cpuSec = n
+				}
+			}
+			// the first read is the baseline
+			seconds, ok := cpuSource.seconds(context.Background())
+			cpu.percent(seconds, ok, time.Now())
+			cpuTicker := time.NewTicker(time.Duration(cpuSec) * time.Second)
+			defer cpuTicker.Stop()
+			cpuEvents = cpuTicker.C
+		}
+	} else {
+		slog.Warn("CPU_STATS_ENABLED is not set, cpu will not be reported")
+	}
+
 	for {
 		select {
-		case <-ctx.Done():
-			// send stop action with duration
-			endTs := time.Now().Unix()
-			stopAction := types.Action{Pod: podName, Action: "stop", Timestamp: endTs, Tenant: tenant}
-			sendAction(stopAction)
+		case sig := <-signals:
+			stop := id.action(types.ActionStop, time.Now().Unix())
+			stop.StartedAt = startedAt
+			stop.Reason = signalReason(sig)
+			sendAction(stop)
 			return
+		case <-heartbeat.C:
+			hb := id.action(types.ActionHeartbeat, time.Now().Unix())
+			hb.StartedAt = startedAt
+			sendAction(hb)
+		case <-podEvents:
+			for _, term := range watcher.poll(context.Background()) {
+				a := id.action(term.action, term.finishedAt)
+				a.Reason = term.reason
+				a.ExitCode = term.exitCode
+				a.StartedAt = term.startedAt
+				sendAction(a)
+			}
+		case <-cpuEvents:
+			// the kubelet refreshes its stats every few seconds, so the rate is
+			// taken over a longer window and reported on every metric until the
+			// next read
+			seconds, ok := cpuSource.seconds(context.Background())
+			cpuPercent = cpu.percent(seconds, ok, time.Now())
 		case <-ticker.C:
-			memMB := scrapeKnativeMemoryMB(metricsURL)
-			metric := types.Metric{Pod: podName, CPUPercent: 0.0, MemMB: memMB, Timestamp: time.Now().Unix(), Tenant: tenant}
+			now := time.Now()
+			sample := scrapeKnativeMetrics(metricsURL)
+			metric := types.Metric{
+				Pod:        podName,
+				CPUPercent: cpuPercent,
+				MemMB:      sample.memMB,
+				Timestamp:  now.Unix(),
+				Tenant:     tenant,
+				Revision:   id.revision,
+				Replica:    id.replica,
+				Requests:   requests.delta(sample),
+			}
 			sendMetric(metric)
 		}
 	}
 }
 
-func scrapeKnativeMemoryMB(url string) float64 {
+type identity struct {
+	pod      string
+	tenant   string
+	revision string
+	replica  string
+}
+
+func (id identity) action(t types.ActionType, ts int64) types.Action {
+	return types.Action{
+		Pod:       id.pod,
+		Action:    t,
+		Timestamp: ts,
+		Tenant:    id.tenant,
+		Revision:  id.revision,
+		Replica:   id.replica,
+	}
+}
+
+func signalReason(sig os.Signal) string {
+	if sig == syscall.SIGINT {
+		return types.ReasonSIGINT
+	}
+	return types.ReasonSIGTERM
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// knativeSample holds the queue-proxy metrics the agent reports.
+type knativeSample struct {
+	memMB float64
+	// requests is the cumulative revision_request_count counter of the
+	// queue-proxy, summed over response codes
+	requests    float64
+	hasRequests bool
+}
+
+func scrapeKnativeMetrics(url string) knativeSample {
+	var sample knativeSample
+
 	resp, err := http.Get(url)
 	if err != nil {
 		slog.Error("failed to GET knative metrics", slog.String("error", err.Error()))
-		return 0
+		return sample
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		slog.Error("failed to read knative metrics", slog.String("error", err.Error()))
-		return 0
+		return sample
 	}
 
 	for line := range strings.SplitSeq(string(body), "\n") {
-		if strings.HasPrefix(line, "revision_go_heap_alloc") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				valStr := parts[len(parts)-1]
-				slog.Debug("got revision_go_heap_alloc metric", slog.String("value", valStr))
-				if f, err := strconv.ParseFloat(valStr, 64); err == nil {
-					return f / (1024.0 * 1024.0)
-				}
+		switch {
+		case strings.HasPrefix(line, "revision_go_heap_alloc"):
+			if f, ok := metricValue(line); ok {
+				slog.Debug("got revision_go_heap_alloc metric", slog.Float64("value", f))
+				sample.memMB = f / (1024.0 * 1024.0)
+			}
+		case strings.HasPrefix(line, "revision_request_count"):
+			if f, ok := metricValue(line); ok {
+				sample.requests += f
+				sample.hasRequests = true
 			}
 		}
 	}
 
-	return 0
+	return sample
+}
+
+func metricValue(line string) (float64, bool) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	return f, err == nil
 }
 
 func sendMetric(m types.Metric) {
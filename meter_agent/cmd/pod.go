@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/k8s"
+	"github.com/usamaroman/faas_demo/pkg/types"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// podWatcher polls the status of the replica's own pod and reports terminations
+// of the user container. Knative restarts the container in place, so the only
+// trace of a crash is the restart counter and the last terminated state.
+type podWatcher struct {
+	cli       kubernetes.Interface
+	namespace string
+	name      string
+	container string
+	restarts  int32
+}
+
+type termination struct {
+	action     types.ActionType
+	reason     string
+	exitCode   int32
+	startedAt  int64
+	finishedAt int64
+}
+
+func newPodWatcher(namespace, name, container string) (*podWatcher, error) {
+	if namespace == "" || name == "" {
+		return nil, errors.New("POD_NAMESPACE and REPLICA_ID must be set")
+	}
+
+	cli, err := k8s.NewClient(k8s.Config{InCluster: true})
+	if err != nil {
+		return nil, err
+	}
+
+	w := &podWatcher{
+		cli:       cli,
+		namespace: namespace,
+		name:      name,
+		container: container,
+		restarts:  -1,
+	}
+
+	return w, nil
+}
+
+// poll returns one termination per restart observed since the previous call.
+// The first call only records the baseline restart count.
+func (w *podWatcher) poll(ctx context.Context) []termination {
+	pod, err := w.cli.CoreV1().Pods(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+	if err != nil {
+		slog.Error("failed to get pod status", slog.String("pod", w.name), slog.String("error", err.Error()))
+		return nil
+	}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != w.container {
+			continue
+		}
+
+		if w.restarts < 0 || cs.RestartCount <= w.restarts {
+			w.restarts = cs.RestartCount
+			return nil
+		}
+
+		restarted := cs.RestartCount - w.restarts
+		w.restarts = cs.RestartCount
+
+		t := termination{action: types.ActionRestart, finishedAt: time.Now().Unix()}
+		if last := cs.LastTerminationState.Terminated; last != nil {
+			t.reason = last.Reason
+			t.exitCode = last.ExitCode
+			t.startedAt = last.StartedAt.Unix()
+			t.finishedAt = last.FinishedAt.Unix()
+			if last.Reason == types.ReasonOOMKilled {
+				t.action = types.ActionOOMKilled
+			}
+		}
+
+		// only the most recent termination is visible in the pod status, earlier
+		// ones in the same poll window are reported as plain restarts
+		out := make([]termination, 0, restarted)
+		for range restarted - 1 {
+			out = append(out, termination{action: types.ActionRestart, finishedAt: t.finishedAt})
+		}
+
+		return append(out, t)
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+// requestCount turns the cumulative request counter into the number of
+// requests served since the previous scrape.
+type requestCount struct {
+	last float64
+	seen bool
+}
+
+func (r *requestCount) delta(sample knativeSample) uint64 {
+	if !sample.hasRequests {
+		return 0
+	}
+
+	prev, seen := r.last, r.seen
+	r.last, r.seen = sample.requests, true
+
+	// unlike CPU the first scrape counts in full: the queue-proxy starts with
+	// the pod, and the request that woke the function up must not be lost. A
+	// counter that went backwards started over from zero.
+	if !seen || sample.requests < prev {
+		return uint64(sample.requests)
+	}
+
+	return uint64(sample.requests - prev)
+}
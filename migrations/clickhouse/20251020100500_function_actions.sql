@@ -0,0 +1,69 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metrics.function_actions_kafka (
+    pod          String,
+    action       String,
+    timestamp    Int64,
+    tenant       String,
+    revision     String,
+    replica      String,
+    reason       String,
+    exit_code    Int32,
+    started_at   Int64
+) ENGINE = Kafka
+SETTINGS
+    kafka_broker_list = 'kafka:29092',
+    kafka_topic_list = 'function_actions',
+    kafka_group_name = 'function_actions_clickhouse',
+    kafka_format = 'JSONEachRow',
+    kafka_skip_broken_messages = 100,
+    input_format_skip_unknown_fields = 1;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metrics.function_actions_local (
+    pod          String,
+    action       LowCardinality(String),
+    timestamp    DateTime,
+    tenant       String,
+    revision     String,
+    replica      String,
+    reason       String,
+    exit_code    Int32,
+    started_at   DateTime
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (tenant, pod, replica, timestamp);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_actions_mv
+TO metrics.function_actions_local
+AS
+SELECT
+    pod,
+    action,
+    toDateTime(timestamp) AS timestamp,
+    tenant,
+    revision,
+    replica,
+    reason,
+    exit_code,
+    toDateTime(started_at) AS started_at
+FROM metrics.function_actions_kafka
+WHERE action != '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_actions_mv;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS metrics.function_actions_local;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS metrics.function_actions_kafka;
+-- +goose StatementEnd
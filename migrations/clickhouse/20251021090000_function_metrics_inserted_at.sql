@@ -0,0 +1,52 @@
+-- +goose Up
+-- rows written before this migration get the epoch, so they always count as
+-- arrived before any billing period was closed
+-- +goose StatementBegin
+ALTER TABLE metrics.function_metrics_local
+    ADD COLUMN IF NOT EXISTS inserted_at DateTime DEFAULT toDateTime(0);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_metrics_mv;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_metrics_mv
+TO metrics.function_metrics_local
+AS
+SELECT
+    pod,
+    cpu_percent,
+    mem_mb,
+    toDateTime(timestamp) AS timestamp,
+    tenant,
+    revision,
+    replica,
+    now() AS inserted_at
+FROM metrics.function_metrics_kafka;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_metrics_mv;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_metrics_mv
+TO metrics.function_metrics_local
+AS
+SELECT
+    pod,
+    cpu_percent,
+    mem_mb,
+    toDateTime(timestamp) AS timestamp,
+    tenant,
+    revision,
+    replica
+FROM metrics.function_metrics_kafka;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE metrics.function_metrics_local
+    DROP COLUMN IF EXISTS inserted_at;
+-- +goose StatementEnd
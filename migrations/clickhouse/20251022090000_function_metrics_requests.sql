@@ -0,0 +1,95 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE metrics.function_metrics_local
+    ADD COLUMN IF NOT EXISTS requests UInt64 DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_metrics_mv;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS metrics.function_metrics_kafka;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metrics.function_metrics_kafka (
+    pod          String,
+    cpu_percent  Float32,
+    mem_mb       Float32,
+    timestamp    Int64,
+    tenant       String,
+    revision     String,
+    replica      String,
+    requests     UInt64
+) ENGINE = Kafka
+SETTINGS
+    kafka_broker_list = 'kafka:29092',
+    kafka_topic_list = 'function_metrics',
+    kafka_group_name = 'function_metrics_clickhouse',
+    kafka_format = 'JSONEachRow';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_metrics_mv
+TO metrics.function_metrics_local
+AS
+SELECT
+    pod,
+    cpu_percent,
+    mem_mb,
+    toDateTime(timestamp) AS timestamp,
+    tenant,
+    revision,
+    replica,
+    requests,
+    now() AS inserted_at
+FROM metrics.function_metrics_kafka;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_metrics_mv;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS metrics.function_metrics_kafka;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metrics.function_metrics_kafka (
+    pod          String,
+    cpu_percent  Float32,
+    mem_mb       Float32,
+    timestamp    Int64,
+    tenant       String,
+    revision     String,
+    replica      String
+) ENGINE = Kafka
+SETTINGS
+    kafka_broker_list = 'kafka:29092',
+    kafka_topic_list = 'function_metrics',
+    kafka_group_name = 'function_metrics_clickhouse',
+    kafka_format = 'JSONEachRow';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_metrics_mv
+TO metrics.function_metrics_local
+AS
+SELECT
+    pod,
+    cpu_percent,
+    mem_mb,
+    toDateTime(timestamp) AS timestamp,
+    tenant,
+    revision,
+    replica,
+    now() AS inserted_at
+FROM metrics.function_metrics_kafka;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE metrics.function_metrics_local
+    DROP COLUMN IF EXISTS requests;
+-- +goose StatementEnd
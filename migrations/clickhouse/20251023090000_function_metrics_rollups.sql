@@ -0,0 +1,185 @@
+-- +goose Up
+-- Rollups of function_metrics_local keyed by tenant, function and pod. The
+-- minute rollup also keys on the insertion minute, so invoicer can apply its
+-- late usage watermark to it; billing windows are aligned to whole minutes,
+-- which makes filtering the buckets select exactly the raw rows.
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metrics.function_metrics_1m (
+    tenant           String,
+    function_name    String,
+    replica_name     String,
+    time_bucket      DateTime,
+    inserted_bucket  DateTime,
+    samples          SimpleAggregateFunction(sum, UInt64),
+    mem_mb           SimpleAggregateFunction(sum, Float64),
+    cpu_percent      SimpleAggregateFunction(sum, Float64),
+    requests         SimpleAggregateFunction(sum, UInt64),
+    first_seen       SimpleAggregateFunction(min, DateTime),
+    last_seen        SimpleAggregateFunction(max, DateTime)
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY toYYYYMM(time_bucket)
+ORDER BY (tenant, function_name, replica_name, time_bucket, inserted_bucket)
+TTL time_bucket + INTERVAL 400 DAY;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metrics.function_metrics_1h (
+    tenant           String,
+    function_name    String,
+    replica_name     String,
+    time_bucket      DateTime,
+    samples          SimpleAggregateFunction(sum, UInt64),
+    mem_mb           SimpleAggregateFunction(sum, Float64),
+    cpu_percent      SimpleAggregateFunction(sum, Float64),
+    requests         SimpleAggregateFunction(sum, UInt64),
+    first_seen       SimpleAggregateFunction(min, DateTime),
+    last_seen        SimpleAggregateFunction(max, DateTime)
+)
+ENGINE = AggregatingMergeTree
+PARTITION BY toYYYYMM(time_bucket)
+ORDER BY (tenant, function_name, replica_name, time_bucket)
+-- kept as long as the minute rollup, so hourly and minute reports cover the
+-- same range
+TTL time_bucket + INTERVAL 400 DAY;
+-- +goose StatementEnd
+
+-- ingestion from Kafka is paused while the rollups are set up, so every raw
+-- row is either backfilled below or rolled up by the views, never both.
+-- Kafka keeps the messages that arrive meanwhile for the consumer group.
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_metrics_mv;
+-- +goose StatementEnd
+
+-- the views are created first, the rows they miss are backfilled after
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_metrics_1m_mv
+TO metrics.function_metrics_1m
+AS
+SELECT
+    tenant,
+    pod AS function_name,
+    if(replica = '', pod, replica) AS replica_name,
+    toStartOfMinute(timestamp) AS time_bucket,
+    toStartOfMinute(inserted_at) AS inserted_bucket,
+    count() AS samples,
+    sum(toFloat64(mem_mb)) AS mem_mb,
+    sum(toFloat64(cpu_percent)) AS cpu_percent,
+    sum(requests) AS requests,
+    min(timestamp) AS first_seen,
+    max(timestamp) AS last_seen
+FROM metrics.function_metrics_local
+GROUP BY tenant, function_name, replica_name, time_bucket, inserted_bucket;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_metrics_1h_mv
+TO metrics.function_metrics_1h
+AS
+SELECT
+    tenant,
+    pod AS function_name,
+    if(replica = '', pod, replica) AS replica_name,
+    toStartOfHour(timestamp) AS time_bucket,
+    count() AS samples,
+    sum(toFloat64(mem_mb)) AS mem_mb,
+    sum(toFloat64(cpu_percent)) AS cpu_percent,
+    sum(requests) AS requests,
+    min(timestamp) AS first_seen,
+    max(timestamp) AS last_seen
+FROM metrics.function_metrics_local
+GROUP BY tenant, function_name, replica_name, time_bucket;
+-- +goose StatementEnd
+
+-- backfill every raw row: ingestion is paused, so these are exactly the rows
+-- inserted before the views existed. A previous, failed run of this migration may have backfilled part
+-- of it already: nothing else writes to the rollups yet, so they are emptied
+-- first and the backfill is not counted twice.
+-- +goose StatementBegin
+TRUNCATE TABLE IF EXISTS metrics.function_metrics_1m;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+TRUNCATE TABLE IF EXISTS metrics.function_metrics_1h;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO metrics.function_metrics_1m
+SELECT
+    tenant,
+    pod AS function_name,
+    if(replica = '', pod, replica) AS replica_name,
+    toStartOfMinute(timestamp) AS time_bucket,
+    toStartOfMinute(inserted_at) AS inserted_bucket,
+    count() AS samples,
+    sum(toFloat64(mem_mb)) AS mem_mb,
+    sum(toFloat64(cpu_percent)) AS cpu_percent,
+    sum(requests) AS requests,
+    min(timestamp) AS first_seen,
+    max(timestamp) AS last_seen
+FROM metrics.function_metrics_local
+GROUP BY tenant, function_name, replica_name, time_bucket, inserted_bucket;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO metrics.function_metrics_1h
+SELECT
+    tenant,
+    pod AS function_name,
+    if(replica = '', pod, replica) AS replica_name,
+    toStartOfHour(timestamp) AS time_bucket,
+    count() AS samples,
+    sum(toFloat64(mem_mb)) AS mem_mb,
+    sum(toFloat64(cpu_percent)) AS cpu_percent,
+    sum(requests) AS requests,
+    min(timestamp) AS first_seen,
+    max(timestamp) AS last_seen
+FROM metrics.function_metrics_local
+GROUP BY tenant, function_name, replica_name, time_bucket;
+-- +goose StatementEnd
+
+-- resume ingestion, the views roll up every row inserted from now on
+-- +goose StatementBegin
+CREATE MATERIALIZED VIEW IF NOT EXISTS metrics.function_metrics_mv
+TO metrics.function_metrics_local
+AS
+SELECT
+    pod,
+    cpu_percent,
+    mem_mb,
+    toDateTime(timestamp) AS timestamp,
+    tenant,
+    revision,
+    replica,
+    requests,
+    now() AS inserted_at
+FROM metrics.function_metrics_kafka;
+-- +goose StatementEnd
+
+-- raw samples are only kept for debugging once they are rolled up
+-- +goose StatementBegin
+ALTER TABLE metrics.function_metrics_local
+    MODIFY TTL timestamp + INTERVAL 30 DAY;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE metrics.function_metrics_local
+    REMOVE TTL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_metrics_1h_mv;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP VIEW IF EXISTS metrics.function_metrics_1m_mv;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS metrics.function_metrics_1h;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS metrics.function_metrics_1m;
+-- +goose StatementEnd
@@ -4,14 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-gomail/gomail"
 	"github.com/kelseyhightower/envconfig"
 
 	"github.com/usamaroman/faas_demo/pkg/kafka"
 	"github.com/usamaroman/faas_demo/pkg/logger"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/observability"
 )
 
 type Config struct {
@@ -26,15 +34,85 @@ type Config struct {
 		ConsumerGroupID string   `envconfig:"KAFKA_CONSUMER_GROUP_ID" required:"true"`
 		Addrs           []string `envconfig:"KAFKA_ADDRS" required:"true"`
 	}
+	HTTP struct {
+		Addr string `envconfig:"HTTP_ADDR" default:":8087"`
+	}
+	// InvoicerURL is where invoice PDFs are downloaded from, without it
+	// invoice emails go out without the attachment.
+	InvoicerURL string `envconfig:"INVOICER_URL"`
 }
 
+// kindBudget marks budget alerts, kindInvoice issued invoices and kindDunning
+// reminders of overdue invoices, every other message (kind stop, or no kind
+// from older invoicers) is a stop notification. budgetHardCap is the alert of a budget that scaled the
+// tenant's functions to zero.
+const (
+	kindBudget    = "budget"
+	kindInvoice   = "invoice"
+	kindDunning   = "dunning"
+	budgetHardCap = "hard_cap"
+)
+
 type NotificationMessage struct {
-	TenantID  string  `json:"tenant_id"`
-	Email     string  `json:"email"`
-	MemoryMB  float64 `json:"memory_mb"`
-	TotalCost float64 `json:"total_cost"`
-	PodName   string  `json:"pod_name"`
-	Timestamp int64   `json:"timestamp"`
+	Kind      string        `json:"kind"`
+	TenantID  string        `json:"tenant_id"`
+	Email     string        `json:"email"`
+	MemoryMB  float64       `json:"memory_mb"`
+	CPUSec    float64       `json:"cpu_sec"`
+	CPUCost   money.Decimal `json:"cpu_cost"`
+	TotalCost money.Decimal `json:"total_cost"`
+	Currency  string        `json:"currency"`
+	Precision int32         `json:"precision"`
+	Rounding  string        `json:"rounding"`
+	PodName   string        `json:"pod_name"`
+	Timestamp int64         `json:"timestamp"`
+	Budget    *BudgetAlert  `json:"budget,omitempty"`
+	Invoice   *Invoice      `json:"invoice,omitempty"`
+}
+
+// BudgetAlert is a budget threshold the tenant crossed this month. Kind is
+// hard_cap when the tenant's functions were scaled to zero.
+type BudgetAlert struct {
+	Kind        string        `json:"kind"`
+	Threshold   int           `json:"threshold"`
+	Spend       money.Decimal `json:"spend"`
+	Amount      money.Decimal `json:"amount"`
+	PeriodStart time.Time     `json:"period_start"`
+}
+
+// Invoice is the header of an issued invoice. FX is set for invoices
+// converted from the billing currency, DueAt for invoices with payment terms.
+type Invoice struct {
+	Number       int64         `json:"number"`
+	PeriodStart  time.Time     `json:"period_start"`
+	PeriodEnd    time.Time     `json:"period_end"`
+	Tax          money.Decimal `json:"tax"`
+	AmountDue    money.Decimal `json:"amount_due"`
+	FX           *FXRate       `json:"fx,omitempty"`
+	DueAt        *time.Time    `json:"due_at,omitempty"`
+	DunningLevel int           `json:"dunning_level"`
+}
+
+// FXRate is what one unit of Base was worth in Currency on Date.
+type FXRate struct {
+	Base     string        `json:"base"`
+	Currency string        `json:"currency"`
+	Rate     money.Decimal `json:"rate"`
+	Date     time.Time     `json:"date"`
+}
+
+// policy formats the costs the way invoicer rounds them. Messages from
+// invoicers without currency support are in US dollars.
+func (n NotificationMessage) policy() money.Policy {
+	currency, err := money.ParseCurrency(n.Currency)
+	if err != nil {
+		return money.DefaultPolicy()
+	}
+	rounding, err := money.ParseRounding(n.Rounding)
+	if err != nil {
+		rounding = money.RoundHalfEven
+	}
+	return money.Policy{Currency: currency, Precision: n.Precision, Rounding: rounding}
 }
 
 func main() {
@@ -56,6 +134,19 @@ func main() {
 	consumer := kafka.NewConsumer(consumerCfg)
 	ctx := context.Background()
 
+	health := observability.NewHealth()
+	health.Add("kafka", observability.TCPCheck(cfg.Kafka.Addrs...))
+	health.Add("smtp", observability.TCPCheck(net.JoinHostPort(cfg.SMTP.Host, strconv.Itoa(cfg.SMTP.Port))))
+
+	mux := http.NewServeMux()
+	health.Register(mux)
+	go func() {
+		slog.Info("listening http", slog.String("addr", cfg.HTTP.Addr))
+		if err := http.ListenAndServe(cfg.HTTP.Addr, observability.Middleware(mux)); err != nil {
+			slog.Error("http server error", slog.String("error", err.Error()))
+		}
+	}()
+
 	for {
 		msg, err := consumer.ReadMessage(ctx)
 		if err != nil {
@@ -69,18 +160,67 @@ func main() {
 			continue
 		}
 
+		policy := notification.policy()
+
 		slog.Info("processing notification",
+			slog.String("kind", notification.Kind),
 			slog.String("tenant_id", notification.TenantID),
 			slog.String("email", notification.Email),
 			slog.Float64("memory_mb", notification.MemoryMB),
-			slog.Float64("total_cost", notification.TotalCost),
+			slog.Float64("cpu_sec", notification.CPUSec),
+			slog.String("total_cost", policy.Format(notification.TotalCost)),
 			slog.String("pod_name", notification.PodName))
 
+		subject, body := stopEmail(notification, policy)
+		var attachment []byte
+		switch {
+		case notification.Kind == kindBudget && notification.Budget != nil:
+			subject, body = budgetEmail(notification, policy)
+		case notification.Kind == kindInvoice && notification.Invoice != nil:
+			subject, body = invoiceEmail(notification, policy)
+			if cfg.InvoicerURL != "" {
+				attachment, err = invoicePDF(ctx, cfg.InvoicerURL, notification.Invoice.Number)
+				if err != nil {
+					slog.Error("failed to download invoice pdf, sending the email without it",
+						slog.Int64("number", notification.Invoice.Number),
+						slog.String("error", err.Error()))
+				}
+			}
+		case notification.Kind == kindDunning && notification.Invoice != nil:
+			subject, body = dunningEmail(notification, policy)
+		}
+
 		m := gomail.NewMessage()
 		m.SetHeader("From", cfg.SMTP.Username)
 		m.SetHeader("To", notification.Email)
-		m.SetHeader("Subject", "FaaS Billing Notification - Time to Pay!")
-		m.SetBody("text/html", fmt.Sprintf(`
+		m.SetHeader("Subject", subject)
+		m.SetBody("text/html", body)
+		if len(attachment) > 0 {
+			m.Attach(fmt.Sprintf("invoice-%d.pdf", notification.Invoice.Number),
+				gomail.SetHeader(map[string][]string{"Content-Type": {"application/pdf"}}),
+				gomail.SetCopyFunc(func(w io.Writer) error {
+					_, err := w.Write(attachment)
+					return err
+				}))
+		}
+
+		d := gomail.NewDialer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password)
+		err = d.DialAndSend(m)
+		observability.EmailSent(err)
+		if err != nil {
+			slog.Error("failed to send email",
+				slog.String("error", err.Error()),
+				slog.String("email", notification.Email))
+		} else {
+			slog.Info("email sent successfully",
+				slog.String("email", notification.Email),
+				slog.String("tenant_id", notification.TenantID))
+		}
+	}
+}
+
+func stopEmail(notification NotificationMessage, policy money.Policy) (string, string) {
+	return "FaaS Billing Notification - Time to Pay!", fmt.Sprintf(`
 			<html>
 			<body>
 				<h2>FaaS Billing Notification</h2>
@@ -89,25 +229,133 @@ func main() {
 				<ul>
 					<li><strong>Pod Name:</strong> %s</li>
 					<li><strong>Memory Used:</strong> %.2f MB</li>
-					<li><strong>Total Cost:</strong> $%.2f</li>
+					<li><strong>CPU Used:</strong> %.2f CPU-seconds (%s)</li>
+					<li><strong>Total Cost:</strong> %s</li>
 					<li><strong>Timestamp:</strong> %s</li>
 				</ul>
 				<p>Please ensure payment is processed for your FaaS usage.</p>
 				<p>Best regards,<br>FaaS Team</p>
 			</body>
 			</html>
-		`, notification.TenantID, notification.PodName, notification.MemoryMB, notification.TotalCost,
-			fmt.Sprintf("%d", notification.Timestamp)))
+		`, notification.TenantID, notification.PodName, notification.MemoryMB,
+		notification.CPUSec, policy.Format(notification.CPUCost), policy.Format(notification.TotalCost),
+		fmt.Sprintf("%d", notification.Timestamp))
+}
 
-		d := gomail.NewDialer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password)
-		if err := d.DialAndSend(m); err != nil {
-			slog.Error("failed to send email",
-				slog.String("error", err.Error()),
-				slog.String("email", notification.Email))
-		} else {
-			slog.Info("email sent successfully",
-				slog.String("email", notification.Email),
-				slog.String("tenant_id", notification.TenantID))
-		}
+func budgetEmail(notification NotificationMessage, policy money.Policy) (string, string) {
+	alert := notification.Budget
+
+	subject := fmt.Sprintf("FaaS Budget Alert - %d%% of your monthly budget used", alert.Threshold)
+	action := "Your functions keep running. Raise the budget or reduce usage to avoid surprises on your invoice."
+	if alert.Kind == budgetHardCap {
+		subject = "FaaS Budget Alert - your functions were scaled to zero"
+		action = "Your budget has a hard cap, so your functions were scaled to zero and stay there while idle. Raise the budget or turn the hard cap off to keep them running."
 	}
+
+	return subject, fmt.Sprintf(`
+			<html>
+			<body>
+				<h2>FaaS Budget Alert</h2>
+				<p>Dear %s,</p>
+				<p>Your spend this month has reached %d%% of your budget:</p>
+				<ul>
+					<li><strong>Month:</strong> %s</li>
+					<li><strong>Spent so far:</strong> %s</li>
+					<li><strong>Monthly budget:</strong> %s</li>
+				</ul>
+				<p>%s</p>
+				<p>Best regards,<br>FaaS Team</p>
+			</body>
+			</html>
+		`, notification.TenantID, alert.Threshold, alert.PeriodStart.Format("January 2006"),
+		policy.Format(alert.Spend), policy.Format(alert.Amount), action)
+}
+
+func invoiceEmail(notification NotificationMessage, policy money.Policy) (string, string) {
+	inv := notification.Invoice
+
+	details := ""
+	if inv.Tax.IsPositive() {
+		details = fmt.Sprintf("<li><strong>Tax included:</strong> %s</li>", policy.Format(inv.Tax))
+	}
+	if inv.DueAt != nil {
+		details += fmt.Sprintf("<li><strong>Due by:</strong> %s</li>", inv.DueAt.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	if inv.FX != nil {
+		details += fmt.Sprintf("<li><strong>Exchange rate:</strong> 1 %s = %s %s (%s)</li>",
+			inv.FX.Base, inv.FX.Rate, inv.FX.Currency, inv.FX.Date.UTC().Format(time.DateOnly))
+	}
+
+	return fmt.Sprintf("FaaS Invoice #%d", inv.Number), fmt.Sprintf(`
+			<html>
+			<body>
+				<h2>FaaS Invoice #%d</h2>
+				<p>Dear %s,</p>
+				<p>Your invoice for the period below has been issued:</p>
+				<ul>
+					<li><strong>Period:</strong> %s - %s</li>
+					<li><strong>Amount due:</strong> %s</li>
+					%s
+				</ul>
+				<p>The invoice is attached as a PDF.</p>
+				<p>Best regards,<br>FaaS Team</p>
+			</body>
+			</html>
+		`, inv.Number, notification.TenantID,
+		inv.PeriodStart.UTC().Format("2006-01-02 15:04"), inv.PeriodEnd.UTC().Format("2006-01-02 15:04 UTC"),
+		policy.Format(inv.AmountDue), details)
+}
+
+// dunningEmail reminds the tenant of an overdue invoice. TotalCost is what is
+// still owed after partial payments.
+func dunningEmail(notification NotificationMessage, policy money.Policy) (string, string) {
+	inv := notification.Invoice
+
+	due := ""
+	if inv.DueAt != nil {
+		due = fmt.Sprintf("<li><strong>Was due by:</strong> %s</li>", inv.DueAt.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+
+	return fmt.Sprintf("FaaS Payment Reminder - invoice #%d is overdue", inv.Number), fmt.Sprintf(`
+			<html>
+			<body>
+				<h2>FaaS Payment Reminder</h2>
+				<p>Dear %s,</p>
+				<p>We have not received full payment of invoice #%d yet (reminder %d):</p>
+				<ul>
+					<li><strong>Period:</strong> %s - %s</li>
+					<li><strong>Amount due:</strong> %s</li>
+					<li><strong>Still owed:</strong> %s</li>
+					%s
+				</ul>
+				<p>Please pay the invoice as soon as possible. If you have paid it already, please ignore this email.</p>
+				<p>Best regards,<br>FaaS Team</p>
+			</body>
+			</html>
+		`, notification.TenantID, inv.Number, inv.DunningLevel,
+		inv.PeriodStart.UTC().Format("2006-01-02 15:04"), inv.PeriodEnd.UTC().Format("2006-01-02 15:04 UTC"),
+		policy.Format(inv.AmountDue), policy.Format(notification.TotalCost), due)
+}
+
+var invoicer = &http.Client{Timeout: 30 * time.Second}
+
+// invoicePDF downloads the rendered invoice from invoicer.
+func invoicePDF(ctx context.Context, baseURL string, number int64) ([]byte, error) {
+	url := fmt.Sprintf("%s/v1/invoices/%d.pdf", strings.TrimRight(baseURL, "/"), number)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := invoicer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invoicer returned status %d for %s", resp.StatusCode, url)
+	}
+
+	return io.ReadAll(resp.Body)
 }
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/observability"
 
 	click "github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
@@ -56,6 +59,42 @@ func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
+// Query runs query and times it until the returned rows are closed, since
+// ClickHouse streams the result while it is read.
 func (c *Client) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
-	return c.conn.Query(ctx, query, args...)
+	started := time.Now()
+	rows, err := c.conn.Query(ctx, query, args...)
+	if err != nil {
+		observability.ObserveDBQuery("clickhouse", started, err)
+		return nil, err
+	}
+
+	return &observedRows{Rows: rows, started: started}, nil
+}
+
+// observedRows records the query once the rows are read and closed.
+type observedRows struct {
+	driver.Rows
+	started time.Time
+	closed  bool
+}
+
+func (r *observedRows) Close() error {
+	err := r.Rows.Close()
+	if r.closed {
+		return err
+	}
+	r.closed = true
+
+	failed := err
+	if failed == nil {
+		failed = r.Rows.Err()
+	}
+	observability.ObserveDBQuery("clickhouse", r.started, failed)
+
+	return err
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx)
 }
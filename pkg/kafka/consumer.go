@@ -1,19 +1,65 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	"github.com/segmentio/kafka-go"
+
+	"github.com/usamaroman/faas_demo/pkg/observability"
 )
 
 type ConsumerConfig struct {
-	Topic   string
+	Topic string
+	// GroupID commits offsets for the group. Without one NewConsumer reads
+	// only the first partition, use NewPartitionConsumers to read them all.
 	GroupID string
 	Addrs   []string
 }
 
 func NewConsumer(cfg ConsumerConfig) *kafka.Reader {
-	return kafka.NewReader(kafka.ReaderConfig{
+	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers: cfg.Addrs,
 		GroupID: cfg.GroupID,
 		Topic:   cfg.Topic,
 	})
+	observability.TrackReader(r)
+
+	return r
+}
+
+// NewPartitionConsumers returns a reader per partition of the topic, each from
+// its first offset, for state every replica rebuilds from the whole topic on
+// start. Partitions added to the topic later are read after a restart.
+func NewPartitionConsumers(ctx context.Context, cfg ConsumerConfig) ([]*kafka.Reader, error) {
+	var (
+		partitions []kafka.Partition
+		errs       []error
+	)
+	for _, addr := range cfg.Addrs {
+		var err error
+		partitions, err = kafka.DefaultDialer.LookupPartitions(ctx, "tcp", addr, cfg.Topic)
+		if err == nil {
+			break
+		}
+		errs = append(errs, err)
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("no partitions of topic %s: %w", cfg.Topic, errors.Join(errs...))
+	}
+
+	readers := make([]*kafka.Reader, 0, len(partitions))
+	for _, p := range partitions {
+		r := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     cfg.Addrs,
+			Topic:       cfg.Topic,
+			Partition:   p.ID,
+			StartOffset: kafka.FirstOffset,
+		})
+		observability.TrackReader(r)
+		readers = append(readers, r)
+	}
+
+	return readers, nil
 }
@@ -4,13 +4,25 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/segmentio/kafka-go"
+
+	"github.com/usamaroman/faas_demo/pkg/observability"
 )
 
 type ProducerConfig struct {
 	Topic string
 	Addrs []string
+	// BatchSize and BatchTimeout tune the writer's internal batching,
+	// zero values keep the kafka-go defaults (100 messages / 1s).
+	BatchSize    int
+	BatchTimeout time.Duration
+	// Compact creates the topic with cleanup.policy=compact, so Kafka keeps
+	// the last message of every key. It suits topics holding a state by key
+	// that consumers rebuild from the start. A topic that already exists is
+	// left as it is.
+	Compact bool
 }
 
 func NewProducer(cfg ProducerConfig) *kafka.Writer {
@@ -20,18 +32,27 @@ func NewProducer(cfg ProducerConfig) *kafka.Writer {
 		os.Exit(1)
 	}
 
-	if err := conn.CreateTopics(kafka.TopicConfig{
+	topic := kafka.TopicConfig{
 		Topic:             cfg.Topic,
 		ReplicationFactor: -1,
 		NumPartitions:     -1,
-	}); err != nil {
+	}
+	if cfg.Compact {
+		topic.ConfigEntries = []kafka.ConfigEntry{{ConfigName: "cleanup.policy", ConfigValue: "compact"}}
+	}
+	if err := conn.CreateTopics(topic); err != nil {
 		slog.Error("failed to create topic", slog.String("topic", cfg.Topic), slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 
-	return kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  cfg.Addrs,
-		Topic:    cfg.Topic,
-		Balancer: &kafka.Hash{}, // hash for partitions
+	w := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      cfg.Addrs,
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{}, // hash for partitions
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: cfg.BatchTimeout,
 	})
+	observability.TrackWriter(w)
+
+	return w
 }
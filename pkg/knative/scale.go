@@ -0,0 +1,82 @@
+package knative
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// TenantAnnotation holds the tenant a service was created for.
+const TenantAnnotation = "tenant"
+
+// visibilityLabel takes a Knative service off the ingress when set to
+// cluster-local.
+const visibilityLabel = "networking.knative.dev/visibility"
+
+// ScaleToZero stops every service of the tenant in the namespace: it takes
+// the service off the ingress, so no outside traffic reaches it, and drops its
+// minimum scale to zero, so Knative removes the replicas once they are idle.
+// It returns the names of the services it changed. Patching a service that
+// is stopped already changes nothing.
+func ScaleToZero(ctx context.Context, restConfig *rest.Config, namespace, tenant string) ([]string, error) {
+	dc, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		slog.Error("failed to construct dynamic client", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	// tenants are emails, which are not valid label values, so services are
+	// matched by annotation
+	list, err := dc.Resource(knativeServiceGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing knative services in %s: %w", namespace, err)
+	}
+
+	patch, err := stopPatch()
+	if err != nil {
+		return nil, err
+	}
+
+	var scaled []string
+	for _, svc := range list.Items {
+		if svc.GetAnnotations()[TenantAnnotation] != tenant {
+			continue
+		}
+
+		_, err := dc.Resource(knativeServiceGVR).Namespace(namespace).Patch(ctx, svc.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return scaled, fmt.Errorf("scaling knative service %s/%s to zero: %w", namespace, svc.GetName(), err)
+		}
+		scaled = append(scaled, svc.GetName())
+	}
+
+	return scaled, nil
+}
+
+// stopPatch is the merge patch ScaleToZero applies. Lowering maxScale cannot
+// stop a service, Knative reads a maxScale of zero as no limit, so a service
+// still getting traffic is taken off the ingress instead.
+func stopPatch() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]string{
+				visibilityLabel: "cluster-local",
+			},
+		},
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						"autoscaling.knative.dev/minScale": "0",
+					},
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,18 @@
+package knative
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_StopPatch(t *testing.T) {
+	patch, err := stopPatch()
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"metadata": {"labels": {"networking.knative.dev/visibility": "cluster-local"}},
+		"spec": {"template": {"metadata": {"annotations": {"autoscaling.knative.dev/minScale": "0"}}}}
+	}`, string(patch))
+}
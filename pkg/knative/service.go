@@ -107,6 +107,13 @@ func buildKnativeServiceObject(cfg ServiceConfig) *unstructured.Unstructured {
 			"name":  "POD_NAME",
 			"value": cfg.ServiceName,
 		},
+		// replica identity comes from the downward API, so lifecycle events can be
+		// attributed to a concrete pod of a concrete revision
+		fieldRefEnv("REPLICA_ID", "metadata.name"),
+		fieldRefEnv("POD_NAMESPACE", "metadata.namespace"),
+		fieldRefEnv("REVISION", "metadata.labels['serving.knative.dev/revision']"),
+		// the user container's CPU is read from the kubelet of this node
+		fieldRefEnv("NODE_NAME", "spec.nodeName"),
 	}
 	if cfg.MeterURL != "" {
 		meterEnv = append(meterEnv, map[string]any{"name": "METER_URL", "value": cfg.MeterURL})
@@ -127,8 +134,9 @@ func buildKnativeServiceObject(cfg ServiceConfig) *unstructured.Unstructured {
 		"apiVersion": "serving.knative.dev/v1",
 		"kind":       "Service",
 		"metadata": map[string]any{
-			"name":      cfg.ServiceName,
-			"namespace": cfg.Namespace,
+			"name":        cfg.ServiceName,
+			"namespace":   cfg.Namespace,
+			"annotations": cfg.Annotations,
 		},
 		"spec": map[string]any{
 			"template": map[string]any{
@@ -144,3 +152,12 @@ func buildKnativeServiceObject(cfg ServiceConfig) *unstructured.Unstructured {
 
 	return service
 }
+
+func fieldRefEnv(name, fieldPath string) map[string]any {
+	return map[string]any{
+		"name": name,
+		"valueFrom": map[string]any{
+			"fieldRef": map[string]any{"fieldPath": fieldPath},
+		},
+	}
+}
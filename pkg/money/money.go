@@ -0,0 +1,183 @@
+// Package money holds the exact decimal arithmetic shared by the services
+// that price usage. Floats are never used for amounts: a per-second price is
+// often a fraction of a cent and float sums drift.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Decimal is an exact decimal number. It marshals to JSON as a string, reads
+// both strings and numbers, and maps to Postgres NUMERIC.
+type Decimal = decimal.Decimal
+
+// Scale is the number of fractional digits prices, quantities and unrounded
+// amounts are kept with. It matches the NUMERIC columns that store them.
+const Scale int32 = 10
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrUnknownRounding  = errors.New("unknown rounding mode")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+var Zero = decimal.Zero
+
+func NewFromFloat(f float64) Decimal {
+	return decimal.NewFromFloat(f)
+}
+
+func NewFromInt(i int64) Decimal {
+	return decimal.NewFromInt(i)
+}
+
+func Parse(s string) (Decimal, error) {
+	return decimal.NewFromString(s)
+}
+
+// MustParse is Parse for constants, it panics on malformed input.
+func MustParse(s string) Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// Currency is an ISO 4217 currency with the number of digits in its minor unit.
+type Currency struct {
+	Code      string
+	Precision int32
+}
+
+var (
+	USD = Currency{Code: "USD", Precision: 2}
+	EUR = Currency{Code: "EUR", Precision: 2}
+	RUB = Currency{Code: "RUB", Precision: 2}
+	JPY = Currency{Code: "JPY", Precision: 0}
+)
+
+var currencies = map[string]Currency{
+	USD.Code: USD,
+	EUR.Code: EUR,
+	RUB.Code: RUB,
+	JPY.Code: JPY,
+}
+
+func ParseCurrency(code string) (Currency, error) {
+	c, ok := currencies[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// Rounding is how an amount is brought down to a fixed number of digits.
+type Rounding string
+
+const (
+	// RoundHalfEven is banker's rounding, it does not bias sums of many amounts.
+	RoundHalfEven Rounding = "half_even"
+	RoundHalfUp   Rounding = "half_up"
+	// RoundDown truncates towards zero, never charging more than was used.
+	RoundDown Rounding = "down"
+	// RoundUp rounds away from zero, never charging less than was used.
+	RoundUp Rounding = "up"
+)
+
+func ParseRounding(s string) (Rounding, error) {
+	switch r := Rounding(strings.ToLower(strings.TrimSpace(s))); r {
+	case RoundHalfEven, RoundHalfUp, RoundDown, RoundUp:
+		return r, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownRounding, s)
+}
+
+// Round rounds d to places fractional digits.
+func (r Rounding) Round(d Decimal, places int32) Decimal {
+	switch r {
+	case RoundHalfUp:
+		return d.Round(places)
+	case RoundDown:
+		return d.RoundDown(places)
+	case RoundUp:
+		return d.RoundUp(places)
+	default:
+		return d.RoundBank(places)
+	}
+}
+
+// Money is an amount in a currency.
+type Money struct {
+	Amount   Decimal `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+func New(amount Decimal, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency.Code}
+}
+
+// Add sums two amounts of the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount.Add(o.Amount), Currency: m.Currency}, nil
+}
+
+func (m Money) String() string {
+	return m.Amount.String() + " " + m.Currency
+}
+
+// Policy decides how exact amounts become payable ones: the currency, how
+// many fractional digits are kept and how the rest is rounded.
+type Policy struct {
+	Currency  Currency
+	Precision int32
+	Rounding  Rounding
+}
+
+// NewPolicy builds a policy from configuration. A negative precision keeps
+// the currency's minor unit.
+func NewPolicy(currency string, precision int, rounding string) (Policy, error) {
+	c, err := ParseCurrency(currency)
+	if err != nil {
+		return Policy{}, err
+	}
+	r, err := ParseRounding(rounding)
+	if err != nil {
+		return Policy{}, err
+	}
+	if precision < 0 {
+		precision = int(c.Precision)
+	}
+	if precision > int(Scale) {
+		return Policy{}, fmt.Errorf("precision %d is above the supported scale %d", precision, Scale)
+	}
+
+	return Policy{Currency: c, Precision: int32(precision), Rounding: r}, nil
+}
+
+// DefaultPolicy bills in US dollars rounded half-even to cents.
+func DefaultPolicy() Policy {
+	return Policy{Currency: USD, Precision: USD.Precision, Rounding: RoundHalfEven}
+}
+
+// Round turns an exact amount into a payable one.
+func (p Policy) Round(d Decimal) Money {
+	return New(p.Rounding.Round(d, p.Precision), p.Currency)
+}
+
+// In returns the policy for amounts in another currency: rounded the same
+// way to that currency's minor unit.
+func (p Policy) In(c Currency) Policy {
+	if c.Code == p.Currency.Code {
+		return p
+	}
+	return Policy{Currency: c, Precision: c.Precision, Rounding: p.Rounding}
+}
+
+// Format renders d as a payable amount, e.g. "12.30 USD".
+func (p Policy) Format(d Decimal) string {
+	return p.Rounding.Round(d, p.Precision).StringFixed(p.Precision) + " " + p.Currency.Code
+}
@@ -0,0 +1,96 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExactSums(t *testing.T) {
+	price := MustParse("0.0000001")
+
+	total := Zero
+	for range 1_000_000 {
+		total = total.Add(price)
+	}
+
+	assert.True(t, total.Equal(MustParse("0.1")), total.String())
+}
+
+func Test_Rounding(t *testing.T) {
+	tests := []struct {
+		rounding Rounding
+		in       string
+		want     string
+	}{
+		{RoundHalfEven, "0.125", "0.12"},
+		{RoundHalfEven, "0.135", "0.14"},
+		{RoundHalfUp, "0.125", "0.13"},
+		{RoundDown, "0.129", "0.12"},
+		{RoundUp, "0.121", "0.13"},
+		{RoundHalfEven, "-0.125", "-0.12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.rounding)+" "+tt.in, func(t *testing.T) {
+			got := tt.rounding.Round(MustParse(tt.in), 2)
+			assert.True(t, got.Equal(MustParse(tt.want)), got.String())
+		})
+	}
+}
+
+func Test_Policy(t *testing.T) {
+	p, err := NewPolicy("usd", -1, "half_up")
+	require.NoError(t, err)
+	assert.Equal(t, USD, p.Currency)
+	assert.Equal(t, "0.01 USD", p.Format(MustParse("0.005")))
+	assert.Equal(t, "0.00 USD", p.Format(MustParse("0.0000012")))
+
+	p, err = NewPolicy("USD", 6, "half_even")
+	require.NoError(t, err)
+	assert.Equal(t, "0.000001 USD", p.Format(MustParse("0.0000012")))
+
+	p, err = NewPolicy("JPY", -1, "down")
+	require.NoError(t, err)
+	assert.Equal(t, New(MustParse("12"), JPY), p.Round(MustParse("12.9")))
+
+	// other currencies keep their minor unit and the rounding
+	p, err = NewPolicy("USD", 6, "down")
+	require.NoError(t, err)
+	assert.Equal(t, p, p.In(USD))
+	assert.Equal(t, Policy{Currency: JPY, Precision: 0, Rounding: RoundDown}, p.In(JPY))
+
+	_, err = NewPolicy("XXX", -1, "half_even")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+
+	_, err = NewPolicy("USD", -1, "ceil")
+	assert.ErrorIs(t, err, ErrUnknownRounding)
+
+	_, err = NewPolicy("USD", 12, "half_even")
+	assert.Error(t, err)
+}
+
+func Test_MoneyAdd(t *testing.T) {
+	sum, err := New(MustParse("1.10"), USD).Add(New(MustParse("2.25"), USD))
+	require.NoError(t, err)
+	assert.Equal(t, "3.35 USD", sum.String())
+
+	_, err = New(MustParse("1"), USD).Add(New(MustParse("1"), EUR))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+}
+
+func Test_DecimalJSON(t *testing.T) {
+	var v struct {
+		Price Decimal `json:"price"`
+	}
+
+	require.NoError(t, json.Unmarshal([]byte(`{"price": 0.0000012}`), &v))
+	assert.Equal(t, "0.0000012", v.Price.String())
+
+	require.NoError(t, json.Unmarshal([]byte(`{"price": "0.00000034"}`), &v))
+	out, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"price": "0.00000034"}`, string(out))
+}
@@ -0,0 +1,144 @@
+package observability
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Health serves liveness and readiness probes. Liveness only says the process
+// is up, readiness runs every registered dependency check.
+type Health struct {
+	mu      sync.RWMutex
+	checks  map[string]Check
+	timeout time.Duration
+}
+
+func NewHealth() *Health {
+	return &Health{
+		checks:  make(map[string]Check),
+		timeout: 2 * time.Second,
+	}
+}
+
+func (h *Health) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks[name] = check
+}
+
+// Register mounts /healthz, /readyz and /metrics on mux.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.Live)
+	mux.HandleFunc("/readyz", h.Ready)
+	mux.Handle("/metrics", Handler())
+}
+
+func (h *Health) Live(w http.ResponseWriter, _ *http.Request) {
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	h.mu.RLock()
+	checks := make(map[string]Check, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.RUnlock()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]string, len(checks))
+		status  = http.StatusOK
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result := "ok"
+			if err := check(ctx); err != nil {
+				slog.Warn("readiness check failed", slog.String("check", name), slog.String("error", err.Error()))
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if result != "ok" {
+				status = http.StatusServiceUnavailable
+			}
+		}()
+	}
+	wg.Wait()
+
+	writeStatus(w, status, results)
+}
+
+func writeStatus(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// TCPCheck succeeds when any of addrs accepts a TCP connection, which is enough
+// to tell whether a Kafka broker or an SMTP relay is reachable.
+func TCPCheck(addrs ...string) Check {
+	return func(ctx context.Context) error {
+		var (
+			d       net.Dialer
+			lastErr error
+		)
+		for _, addr := range addrs {
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			return conn.Close()
+		}
+		return lastErr
+	}
+}
+
+// HTTPCheck succeeds when url answers with a 2xx status.
+func HTTPCheck(url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &statusError{url: url, status: resp.StatusCode}
+		}
+		return nil
+	}
+}
+
+type statusError struct {
+	url    string
+	status int
+}
+
+func (e *statusError) Error() string {
+	return e.url + " returned " + http.StatusText(e.status)
+}
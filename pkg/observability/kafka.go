@@ -0,0 +1,111 @@
+package observability
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafka-go keeps its own counters and resets them every time Stats is called,
+// so the collector pulls the deltas on scrape and accumulates them.
+type kafkaCollector struct {
+	mu      sync.Mutex
+	writers []*kafka.Writer
+	readers []*kafka.Reader
+
+	produced       *prometheus.CounterVec
+	produceErrors  *prometheus.CounterVec
+	consumed       *prometheus.CounterVec
+	consumeErrors  *prometheus.CounterVec
+	consumerLag    *prometheus.GaugeVec
+	produceLatency *prometheus.GaugeVec
+}
+
+var kafkaStats = &kafkaCollector{
+	produced: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_produced_messages_total",
+		Help:      "Messages written to Kafka by topic.",
+	}, []string{"topic"}),
+	produceErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_produce_errors_total",
+		Help:      "Failed Kafka write attempts by topic.",
+	}, []string{"topic"}),
+	consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumed_messages_total",
+		Help:      "Messages read from Kafka by topic and consumer group.",
+	}, []string{"topic", "group"}),
+	consumeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_consume_errors_total",
+		Help:      "Failed Kafka reads by topic and consumer group.",
+	}, []string{"topic", "group"}),
+	consumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Messages between the consumer offsets and the partition ends, summed over the partitions read.",
+	}, []string{"topic", "group"}),
+	produceLatency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_produce_write_seconds_avg",
+		Help:      "Average batch write time since the previous scrape by topic.",
+	}, []string{"topic"}),
+}
+
+// TrackWriter exposes the writer's stats on /metrics.
+func TrackWriter(w *kafka.Writer) {
+	kafkaStats.mu.Lock()
+	defer kafkaStats.mu.Unlock()
+
+	kafkaStats.writers = append(kafkaStats.writers, w)
+}
+
+// TrackReader exposes the reader's stats, including its lag, on /metrics.
+func TrackReader(r *kafka.Reader) {
+	kafkaStats.mu.Lock()
+	defer kafkaStats.mu.Unlock()
+
+	kafkaStats.readers = append(kafkaStats.readers, r)
+}
+
+func (c *kafkaCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.produced.Describe(ch)
+	c.produceErrors.Describe(ch)
+	c.consumed.Describe(ch)
+	c.consumeErrors.Describe(ch)
+	c.consumerLag.Describe(ch)
+	c.produceLatency.Describe(ch)
+}
+
+func (c *kafkaCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	for _, w := range c.writers {
+		s := w.Stats()
+		c.produced.WithLabelValues(s.Topic).Add(float64(s.Messages))
+		c.produceErrors.WithLabelValues(s.Topic).Add(float64(s.Errors))
+		c.produceLatency.WithLabelValues(s.Topic).Set(s.WriteTime.Avg.Seconds())
+	}
+	// a reader per partition shares its topic and group with the others
+	lag := make(map[[2]string]int64)
+	for _, r := range c.readers {
+		s := r.Stats()
+		group := r.Config().GroupID
+		c.consumed.WithLabelValues(s.Topic, group).Add(float64(s.Messages))
+		c.consumeErrors.WithLabelValues(s.Topic, group).Add(float64(s.Errors))
+		lag[[2]string{s.Topic, group}] += s.Lag
+	}
+	for key, n := range lag {
+		c.consumerLag.WithLabelValues(key[0], key[1]).Set(float64(n))
+	}
+	c.mu.Unlock()
+
+	c.produced.Collect(ch)
+	c.produceErrors.Collect(ch)
+	c.consumed.Collect(ch)
+	c.consumeErrors.Collect(ch)
+	c.consumerLag.Collect(ch)
+	c.produceLatency.Collect(ch)
+}
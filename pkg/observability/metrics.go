@@ -0,0 +1,123 @@
+package observability
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "faas"
+
+// Registry holds every collector exposed on /metrics. A dedicated registry
+// keeps the output free of collectors registered by third-party packages.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of database queries by database and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"db", "status"})
+
+	EmailsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "Emails handed to the SMTP server by outcome (sent, failed).",
+	}, []string{"status"})
+
+	DeadLettersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Messages moved to a dead letter topic by source topic and reason.",
+	}, []string{"topic", "reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		DBQueryDuration,
+		EmailsTotal,
+		DeadLettersTotal,
+		kafkaStats,
+	)
+}
+
+// Register adds service specific collectors to the shared registry.
+func Register(cs ...prometheus.Collector) {
+	Registry.MustRegister(cs...)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTP records a finished HTTP request. Route must be the matched
+// pattern, not the raw path, to keep the label cardinality bounded.
+func ObserveHTTP(method, route string, status int, started time.Time) {
+	if route == "" {
+		route = "unmatched"
+	}
+	HTTPRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(time.Since(started).Seconds())
+}
+
+// ObserveDBQuery records a finished query against db ("clickhouse", "postgres").
+func ObserveDBQuery(db string, started time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	DBQueryDuration.WithLabelValues(db, status).Observe(time.Since(started).Seconds())
+}
+
+// EmailSent records the outcome of a single email delivery.
+func EmailSent(err error) {
+	if err != nil {
+		EmailsTotal.WithLabelValues("failed").Inc()
+		return
+	}
+	EmailsTotal.WithLabelValues("sent").Inc()
+}
+
+// DeadLettered records a message of topic given up on for reason.
+func DeadLettered(topic, reason string) {
+	DeadLettersTotal.WithLabelValues(topic, reason).Inc()
+}
+
+// NewSnapshotCollector exposes a set of counters kept outside of Prometheus,
+// e.g. the meter pipeline stats, as one counter family labelled by key.
+func NewSnapshotCollector(name, help, label string, snapshot func() map[string]uint64) prometheus.Collector {
+	return &snapshotCollector{
+		desc:     prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, []string{label}, nil),
+		snapshot: snapshot,
+	}
+}
+
+type snapshotCollector struct {
+	desc     *prometheus.Desc
+	snapshot func() map[string]uint64
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	for k, v := range c.snapshot() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(v), k)
+	}
+}
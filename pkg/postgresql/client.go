@@ -59,6 +59,8 @@ func New(cfg Config) (*Postgres, error) {
 		return nil, err
 	}
 
+	pgCfg.ConnConfig.Tracer = queryTracer{}
+
 	conn, err := pgxpool.NewWithConfig(ctx, pgCfg)
 	if err != nil {
 		slog.Error("pool constructor failed", slog.String("error", err.Error()))
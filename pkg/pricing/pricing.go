@@ -0,0 +1,268 @@
+// Package pricing turns usage quantities into amounts with flat, graduated and
+// volume price schedules. It is pure: no I/O and no floats, so price_service
+// can validate a schedule with the same code invoicer evaluates it with.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+// Billing dimensions a tariff prices.
+const (
+	DimensionExec     = "exec"
+	DimensionMemory   = "memory"
+	DimensionCPU      = "cpu"
+	DimensionRequests = "requests"
+)
+
+// Dimensions lists every dimension in invoice order.
+var Dimensions = []string{DimensionExec, DimensionMemory, DimensionCPU, DimensionRequests}
+
+// Model is how the tiers of a price apply to a quantity.
+type Model string
+
+const (
+	// ModelFlat prices every unit with the single tier.
+	ModelFlat Model = "flat"
+	// ModelGraduated prices every unit with the tier it falls into: the
+	// first 1M units at the first tier price, the rest at the next one.
+	ModelGraduated Model = "graduated"
+	// ModelVolume prices all the units with the tier the total falls into.
+	ModelVolume Model = "volume"
+)
+
+var (
+	ErrUnknownModel     = errors.New("unknown pricing model")
+	ErrUnknownDimension = errors.New("unknown pricing dimension")
+	ErrInvalidTiers     = errors.New("invalid price tiers")
+)
+
+// Tier prices the units up to and including UpTo. Tiers are ordered by UpTo,
+// the last one has a nil UpTo and takes everything above the previous tier.
+type Tier struct {
+	UpTo      *money.Decimal `json:"up_to,omitempty" swaggertype:"string" example:"1000000"`
+	UnitPrice money.Decimal  `json:"unit_price" swaggertype:"string" example:"0.0000000035"`
+}
+
+// Price is the price of one dimension.
+type Price struct {
+	Model Model  `json:"model"`
+	Tiers []Tier `json:"tiers"`
+}
+
+// Schedule holds the tiered prices of a tariff by dimension. Dimensions that
+// are not in it keep the tariff's flat price.
+type Schedule map[string]Price
+
+// Flat is a price with a single unit price.
+func Flat(unitPrice money.Decimal) Price {
+	return Price{Model: ModelFlat, Tiers: []Tier{{UnitPrice: unitPrice}}}
+}
+
+// Price is the price of a dimension: its tiers, or else the flat price.
+func (s Schedule) Price(dimension string, flat money.Decimal) Price {
+	if p, ok := s[dimension]; ok {
+		return p
+	}
+	return Flat(flat)
+}
+
+// Validate checks that the tiers are ordered, only the last one is open and
+// no price is negative.
+func (p Price) Validate() error {
+	switch p.Model {
+	case ModelFlat:
+		if len(p.Tiers) != 1 {
+			return fmt.Errorf("%w: a flat price has exactly one tier", ErrInvalidTiers)
+		}
+	case ModelGraduated, ModelVolume:
+		if len(p.Tiers) == 0 {
+			return fmt.Errorf("%w: no tiers", ErrInvalidTiers)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownModel, p.Model)
+	}
+
+	prev := money.Zero
+	for i, t := range p.Tiers {
+		if t.UnitPrice.IsNegative() {
+			return fmt.Errorf("%w: tier %d has a negative unit price", ErrInvalidTiers, i+1)
+		}
+
+		last := i == len(p.Tiers)-1
+		switch {
+		case last && t.UpTo != nil:
+			return fmt.Errorf("%w: the last tier must not have an upper bound", ErrInvalidTiers)
+		case !last && t.UpTo == nil:
+			return fmt.Errorf("%w: tier %d has no upper bound", ErrInvalidTiers, i+1)
+		case !last && !t.UpTo.GreaterThan(prev):
+			return fmt.Errorf("%w: tier %d must end above %s", ErrInvalidTiers, i+1, prev)
+		}
+		if t.UpTo != nil {
+			prev = *t.UpTo
+		}
+	}
+
+	return nil
+}
+
+// Validate checks every price of the schedule and its dimensions.
+func (s Schedule) Validate() error {
+	for dimension, p := range s {
+		if !known(dimension) {
+			return fmt.Errorf("%w: %q", ErrUnknownDimension, dimension)
+		}
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", dimension, err)
+		}
+	}
+	return nil
+}
+
+func known(dimension string) bool {
+	for _, d := range Dimensions {
+		if d == dimension {
+			return true
+		}
+	}
+	return false
+}
+
+// Allocation is the part of a quantity priced with one tier.
+// Amount = Quantity * UnitPrice, kept with money.Scale digits.
+type Allocation struct {
+	Tier      int
+	Quantity  money.Decimal
+	UnitPrice money.Decimal
+	Amount    money.Decimal
+}
+
+// Evaluate prices a single quantity.
+func (p Price) Evaluate(quantity money.Decimal) []Allocation {
+	return p.Allocate([]money.Decimal{quantity})[0]
+}
+
+// Allocate prices consecutive quantities that share the tiers, such as the
+// charges of one dimension in an invoice, oldest first. Under a graduated
+// price the earlier quantities fill the lower tiers and a quantity crossing a
+// tier bound gets an allocation per tier. Every quantity gets at least one
+// allocation, a zero one at the price its next unit would have.
+func (p Price) Allocate(quantities []money.Decimal) [][]Allocation {
+	out := make([][]Allocation, len(quantities))
+	if len(p.Tiers) == 0 {
+		for i, q := range quantities {
+			out[i] = []Allocation{allocation(0, q, money.Zero)}
+		}
+		return out
+	}
+
+	switch p.Model {
+	case ModelGraduated:
+		used := money.Zero
+		for i, q := range quantities {
+			out[i] = p.graduated(used, q)
+			used = used.Add(q)
+		}
+	case ModelVolume:
+		total := money.Zero
+		for _, q := range quantities {
+			total = total.Add(q)
+		}
+		tier := p.tierOf(total, false)
+		for i, q := range quantities {
+			out[i] = []Allocation{allocation(tier, q, p.Tiers[tier].UnitPrice)}
+		}
+	default:
+		for i, q := range quantities {
+			out[i] = []Allocation{allocation(0, q, p.Tiers[0].UnitPrice)}
+		}
+	}
+
+	return out
+}
+
+// graduated spreads quantity over the tiers, starting used units in.
+func (p Price) graduated(used, quantity money.Decimal) []Allocation {
+	tier := p.tierOf(used, true)
+	if !quantity.IsPositive() {
+		return []Allocation{allocation(tier, quantity, p.Tiers[tier].UnitPrice)}
+	}
+
+	var out []Allocation
+	left := quantity
+	for ; left.IsPositive() && tier < len(p.Tiers); tier++ {
+		t := p.Tiers[tier]
+		take := left
+		// the last tier takes the rest even if a bad schedule bounds it
+		if t.UpTo != nil && tier < len(p.Tiers)-1 {
+			if room := t.UpTo.Sub(used); room.LessThan(take) {
+				take = room
+			}
+		}
+		if !take.IsPositive() {
+			continue
+		}
+
+		out = append(out, allocation(tier, take, t.UnitPrice))
+		used = used.Add(take)
+		left = left.Sub(take)
+	}
+
+	return out
+}
+
+// tierOf finds the tier a quantity falls into. With next it is the tier of
+// the unit right after the quantity instead, the one a bound starts.
+func (p Price) tierOf(quantity money.Decimal, next bool) int {
+	for i, t := range p.Tiers {
+		if t.UpTo == nil {
+			return i
+		}
+		if quantity.LessThan(*t.UpTo) || (!next && quantity.Equal(*t.UpTo)) {
+			return i
+		}
+	}
+	return len(p.Tiers) - 1
+}
+
+func allocation(tier int, quantity, unitPrice money.Decimal) Allocation {
+	return Allocation{
+		Tier:      tier,
+		Quantity:  quantity,
+		UnitPrice: unitPrice,
+		Amount:    quantity.Mul(unitPrice).Round(money.Scale),
+	}
+}
+
+// Total sums the amounts of the allocations.
+func Total(allocations []Allocation) money.Decimal {
+	total := money.Zero
+	for _, a := range allocations {
+		total = total.Add(a.Amount)
+	}
+	return total
+}
+
+// Waive takes a free allowance off allocations in their order, the way a
+// tariff's free allowance takes the earliest usage of a billing period. It
+// returns the waived quantity and amount.
+func Waive(allocations []Allocation, free money.Decimal) (money.Decimal, money.Decimal) {
+	quantity, amount := money.Zero, money.Zero
+	for _, a := range allocations {
+		left := free.Sub(quantity)
+		if !left.IsPositive() {
+			break
+		}
+
+		waived := a.Quantity
+		if left.LessThan(waived) {
+			waived = left
+		}
+		quantity = quantity.Add(waived)
+		amount = amount.Add(waived.Mul(a.UnitPrice).Round(money.Scale))
+	}
+	return quantity, amount
+}
@@ -0,0 +1,252 @@
+package pricing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+)
+
+var dec = money.MustParse
+
+func upTo(s string) *money.Decimal {
+	d := dec(s)
+	return &d
+}
+
+// storage is 100 units at 1, 200 more at 0.5 and the rest at 0.1.
+func storage(model Model) Price {
+	return Price{
+		Model: model,
+		Tiers: []Tier{
+			{UpTo: upTo("100"), UnitPrice: dec("1")},
+			{UpTo: upTo("300"), UnitPrice: dec("0.5")},
+			{UnitPrice: dec("0.1")},
+		},
+	}
+}
+
+func assertAllocations(t *testing.T, want [][3]string, got []Allocation) {
+	t.Helper()
+
+	require.Len(t, got, len(want))
+	for i, w := range want {
+		assert.True(t, dec(w[0]).Equal(got[i].Quantity), "allocation %d quantity: want %s, got %s", i, w[0], got[i].Quantity)
+		assert.True(t, dec(w[1]).Equal(got[i].UnitPrice), "allocation %d unit price: want %s, got %s", i, w[1], got[i].UnitPrice)
+		assert.True(t, dec(w[2]).Equal(got[i].Amount), "allocation %d amount: want %s, got %s", i, w[2], got[i].Amount)
+	}
+}
+
+func Test_Evaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		price    Price
+		quantity string
+		want     [][3]string
+		total    string
+	}{
+		{
+			name:     "flat",
+			price:    Flat(dec("0.0000002")),
+			quantity: "1500",
+			want:     [][3]string{{"1500", "0.0000002", "0.0003"}},
+			total:    "0.0003",
+		},
+		{
+			name:     "graduated within the first tier",
+			price:    storage(ModelGraduated),
+			quantity: "40",
+			want:     [][3]string{{"40", "1", "40"}},
+			total:    "40",
+		},
+		{
+			name:     "graduated on a tier bound",
+			price:    storage(ModelGraduated),
+			quantity: "100",
+			want:     [][3]string{{"100", "1", "100"}},
+			total:    "100",
+		},
+		{
+			name:     "graduated across every tier",
+			price:    storage(ModelGraduated),
+			quantity: "350.5",
+			want: [][3]string{
+				{"100", "1", "100"},
+				{"200", "0.5", "100"},
+				{"50.5", "0.1", "5.05"},
+			},
+			total: "205.05",
+		},
+		{
+			name:     "volume on a tier bound",
+			price:    storage(ModelVolume),
+			quantity: "100",
+			want:     [][3]string{{"100", "1", "100"}},
+			total:    "100",
+		},
+		{
+			name:     "volume above a tier bound",
+			price:    storage(ModelVolume),
+			quantity: "100.5",
+			want:     [][3]string{{"100.5", "0.5", "50.25"}},
+			total:    "50.25",
+		},
+		{
+			name:     "volume in the last tier",
+			price:    storage(ModelVolume),
+			quantity: "1000",
+			want:     [][3]string{{"1000", "0.1", "100"}},
+			total:    "100",
+		},
+		{
+			name:     "zero quantity",
+			price:    storage(ModelGraduated),
+			quantity: "0",
+			want:     [][3]string{{"0", "1", "0"}},
+			total:    "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.price.Evaluate(dec(tt.quantity))
+			assertAllocations(t, tt.want, got)
+			assert.True(t, dec(tt.total).Equal(Total(got)), Total(got).String())
+		})
+	}
+}
+
+func Test_AllocateGraduated(t *testing.T) {
+	got := storage(ModelGraduated).Allocate([]money.Decimal{dec("60"), dec("60"), dec("0"), dec("200"), dec("30")})
+
+	require.Len(t, got, 5)
+	assertAllocations(t, [][3]string{{"60", "1", "60"}}, got[0])
+	assertAllocations(t, [][3]string{{"40", "1", "40"}, {"20", "0.5", "10"}}, got[1])
+	assertAllocations(t, [][3]string{{"0", "0.5", "0"}}, got[2])
+	assertAllocations(t, [][3]string{{"180", "0.5", "90"}, {"20", "0.1", "2"}}, got[3])
+	assertAllocations(t, [][3]string{{"30", "0.1", "3"}}, got[4])
+	assert.Equal(t, []int{0, 1}, []int{got[1][0].Tier, got[1][1].Tier})
+
+	// spreading the quantity over several charges does not change the total
+	var total money.Decimal
+	for _, allocations := range got {
+		total = total.Add(Total(allocations))
+	}
+	assert.True(t, total.Equal(Total(storage(ModelGraduated).Evaluate(dec("350")))), total.String())
+}
+
+func Test_AllocateVolume(t *testing.T) {
+	got := storage(ModelVolume).Allocate([]money.Decimal{dec("60"), dec("60")})
+
+	require.Len(t, got, 2)
+	assertAllocations(t, [][3]string{{"60", "0.5", "30"}}, got[0])
+	assertAllocations(t, [][3]string{{"60", "0.5", "30"}}, got[1])
+}
+
+func Test_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		price Price
+		err   error
+	}{
+		{name: "flat", price: Flat(dec("1"))},
+		{name: "graduated", price: storage(ModelGraduated)},
+		{name: "volume", price: storage(ModelVolume)},
+		{name: "single open tier", price: Price{Model: ModelVolume, Tiers: []Tier{{UnitPrice: dec("1")}}}},
+		{name: "unknown model", price: Price{Model: "stairs", Tiers: []Tier{{UnitPrice: dec("1")}}}, err: ErrUnknownModel},
+		{name: "no tiers", price: Price{Model: ModelGraduated}, err: ErrInvalidTiers},
+		{
+			name:  "flat with two tiers",
+			price: Price{Model: ModelFlat, Tiers: []Tier{{UpTo: upTo("1"), UnitPrice: dec("1")}, {UnitPrice: dec("1")}}},
+			err:   ErrInvalidTiers,
+		},
+		{
+			name:  "bounded last tier",
+			price: Price{Model: ModelGraduated, Tiers: []Tier{{UpTo: upTo("10"), UnitPrice: dec("1")}}},
+			err:   ErrInvalidTiers,
+		},
+		{
+			name:  "open middle tier",
+			price: Price{Model: ModelGraduated, Tiers: []Tier{{UnitPrice: dec("1")}, {UnitPrice: dec("1")}}},
+			err:   ErrInvalidTiers,
+		},
+		{
+			name: "unordered tiers",
+			price: Price{Model: ModelGraduated, Tiers: []Tier{
+				{UpTo: upTo("10"), UnitPrice: dec("1")},
+				{UpTo: upTo("10"), UnitPrice: dec("1")},
+				{UnitPrice: dec("1")},
+			}},
+			err: ErrInvalidTiers,
+		},
+		{
+			name:  "zero bound",
+			price: Price{Model: ModelVolume, Tiers: []Tier{{UpTo: upTo("0"), UnitPrice: dec("1")}, {UnitPrice: dec("1")}}},
+			err:   ErrInvalidTiers,
+		},
+		{
+			name:  "negative price",
+			price: Price{Model: ModelVolume, Tiers: []Tier{{UpTo: upTo("10"), UnitPrice: dec("1")}, {UnitPrice: dec("-1")}}},
+			err:   ErrInvalidTiers,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.price.Validate()
+			if tt.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func Test_ScheduleJSON(t *testing.T) {
+	var s Schedule
+	err := json.Unmarshal([]byte(`{
+		"memory": {"model": "graduated", "tiers": [{"up_to": "1000000", "unit_price": "0.00001"}, {"unit_price": 0.000005}]}
+	}`), &s)
+	require.NoError(t, err)
+	require.NoError(t, s.Validate())
+
+	got := s[DimensionMemory].Evaluate(dec("1500000"))
+	assertAllocations(t, [][3]string{{"1000000", "0.00001", "10"}, {"500000", "0.000005", "2.5"}}, got)
+
+	s["disk"] = Flat(dec("1"))
+	assert.ErrorIs(t, s.Validate(), ErrUnknownDimension)
+}
+
+func Test_Waive(t *testing.T) {
+	allocations := storage(ModelGraduated).Evaluate(dec("350"))
+
+	quantity, amount := Waive(allocations, dec("150"))
+	assert.True(t, dec("150").Equal(quantity), quantity.String())
+	// the first tier in full and 50 units of the second
+	assert.True(t, dec("125").Equal(amount), amount.String())
+
+	quantity, amount = Waive(allocations, dec("1000"))
+	assert.True(t, dec("350").Equal(quantity), quantity.String())
+	assert.True(t, Total(allocations).Equal(amount), amount.String())
+
+	quantity, amount = Waive(allocations, money.Zero)
+	assert.True(t, quantity.IsZero())
+	assert.True(t, amount.IsZero())
+}
+
+func Test_SchedulePrice(t *testing.T) {
+	s := Schedule{DimensionMemory: storage(ModelVolume)}
+
+	assert.Equal(t, ModelVolume, s.Price(DimensionMemory, dec("7")).Model)
+
+	flat := s.Price(DimensionCPU, dec("7"))
+	assert.Equal(t, ModelFlat, flat.Model)
+	assert.True(t, dec("7").Equal(flat.Tiers[0].UnitPrice))
+
+	var none Schedule
+	assert.Equal(t, ModelFlat, none.Price(DimensionCPU, dec("7")).Model)
+}
@@ -8,13 +8,50 @@ type Metric struct {
 	MemMB      float64 `json:"mem_mb"`
 	Timestamp  int64   `json:"timestamp"`
 	Tenant     string  `json:"tenant"`
+	Revision   string  `json:"revision,omitempty"`
+	Replica    string  `json:"replica,omitempty"`
+	// Requests is the number of requests the replica served since its
+	// previous sample.
+	Requests uint64 `json:"requests,omitempty"`
 }
 
+// ActionType is a lifecycle event emitted by meter_agent for a function replica.
+type ActionType string
+
+const (
+	// ActionStart is sent once when the replica's sidecar boots.
+	ActionStart ActionType = "start"
+	// ActionHeartbeat is sent periodically while the replica is alive.
+	ActionHeartbeat ActionType = "heartbeat"
+	// ActionOOMKilled is sent when the user container was killed for exceeding its memory limit.
+	ActionOOMKilled ActionType = "oom_killed"
+	// ActionRestart is sent when the user container was restarted for any other reason.
+	ActionRestart ActionType = "restart"
+	// ActionStop is sent when the replica is shutting down.
+	ActionStop ActionType = "stop"
+)
+
+// Exit reasons reported in Action.Reason.
+const (
+	ReasonSIGTERM   = "sigterm"
+	ReasonSIGINT    = "sigint"
+	ReasonOOMKilled = "OOMKilled"
+	ReasonError     = "Error"
+	ReasonCompleted = "Completed"
+)
+
 type Action struct {
-	Pod       string `json:"pod"`
-	Action    string `json:"action"`
-	Timestamp int64  `json:"timestamp"`
-	Tenant    string `json:"tenant"`
+	Pod       string     `json:"pod"`
+	Action    ActionType `json:"action"`
+	Timestamp int64      `json:"timestamp"`
+	Tenant    string     `json:"tenant"`
+	Revision  string     `json:"revision,omitempty"`
+	Replica   string     `json:"replica,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	ExitCode  int32      `json:"exit_code,omitempty"`
+	// StartedAt is the unix timestamp the replica started at, set on stop actions
+	// so consumers can compute the exact lifetime without looking up the start event.
+	StartedAt int64 `json:"started_at,omitempty"`
 }
 
 type Envelope struct {
@@ -15,20 +15,35 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/v1/tariff": {
+        "/v1/credit": {
             "get": {
-                "description": "Получить все тарифы",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Получить кредиты, можно отфильтровать по тенанту и по периоду [from, to), в котором кредит действует",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "тарифы"
+                    "кредиты"
                 ],
-                "summary": "Получить все тарифы",
+                "summary": "Получить промо-кредиты",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Тенант",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Начало периода, RFC3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец периода, RFC3339",
+                        "name": "to",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
                         "default": 10,
@@ -48,22 +63,25 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/response.GetAllTariffs"
+                            "$ref": "#/definitions/response.GetAllCredits"
                         }
                     }
                 }
             }
         },
-        "/v1/tariff/": {
+        "/v1/credit/": {
             "post": {
-                "description": "Создание нового тарифа",
+                "description": "Кредит оплачивает потребление с granted_at (по умолчанию сейчас) до expires_at (без него не сгорает). Остаток кредита ведёт invoicer",
                 "consumes": [
                     "application/json"
                 ],
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "тарифы"
+                    "кредиты"
                 ],
-                "summary": "Создание нового тарифа",
+                "summary": "Начислить промо-кредит тенанту",
                 "parameters": [
                     {
                         "description": "Тело запроса",
@@ -71,7 +89,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/request.CreateTariff"
+                            "$ref": "#/definitions/request.GrantCredit"
                         }
                     }
                 ],
@@ -79,26 +97,26 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/entity.Tariff"
+                            "$ref": "#/definitions/entity.Credit"
                         }
                     }
                 }
             }
         },
-        "/v1/tariff/{id}": {
+        "/v1/credit/{id}": {
             "get": {
-                "description": "Получить тариф по идентификатору",
+                "description": "Получить промо-кредит по идентификатору",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "тарифы"
+                    "кредиты"
                 ],
-                "summary": "Получить тариф по идентификатору",
+                "summary": "Получить промо-кредит по идентификатору",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Идентификатор тарифа",
+                        "description": "Идентификатор кредита",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -108,45 +126,146 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/entity.Tariff"
+                            "$ref": "#/definitions/entity.Credit"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Удалить тариф по его идентификатору",
+            }
+        },
+        "/v1/discount": {
+            "get": {
+                "description": "Получить скидки, можно отфильтровать по тенанту и по периоду [from, to), с которым скидка пересекается",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "тарифы"
+                    "скидки"
                 ],
-                "summary": "Удалить тариф по его идентификатору",
+                "summary": "Получить скидки",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Тенант",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Начало периода, RFC3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец периода, RFC3339",
+                        "name": "to",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
-                        "description": "Идентификатор тарифа",
+                        "default": 10,
+                        "description": "Limit",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.GetAllDiscounts"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/discount/": {
+            "post": {
+                "description": "Скидка в процентах действует с valid_from (по умолчанию сейчас) до valid_to. Скидки тенанта не пересекаются",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "скидки"
+                ],
+                "summary": "Назначить скидку тенанту",
+                "parameters": [
+                    {
+                        "description": "Тело запроса",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/request.CreateDiscount"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Discount"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/discount/{id}": {
+            "get": {
+                "description": "Получить скидку по идентификатору",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "скидки"
+                ],
+                "summary": "Получить скидку по идентификатору",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Идентификатор скидки",
                         "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Discount"
+                        }
                     }
                 }
-            },
-            "patch": {
-                "description": "Обновить тариф по его идентификатору. Принимает JSON с обновленными полями",
+            }
+        },
+        "/v1/discount/{id}/end": {
+            "post": {
+                "description": "Завершает скидку в момент valid_to (по умолчанию сейчас). Скидку можно сократить, но не продлить",
                 "consumes": [
                     "application/json"
                 ],
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "тарифы"
+                    "скидки"
                 ],
-                "summary": "Обновить тариф по его идентификатору",
+                "summary": "Завершить скидку",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Идентификатор тарифа",
+                        "description": "Идентификатор скидки",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -155,9 +274,8 @@ const docTemplate = `{
                         "description": "Тело запроса",
                         "name": "input",
                         "in": "body",
-                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/request.UpdateTariff"
+                            "$ref": "#/definitions/request.EndDiscount"
                         }
                     }
                 ],
@@ -165,93 +283,1158 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/entity.Tariff"
+                            "$ref": "#/definitions/entity.Discount"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "entity.Tariff": {
-            "type": "object",
-            "properties": {
-                "cpuPrice": {
-                    "type": "number"
-                },
-                "createdAt": {
-                    "type": "string"
-                },
-                "execPrice": {
-                    "type": "number"
-                },
-                "id": {
-                    "type": "integer"
-                },
-                "memPrice": {
-                    "type": "number"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "updatedAt": {
-                    "type": "string"
-                }
-            }
         },
-        "request.CreateTariff": {
-            "type": "object",
-            "required": [
-                "cpu_price",
-                "exec_price",
-                "mem_price",
-                "name"
-            ],
-            "properties": {
-                "cpu_price": {
-                    "type": "number",
-                    "minimum": 0
-                },
-                "exec_price": {
-                    "type": "number",
-                    "minimum": 0
-                },
-                "mem_price": {
-                    "type": "number",
-                    "minimum": 0
-                },
-                "name": {
-                    "type": "string"
+        "/v1/quote/": {
+            "post": {
+                "description": "Оценка стоимости прогнозируемого потребления за один расчётный период по тарифу tariff_id или по активному тарифу тенанта. Считается так же, как счёт в invoicer: ступени цен и бесплатный лимит тарифа",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "оценка стоимости"
+                ],
+                "summary": "Оценка стоимости",
+                "parameters": [
+                    {
+                        "description": "Тело запроса",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/request.Quote"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Quote"
+                        }
+                    }
                 }
             }
         },
-        "request.UpdateTariff": {
-            "type": "object",
-            "properties": {
-                "cpu_price": {
-                    "type": "number",
-                    "minimum": 0
-                },
-                "exec_price": {
-                    "type": "number",
-                    "minimum": 0
-                },
-                "mem_price": {
-                    "type": "number",
-                    "minimum": 0
-                },
-                "name": {
-                    "type": "string"
+        "/v1/quote/function": {
+            "post": {
+                "description": "Берёт потребление функции за последние days дней (по умолчанию 7) из invoicer, пересчитывает его на 30 дней и оценивает по тарифу tariff_id или по активному тарифу тенанта",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "оценка стоимости"
+                ],
+                "summary": "Оценка стоимости функции за месяц",
+                "parameters": [
+                    {
+                        "description": "Тело запроса",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/request.FunctionQuote"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Quote"
+                        }
+                    }
                 }
             }
         },
-        "response.GetAllTariffs": {
-            "type": "object",
-            "properties": {
-                "tariffs": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/entity.Tariff"
+        "/v1/subscription": {
+            "get": {
+                "description": "Получить подписки, можно отфильтровать по тенанту и по периоду [from, to), с которым подписка пересекается",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "подписки"
+                ],
+                "summary": "Получить подписки",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Тенант",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Начало периода, RFC3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец периода, RFC3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "Limit",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.GetAllSubscriptions"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/subscription/": {
+            "post": {
+                "description": "Назначает тенанту тариф с момента valid_from (по умолчанию сейчас). Текущая открытая подписка тенанта завершается в этот же момент",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "подписки"
+                ],
+                "summary": "Назначить тариф тенанту",
+                "parameters": [
+                    {
+                        "description": "Тело запроса",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/request.AssignSubscription"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Subscription"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/subscription/{id}": {
+            "get": {
+                "description": "Получить подписку по идентификатору",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "подписки"
+                ],
+                "summary": "Получить подписку по идентификатору",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Идентификатор подписки",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Subscription"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/subscription/{id}/end": {
+            "post": {
+                "description": "Завершает подписку в момент valid_to (по умолчанию сейчас). Подписку можно сократить, но не продлить",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "подписки"
+                ],
+                "summary": "Завершить подписку",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Идентификатор подписки",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Тело запроса",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/request.EndSubscription"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Subscription"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/tariff": {
+            "get": {
+                "description": "Получить все тарифы",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "тарифы"
+                ],
+                "summary": "Получить все тарифы",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "Limit",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.GetAllTariffs"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/tariff/": {
+            "post": {
+                "description": "Создание нового тарифа",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "тарифы"
+                ],
+                "summary": "Создание нового тарифа",
+                "parameters": [
+                    {
+                        "description": "Тело запроса",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/request.CreateTariff"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Tariff"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/tariff/{id}": {
+            "get": {
+                "description": "Получить тариф по идентификатору с ценами версии, действовавшей в момент at (по умолчанию сейчас)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "тарифы"
+                ],
+                "summary": "Получить тариф по идентификатору",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Идентификатор тарифа",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Момент времени, RFC3339",
+                        "name": "at",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Tariff"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Удалить тариф по его идентификатору",
+                "tags": [
+                    "тарифы"
+                ],
+                "summary": "Удалить тариф по его идентификатору",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Идентификатор тарифа",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "description": "Обновить тариф по его идентификатору. Принимает JSON с обновленными полями. Новые цены не переписывают старые, а добавляют версию тарифа, действующую с effective_from (по умолчанию сейчас)",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "тарифы"
+                ],
+                "summary": "Обновить тариф по его идентификатору",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Идентификатор тарифа",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Тело запроса",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/request.UpdateTariff"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/entity.Tariff"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/tariff/{id}/versions": {
+            "get": {
+                "description": "Версии цен тарифа от старой к новой, можно отфильтровать по периоду [from, to), в котором версия действовала",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "тарифы"
+                ],
+                "summary": "Получить историю цен тарифа",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Идентификатор тарифа",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Начало периода, RFC3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Конец периода, RFC3339",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/response.GetTariffVersions"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "entity.Credit": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "string",
+                    "example": "50"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "grantedAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.Discount": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "percent": {
+                    "type": "string",
+                    "example": "20"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "validFrom": {
+                    "type": "string"
+                },
+                "validTo": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.Quote": {
+            "type": "object",
+            "properties": {
+                "allowances": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.QuoteAllowance"
+                    }
+                },
+                "basis": {
+                    "description": "Basis is set when the usage is projected from a function's past usage",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/entity.QuoteBasis"
+                        }
+                    ]
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.QuoteItem"
+                    }
+                },
+                "subtotal": {
+                    "type": "string",
+                    "example": "12.5"
+                },
+                "tariffID": {
+                    "type": "integer"
+                },
+                "tariffName": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "string",
+                    "example": "12.49"
+                },
+                "usage": {
+                    "$ref": "#/definitions/entity.QuoteUsage"
+                }
+            }
+        },
+        "entity.QuoteAllowance": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "string",
+                    "example": "-0.0014"
+                },
+                "dimension": {
+                    "type": "string"
+                },
+                "quantity": {
+                    "type": "string",
+                    "example": "400000"
+                },
+                "unit": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.QuoteBasis": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "function": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                },
+                "usage": {
+                    "$ref": "#/definitions/entity.QuoteUsage"
+                }
+            }
+        },
+        "entity.QuoteItem": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "string",
+                    "example": "0.2"
+                },
+                "dimension": {
+                    "type": "string"
+                },
+                "quantity": {
+                    "type": "string",
+                    "example": "1000000"
+                },
+                "tier": {
+                    "type": "integer"
+                },
+                "unit": {
+                    "type": "string"
+                },
+                "unitPrice": {
+                    "type": "string",
+                    "example": "0.0000002"
+                }
+            }
+        },
+        "entity.QuoteUsage": {
+            "type": "object",
+            "properties": {
+                "cpusec": {
+                    "type": "string",
+                    "example": "648000"
+                },
+                "execSec": {
+                    "type": "string",
+                    "example": "2592000"
+                },
+                "memoryMBSec": {
+                    "type": "string",
+                    "example": "331776000"
+                },
+                "requests": {
+                    "type": "string",
+                    "example": "1000000"
+                }
+            }
+        },
+        "entity.Subscription": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "tariffID": {
+                    "type": "integer"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "validFrom": {
+                    "type": "string"
+                },
+                "validTo": {
+                    "type": "string"
+                }
+            }
+        },
+        "entity.Tariff": {
+            "type": "object",
+            "properties": {
+                "cpuPrice": {
+                    "type": "string",
+                    "example": "0.000024"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "effectiveFrom": {
+                    "type": "string"
+                },
+                "execPrice": {
+                    "type": "string",
+                    "example": "0.0000021"
+                },
+                "freeCPUSec": {
+                    "type": "string",
+                    "example": "0"
+                },
+                "freeExecSec": {
+                    "type": "string",
+                    "example": "0"
+                },
+                "freeMemMBSec": {
+                    "type": "string",
+                    "example": "400000"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "memPrice": {
+                    "type": "string",
+                    "example": "0.0000000035"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "requestPrice": {
+                    "type": "string",
+                    "example": "0.0000002"
+                },
+                "tiers": {
+                    "$ref": "#/definitions/pricing.Schedule"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "entity.TariffVersion": {
+            "type": "object",
+            "properties": {
+                "cpuPrice": {
+                    "type": "string",
+                    "example": "0.000024"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "effectiveFrom": {
+                    "type": "string"
+                },
+                "effectiveTo": {
+                    "type": "string"
+                },
+                "execPrice": {
+                    "type": "string",
+                    "example": "0.0000021"
+                },
+                "freeCPUSec": {
+                    "type": "string",
+                    "example": "0"
+                },
+                "freeExecSec": {
+                    "type": "string",
+                    "example": "0"
+                },
+                "freeMemMBSec": {
+                    "type": "string",
+                    "example": "400000"
+                },
+                "memPrice": {
+                    "type": "string",
+                    "example": "0.0000000035"
+                },
+                "requestPrice": {
+                    "type": "string",
+                    "example": "0.0000002"
+                },
+                "tariffID": {
+                    "type": "integer"
+                },
+                "tiers": {
+                    "$ref": "#/definitions/pricing.Schedule"
+                },
+                "version": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "pricing.Model": {
+            "type": "string",
+            "enum": [
+                "flat",
+                "graduated",
+                "volume"
+            ],
+            "x-enum-varnames": [
+                "ModelFlat",
+                "ModelGraduated",
+                "ModelVolume"
+            ]
+        },
+        "pricing.Price": {
+            "type": "object",
+            "properties": {
+                "model": {
+                    "$ref": "#/definitions/pricing.Model"
+                },
+                "tiers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/pricing.Tier"
+                    }
+                }
+            }
+        },
+        "pricing.Schedule": {
+            "type": "object",
+            "additionalProperties": {
+                "$ref": "#/definitions/pricing.Price"
+            }
+        },
+        "pricing.Tier": {
+            "type": "object",
+            "properties": {
+                "unit_price": {
+                    "type": "string",
+                    "example": "0.0000000035"
+                },
+                "up_to": {
+                    "type": "string",
+                    "example": "1000000"
+                }
+            }
+        },
+        "request.AssignSubscription": {
+            "type": "object",
+            "required": [
+                "tariff_id",
+                "tenant"
+            ],
+            "properties": {
+                "tariff_id": {
+                    "type": "integer"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "valid_from": {
+                    "type": "string"
+                },
+                "valid_to": {
+                    "type": "string"
+                }
+            }
+        },
+        "request.CreateDiscount": {
+            "type": "object",
+            "required": [
+                "percent",
+                "tenant"
+            ],
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "percent": {
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "20"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "valid_from": {
+                    "description": "ValidFrom defaults to now",
+                    "type": "string"
+                },
+                "valid_to": {
+                    "type": "string"
+                }
+            }
+        },
+        "request.CreateTariff": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "cpu_price": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.000024"
+                },
+                "currency": {
+                    "description": "Currency defaults to USD",
+                    "type": "string",
+                    "example": "USD"
+                },
+                "exec_price": {
+                    "description": "Flat prices may be left out when tiers are given, they are then zero",
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.0000021"
+                },
+                "free_cpu_sec": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0"
+                },
+                "free_exec_sec": {
+                    "description": "Free allowances are waived in every billing period, zero by default",
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0"
+                },
+                "free_mem_mb_sec": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "400000"
+                },
+                "mem_price": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.0000000035"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "request_price": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.0000002"
+                },
+                "tiers": {
+                    "description": "Tiers are graduated or volume prices by dimension: exec, memory, cpu\nor requests. They replace the flat price of their dimension",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/pricing.Schedule"
+                        }
+                    ]
+                }
+            }
+        },
+        "request.EndDiscount": {
+            "type": "object",
+            "properties": {
+                "valid_to": {
+                    "description": "ValidTo defaults to now",
+                    "type": "string"
+                }
+            }
+        },
+        "request.EndSubscription": {
+            "type": "object",
+            "properties": {
+                "valid_to": {
+                    "description": "ValidTo defaults to now",
+                    "type": "string"
+                }
+            }
+        },
+        "request.FunctionQuote": {
+            "type": "object",
+            "required": [
+                "function",
+                "tenant"
+            ],
+            "properties": {
+                "days": {
+                    "description": "Days of past usage projected to a 30 day month, 7 by default",
+                    "type": "integer",
+                    "maximum": 90,
+                    "example": 7
+                },
+                "function": {
+                    "type": "string"
+                },
+                "tariff_id": {
+                    "description": "TariffID defaults to the tenant's active tariff",
+                    "type": "integer"
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
+        "request.GrantCredit": {
+            "type": "object",
+            "required": [
+                "amount",
+                "tenant"
+            ],
+            "properties": {
+                "amount": {
+                    "type": "string",
+                    "example": "50"
+                },
+                "currency": {
+                    "description": "Currency defaults to USD",
+                    "type": "string",
+                    "example": "USD"
+                },
+                "description": {
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "granted_at": {
+                    "description": "GrantedAt defaults to now, a missing ExpiresAt never expires",
+                    "type": "string"
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
+        "request.Quote": {
+            "type": "object",
+            "properties": {
+                "cpu": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.25"
+                },
+                "duration_sec": {
+                    "description": "DurationSec is how long every replica runs in the billing period",
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "2592000"
+                },
+                "memory_mb": {
+                    "description": "MemoryMB and CPU (cores) are the average use of a replica",
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "128"
+                },
+                "replicas": {
+                    "description": "Replicas defaults to 1",
+                    "type": "integer",
+                    "maximum": 10000,
+                    "example": 2
+                },
+                "requests": {
+                    "description": "Requests is the total over all replicas",
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "1000000"
+                },
+                "tariff_id": {
+                    "description": "TariffID defaults to the tenant's active tariff",
+                    "type": "integer"
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
+        "request.UpdateTariff": {
+            "type": "object",
+            "properties": {
+                "cpu_price": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.000024"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "effective_from": {
+                    "description": "EffectiveFrom is when the new prices come into force, now by default.\nIt must be after the start of the latest version",
+                    "type": "string"
+                },
+                "exec_price": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.0000021"
+                },
+                "free_cpu_sec": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0"
+                },
+                "free_exec_sec": {
+                    "description": "A zero allowance removes it, a missing one is kept",
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0"
+                },
+                "free_mem_mb_sec": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "400000"
+                },
+                "mem_price": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.0000000035"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "request_price": {
+                    "type": "string",
+                    "minLength": 0,
+                    "example": "0.0000002"
+                },
+                "tiers": {
+                    "description": "Tiers replace the whole schedule, an empty object removes it and a\nmissing one is kept",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/pricing.Schedule"
+                        }
+                    ]
+                }
+            }
+        },
+        "response.GetAllCredits": {
+            "type": "object",
+            "properties": {
+                "credits": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Credit"
+                    }
+                }
+            }
+        },
+        "response.GetAllDiscounts": {
+            "type": "object",
+            "properties": {
+                "discounts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Discount"
+                    }
+                }
+            }
+        },
+        "response.GetAllSubscriptions": {
+            "type": "object",
+            "properties": {
+                "subscriptions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Subscription"
+                    }
+                }
+            }
+        },
+        "response.GetAllTariffs": {
+            "type": "object",
+            "properties": {
+                "tariffs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.Tariff"
+                    }
+                }
+            }
+        },
+        "response.GetTariffVersions": {
+            "type": "object",
+            "properties": {
+                "versions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/entity.TariffVersion"
                     }
                 }
             }
@@ -12,11 +12,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/usamaroman/faas_demo/pkg/logger"
+	"github.com/usamaroman/faas_demo/pkg/observability"
 	"github.com/usamaroman/faas_demo/pkg/postgresql"
 	"github.com/usamaroman/faas_demo/price_service/internal/config"
 	v1 "github.com/usamaroman/faas_demo/price_service/internal/controller/v1"
 	"github.com/usamaroman/faas_demo/price_service/internal/repo"
 	"github.com/usamaroman/faas_demo/price_service/internal/service"
+	"github.com/usamaroman/faas_demo/price_service/internal/webapi/invoicer"
 )
 
 func Run() {
@@ -50,10 +52,14 @@ func Run() {
 	slog.Info("services init")
 	services := service.NewServices(&service.Dependencies{
 		Repos: repositories,
+		Usage: invoicer.New(cfg.Invoicer.URL),
 	})
 
+	health := observability.NewHealth()
+	health.Add("postgres", postgres.Pool.Ping)
+
 	r := router()
-	v1.NewRouter(r, services)
+	v1.NewRouter(r, services, health)
 
 	slog.Debug("server starting")
 	server := &http.Server{
@@ -10,6 +10,7 @@ import (
 type Config struct {
 	HTTP       HTTP
 	Postgresql Postgresql
+	Invoicer   Invoicer
 }
 
 type HTTP struct {
@@ -25,9 +26,15 @@ type Postgresql struct {
 	Database string `env:"PG_DATABASE, default=control-plane"`
 }
 
+// Invoicer serves the measured usage function quotes are projected from.
+type Invoicer struct {
+	URL string `env:"INVOICER_URL, default=http://localhost:8081"`
+}
+
 func New(ctx context.Context) (*Config, error) {
 	var configHttp HTTP
 	var configPostgresql Postgresql
+	var configInvoicer Invoicer
 
 	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
 		Target: &configHttp,
@@ -35,7 +42,7 @@ func New(ctx context.Context) (*Config, error) {
 		DefaultDelimiter: ";",
 		DefaultSeparator: "@",
 	}); err != nil {
-		slog.Error("failed to process env http vars", err.Error())
+		slog.Error("failed to process env http vars", slog.String("error", err.Error()))
 		return nil, err
 	}
 
@@ -45,12 +52,23 @@ func New(ctx context.Context) (*Config, error) {
 		DefaultDelimiter: ";",
 		DefaultSeparator: "@",
 	}); err != nil {
-		slog.Error("failed to process env postgresql vars", err.Error())
+		slog.Error("failed to process env postgresql vars", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target: &configInvoicer,
+
+		DefaultDelimiter: ";",
+		DefaultSeparator: "@",
+	}); err != nil {
+		slog.Error("failed to process env invoicer vars", slog.String("error", err.Error()))
 		return nil, err
 	}
 
 	return &Config{
 		HTTP:       configHttp,
 		Postgresql: configPostgresql,
+		Invoicer:   configInvoicer,
 	}, nil
 }
@@ -0,0 +1,152 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/request"
+	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/response"
+	"github.com/usamaroman/faas_demo/price_service/internal/service"
+)
+
+type creditRoutes struct {
+	valid *validator.Validate
+
+	creditService service.Credit
+}
+
+func newCreditRoutes(g *gin.RouterGroup, creditService service.Credit) {
+	slog.Debug("component", slog.String("name", "credit routes"))
+
+	v := validator.New()
+	v.RegisterCustomTypeFunc(decimalValue, money.Decimal{})
+
+	r := &creditRoutes{
+		valid:         v,
+		creditService: creditService,
+	}
+
+	g.POST("/", r.grantCredit)
+	g.GET("/", r.getCredits)
+	g.GET("/:id", r.getCreditByID)
+}
+
+// @Summary Начислить промо-кредит тенанту
+// @Description Кредит оплачивает потребление с granted_at (по умолчанию сейчас) до expires_at (без него не сгорает). Остаток кредита ведёт invoicer
+// @Tags кредиты
+// @Accept json
+// @Produce json
+// @Param input body request.GrantCredit true "Тело запроса"
+// @Success 201 {object} entity.Credit
+// @Router /v1/credit/ [post]
+func (r *creditRoutes) grantCredit(c *gin.Context) {
+	var body request.GrantCredit
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := r.valid.Struct(&body); err != nil {
+		slog.Info("error validating data", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	credit, err := r.creditService.Grant(c, &service.CreditInput{
+		Tenant:      body.Tenant,
+		Amount:      body.Amount,
+		Currency:    body.Currency,
+		Description: body.Description,
+		GrantedAt:   body.GrantedAt,
+		ExpiresAt:   body.ExpiresAt,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownCurrency), errors.Is(err, service.ErrInvalidAdjustmentEnd):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			slog.Error("failed to grant credit", slog.String("error", err.Error()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	slog.Info("granted credit", slog.String("tenant", credit.Tenant), slog.String("amount", credit.Amount.String()))
+	c.JSON(http.StatusCreated, gin.H{
+		"credit": credit,
+	})
+}
+
+// @Summary Получить промо-кредиты
+// @Description Получить кредиты, можно отфильтровать по тенанту и по периоду [from, to), в котором кредит действует
+// @Tags кредиты
+// @Produce json
+// @Param tenant query string false "Тенант"
+// @Param from query string false "Начало периода, RFC3339"
+// @Param to query string false "Конец периода, RFC3339"
+// @Param limit query int false "Limit" default(10)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {object} response.GetAllCredits
+// @Router /v1/credit [get]
+func (r *creditRoutes) getCredits(c *gin.Context) {
+	filters := buildAdjustmentFilters(c)
+	if filters == nil {
+		return
+	}
+
+	credits, err := r.creditService.GetAll(c, filters)
+	if err != nil {
+		slog.Error("failed to get credits", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response.GetAllCredits{
+		Credits: credits,
+	})
+}
+
+// @Summary Получить промо-кредит по идентификатору
+// @Description Получить промо-кредит по идентификатору
+// @Tags кредиты
+// @Produce json
+// @Param id path int true "Идентификатор кредита"
+// @Success 200 {object} entity.Credit
+// @Router /v1/credit/{id} [get]
+func (r *creditRoutes) getCreditByID(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid id parameter",
+		})
+		return
+	}
+
+	credit, err := r.creditService.GetByID(c, id)
+	if err != nil {
+		if errors.Is(err, service.ErrCreditNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		slog.Error("failed to get credit", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"credit": credit,
+	})
+}
@@ -0,0 +1,208 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/request"
+	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/response"
+	"github.com/usamaroman/faas_demo/price_service/internal/service"
+)
+
+type discountRoutes struct {
+	valid *validator.Validate
+
+	discountService service.Discount
+}
+
+func newDiscountRoutes(g *gin.RouterGroup, discountService service.Discount) {
+	slog.Debug("component", slog.String("name", "discount routes"))
+
+	v := validator.New()
+	v.RegisterCustomTypeFunc(decimalValue, money.Decimal{})
+
+	r := &discountRoutes{
+		valid:           v,
+		discountService: discountService,
+	}
+
+	g.POST("/", r.createDiscount)
+	g.GET("/", r.getDiscounts)
+	g.GET("/:id", r.getDiscountByID)
+	g.POST("/:id/end", r.endDiscount)
+}
+
+// @Summary Назначить скидку тенанту
+// @Description Скидка в процентах действует с valid_from (по умолчанию сейчас) до valid_to. Скидки тенанта не пересекаются
+// @Tags скидки
+// @Accept json
+// @Produce json
+// @Param input body request.CreateDiscount true "Тело запроса"
+// @Success 201 {object} entity.Discount
+// @Router /v1/discount/ [post]
+func (r *discountRoutes) createDiscount(c *gin.Context) {
+	var body request.CreateDiscount
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := r.valid.Struct(&body); err != nil {
+		slog.Info("error validating data", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	discount, err := r.discountService.Create(c, &service.DiscountInput{
+		Tenant:      body.Tenant,
+		Percent:     body.Percent,
+		Description: body.Description,
+		ValidFrom:   body.ValidFrom,
+		ValidTo:     body.ValidTo,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrDiscountOverlap):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidAdjustmentEnd):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			slog.Error("failed to create discount", slog.String("error", err.Error()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	slog.Info("created discount", slog.String("tenant", discount.Tenant), slog.String("percent", discount.Percent.String()))
+	c.JSON(http.StatusCreated, gin.H{
+		"discount": discount,
+	})
+}
+
+// @Summary Получить скидки
+// @Description Получить скидки, можно отфильтровать по тенанту и по периоду [from, to), с которым скидка пересекается
+// @Tags скидки
+// @Produce json
+// @Param tenant query string false "Тенант"
+// @Param from query string false "Начало периода, RFC3339"
+// @Param to query string false "Конец периода, RFC3339"
+// @Param limit query int false "Limit" default(10)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {object} response.GetAllDiscounts
+// @Router /v1/discount [get]
+func (r *discountRoutes) getDiscounts(c *gin.Context) {
+	filters := buildAdjustmentFilters(c)
+	if filters == nil {
+		return
+	}
+
+	discounts, err := r.discountService.GetAll(c, filters)
+	if err != nil {
+		slog.Error("failed to get discounts", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response.GetAllDiscounts{
+		Discounts: discounts,
+	})
+}
+
+// @Summary Получить скидку по идентификатору
+// @Description Получить скидку по идентификатору
+// @Tags скидки
+// @Produce json
+// @Param id path int true "Идентификатор скидки"
+// @Success 200 {object} entity.Discount
+// @Router /v1/discount/{id} [get]
+func (r *discountRoutes) getDiscountByID(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid id parameter",
+		})
+		return
+	}
+
+	discount, err := r.discountService.GetByID(c, id)
+	if err != nil {
+		if errors.Is(err, service.ErrDiscountNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		slog.Error("failed to get discount", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"discount": discount,
+	})
+}
+
+// @Summary Завершить скидку
+// @Description Завершает скидку в момент valid_to (по умолчанию сейчас). Скидку можно сократить, но не продлить
+// @Tags скидки
+// @Accept json
+// @Produce json
+// @Param id path int true "Идентификатор скидки"
+// @Param input body request.EndDiscount false "Тело запроса"
+// @Success 200 {object} entity.Discount
+// @Router /v1/discount/{id}/end [post]
+func (r *discountRoutes) endDiscount(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid id parameter",
+		})
+		return
+	}
+
+	var body request.EndDiscount
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	at := time.Now()
+	if body.ValidTo != nil {
+		at = *body.ValidTo
+	}
+
+	discount, err := r.discountService.End(c, id, at)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrDiscountNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidAdjustmentEnd):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			slog.Error("failed to end discount", slog.String("error", err.Error()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	slog.Info("ended discount", slog.Int("id", id))
+	c.JSON(http.StatusOK, gin.H{
+		"discount": discount,
+	})
+}
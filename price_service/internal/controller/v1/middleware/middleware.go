@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"log/slog"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/pkg/observability"
 )
 
 func Log() gin.HandlerFunc {
@@ -12,3 +14,11 @@ func Log() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+		observability.ObserveHTTP(c.Request.Method, c.FullPath(), c.Writer.Status(), started)
+	}
+}
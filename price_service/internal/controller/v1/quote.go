@@ -0,0 +1,136 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/request"
+	_ "github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/service"
+)
+
+type quoteRoutes struct {
+	valid *validator.Validate
+
+	quoteService service.Quote
+}
+
+func newQuoteRoutes(g *gin.RouterGroup, quoteService service.Quote) {
+	slog.Debug("component", slog.String("name", "quote routes"))
+
+	v := validator.New()
+	v.RegisterCustomTypeFunc(decimalValue, money.Decimal{})
+
+	r := &quoteRoutes{
+		valid:        v,
+		quoteService: quoteService,
+	}
+
+	g.POST("/", r.quote)
+	g.POST("/function", r.functionQuote)
+}
+
+// @Summary Оценка стоимости
+// @Description Оценка стоимости прогнозируемого потребления за один расчётный период по тарифу tariff_id или по активному тарифу тенанта. Считается так же, как счёт в invoicer: ступени цен и бесплатный лимит тарифа
+// @Tags оценка стоимости
+// @Accept json
+// @Produce json
+// @Param input body request.Quote true "Тело запроса"
+// @Success 200 {object} entity.Quote
+// @Router /v1/quote/ [post]
+func (r *quoteRoutes) quote(c *gin.Context) {
+	var body request.Quote
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := r.valid.Struct(&body); err != nil {
+		slog.Info("error validating data", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	quote, err := r.quoteService.Quote(c, &service.QuoteInput{
+		TariffID:    body.TariffID,
+		Tenant:      body.Tenant,
+		DurationSec: body.DurationSec,
+		MemoryMB:    body.MemoryMB,
+		CPU:         body.CPU,
+		Requests:    body.Requests,
+		Replicas:    body.Replicas,
+	})
+	if err != nil {
+		quoteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quote": quote,
+	})
+}
+
+// @Summary Оценка стоимости функции за месяц
+// @Description Берёт потребление функции за последние days дней (по умолчанию 7) из invoicer, пересчитывает его на 30 дней и оценивает по тарифу tariff_id или по активному тарифу тенанта
+// @Tags оценка стоимости
+// @Accept json
+// @Produce json
+// @Param input body request.FunctionQuote true "Тело запроса"
+// @Success 200 {object} entity.Quote
+// @Router /v1/quote/function [post]
+func (r *quoteRoutes) functionQuote(c *gin.Context) {
+	var body request.FunctionQuote
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := r.valid.Struct(&body); err != nil {
+		slog.Info("error validating data", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	quote, err := r.quoteService.FunctionQuote(c, &service.FunctionQuoteInput{
+		TariffID: body.TariffID,
+		Tenant:   body.Tenant,
+		Function: body.Function,
+		Days:     body.Days,
+	})
+	if err != nil {
+		quoteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quote": quote,
+	})
+}
+
+func quoteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTariffNotFound),
+		errors.Is(err, service.ErrNoActiveSubscription),
+		errors.Is(err, service.ErrNoFunctionUsage):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrUsageUnavailable):
+		c.JSON(http.StatusBadGateway, gin.H{"error": service.ErrUsageUnavailable.Error()})
+	default:
+		slog.Error("failed to quote", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,15 +1,107 @@
 package request
 
+import (
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
+)
+
+// Prices are accepted as JSON strings or numbers, strings keep every digit.
 type CreateTariff struct {
-	Name      string  `json:"name" validate:"required"`
-	ExecPrice float64 `json:"exec_price" validate:"required,gte=0"`
-	MemPrice  float64 `json:"mem_price" validate:"required,gte=0"`
-	CpuPrice  float64 `json:"cpu_price" validate:"required,gte=0"`
+	Name string `json:"name" validate:"required"`
+	// Flat prices may be left out when tiers are given, they are then zero
+	ExecPrice    money.Decimal `json:"exec_price" validate:"required_without=Tiers,gte=0" swaggertype:"string" example:"0.0000021"`
+	MemPrice     money.Decimal `json:"mem_price" validate:"required_without=Tiers,gte=0" swaggertype:"string" example:"0.0000000035"`
+	CpuPrice     money.Decimal `json:"cpu_price" validate:"required_without=Tiers,gte=0" swaggertype:"string" example:"0.000024"`
+	RequestPrice money.Decimal `json:"request_price" validate:"gte=0" swaggertype:"string" example:"0.0000002"`
+	// Currency defaults to USD
+	Currency string `json:"currency" example:"USD"`
+	// Free allowances are waived in every billing period, zero by default
+	FreeExecSec  *money.Decimal `json:"free_exec_sec" validate:"omitempty,gte=0" swaggertype:"string" example:"0"`
+	FreeMemMBSec *money.Decimal `json:"free_mem_mb_sec" validate:"omitempty,gte=0" swaggertype:"string" example:"400000"`
+	FreeCPUSec   *money.Decimal `json:"free_cpu_sec" validate:"omitempty,gte=0" swaggertype:"string" example:"0"`
+	// Tiers are graduated or volume prices by dimension: exec, memory, cpu
+	// or requests. They replace the flat price of their dimension
+	Tiers pricing.Schedule `json:"tiers"`
 }
 
 type UpdateTariff struct {
-	Name      string  `json:"name"`
-	ExecPrice float64 `json:"exec_price" validate:"gte=0"`
-	MemPrice  float64 `json:"mem_price" validate:"gte=0"`
-	CpuPrice  float64 `json:"cpu_price" validate:"gte=0"`
+	Name         string        `json:"name"`
+	ExecPrice    money.Decimal `json:"exec_price" validate:"gte=0" swaggertype:"string" example:"0.0000021"`
+	MemPrice     money.Decimal `json:"mem_price" validate:"gte=0" swaggertype:"string" example:"0.0000000035"`
+	CpuPrice     money.Decimal `json:"cpu_price" validate:"gte=0" swaggertype:"string" example:"0.000024"`
+	RequestPrice money.Decimal `json:"request_price" validate:"gte=0" swaggertype:"string" example:"0.0000002"`
+	Currency     string        `json:"currency" example:"USD"`
+	// A zero allowance removes it, a missing one is kept
+	FreeExecSec  *money.Decimal `json:"free_exec_sec" validate:"omitempty,gte=0" swaggertype:"string" example:"0"`
+	FreeMemMBSec *money.Decimal `json:"free_mem_mb_sec" validate:"omitempty,gte=0" swaggertype:"string" example:"400000"`
+	FreeCPUSec   *money.Decimal `json:"free_cpu_sec" validate:"omitempty,gte=0" swaggertype:"string" example:"0"`
+	// Tiers replace the whole schedule, an empty object removes it and a
+	// missing one is kept
+	Tiers pricing.Schedule `json:"tiers"`
+	// EffectiveFrom is when the new prices come into force, now by default.
+	// It must be after the start of the latest version and not in the past
+	EffectiveFrom *time.Time `json:"effective_from"`
+}
+
+type AssignSubscription struct {
+	Tenant    string     `json:"tenant" validate:"required"`
+	TariffID  int        `json:"tariff_id" validate:"required,gt=0"`
+	ValidFrom *time.Time `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+}
+
+type EndSubscription struct {
+	// ValidTo defaults to now
+	ValidTo *time.Time `json:"valid_to"`
+}
+
+type CreateDiscount struct {
+	Tenant      string        `json:"tenant" validate:"required"`
+	Percent     money.Decimal `json:"percent" validate:"required,gt=0,lte=100" swaggertype:"string" example:"20"`
+	Description string        `json:"description" validate:"max=255"`
+	// ValidFrom defaults to now
+	ValidFrom *time.Time `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+}
+
+type EndDiscount struct {
+	// ValidTo defaults to now
+	ValidTo *time.Time `json:"valid_to"`
+}
+
+type GrantCredit struct {
+	Tenant      string        `json:"tenant" validate:"required"`
+	Amount      money.Decimal `json:"amount" validate:"required,gt=0" swaggertype:"string" example:"50"`
+	Description string        `json:"description" validate:"max=255"`
+	// Currency defaults to USD
+	Currency string `json:"currency" example:"USD"`
+	// GrantedAt defaults to now, a missing ExpiresAt never expires
+	GrantedAt *time.Time `json:"granted_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type Quote struct {
+	// TariffID defaults to the tenant's active tariff
+	TariffID int    `json:"tariff_id" validate:"omitempty,gt=0"`
+	Tenant   string `json:"tenant" validate:"required_without=TariffID"`
+	// DurationSec is how long every replica runs in the billing period
+	DurationSec money.Decimal `json:"duration_sec" validate:"gte=0" swaggertype:"string" example:"2592000"`
+	// MemoryMB and CPU (cores) are the average use of a replica
+	MemoryMB money.Decimal `json:"memory_mb" validate:"gte=0" swaggertype:"string" example:"128"`
+	CPU      money.Decimal `json:"cpu" validate:"gte=0" swaggertype:"string" example:"0.25"`
+	// Requests is the total over all replicas
+	Requests money.Decimal `json:"requests" validate:"gte=0" swaggertype:"string" example:"1000000"`
+	// Replicas defaults to 1
+	Replicas int `json:"replicas" validate:"omitempty,gt=0,lte=10000" example:"2"`
+}
+
+type FunctionQuote struct {
+	// TariffID defaults to the tenant's active tariff
+	TariffID int    `json:"tariff_id" validate:"omitempty,gt=0"`
+	Tenant   string `json:"tenant" validate:"required"`
+	Function string `json:"function" validate:"required"`
+	// Days of past usage projected to a 30 day month, 7 by default
+	Days int `json:"days" validate:"omitempty,gt=0,lte=90" example:"7"`
 }
@@ -5,3 +5,19 @@ import "github.com/usamaroman/faas_demo/price_service/internal/entity"
 type GetAllTariffs struct {
 	Tariffs []entity.Tariff `json:"tariffs"`
 }
+
+type GetTariffVersions struct {
+	Versions []entity.TariffVersion `json:"versions"`
+}
+
+type GetAllSubscriptions struct {
+	Subscriptions []entity.Subscription `json:"subscriptions"`
+}
+
+type GetAllDiscounts struct {
+	Discounts []entity.Discount `json:"discounts"`
+}
+
+type GetAllCredits struct {
+	Credits []entity.Credit `json:"credits"`
+}
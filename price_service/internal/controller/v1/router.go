@@ -8,16 +8,21 @@ import (
 	_ "github.com/usamaroman/faas_demo/price_service/docs"
 
 	"github.com/gin-gonic/gin"
+	"github.com/usamaroman/faas_demo/pkg/observability"
 	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/middleware"
 	"github.com/usamaroman/faas_demo/price_service/internal/service"
 )
 
-func NewRouter(router *gin.Engine, services *service.Services) {
+func NewRouter(router *gin.Engine, services *service.Services, health *observability.Health) {
 	router.Use(middleware.Log())
+	router.Use(middleware.Metrics())
 
 	router.GET("/health", func(c *gin.Context) {
 		c.Status(http.StatusOK)
 	})
+	router.GET("/healthz", gin.WrapF(health.Live))
+	router.GET("/readyz", gin.WrapF(health.Ready))
+	router.GET("/metrics", gin.WrapH(observability.Handler()))
 
 	ginSwagger.WrapHandler(swaggerfiles.Handler,
 		ginSwagger.URL("http://localhost:8085/swagger/doc.json"),
@@ -27,5 +32,9 @@ func NewRouter(router *gin.Engine, services *service.Services) {
 	v1 := router.Group("/v1")
 	{
 		newTariffRoutes(v1.Group("/tariff"), services.Tariff)
+		newSubscriptionRoutes(v1.Group("/subscription"), services.Subscription)
+		newDiscountRoutes(v1.Group("/discount"), services.Discount)
+		newCreditRoutes(v1.Group("/credit"), services.Credit)
+		newQuoteRoutes(v1.Group("/quote"), services.Quote)
 	}
 }
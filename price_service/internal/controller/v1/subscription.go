@@ -0,0 +1,205 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/request"
+	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/response"
+	"github.com/usamaroman/faas_demo/price_service/internal/service"
+)
+
+type subscriptionRoutes struct {
+	valid *validator.Validate
+
+	subscriptionService service.Subscription
+}
+
+func newSubscriptionRoutes(g *gin.RouterGroup, subscriptionService service.Subscription) {
+	slog.Debug("component", slog.String("name", "subscription routes"))
+
+	r := &subscriptionRoutes{
+		valid:               validator.New(),
+		subscriptionService: subscriptionService,
+	}
+
+	g.POST("/", r.assignSubscription)
+	g.GET("/", r.getSubscriptions)
+	g.GET("/:id", r.getSubscriptionByID)
+	g.POST("/:id/end", r.endSubscription)
+}
+
+// @Summary Назначить тариф тенанту
+// @Description Назначает тенанту тариф с момента valid_from (по умолчанию сейчас). Текущая открытая подписка тенанта завершается в этот же момент
+// @Tags подписки
+// @Accept json
+// @Produce json
+// @Param input body request.AssignSubscription true "Тело запроса"
+// @Success 201 {object} entity.Subscription
+// @Router /v1/subscription/ [post]
+func (r *subscriptionRoutes) assignSubscription(c *gin.Context) {
+	var body request.AssignSubscription
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := r.valid.Struct(&body); err != nil {
+		slog.Info("error validating data", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	sub, err := r.subscriptionService.Assign(c, &service.SubscriptionInput{
+		Tenant:    body.Tenant,
+		TariffID:  body.TariffID,
+		ValidFrom: body.ValidFrom,
+		ValidTo:   body.ValidTo,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTariffNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrSubscriptionOverlap):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidSubscriptionEnd):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			slog.Error("failed to assign subscription", slog.String("error", err.Error()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	slog.Info("assigned subscription", slog.String("tenant", sub.Tenant), slog.Int("tariff_id", sub.TariffID))
+	c.JSON(http.StatusCreated, gin.H{
+		"subscription": sub,
+	})
+}
+
+// @Summary Получить подписки
+// @Description Получить подписки, можно отфильтровать по тенанту и по периоду [from, to), с которым подписка пересекается
+// @Tags подписки
+// @Produce json
+// @Param tenant query string false "Тенант"
+// @Param from query string false "Начало периода, RFC3339"
+// @Param to query string false "Конец периода, RFC3339"
+// @Param limit query int false "Limit" default(10)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {object} response.GetAllSubscriptions
+// @Router /v1/subscription [get]
+func (r *subscriptionRoutes) getSubscriptions(c *gin.Context) {
+	filters := buildSubscriptionFilters(c)
+	if filters == nil {
+		return
+	}
+
+	subs, err := r.subscriptionService.GetAll(c, filters)
+	if err != nil {
+		slog.Error("failed to get subscriptions", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response.GetAllSubscriptions{
+		Subscriptions: subs,
+	})
+}
+
+// @Summary Получить подписку по идентификатору
+// @Description Получить подписку по идентификатору
+// @Tags подписки
+// @Produce json
+// @Param id path int true "Идентификатор подписки"
+// @Success 200 {object} entity.Subscription
+// @Router /v1/subscription/{id} [get]
+func (r *subscriptionRoutes) getSubscriptionByID(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid id parameter",
+		})
+		return
+	}
+
+	sub, err := r.subscriptionService.GetByID(c, id)
+	if err != nil {
+		if errors.Is(err, service.ErrSubscriptionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		slog.Error("failed to get subscription", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscription": sub,
+	})
+}
+
+// @Summary Завершить подписку
+// @Description Завершает подписку в момент valid_to (по умолчанию сейчас). Подписку можно сократить, но не продлить
+// @Tags подписки
+// @Accept json
+// @Produce json
+// @Param id path int true "Идентификатор подписки"
+// @Param input body request.EndSubscription false "Тело запроса"
+// @Success 200 {object} entity.Subscription
+// @Router /v1/subscription/{id}/end [post]
+func (r *subscriptionRoutes) endSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid id parameter",
+		})
+		return
+	}
+
+	var body request.EndSubscription
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	at := time.Now()
+	if body.ValidTo != nil {
+		at = *body.ValidTo
+	}
+
+	sub, err := r.subscriptionService.End(c, id, at)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrSubscriptionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidSubscriptionEnd):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			slog.Error("failed to end subscription", slog.String("error", err.Error()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	slog.Info("ended subscription", slog.Int("id", id))
+	c.JSON(http.StatusOK, gin.H{
+		"subscription": sub,
+	})
+}
@@ -4,10 +4,13 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"reflect"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/usamaroman/faas_demo/pkg/money"
 	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/request"
 	"github.com/usamaroman/faas_demo/price_service/internal/controller/v1/response"
 	_ "github.com/usamaroman/faas_demo/price_service/internal/entity"
@@ -21,9 +24,10 @@ type tariffRoutes struct {
 }
 
 func newTariffRoutes(g *gin.RouterGroup, tariffService service.Tariff) {
-	slog.Debug("component", "tariff routes")
+	slog.Debug("component", slog.String("name", "tariff routes"))
 
 	v := validator.New()
+	v.RegisterCustomTypeFunc(decimalValue, money.Decimal{})
 
 	r := &tariffRoutes{
 		valid:         v,
@@ -32,6 +36,7 @@ func newTariffRoutes(g *gin.RouterGroup, tariffService service.Tariff) {
 
 	g.POST("/", r.createNewTariff)
 	g.GET("/:id", r.getTariffByID)
+	g.GET("/:id/versions", r.getTariffVersions)
 	g.GET("/", r.getTariffs)
 	g.PATCH("/:id", r.updateTariffByID)
 	g.DELETE("/:id", r.deleteTariffByID)
@@ -67,13 +72,33 @@ func (r *tariffRoutes) createNewTariff(c *gin.Context) {
 	}
 
 	createdTariff, err := r.tariffService.Create(c, &service.TariffInput{
-		Name:      tariff.Name,
-		ExecPrice: tariff.ExecPrice,
-		MemPrice:  tariff.MemPrice,
-		CpuPrice:  tariff.CpuPrice,
+		Name:         tariff.Name,
+		ExecPrice:    tariff.ExecPrice,
+		MemPrice:     tariff.MemPrice,
+		CpuPrice:     tariff.CpuPrice,
+		RequestPrice: tariff.RequestPrice,
+		Currency:     tariff.Currency,
+		FreeExecSec:  tariff.FreeExecSec,
+		FreeMemMBSec: tariff.FreeMemMBSec,
+		FreeCPUSec:   tariff.FreeCPUSec,
+		Tiers:        tariff.Tiers,
 	})
 	if err != nil {
-		slog.Error("failed to create tariff", err.Error())
+		if errors.Is(err, service.ErrUnknownCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": service.ErrUnknownCurrency.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, service.ErrInvalidTiers) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		slog.Error("failed to create tariff", slog.String("error", err.Error()))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -87,10 +112,11 @@ func (r *tariffRoutes) createNewTariff(c *gin.Context) {
 }
 
 // @Summary Получить тариф по идентификатору
-// @Description Получить тариф по идентификатору
+// @Description Получить тариф по идентификатору с ценами версии, действовавшей в момент at (по умолчанию сейчас)
 // @Tags тарифы
 // @Produce json
 // @Param id path int true "Идентификатор тарифа"
+// @Param at query string false "Момент времени, RFC3339"
 // @Success 200 {object} entity.Tariff
 // @Router /v1/tariff/{id} [get]
 func (r *tariffRoutes) getTariffByID(c *gin.Context) {
@@ -98,14 +124,19 @@ func (r *tariffRoutes) getTariffByID(c *gin.Context) {
 
 	id, err := strconv.Atoi(tariffID)
 	if err != nil {
-		slog.Error("invalid id parameter", err.Error())
+		slog.Error("invalid id parameter", slog.String("error", err.Error()))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "invalid id parameter",
 		})
 		return
 	}
 
-	tariff, err := r.tariffService.GetByID(c, id)
+	var at *time.Time
+	if !parseTimes(c, map[string]**time.Time{"at": &at}) {
+		return
+	}
+
+	tariff, err := r.tariffService.GetByID(c, id, at)
 	if err != nil {
 		if errors.Is(err, service.ErrTariffNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -114,7 +145,7 @@ func (r *tariffRoutes) getTariffByID(c *gin.Context) {
 			return
 		}
 
-		slog.Error("failed to get tariff", err.Error())
+		slog.Error("failed to get tariff", slog.String("error", err.Error()))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -126,6 +157,51 @@ func (r *tariffRoutes) getTariffByID(c *gin.Context) {
 	})
 }
 
+// @Summary Получить историю цен тарифа
+// @Description Версии цен тарифа от старой к новой, можно отфильтровать по периоду [from, to), в котором версия действовала
+// @Tags тарифы
+// @Produce json
+// @Param id path int true "Идентификатор тарифа"
+// @Param from query string false "Начало периода, RFC3339"
+// @Param to query string false "Конец периода, RFC3339"
+// @Success 200 {object} response.GetTariffVersions
+// @Router /v1/tariff/{id}/versions [get]
+func (r *tariffRoutes) getTariffVersions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		slog.Error("invalid id parameter", slog.String("error", err.Error()))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid id parameter",
+		})
+		return
+	}
+
+	filters := buildTariffVersionFilters(c)
+	if filters == nil {
+		return
+	}
+
+	versions, err := r.tariffService.GetVersions(c, id, filters)
+	if err != nil {
+		if errors.Is(err, service.ErrTariffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": service.ErrTariffNotFound.Error(),
+			})
+			return
+		}
+
+		slog.Error("failed to get tariff versions", slog.String("error", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response.GetTariffVersions{
+		Versions: versions,
+	})
+}
+
 // @Summary Получить все тарифы
 // @Description Получить все тарифы
 // @Tags тарифы
@@ -143,7 +219,7 @@ func (r *tariffRoutes) getTariffs(c *gin.Context) {
 
 	tariffs, err := r.tariffService.GetAll(c, filters)
 	if err != nil {
-		slog.Error("failed to get all tariffs", err.Error())
+		slog.Error("failed to get all tariffs", slog.String("error", err.Error()))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -157,7 +233,7 @@ func (r *tariffRoutes) getTariffs(c *gin.Context) {
 }
 
 // @Summary Обновить тариф по его идентификатору
-// @Description Обновить тариф по его идентификатору. Принимает JSON с обновленными полями
+// @Description Обновить тариф по его идентификатору. Принимает JSON с обновленными полями. Новые цены не переписывают старые, а добавляют версию тарифа, действующую с effective_from (по умолчанию сейчас)
 // @Tags тарифы
 // @Accept json
 // @Param id path int true "Идентификатор тарифа"
@@ -169,7 +245,7 @@ func (r *tariffRoutes) updateTariffByID(c *gin.Context) {
 
 	id, err := strconv.Atoi(tariffID)
 	if err != nil {
-		slog.Error("invalid id parameter", err.Error())
+		slog.Error("invalid id parameter", slog.String("error", err.Error()))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "invalid id parameter",
 		})
@@ -179,18 +255,33 @@ func (r *tariffRoutes) updateTariffByID(c *gin.Context) {
 	var updateData request.UpdateTariff
 
 	if err := c.ShouldBindJSON(&updateData); err != nil {
-		slog.Error("Invalid JSON payload", err.Error())
+		slog.Error("Invalid JSON payload", slog.String("error", err.Error()))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid JSON payload",
 		})
 		return
 	}
 
+	if err := r.valid.Struct(&updateData); err != nil {
+		slog.Info("error validating data", slog.Any("error", err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	updatedTariff, err := r.tariffService.UpdateByID(c, id, &service.TariffInput{
-		Name:      updateData.Name,
-		ExecPrice: updateData.ExecPrice,
-		MemPrice:  updateData.MemPrice,
-		CpuPrice:  updateData.CpuPrice,
+		Name:          updateData.Name,
+		ExecPrice:     updateData.ExecPrice,
+		MemPrice:      updateData.MemPrice,
+		CpuPrice:      updateData.CpuPrice,
+		RequestPrice:  updateData.RequestPrice,
+		Currency:      updateData.Currency,
+		FreeExecSec:   updateData.FreeExecSec,
+		FreeMemMBSec:  updateData.FreeMemMBSec,
+		FreeCPUSec:    updateData.FreeCPUSec,
+		Tiers:         updateData.Tiers,
+		EffectiveFrom: updateData.EffectiveFrom,
 	})
 	if err != nil {
 		if errors.Is(err, service.ErrTariffNotFound) {
@@ -200,7 +291,35 @@ func (r *tariffRoutes) updateTariffByID(c *gin.Context) {
 			return
 		}
 
-		slog.Error("failed to update tariff", err.Error())
+		if errors.Is(err, service.ErrUnknownCurrency) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": service.ErrUnknownCurrency.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, service.ErrInvalidTiers) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, service.ErrBackdatedVersion) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, service.ErrInvalidVersionStart) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		slog.Error("failed to update tariff", slog.String("error", err.Error()))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -224,7 +343,7 @@ func (r *tariffRoutes) deleteTariffByID(c *gin.Context) {
 
 	id, err := strconv.Atoi(tariffID)
 	if err != nil {
-		slog.Error("invalid id parameter", err.Error())
+		slog.Error("invalid id parameter", slog.String("error", err.Error()))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "invalid id parameter",
 		})
@@ -239,8 +358,14 @@ func (r *tariffRoutes) deleteTariffByID(c *gin.Context) {
 			})
 			return
 		}
+		if errors.Is(err, service.ErrTariffInUse) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": service.ErrTariffInUse.Error(),
+			})
+			return
+		}
 
-		slog.Error("failed to delete tariff", err.Error())
+		slog.Error("failed to delete tariff", slog.String("error", err.Error()))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -250,3 +375,11 @@ func (r *tariffRoutes) deleteTariffByID(c *gin.Context) {
 	slog.Info("deleted tariff", slog.String("tariffID", tariffID))
 	c.Status(http.StatusNoContent)
 }
+
+// decimalValue lets the validator compare prices with gte and friends.
+func decimalValue(field reflect.Value) any {
+	if d, ok := field.Interface().(money.Decimal); ok {
+		return d.InexactFloat64()
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package v1
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/usamaroman/faas_demo/price_service/internal/entity"
@@ -33,3 +34,69 @@ func buildTariffFilters(c *gin.Context) *entity.TariffFilters {
 		Offset: offset,
 	}
 }
+
+func buildSubscriptionFilters(c *gin.Context) *entity.SubscriptionFilters {
+	page := buildTariffFilters(c)
+	if page == nil {
+		return nil
+	}
+
+	filters := &entity.SubscriptionFilters{
+		Tenant: c.Query("tenant"),
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	}
+
+	if !parseTimes(c, map[string]**time.Time{"from": &filters.From, "to": &filters.To}) {
+		return nil
+	}
+
+	return filters
+}
+
+func buildTariffVersionFilters(c *gin.Context) *entity.TariffVersionFilters {
+	filters := &entity.TariffVersionFilters{}
+	if !parseTimes(c, map[string]**time.Time{"from": &filters.From, "to": &filters.To}) {
+		return nil
+	}
+
+	return filters
+}
+
+// parseTimes reads optional RFC3339 query parameters. It answers with 400 and
+// returns false if one is malformed.
+func parseTimes(c *gin.Context, params map[string]**time.Time) bool {
+	for param, dst := range params {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid " + param + " parameter",
+			})
+			return false
+		}
+		*dst = &t
+	}
+
+	return true
+}
+
+// buildAdjustmentFilters takes the same parameters as the subscription list.
+func buildAdjustmentFilters(c *gin.Context) *entity.AdjustmentFilters {
+	filters := buildSubscriptionFilters(c)
+	if filters == nil {
+		return nil
+	}
+
+	return &entity.AdjustmentFilters{
+		Tenant: filters.Tenant,
+		From:   filters.From,
+		To:     filters.To,
+		Limit:  filters.Limit,
+		Offset: filters.Offset,
+	}
+}
@@ -1,18 +1,180 @@
 package entity
 
-import "time"
+import (
+	"time"
 
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
+)
+
+// Tariff prices are per unit of usage in Currency and are kept exact. The
+// free allowances are waived in every billing period before pricing. Tiers
+// replace the flat price of the dimensions they hold. The prices are those of
+// Version, in force since EffectiveFrom.
 type Tariff struct {
-	ID        int       `db:"id"`
-	Name      string    `db:"name"`
-	ExecPrice float64   `db:"exec_price"`
-	MemPrice  float64   `db:"mem_price"`
-	CpuPrice  float64   `db:"cpu_price"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	ID            int              `db:"id"`
+	Name          string           `db:"name"`
+	Version       int              `db:"version" example:"1"`
+	EffectiveFrom time.Time        `db:"effective_from"`
+	ExecPrice     money.Decimal    `db:"exec_price" swaggertype:"string" example:"0.0000021"`
+	MemPrice      money.Decimal    `db:"mem_price" swaggertype:"string" example:"0.0000000035"`
+	CpuPrice      money.Decimal    `db:"cpu_price" swaggertype:"string" example:"0.000024"`
+	RequestPrice  money.Decimal    `db:"request_price" swaggertype:"string" example:"0.0000002"`
+	Currency      string           `db:"currency" example:"USD"`
+	FreeExecSec   money.Decimal    `db:"free_exec_sec" swaggertype:"string" example:"0"`
+	FreeMemMBSec  money.Decimal    `db:"free_mem_mb_sec" swaggertype:"string" example:"400000"`
+	FreeCPUSec    money.Decimal    `db:"free_cpu_sec" swaggertype:"string" example:"0"`
+	Tiers         pricing.Schedule `db:"tiers"`
+	CreatedAt     time.Time        `db:"created_at"`
+	UpdatedAt     time.Time        `db:"updated_at"`
+}
+
+// TariffVersion is an entry of a tariff's price history, in force during
+// [EffectiveFrom, EffectiveTo). A nil EffectiveTo means it still is. The first
+// version also prices usage before its EffectiveFrom.
+type TariffVersion struct {
+	TariffID      int              `db:"tariff_id"`
+	Version       int              `db:"version" example:"1"`
+	EffectiveFrom time.Time        `db:"effective_from"`
+	EffectiveTo   *time.Time       `db:"effective_to"`
+	ExecPrice     money.Decimal    `db:"exec_price" swaggertype:"string" example:"0.0000021"`
+	MemPrice      money.Decimal    `db:"mem_price" swaggertype:"string" example:"0.0000000035"`
+	CpuPrice      money.Decimal    `db:"cpu_price" swaggertype:"string" example:"0.000024"`
+	RequestPrice  money.Decimal    `db:"request_price" swaggertype:"string" example:"0.0000002"`
+	Currency      string           `db:"currency" example:"USD"`
+	FreeExecSec   money.Decimal    `db:"free_exec_sec" swaggertype:"string" example:"0"`
+	FreeMemMBSec  money.Decimal    `db:"free_mem_mb_sec" swaggertype:"string" example:"400000"`
+	FreeCPUSec    money.Decimal    `db:"free_cpu_sec" swaggertype:"string" example:"0"`
+	Tiers         pricing.Schedule `db:"tiers"`
+	CreatedAt     time.Time        `db:"created_at"`
+}
+
+type TariffVersionFilters struct {
+	// From and To select versions in force during [From, To)
+	From *time.Time
+	To   *time.Time
+}
+
+// FreeAllowances changes a tariff's free allowances, nil ones are kept.
+type FreeAllowances struct {
+	ExecSec  *money.Decimal
+	MemMBSec *money.Decimal
+	CPUSec   *money.Decimal
 }
 
 type TariffFilters struct {
+	// At selects the versions in force at that moment
+	At     time.Time
+	Limit  uint64
+	Offset uint64
+}
+
+// Subscription assigns a tariff to a tenant for [ValidFrom, ValidTo).
+// A nil ValidTo means the subscription is still active.
+type Subscription struct {
+	ID        int        `db:"id"`
+	Tenant    string     `db:"tenant"`
+	TariffID  int        `db:"tariff_id"`
+	ValidFrom time.Time  `db:"valid_from"`
+	ValidTo   *time.Time `db:"valid_to"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+}
+
+type SubscriptionFilters struct {
+	Tenant string
+	// From and To select subscriptions overlapping [From, To)
+	From   *time.Time
+	To     *time.Time
 	Limit  uint64
 	Offset uint64
 }
+
+// Discount takes Percent off a tenant's usage during [ValidFrom, ValidTo).
+// Discounts of a tenant never overlap. A nil ValidTo means it is still active.
+type Discount struct {
+	ID          int           `db:"id"`
+	Tenant      string        `db:"tenant"`
+	Percent     money.Decimal `db:"percent" swaggertype:"string" example:"20"`
+	Description string        `db:"description"`
+	ValidFrom   time.Time     `db:"valid_from"`
+	ValidTo     *time.Time    `db:"valid_to"`
+	CreatedAt   time.Time     `db:"created_at"`
+	UpdatedAt   time.Time     `db:"updated_at"`
+}
+
+// Credit is a prepaid amount that pays for usage from GrantedAt until
+// ExpiresAt. A nil ExpiresAt means it never expires.
+type Credit struct {
+	ID          int           `db:"id"`
+	Tenant      string        `db:"tenant"`
+	Amount      money.Decimal `db:"amount" swaggertype:"string" example:"50"`
+	Currency    string        `db:"currency" example:"USD"`
+	Description string        `db:"description"`
+	GrantedAt   time.Time     `db:"granted_at"`
+	ExpiresAt   *time.Time    `db:"expires_at"`
+	CreatedAt   time.Time     `db:"created_at"`
+	UpdatedAt   time.Time     `db:"updated_at"`
+}
+
+// AdjustmentFilters select a tenant's discounts or credits in force at some
+// point of [From, To).
+type AdjustmentFilters struct {
+	Tenant string
+	From   *time.Time
+	To     *time.Time
+	Limit  uint64
+	Offset uint64
+}
+
+// QuoteUsage is the usage a quote prices, in the units it is billed in:
+// seconds of execution summed over replicas, MB*s, CPU*s and requests.
+type QuoteUsage struct {
+	ExecSec     money.Decimal `swaggertype:"string" example:"2592000"`
+	MemoryMBSec money.Decimal `swaggertype:"string" example:"331776000"`
+	CPUSec      money.Decimal `swaggertype:"string" example:"648000"`
+	Requests    money.Decimal `swaggertype:"string" example:"1000000"`
+}
+
+// QuoteItem is the price of the usage of one dimension in one tier.
+type QuoteItem struct {
+	Dimension string
+	Tier      int
+	Quantity  money.Decimal `swaggertype:"string" example:"1000000"`
+	Unit      string
+	UnitPrice money.Decimal `swaggertype:"string" example:"0.0000002"`
+	Amount    money.Decimal `swaggertype:"string" example:"0.2"`
+}
+
+// QuoteAllowance is a free allowance of the tariff taken off the quote.
+// Amount is negative.
+type QuoteAllowance struct {
+	Dimension string
+	Quantity  money.Decimal `swaggertype:"string" example:"400000"`
+	Unit      string
+	Amount    money.Decimal `swaggertype:"string" example:"-0.0014"`
+}
+
+// QuoteBasis is the measured usage of a function a quote is projected from.
+type QuoteBasis struct {
+	Function string
+	From     time.Time
+	To       time.Time
+	Usage    QuoteUsage
+}
+
+// Quote estimates what the usage costs under a tariff in one billing period,
+// priced the way invoicer prices an invoice. Total is Subtotal less the free
+// allowances, rounded to the currency.
+type Quote struct {
+	TariffID   int
+	TariffName string
+	Currency   string
+	Usage      QuoteUsage
+	Items      []QuoteItem
+	Allowances []QuoteAllowance
+	Subtotal   money.Decimal `swaggertype:"string" example:"12.5"`
+	Total      money.Decimal `swaggertype:"string" example:"12.49"`
+	// Basis is set when the usage is projected from a function's past usage
+	Basis *QuoteBasis
+}
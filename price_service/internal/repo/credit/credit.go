@@ -0,0 +1,124 @@
+package credit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+var columns = []string{"id", "tenant", "amount", "currency", "description", "granted_at", "expires_at", "created_at", "updated_at"}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+func (r *Repo) Grant(ctx context.Context, body *entity.Credit) (*entity.Credit, error) {
+	q, args, err := r.Builder.Insert("credits").
+		Columns("tenant", "amount", "currency", "description", "granted_at", "expires_at").
+		Values(body.Tenant, body.Amount, body.Currency, body.Description, body.GrantedAt, body.ExpiresAt).
+		Suffix("RETURNING " + strings.Join(columns, ", ")).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("grant credit query", slog.String("query", q))
+
+	return r.one(ctx, q, args)
+}
+
+func (r *Repo) GetByID(ctx context.Context, id int) (*entity.Credit, error) {
+	q, args, err := r.Builder.
+		Select(columns...).
+		From("credits").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get credit by id query", slog.String("query", q))
+
+	return r.one(ctx, q, args)
+}
+
+func (r *Repo) GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Credit, error) {
+	qb := r.Builder.
+		Select(columns...).
+		From("credits").
+		OrderBy("tenant", "granted_at", "id")
+
+	if filters.Tenant != "" {
+		qb = qb.Where(squirrel.Eq{"tenant": filters.Tenant})
+	}
+	if filters.To != nil {
+		qb = qb.Where(squirrel.Lt{"granted_at": *filters.To})
+	}
+	if filters.From != nil {
+		qb = qb.Where(squirrel.Or{
+			squirrel.Eq{"expires_at": nil},
+			squirrel.Gt{"expires_at": *filters.From},
+		})
+	}
+	if filters.Limit > 0 {
+		qb = qb.Limit(filters.Limit)
+	}
+
+	q, args, err := qb.Offset(filters.Offset).ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get all credits query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get credits from database", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Credit])
+	if err != nil {
+		slog.Error("failed to collect rows", slog.String("error", err.Error()))
+	}
+
+	return credits, err
+}
+
+func (r *Repo) one(ctx context.Context, q string, args []any) (*entity.Credit, error) {
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to query credit", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	credit, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[entity.Credit])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to collect credit", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &credit, nil
+}
@@ -0,0 +1,149 @@
+package discount
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const exclusionViolation = "23P01"
+
+var columns = []string{"id", "tenant", "percent", "description", "valid_from", "valid_to", "created_at", "updated_at"}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+func (r *Repo) Create(ctx context.Context, body *entity.Discount) (*entity.Discount, error) {
+	q, args, err := r.Builder.Insert("discounts").
+		Columns("tenant", "percent", "description", "valid_from", "valid_to").
+		Values(body.Tenant, body.Percent, body.Description, body.ValidFrom, body.ValidTo).
+		Suffix("RETURNING " + strings.Join(columns, ", ")).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("create discount query", slog.String("query", q))
+
+	return r.one(ctx, q, args)
+}
+
+func (r *Repo) GetByID(ctx context.Context, id int) (*entity.Discount, error) {
+	q, args, err := r.Builder.
+		Select(columns...).
+		From("discounts").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get discount by id query", slog.String("query", q))
+
+	return r.one(ctx, q, args)
+}
+
+func (r *Repo) GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Discount, error) {
+	qb := r.Builder.
+		Select(columns...).
+		From("discounts").
+		OrderBy("tenant", "valid_from")
+
+	if filters.Tenant != "" {
+		qb = qb.Where(squirrel.Eq{"tenant": filters.Tenant})
+	}
+	if filters.To != nil {
+		qb = qb.Where(squirrel.Lt{"valid_from": *filters.To})
+	}
+	if filters.From != nil {
+		qb = qb.Where(squirrel.Or{
+			squirrel.Eq{"valid_to": nil},
+			squirrel.Gt{"valid_to": *filters.From},
+		})
+	}
+	if filters.Limit > 0 {
+		qb = qb.Limit(filters.Limit)
+	}
+
+	q, args, err := qb.Offset(filters.Offset).ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get all discounts query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get discounts from database", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	discounts, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Discount])
+	if err != nil {
+		slog.Error("failed to collect rows", slog.String("error", err.Error()))
+	}
+
+	return discounts, err
+}
+
+func (r *Repo) End(ctx context.Context, id int, at time.Time) (*entity.Discount, error) {
+	q, args, err := r.Builder.Update("discounts").
+		Set("valid_to", at).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING " + strings.Join(columns, ", ")).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("end discount query", slog.String("query", q))
+
+	return r.one(ctx, q, args)
+}
+
+func (r *Repo) one(ctx context.Context, q string, args []any) (*entity.Discount, error) {
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to query discount", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	discount, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[entity.Discount])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == exclusionViolation {
+			return nil, repoerrors.ErrConflict
+		}
+
+		slog.Error("failed to collect discount", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &discount, nil
+}
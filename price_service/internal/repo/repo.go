@@ -2,26 +2,57 @@ package repo
 
 import (
 	"context"
+	"time"
 
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
 	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/credit"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/discount"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/subscription"
 	"github.com/usamaroman/faas_demo/price_service/internal/repo/tariff"
-	"github.com/usamaroman/faas_demo/pkg/postgresql"
 )
 
 type Tariff interface {
 	Create(ctx context.Context, body *entity.Tariff) (*entity.Tariff, error)
-	GetByID(ctx context.Context, id int) (*entity.Tariff, error)
+	GetByID(ctx context.Context, id int, at time.Time) (*entity.Tariff, error)
 	GetAll(ctx context.Context, filters *entity.TariffFilters) ([]entity.Tariff, error)
-	UpdateByID(ctx context.Context, id int, updates *entity.Tariff) (*entity.Tariff, error)
-	DeleteByID(ctx context.Context, id int) error
+	GetVersions(ctx context.Context, id int, filters *entity.TariffVersionFilters) ([]entity.TariffVersion, error)
+	UpdateByID(ctx context.Context, id int, updates *entity.Tariff, allowances *entity.FreeAllowances) (*entity.Tariff, error)
+	DeleteByID(ctx context.Context, id int, now time.Time) error
+}
+
+type Subscription interface {
+	Assign(ctx context.Context, body *entity.Subscription) (*entity.Subscription, error)
+	GetByID(ctx context.Context, id int) (*entity.Subscription, error)
+	GetAll(ctx context.Context, filters *entity.SubscriptionFilters) ([]entity.Subscription, error)
+	End(ctx context.Context, id int, at time.Time) (*entity.Subscription, error)
+}
+
+type Discount interface {
+	Create(ctx context.Context, body *entity.Discount) (*entity.Discount, error)
+	GetByID(ctx context.Context, id int) (*entity.Discount, error)
+	GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Discount, error)
+	End(ctx context.Context, id int, at time.Time) (*entity.Discount, error)
+}
+
+type Credit interface {
+	Grant(ctx context.Context, body *entity.Credit) (*entity.Credit, error)
+	GetByID(ctx context.Context, id int) (*entity.Credit, error)
+	GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Credit, error)
 }
 
 type Repositories struct {
 	Tariff
+	Subscription
+	Discount
+	Credit
 }
 
 func NewRepositories(pg *postgresql.Postgres) *Repositories {
 	return &Repositories{
-		Tariff: tariff.NewRepo(pg),
+		Tariff:       tariff.NewRepo(pg),
+		Subscription: subscription.NewRepo(pg),
+		Discount:     discount.NewRepo(pg),
+		Credit:       credit.NewRepo(pg),
 	}
 }
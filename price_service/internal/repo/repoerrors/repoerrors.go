@@ -3,5 +3,7 @@ package repoerrors
 import "errors"
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("conflict")
+	ErrReference = errors.New("referenced row does not exist")
 )
@@ -0,0 +1,233 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	exclusionViolation  = "23P01"
+	foreignKeyViolation = "23503"
+)
+
+var columns = []string{"id", "tenant", "tariff_id", "valid_from", "valid_to", "created_at", "updated_at"}
+
+type Repo struct {
+	*postgresql.Postgres
+}
+
+func NewRepo(pg *postgresql.Postgres) *Repo {
+	return &Repo{
+		Postgres: pg,
+	}
+}
+
+// Assign ends the tenant's open subscription at body.ValidFrom and inserts the
+// new one in the same transaction, so a plan change leaves no gap.
+func (r *Repo) Assign(ctx context.Context, body *entity.Subscription) (*entity.Subscription, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// deleted tariffs take no new subscriptions, the share lock keeps the
+	// tariff from being deleted until the subscription is in
+	q, args, err := r.Builder.
+		Select("id").
+		From("tariffs").
+		Where(squirrel.Eq{"id": body.TariffID, "deleted_at": nil}).
+		Suffix("FOR SHARE").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var tariffID int
+	if err := tx.QueryRow(ctx, q, args...).Scan(&tariffID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrReference
+		}
+
+		slog.Error("failed to lock tariff", slog.Int("tariff_id", body.TariffID), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	q, args, err = r.Builder.Update("subscriptions").
+		Set("valid_to", body.ValidFrom).
+		Where(squirrel.Eq{"tenant": body.Tenant, "valid_to": nil}).
+		Where(squirrel.Lt{"valid_from": body.ValidFrom}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("end open subscription query", slog.String("query", q))
+
+	if _, err := tx.Exec(ctx, q, args...); err != nil {
+		slog.Error("failed to end open subscription", slog.String("tenant", body.Tenant), slog.String("error", err.Error()))
+		return nil, mapError(err)
+	}
+
+	q, args, err = r.Builder.Insert("subscriptions").
+		Columns("tenant", "tariff_id", "valid_from", "valid_to").
+		Values(body.Tenant, body.TariffID, body.ValidFrom, body.ValidTo).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("create subscription query", slog.String("query", q))
+
+	if err := tx.QueryRow(ctx, q, args...).Scan(&body.ID, &body.CreatedAt, &body.UpdatedAt); err != nil {
+		slog.Error("failed to create subscription", slog.String("tenant", body.Tenant), slog.String("error", err.Error()))
+		return nil, mapError(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit subscription", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (r *Repo) GetByID(ctx context.Context, id int) (*entity.Subscription, error) {
+	q, args, err := r.Builder.
+		Select(columns...).
+		From("subscriptions").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get subscription by id query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get subscription", slog.Int("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	sub, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[entity.Subscription])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to collect subscription", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &sub, nil
+}
+
+func (r *Repo) GetAll(ctx context.Context, filters *entity.SubscriptionFilters) ([]entity.Subscription, error) {
+	qb := r.Builder.
+		Select(columns...).
+		From("subscriptions").
+		OrderBy("tenant", "valid_from")
+
+	if filters.Tenant != "" {
+		qb = qb.Where(squirrel.Eq{"tenant": filters.Tenant})
+	}
+	if filters.To != nil {
+		qb = qb.Where(squirrel.Lt{"valid_from": *filters.To})
+	}
+	if filters.From != nil {
+		qb = qb.Where(squirrel.Or{
+			squirrel.Eq{"valid_to": nil},
+			squirrel.Gt{"valid_to": *filters.From},
+		})
+	}
+	if filters.Limit > 0 {
+		qb = qb.Limit(filters.Limit)
+	}
+
+	q, args, err := qb.Offset(filters.Offset).ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get all subscriptions query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get subscriptions from database", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Subscription])
+	if err != nil {
+		slog.Error("failed to collect rows", slog.String("error", err.Error()))
+	}
+
+	return subs, err
+}
+
+func (r *Repo) End(ctx context.Context, id int, at time.Time) (*entity.Subscription, error) {
+	q, args, err := r.Builder.Update("subscriptions").
+		Set("valid_to", at).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING " + strings.Join(columns, ", ")).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("end subscription query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to end subscription", slog.Int("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	sub, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[entity.Subscription])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to scan subscription after ending it", slog.String("error", err.Error()))
+		return nil, mapError(err)
+	}
+
+	return &sub, nil
+}
+
+func mapError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case exclusionViolation:
+			return repoerrors.ErrConflict
+		case foreignKeyViolation:
+			return repoerrors.ErrReference
+		}
+	}
+	return err
+}
@@ -4,15 +4,27 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"time"
 
+	"github.com/usamaroman/faas_demo/pkg/postgresql"
 	"github.com/usamaroman/faas_demo/price_service/internal/entity"
 	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
-	"github.com/usamaroman/faas_demo/pkg/postgresql"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 )
 
+// columns of a tariff joined with one of its versions.
+var columns = []string{
+	"t.id", "t.name", "v.version", "v.effective_from", "v.exec_price", "v.mem_price", "v.cpu_price", "v.request_price",
+	"v.currency", "v.free_exec_sec", "v.free_mem_mb_sec", "v.free_cpu_sec", "v.tiers", "t.created_at", "t.updated_at",
+}
+
+var versionColumns = []string{
+	"tariff_id", "version", "effective_from", "effective_to", "exec_price", "mem_price", "cpu_price", "request_price",
+	"currency", "free_exec_sec", "free_mem_mb_sec", "free_cpu_sec", "tiers", "created_at",
+}
+
 type Repo struct {
 	*postgresql.Postgres
 }
@@ -23,64 +35,88 @@ func NewRepo(pg *postgresql.Postgres) *Repo {
 	}
 }
 
+// Create inserts the tariff with its first version.
 func (r *Repo) Create(ctx context.Context, body *entity.Tariff) (*entity.Tariff, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
 	q, args, err := r.Builder.Insert("tariffs").
-		Columns("name", "exec_price", "mem_price", "cpu_price").
-		Values(body.Name, body.ExecPrice, body.MemPrice, body.CpuPrice).
-		Suffix("RETURNING id, exec_price, mem_price, cpu_price, created_at, updated_at").
+		Columns("name").
+		Values(body.Name).
+		Suffix("RETURNING id, created_at, updated_at").
 		ToSql()
 	if err != nil {
-		slog.Error("failed to make query", err.Error())
+		slog.Error("failed to make query", slog.String("error", err.Error()))
 		return nil, err
 	}
 
 	slog.Debug("create tariff query", slog.String("query", q))
 
-	if err := r.Pool.QueryRow(ctx, q, args...).Scan(
-		&body.ID,
-		&body.ExecPrice,
-		&body.MemPrice,
-		&body.CpuPrice,
-		&body.CreatedAt,
-		&body.UpdatedAt,
-	); err != nil {
-		slog.Error("failed to scan returning values after creating tariff", err.Error())
+	if err := tx.QueryRow(ctx, q, args...).Scan(&body.ID, &body.CreatedAt, &body.UpdatedAt); err != nil {
+		slog.Error("failed to scan returning values after creating tariff", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	version, err := r.insertVersion(ctx, tx, &entity.TariffVersion{
+		TariffID:      body.ID,
+		Version:       1,
+		EffectiveFrom: body.EffectiveFrom,
+		ExecPrice:     body.ExecPrice,
+		MemPrice:      body.MemPrice,
+		CpuPrice:      body.CpuPrice,
+		RequestPrice:  body.RequestPrice,
+		Currency:      body.Currency,
+		FreeExecSec:   body.FreeExecSec,
+		FreeMemMBSec:  body.FreeMemMBSec,
+		FreeCPUSec:    body.FreeCPUSec,
+		Tiers:         body.Tiers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit tariff", slog.String("error", err.Error()))
 		return nil, err
 	}
 
+	body.Version = version.Version
+	body.EffectiveFrom = version.EffectiveFrom
 	return body, nil
 }
 
-func (r *Repo) GetByID(ctx context.Context, id int) (*entity.Tariff, error) {
-	q, args, err := r.Builder.
-		Select("*").
-		From("tariffs").
-		Where(squirrel.Eq{"id": id}).
+// GetByID returns the tariff with the prices in force at the given moment.
+func (r *Repo) GetByID(ctx context.Context, id int, at time.Time) (*entity.Tariff, error) {
+	q, args, err := r.withVersion(at).
+		Where(squirrel.Eq{"t.id": id}).
 		ToSql()
-
 	if err != nil {
-		slog.Error("failed to build query", err.Error())
+		slog.Error("failed to build query", slog.String("error", err.Error()))
 		return nil, err
 	}
 
 	slog.Debug("get tariff by id query", slog.String("query", q))
 
-	var tariff entity.Tariff
-	if err := r.Pool.QueryRow(ctx, q, args...).Scan(
-		&tariff.ID,
-		&tariff.Name,
-		&tariff.ExecPrice,
-		&tariff.MemPrice,
-		&tariff.CpuPrice,
-		&tariff.CreatedAt,
-		&tariff.UpdatedAt,
-	); err != nil {
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get tariff", slog.Int("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	tariff, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[entity.Tariff])
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("no tariff found", slog.Any("id", id), err.Error())
+			slog.Error("no tariff found", slog.Any("id", id), slog.String("error", err.Error()))
 			return nil, repoerrors.ErrNotFound
 		}
 
-		slog.Error("failed to scan tariff", err.Error())
+		slog.Error("failed to scan tariff", slog.String("error", err.Error()))
 		return nil, err
 	}
 
@@ -88,16 +124,14 @@ func (r *Repo) GetByID(ctx context.Context, id int) (*entity.Tariff, error) {
 }
 
 func (r *Repo) GetAll(ctx context.Context, filters *entity.TariffFilters) ([]entity.Tariff, error) {
-	qb := r.Builder.
-		Select("*").
-		From("tariffs")
-
-	q, args, err := qb.Limit(filters.Limit).
+	q, args, err := r.withVersion(filters.At).
+		OrderBy("t.id").
+		Limit(filters.Limit).
 		Offset(filters.Offset).
 		ToSql()
 
 	if err != nil {
-		slog.Error("failed to build query", err.Error())
+		slog.Error("failed to build query", slog.String("error", err.Error()))
 		return nil, err
 	}
 
@@ -105,82 +139,172 @@ func (r *Repo) GetAll(ctx context.Context, filters *entity.TariffFilters) ([]ent
 
 	rows, err := r.Pool.Query(ctx, q, args...)
 	if err != nil {
-		slog.Error("failed to get tariffs from database", err.Error())
+		slog.Error("failed to get tariffs from database", slog.String("error", err.Error()))
 		return nil, err
 	}
 	defer rows.Close()
 
 	tariffs, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Tariff])
 	if err != nil {
-		slog.Error("failed to collect rows", err.Error())
+		slog.Error("failed to collect rows", slog.String("error", err.Error()))
 	}
 
 	return tariffs, err
 }
 
-func (r *Repo) UpdateByID(ctx context.Context, id int, updates *entity.Tariff) (*entity.Tariff, error) {
-	builder := r.Builder.Update("tariffs")
+// GetVersions returns the price history of a tariff, oldest first. A tariff
+// always has a version in force, so no versions means no tariff.
+func (r *Repo) GetVersions(ctx context.Context, id int, filters *entity.TariffVersionFilters) ([]entity.TariffVersion, error) {
+	qb := r.Builder.
+		Select(versionColumns...).
+		From("tariff_version_periods").
+		Where(squirrel.Eq{"tariff_id": id}).
+		OrderBy("version")
+
+	if filters.To != nil {
+		qb = qb.Where(squirrel.Or{
+			squirrel.Lt{"effective_from": *filters.To},
+			squirrel.Eq{"version": 1},
+		})
+	}
+	if filters.From != nil {
+		qb = qb.Where(squirrel.Or{
+			squirrel.Eq{"effective_to": nil},
+			squirrel.Gt{"effective_to": *filters.From},
+		})
+	}
 
-	if updates.Name != "" {
-		builder = builder.Set("name", updates.Name)
+	q, args, err := qb.ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
 	}
 
-	if updates.ExecPrice != 0 {
-		builder = builder.Set("exec_price", updates.ExecPrice)
+	slog.Debug("get tariff versions query", slog.String("query", q))
+
+	rows, err := r.Pool.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get tariff versions", slog.Int("id", id), slog.String("error", err.Error()))
+		return nil, err
 	}
+	defer rows.Close()
 
-	if updates.MemPrice != 0 {
-		builder = builder.Set("mem_price", updates.MemPrice)
+	versions, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.TariffVersion])
+	if err != nil {
+		slog.Error("failed to collect rows", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, repoerrors.ErrNotFound
 	}
 
-	if updates.CpuPrice != 0 {
-		builder = builder.Set("cpu_price", updates.CpuPrice)
+	return versions, nil
+}
+
+// UpdateByID renames the tariff and adds a version with the non-zero fields
+// of updates over the latest version, in force from updates.EffectiveFrom.
+// Non-nil Tiers replace the whole schedule. Free allowances are in allowances
+// instead, a nil one is kept and a zero one removed. The new version must
+// start after the latest one, or else it is a repoerrors.ErrConflict. The
+// tariff is returned with the new version.
+func (r *Repo) UpdateByID(ctx context.Context, id int, updates *entity.Tariff, allowances *entity.FreeAllowances) (*entity.Tariff, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// the row lock keeps concurrent updates from adding the same version
+	builder := r.Builder.Update("tariffs").
+		Set("updated_at", squirrel.Expr("CURRENT_TIMESTAMP"))
+	if updates.Name != "" {
+		builder = builder.Set("name", updates.Name)
 	}
 
-	q, args, err := builder.Where(squirrel.Eq{"id": id}).
-		Suffix("RETURNING id, exec_price, mem_price, cpu_price, created_at, updated_at").
+	q, args, err := builder.Where(squirrel.Eq{"id": id, "deleted_at": nil}).
+		Suffix("RETURNING id").
 		ToSql()
 	if err != nil {
-		slog.Error("failed to build SQL query", slog.Any("id", id), err.Error())
+		slog.Error("failed to build SQL query", slog.Any("id", id), slog.String("error", err.Error()))
 		return nil, err
 	}
 
 	slog.Debug("update tariff query", slog.String("query", q))
 
-	if err := r.Pool.QueryRow(ctx, q, args...).Scan(
-		&updates.ID,
-		&updates.ExecPrice,
-		&updates.MemPrice,
-		&updates.CpuPrice,
-		&updates.CreatedAt,
-		&updates.UpdatedAt,
-	); err != nil {
+	if err := tx.QueryRow(ctx, q, args...).Scan(&id); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("no tariff for update", err.Error())
+			slog.Error("no tariff for update", slog.String("error", err.Error()))
 			return nil, repoerrors.ErrNotFound
 		}
 
-		slog.Error("failed to scan returning values after updating tariff", err.Error())
+		slog.Error("failed to update tariff", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	at := time.Now()
+	if changesPrices(updates, allowances) {
+		version, err := r.latestVersion(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !updates.EffectiveFrom.After(version.EffectiveFrom) {
+			slog.Error("tariff version must start after the latest one", slog.Int("id", id),
+				slog.Time("effective_from", updates.EffectiveFrom), slog.Time("latest", version.EffectiveFrom))
+			return nil, repoerrors.ErrConflict
+		}
+
+		version.Version++
+		version.EffectiveFrom = updates.EffectiveFrom
+		applyUpdates(version, updates, allowances)
+
+		if _, err := r.insertVersion(ctx, tx, version); err != nil {
+			return nil, err
+		}
+		at = version.EffectiveFrom
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit tariff update", slog.String("error", err.Error()))
 		return nil, err
 	}
 
 	slog.Debug("update rows successfuly")
 
-	return updates, nil
+	return r.GetByID(ctx, id, at)
 }
 
-func (r *Repo) DeleteByID(ctx context.Context, id int) error {
-	q, args, err := r.Builder.Delete("tariffs").Where(squirrel.Eq{"id": id}).ToSql()
+// DeleteByID marks the tariff deleted, its versions stay to price the usage
+// invoiced with them. A tariff with a subscription open at or after now is a
+// repoerrors.ErrConflict.
+func (r *Repo) DeleteByID(ctx context.Context, id int, now time.Time) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		slog.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// the row lock makes subscriptions assigned meanwhile wait and then see
+	// the tariff deleted
+	q, args, err := r.Builder.Update("tariffs").
+		Set("deleted_at", now).
+		Where(squirrel.Eq{"id": id, "deleted_at": nil}).
+		ToSql()
 	if err != nil {
-		slog.Error("failed to make query", err.Error())
+		slog.Error("failed to make query", slog.String("error", err.Error()))
 		return err
 	}
 
 	slog.Debug("delete tariff by id query", slog.String("query", q))
 
-	result, err := r.Pool.Exec(ctx, q, args...)
+	result, err := tx.Exec(ctx, q, args...)
 	if err != nil {
-		slog.Error("failed to delete tariff by id", slog.Any("id", id), err.Error())
+		slog.Error("failed to delete tariff by id", slog.Any("id", id), slog.String("error", err.Error()))
 		return err
 	}
 
@@ -189,5 +313,160 @@ func (r *Repo) DeleteByID(ctx context.Context, id int) error {
 		return repoerrors.ErrNotFound
 	}
 
+	q, args, err = r.Builder.
+		Select("count(*)").
+		From("subscriptions").
+		Where(squirrel.Eq{"tariff_id": id}).
+		Where(squirrel.Or{
+			squirrel.Eq{"valid_to": nil},
+			squirrel.Gt{"valid_to": now},
+		}).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to make query", slog.String("error", err.Error()))
+		return err
+	}
+
+	var open int
+	if err := tx.QueryRow(ctx, q, args...).Scan(&open); err != nil {
+		slog.Error("failed to count tariff subscriptions", slog.Any("id", id), slog.String("error", err.Error()))
+		return err
+	}
+	if open > 0 {
+		slog.Error("tariff has open subscriptions", slog.Any("id", id), slog.Int("subscriptions", open))
+		return repoerrors.ErrConflict
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("failed to commit tariff deletion", slog.String("error", err.Error()))
+		return err
+	}
+
 	return nil
 }
+
+// withVersion selects tariffs with the version in force at the given moment:
+// the latest one started by then, or the first one before any started.
+// Deleted tariffs are left out, their versions are read with GetVersions.
+func (r *Repo) withVersion(at time.Time) squirrel.SelectBuilder {
+	return r.Builder.
+		Select(columns...).
+		From("tariffs t").
+		Join("tariff_version_periods v ON v.tariff_id = t.id").
+		Where(squirrel.Eq{"t.deleted_at": nil}).
+		Where(squirrel.Or{
+			squirrel.LtOrEq{"v.effective_from": at},
+			squirrel.Eq{"v.version": 1},
+		}).
+		Where(squirrel.Or{
+			squirrel.Eq{"v.effective_to": nil},
+			squirrel.Gt{"v.effective_to": at},
+		})
+}
+
+func (r *Repo) latestVersion(ctx context.Context, tx pgx.Tx, id int) (*entity.TariffVersion, error) {
+	q, args, err := r.Builder.
+		Select(versionColumns...).
+		From("tariff_version_periods").
+		Where(squirrel.Eq{"tariff_id": id}).
+		OrderBy("version DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		slog.Error("failed to build query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("get latest tariff version query", slog.String("query", q))
+
+	rows, err := tx.Query(ctx, q, args...)
+	if err != nil {
+		slog.Error("failed to get latest tariff version", slog.Int("id", id), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	version, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[entity.TariffVersion])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repoerrors.ErrNotFound
+		}
+
+		slog.Error("failed to scan tariff version", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+func (r *Repo) insertVersion(ctx context.Context, tx pgx.Tx, v *entity.TariffVersion) (*entity.TariffVersion, error) {
+	q, args, err := r.Builder.Insert("tariff_versions").
+		Columns("tariff_id", "version", "effective_from", "exec_price", "mem_price", "cpu_price", "request_price",
+			"currency", "free_exec_sec", "free_mem_mb_sec", "free_cpu_sec", "tiers").
+		Values(v.TariffID, v.Version, v.EffectiveFrom, v.ExecPrice, v.MemPrice, v.CpuPrice, v.RequestPrice,
+			v.Currency, v.FreeExecSec, v.FreeMemMBSec, v.FreeCPUSec, v.Tiers).
+		Suffix("RETURNING effective_from, created_at").
+		ToSql()
+	if err != nil {
+		slog.Error("failed to make query", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	slog.Debug("create tariff version query", slog.String("query", q))
+
+	if err := tx.QueryRow(ctx, q, args...).Scan(&v.EffectiveFrom, &v.CreatedAt); err != nil {
+		slog.Error("failed to create tariff version", slog.Int("tariff_id", v.TariffID), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// changesPrices tells whether an update touches anything but the name.
+func changesPrices(updates *entity.Tariff, allowances *entity.FreeAllowances) bool {
+	if !updates.ExecPrice.IsZero() || !updates.MemPrice.IsZero() || !updates.CpuPrice.IsZero() ||
+		!updates.RequestPrice.IsZero() || updates.Currency != "" || updates.Tiers != nil {
+		return true
+	}
+
+	return allowances != nil && (allowances.ExecSec != nil || allowances.MemMBSec != nil || allowances.CPUSec != nil)
+}
+
+func applyUpdates(v *entity.TariffVersion, updates *entity.Tariff, allowances *entity.FreeAllowances) {
+	if !updates.ExecPrice.IsZero() {
+		v.ExecPrice = updates.ExecPrice
+	}
+
+	if !updates.MemPrice.IsZero() {
+		v.MemPrice = updates.MemPrice
+	}
+
+	if !updates.CpuPrice.IsZero() {
+		v.CpuPrice = updates.CpuPrice
+	}
+
+	if !updates.RequestPrice.IsZero() {
+		v.RequestPrice = updates.RequestPrice
+	}
+
+	if updates.Currency != "" {
+		v.Currency = updates.Currency
+	}
+
+	if updates.Tiers != nil {
+		v.Tiers = updates.Tiers
+	}
+
+	if allowances != nil {
+		if allowances.ExecSec != nil {
+			v.FreeExecSec = *allowances.ExecSec
+		}
+
+		if allowances.MemMBSec != nil {
+			v.FreeMemMBSec = *allowances.MemMBSec
+		}
+
+		if allowances.CPUSec != nil {
+			v.FreeCPUSec = *allowances.CPUSec
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+)
+
+type DiscountService struct {
+	discountRepo repo.Discount
+	now          func() time.Time
+}
+
+type DiscountInput struct {
+	Tenant      string        `json:"tenant"`
+	Percent     money.Decimal `json:"percent"`
+	Description string        `json:"description"`
+	// ValidFrom defaults to now
+	ValidFrom *time.Time `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+}
+
+func NewDiscountService(discountRepo repo.Discount) *DiscountService {
+	slog.Debug("component", slog.String("name", "discount service"))
+
+	return &DiscountService{
+		discountRepo: discountRepo,
+		now:          time.Now,
+	}
+}
+
+// Create gives the tenant a discount from ValidFrom on. Unlike subscriptions
+// a running discount is not ended, overlapping discounts are rejected.
+func (s *DiscountService) Create(ctx context.Context, body *DiscountInput) (*entity.Discount, error) {
+	validFrom := s.now().UTC()
+	if body.ValidFrom != nil {
+		validFrom = body.ValidFrom.UTC()
+	}
+	if body.ValidTo != nil && !body.ValidTo.After(validFrom) {
+		return nil, ErrInvalidAdjustmentEnd
+	}
+
+	discount, err := s.discountRepo.Create(ctx, &entity.Discount{
+		Tenant:      body.Tenant,
+		Percent:     body.Percent,
+		Description: body.Description,
+		ValidFrom:   validFrom,
+		ValidTo:     body.ValidTo,
+	})
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrConflict) {
+			return nil, ErrDiscountOverlap
+		}
+
+		slog.Error("failed to create discount", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return discount, nil
+}
+
+func (s *DiscountService) GetByID(ctx context.Context, id int) (*entity.Discount, error) {
+	discount, err := s.discountRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrDiscountNotFound
+		}
+
+		return nil, err
+	}
+
+	return discount, nil
+}
+
+func (s *DiscountService) GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Discount, error) {
+	return s.discountRepo.GetAll(ctx, filters)
+}
+
+// End closes the discount at the given moment. Like subscriptions, a discount
+// can be shortened but never extended.
+func (s *DiscountService) End(ctx context.Context, id int, at time.Time) (*entity.Discount, error) {
+	discount, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	at = at.UTC()
+	if !at.After(discount.ValidFrom) || (discount.ValidTo != nil && at.After(*discount.ValidTo)) {
+		return nil, ErrInvalidAdjustmentEnd
+	}
+
+	ended, err := s.discountRepo.End(ctx, id, at)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrDiscountNotFound
+		}
+
+		return nil, err
+	}
+
+	return ended, nil
+}
+
+type CreditService struct {
+	creditRepo repo.Credit
+	now        func() time.Time
+}
+
+type CreditInput struct {
+	Tenant      string        `json:"tenant"`
+	Amount      money.Decimal `json:"amount"`
+	Currency    string        `json:"currency"`
+	Description string        `json:"description"`
+	// GrantedAt defaults to now
+	GrantedAt *time.Time `json:"granted_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func NewCreditService(creditRepo repo.Credit) *CreditService {
+	slog.Debug("component", slog.String("name", "credit service"))
+
+	return &CreditService{
+		creditRepo: creditRepo,
+		now:        time.Now,
+	}
+}
+
+// Grant gives the tenant a credit. Credits are never changed afterwards,
+// invoicer keeps track of how much of each one is spent.
+func (s *CreditService) Grant(ctx context.Context, body *CreditInput) (*entity.Credit, error) {
+	currency := money.USD
+	if body.Currency != "" {
+		c, err := money.ParseCurrency(body.Currency)
+		if err != nil {
+			return nil, ErrUnknownCurrency
+		}
+		currency = c
+	}
+
+	grantedAt := s.now().UTC()
+	if body.GrantedAt != nil {
+		grantedAt = body.GrantedAt.UTC()
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(grantedAt) {
+		return nil, ErrInvalidAdjustmentEnd
+	}
+
+	credit, err := s.creditRepo.Grant(ctx, &entity.Credit{
+		Tenant:      body.Tenant,
+		Amount:      body.Amount,
+		Currency:    currency.Code,
+		Description: body.Description,
+		GrantedAt:   grantedAt,
+		ExpiresAt:   body.ExpiresAt,
+	})
+	if err != nil {
+		slog.Error("failed to grant credit", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return credit, nil
+}
+
+func (s *CreditService) GetByID(ctx context.Context, id int) (*entity.Credit, error) {
+	credit, err := s.creditRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrCreditNotFound
+		}
+
+		return nil, err
+	}
+
+	return credit, nil
+}
+
+func (s *CreditService) GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Credit, error) {
+	return s.creditRepo.GetAll(ctx, filters)
+}
@@ -3,5 +3,20 @@ package service
 import "errors"
 
 var (
-	ErrTariffNotFound = errors.New("tariff not found")
+	ErrTariffNotFound         = errors.New("tariff not found")
+	ErrUnknownCurrency        = errors.New("unknown currency")
+	ErrInvalidTiers           = errors.New("invalid tariff tiers")
+	ErrInvalidVersionStart    = errors.New("tariff version can only start after the latest version")
+	ErrBackdatedVersion       = errors.New("tariff version can not start in the past, recalculate the invoices instead")
+	ErrTariffInUse            = errors.New("tariff has open or future subscriptions")
+	ErrSubscriptionNotFound   = errors.New("subscription not found")
+	ErrSubscriptionOverlap    = errors.New("subscription overlaps another subscription of the tenant")
+	ErrInvalidSubscriptionEnd = errors.New("subscription can only end after it starts and before its current end")
+	ErrDiscountNotFound       = errors.New("discount not found")
+	ErrDiscountOverlap        = errors.New("discount overlaps another discount of the tenant")
+	ErrCreditNotFound         = errors.New("credit not found")
+	ErrInvalidAdjustmentEnd   = errors.New("adjustment can only end after it starts and before its current end")
+	ErrNoActiveSubscription   = errors.New("tenant has no active subscription")
+	ErrNoFunctionUsage        = errors.New("function has no usage in the period")
+	ErrUsageUnavailable       = errors.New("failed to get usage from invoicer")
 )
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+)
+
+// UsageProvider reads the measured usage of a function from invoicer.
+type UsageProvider interface {
+	FunctionUsage(ctx context.Context, tenant, function string, from, to time.Time) (*entity.QuoteUsage, error)
+}
+
+const (
+	// quoteMonth is the period a function's past usage is projected to.
+	quoteMonth = 30 * 24 * time.Hour
+	// defaultQuoteDays of past usage are projected when none are asked for.
+	defaultQuoteDays = 7
+)
+
+type QuoteService struct {
+	tariffRepo       repo.Tariff
+	subscriptionRepo repo.Subscription
+	usage            UsageProvider
+	now              func() time.Time
+}
+
+// QuoteInput is projected usage. Every replica runs for DurationSec with
+// MemoryMB of memory and CPU cores on average, Requests are the total.
+type QuoteInput struct {
+	// TariffID defaults to the tenant's active tariff
+	TariffID    int           `json:"tariff_id"`
+	Tenant      string        `json:"tenant"`
+	DurationSec money.Decimal `json:"duration_sec"`
+	MemoryMB    money.Decimal `json:"memory_mb"`
+	CPU         money.Decimal `json:"cpu"`
+	Requests    money.Decimal `json:"requests"`
+	// Replicas defaults to 1
+	Replicas int `json:"replicas"`
+}
+
+type FunctionQuoteInput struct {
+	// TariffID defaults to the tenant's active tariff
+	TariffID int    `json:"tariff_id"`
+	Tenant   string `json:"tenant"`
+	Function string `json:"function"`
+	// Days of past usage the month is projected from, 7 by default
+	Days int `json:"days"`
+}
+
+func NewQuoteService(tariffRepo repo.Tariff, subscriptionRepo repo.Subscription, usage UsageProvider) *QuoteService {
+	slog.Debug("component", slog.String("name", "quote service"))
+
+	return &QuoteService{
+		tariffRepo:       tariffRepo,
+		subscriptionRepo: subscriptionRepo,
+		usage:            usage,
+		now:              time.Now,
+	}
+}
+
+// Quote prices projected usage.
+func (s *QuoteService) Quote(ctx context.Context, body *QuoteInput) (*entity.Quote, error) {
+	tariff, err := s.quoteTariff(ctx, body.TariffID, body.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := money.NewFromInt(1)
+	if body.Replicas > 0 {
+		replicas = money.NewFromInt(int64(body.Replicas))
+	}
+	runtime := body.DurationSec.Mul(replicas)
+
+	return priceQuote(tariff, entity.QuoteUsage{
+		ExecSec:     runtime,
+		MemoryMBSec: runtime.Mul(body.MemoryMB),
+		CPUSec:      runtime.Mul(body.CPU),
+		Requests:    body.Requests,
+	})
+}
+
+// FunctionQuote projects the function's usage of the last days to a month
+// and prices it.
+func (s *QuoteService) FunctionQuote(ctx context.Context, body *FunctionQuoteInput) (*entity.Quote, error) {
+	tariff, err := s.quoteTariff(ctx, body.TariffID, body.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	days := body.Days
+	if days <= 0 {
+		days = defaultQuoteDays
+	}
+	to := s.now().UTC().Truncate(time.Minute)
+	from := to.AddDate(0, 0, -days)
+
+	measured, err := s.usage.FunctionUsage(ctx, body.Tenant, body.Function, from, to)
+	if err != nil {
+		slog.Error("failed to get function usage", slog.String("tenant", body.Tenant),
+			slog.String("function", body.Function), slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%w: %w", ErrUsageUnavailable, err)
+	}
+	if measured.ExecSec.IsZero() && measured.Requests.IsZero() {
+		return nil, ErrNoFunctionUsage
+	}
+
+	scale := money.NewFromInt(int64(quoteMonth)).Div(money.NewFromInt(int64(to.Sub(from))))
+	project := func(d money.Decimal) money.Decimal {
+		return d.Mul(scale).Round(money.Scale)
+	}
+
+	quote, err := priceQuote(tariff, entity.QuoteUsage{
+		ExecSec:     project(measured.ExecSec),
+		MemoryMBSec: project(measured.MemoryMBSec),
+		CPUSec:      project(measured.CPUSec),
+		Requests:    project(measured.Requests),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quote.Basis = &entity.QuoteBasis{
+		Function: body.Function,
+		From:     from,
+		To:       to,
+		Usage:    *measured,
+	}
+	return quote, nil
+}
+
+// quoteTariff is the tariff asked for, or else the one the tenant is
+// subscribed to now, with its current prices.
+func (s *QuoteService) quoteTariff(ctx context.Context, tariffID int, tenant string) (*entity.Tariff, error) {
+	now := s.now().UTC()
+	if tariffID == 0 {
+		next := now.Add(time.Second)
+		subs, err := s.subscriptionRepo.GetAll(ctx, &entity.SubscriptionFilters{
+			Tenant: tenant,
+			From:   &now,
+			To:     &next,
+			Limit:  1,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(subs) == 0 {
+			return nil, ErrNoActiveSubscription
+		}
+		tariffID = subs[0].TariffID
+	}
+
+	tariff, err := s.tariffRepo.GetByID(ctx, tariffID, now)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrTariffNotFound
+		}
+		return nil, err
+	}
+
+	return tariff, nil
+}
+
+// priceQuote prices the usage with pkg/pricing, as invoicer prices the
+// charges of an invoice. The free allowances are taken off the first units,
+// as invoicer takes them off the earliest usage of a billing period.
+func priceQuote(tariff *entity.Tariff, usage entity.QuoteUsage) (*entity.Quote, error) {
+	currency, err := money.ParseCurrency(tariff.Currency)
+	if err != nil {
+		return nil, ErrUnknownCurrency
+	}
+
+	quote := &entity.Quote{
+		TariffID:   tariff.ID,
+		TariffName: tariff.Name,
+		Currency:   currency.Code,
+		Usage:      usage,
+		Items:      []entity.QuoteItem{},
+		Allowances: []entity.QuoteAllowance{},
+		Subtotal:   money.Zero,
+	}
+
+	dimensions := []struct {
+		dimension string
+		unit      string
+		quantity  money.Decimal
+		flat      money.Decimal
+		free      money.Decimal
+	}{
+		{pricing.DimensionExec, "s", usage.ExecSec, tariff.ExecPrice, tariff.FreeExecSec},
+		{pricing.DimensionMemory, "MB*s", usage.MemoryMBSec, tariff.MemPrice, tariff.FreeMemMBSec},
+		{pricing.DimensionCPU, "CPU*s", usage.CPUSec, tariff.CpuPrice, tariff.FreeCPUSec},
+		{pricing.DimensionRequests, "requests", usage.Requests, tariff.RequestPrice, money.Zero},
+	}
+
+	waived := money.Zero
+	for _, d := range dimensions {
+		allocations := tariff.Tiers.Price(d.dimension, d.flat).Evaluate(d.quantity)
+		for _, a := range allocations {
+			quote.Items = append(quote.Items, entity.QuoteItem{
+				Dimension: d.dimension,
+				Tier:      a.Tier,
+				Quantity:  a.Quantity,
+				Unit:      d.unit,
+				UnitPrice: a.UnitPrice,
+				Amount:    a.Amount,
+			})
+		}
+		quote.Subtotal = quote.Subtotal.Add(pricing.Total(allocations))
+
+		quantity, amount := pricing.Waive(allocations, d.free)
+		if quantity.IsPositive() {
+			quote.Allowances = append(quote.Allowances, entity.QuoteAllowance{
+				Dimension: d.dimension,
+				Quantity:  quantity,
+				Unit:      d.unit,
+				Amount:    amount.Neg(),
+			})
+			waived = waived.Add(amount)
+		}
+	}
+
+	policy := money.Policy{Currency: currency, Precision: currency.Precision, Rounding: money.RoundHalfEven}
+	quote.Total = policy.Round(quote.Subtotal.Sub(waived)).Amount
+
+	return quote, nil
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+)
+
+var dec = money.MustParse
+
+type fakeTariffRepo struct {
+	repo.Tariff
+	tariffs map[int]entity.Tariff
+}
+
+func (f *fakeTariffRepo) GetByID(_ context.Context, id int, _ time.Time) (*entity.Tariff, error) {
+	t, ok := f.tariffs[id]
+	if !ok {
+		return nil, repoerrors.ErrNotFound
+	}
+	return &t, nil
+}
+
+type fakeSubscriptionRepo struct {
+	repo.Subscription
+	subscriptions []entity.Subscription
+}
+
+func (f *fakeSubscriptionRepo) GetAll(_ context.Context, filters *entity.SubscriptionFilters) ([]entity.Subscription, error) {
+	var out []entity.Subscription
+	for _, s := range f.subscriptions {
+		if s.Tenant == filters.Tenant && s.ValidFrom.Before(*filters.To) && (s.ValidTo == nil || s.ValidTo.After(*filters.From)) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+type fakeUsage struct {
+	usage    map[string]entity.QuoteUsage
+	from, to time.Time
+	err      error
+}
+
+func (f *fakeUsage) FunctionUsage(_ context.Context, _, function string, from, to time.Time) (*entity.QuoteUsage, error) {
+	f.from, f.to = from, to
+	u := f.usage[function]
+	return &u, f.err
+}
+
+// quoteTariff charges memory graduated, the first 1000 MB*s at 0.01 and the
+// rest at 0.005, with 200 MB*s free.
+func quoteTariff() entity.Tariff {
+	bound := dec("1000")
+	return entity.Tariff{
+		ID:           2,
+		Name:         "pro",
+		ExecPrice:    dec("0.001"),
+		CpuPrice:     dec("0.02"),
+		RequestPrice: dec("0.0001"),
+		Currency:     "USD",
+		FreeMemMBSec: dec("200"),
+		Tiers: pricing.Schedule{
+			pricing.DimensionMemory: {Model: pricing.ModelGraduated, Tiers: []pricing.Tier{
+				{UpTo: &bound, UnitPrice: dec("0.01")},
+				{UnitPrice: dec("0.005")},
+			}},
+		},
+	}
+}
+
+func newTestQuotes(usage *fakeUsage, now time.Time) *QuoteService {
+	s := NewQuoteService(
+		&fakeTariffRepo{tariffs: map[int]entity.Tariff{2: quoteTariff()}},
+		&fakeSubscriptionRepo{subscriptions: []entity.Subscription{
+			{Tenant: "alice", TariffID: 2, ValidFrom: now.Add(-time.Hour)},
+		}},
+		usage,
+	)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func assertDecimal(t *testing.T, want, got money.Decimal, msgAndArgs ...any) {
+	t.Helper()
+
+	assert.Equal(t, want.String(), got.String(), msgAndArgs...)
+}
+
+func Test_Quote(t *testing.T) {
+	s := newTestQuotes(&fakeUsage{}, time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC))
+
+	// two replicas, 10 s each with 100 MB and half a core
+	quote, err := s.Quote(context.Background(), &QuoteInput{
+		Tenant:      "alice",
+		DurationSec: dec("10"),
+		MemoryMB:    dec("100"),
+		CPU:         dec("0.5"),
+		Requests:    dec("300"),
+		Replicas:    2,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, quote.TariffID)
+	assert.Equal(t, "USD", quote.Currency)
+	assertDecimal(t, dec("20"), quote.Usage.ExecSec)
+	assertDecimal(t, dec("2000"), quote.Usage.MemoryMBSec)
+	assertDecimal(t, dec("10"), quote.Usage.CPUSec)
+
+	want := []entity.QuoteItem{
+		{Dimension: pricing.DimensionExec, Quantity: dec("20"), UnitPrice: dec("0.001"), Amount: dec("0.02")},
+		{Dimension: pricing.DimensionMemory, Quantity: dec("1000"), UnitPrice: dec("0.01"), Amount: dec("10")},
+		{Dimension: pricing.DimensionMemory, Tier: 1, Quantity: dec("1000"), UnitPrice: dec("0.005"), Amount: dec("5")},
+		{Dimension: pricing.DimensionCPU, Quantity: dec("10"), UnitPrice: dec("0.02"), Amount: dec("0.2")},
+		{Dimension: pricing.DimensionRequests, Quantity: dec("300"), UnitPrice: dec("0.0001"), Amount: dec("0.03")},
+	}
+	require.Len(t, quote.Items, len(want))
+	for i, w := range want {
+		got := quote.Items[i]
+		assert.Equal(t, w.Dimension, got.Dimension, "item %d", i)
+		assert.Equal(t, w.Tier, got.Tier, "item %d", i)
+		assertDecimal(t, w.Quantity, got.Quantity, "item %d", i)
+		assertDecimal(t, w.UnitPrice, got.UnitPrice, "item %d", i)
+		assertDecimal(t, w.Amount, got.Amount, "item %d", i)
+	}
+
+	// the free 200 MB*s come off the first tier
+	require.Len(t, quote.Allowances, 1)
+	assertDecimal(t, dec("200"), quote.Allowances[0].Quantity)
+	assertDecimal(t, dec("-2"), quote.Allowances[0].Amount)
+
+	assertDecimal(t, dec("15.25"), quote.Subtotal)
+	assertDecimal(t, dec("13.25"), quote.Total)
+	assert.Nil(t, quote.Basis)
+}
+
+func Test_QuoteTariff(t *testing.T) {
+	s := newTestQuotes(&fakeUsage{}, time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC))
+
+	_, err := s.Quote(context.Background(), &QuoteInput{Tenant: "bob"})
+	assert.ErrorIs(t, err, ErrNoActiveSubscription)
+
+	_, err = s.Quote(context.Background(), &QuoteInput{TariffID: 7})
+	assert.ErrorIs(t, err, ErrTariffNotFound)
+
+	quote, err := s.Quote(context.Background(), &QuoteInput{TariffID: 2})
+	require.NoError(t, err)
+	assertDecimal(t, money.Zero, quote.Total)
+}
+
+func Test_FunctionQuote(t *testing.T) {
+	now := time.Date(2025, 10, 20, 12, 0, 30, 0, time.UTC)
+	usage := &fakeUsage{usage: map[string]entity.QuoteUsage{
+		"hello": {ExecSec: dec("70"), MemoryMBSec: dec("140"), CPUSec: dec("7"), Requests: dec("700")},
+	}}
+	s := newTestQuotes(usage, now)
+
+	quote, err := s.FunctionQuote(context.Background(), &FunctionQuoteInput{Tenant: "alice", Function: "hello"})
+	require.NoError(t, err)
+
+	// a week of usage is projected to 30 days
+	assert.Equal(t, time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC), usage.to)
+	assert.Equal(t, usage.to.AddDate(0, 0, -7), usage.from)
+	assertDecimal(t, dec("300"), quote.Usage.ExecSec)
+	assertDecimal(t, dec("600"), quote.Usage.MemoryMBSec)
+	assertDecimal(t, dec("30"), quote.Usage.CPUSec)
+	assertDecimal(t, dec("3000"), quote.Usage.Requests)
+
+	require.NotNil(t, quote.Basis)
+	assert.Equal(t, "hello", quote.Basis.Function)
+	assertDecimal(t, dec("70"), quote.Basis.Usage.ExecSec)
+
+	// 0.3 + 6 + 0.6 + 0.3, less 2 for the free memory
+	assertDecimal(t, dec("7.2"), quote.Subtotal)
+	assertDecimal(t, dec("5.2"), quote.Total)
+
+	_, err = s.FunctionQuote(context.Background(), &FunctionQuoteInput{Tenant: "alice", Function: "resize"})
+	assert.ErrorIs(t, err, ErrNoFunctionUsage)
+
+	usage.err = errors.New("invoicer returned status 500 for /v1/usage")
+	_, err = s.FunctionQuote(context.Background(), &FunctionQuoteInput{Tenant: "alice", Function: "hello"})
+	assert.ErrorIs(t, err, ErrUsageUnavailable)
+}
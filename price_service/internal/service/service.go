@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/usamaroman/faas_demo/price_service/internal/entity"
 	"github.com/usamaroman/faas_demo/price_service/internal/repo"
@@ -9,23 +10,58 @@ import (
 
 type Tariff interface {
 	Create(ctx context.Context, body *TariffInput) (*entity.Tariff, error)
-	GetByID(ctx context.Context, id int) (*entity.Tariff, error)
+	GetByID(ctx context.Context, id int, at *time.Time) (*entity.Tariff, error)
 	GetAll(ctx context.Context, filters *entity.TariffFilters) ([]entity.Tariff, error)
+	GetVersions(ctx context.Context, id int, filters *entity.TariffVersionFilters) ([]entity.TariffVersion, error)
 	UpdateByID(ctx context.Context, id int, updates *TariffInput) (*entity.Tariff, error)
 	DeleteByID(ctx context.Context, id int) error
 }
 
+type Subscription interface {
+	Assign(ctx context.Context, body *SubscriptionInput) (*entity.Subscription, error)
+	GetByID(ctx context.Context, id int) (*entity.Subscription, error)
+	GetAll(ctx context.Context, filters *entity.SubscriptionFilters) ([]entity.Subscription, error)
+	End(ctx context.Context, id int, at time.Time) (*entity.Subscription, error)
+}
+
+type Discount interface {
+	Create(ctx context.Context, body *DiscountInput) (*entity.Discount, error)
+	GetByID(ctx context.Context, id int) (*entity.Discount, error)
+	GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Discount, error)
+	End(ctx context.Context, id int, at time.Time) (*entity.Discount, error)
+}
+
+type Credit interface {
+	Grant(ctx context.Context, body *CreditInput) (*entity.Credit, error)
+	GetByID(ctx context.Context, id int) (*entity.Credit, error)
+	GetAll(ctx context.Context, filters *entity.AdjustmentFilters) ([]entity.Credit, error)
+}
+
+type Quote interface {
+	Quote(ctx context.Context, body *QuoteInput) (*entity.Quote, error)
+	FunctionQuote(ctx context.Context, body *FunctionQuoteInput) (*entity.Quote, error)
+}
+
 type Dependencies struct {
 	Repos *repo.Repositories
+	Usage UsageProvider
 }
 
 type Services struct {
-	Tariff Tariff
+	Tariff       Tariff
+	Subscription Subscription
+	Discount     Discount
+	Credit       Credit
+	Quote        Quote
 }
 
 func NewServices(deps *Dependencies) *Services {
 	services := &Services{
-		Tariff: NewTariffService(deps.Repos.Tariff),
+		Tariff:       NewTariffService(deps.Repos.Tariff),
+		Subscription: NewSubscriptionService(deps.Repos.Subscription),
+		Discount:     NewDiscountService(deps.Repos.Discount),
+		Credit:       NewCreditService(deps.Repos.Credit),
+		Quote:        NewQuoteService(deps.Repos.Tariff, deps.Repos.Subscription, deps.Usage),
 	}
 
 	return services
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+)
+
+type SubscriptionService struct {
+	subscriptionRepo repo.Subscription
+	now              func() time.Time
+}
+
+type SubscriptionInput struct {
+	Tenant   string `json:"tenant"`
+	TariffID int    `json:"tariff_id"`
+	// ValidFrom defaults to now
+	ValidFrom *time.Time `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+}
+
+func NewSubscriptionService(subscriptionRepo repo.Subscription) *SubscriptionService {
+	slog.Debug("component", slog.String("name", "subscription service"))
+
+	return &SubscriptionService{
+		subscriptionRepo: subscriptionRepo,
+		now:              time.Now,
+	}
+}
+
+// Assign moves the tenant to a tariff starting at ValidFrom. The currently
+// open subscription, if it started earlier, is ended at that moment.
+func (s *SubscriptionService) Assign(ctx context.Context, body *SubscriptionInput) (*entity.Subscription, error) {
+	validFrom := s.now().UTC()
+	if body.ValidFrom != nil {
+		validFrom = body.ValidFrom.UTC()
+	}
+	if body.ValidTo != nil && !body.ValidTo.After(validFrom) {
+		return nil, ErrInvalidSubscriptionEnd
+	}
+
+	sub, err := s.subscriptionRepo.Assign(ctx, &entity.Subscription{
+		Tenant:    body.Tenant,
+		TariffID:  body.TariffID,
+		ValidFrom: validFrom,
+		ValidTo:   body.ValidTo,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, repoerrors.ErrReference):
+			return nil, ErrTariffNotFound
+		case errors.Is(err, repoerrors.ErrConflict):
+			return nil, ErrSubscriptionOverlap
+		}
+
+		slog.Error("failed to assign subscription", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (s *SubscriptionService) GetByID(ctx context.Context, id int) (*entity.Subscription, error) {
+	sub, err := s.subscriptionRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrSubscriptionNotFound
+		}
+
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (s *SubscriptionService) GetAll(ctx context.Context, filters *entity.SubscriptionFilters) ([]entity.Subscription, error) {
+	return s.subscriptionRepo.GetAll(ctx, filters)
+}
+
+// End closes the subscription at the given moment. A subscription can be
+// shortened but never extended, extending could overlap a later one.
+func (s *SubscriptionService) End(ctx context.Context, id int, at time.Time) (*entity.Subscription, error) {
+	sub, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	at = at.UTC()
+	if !at.After(sub.ValidFrom) || (sub.ValidTo != nil && at.After(*sub.ValidTo)) {
+		return nil, ErrInvalidSubscriptionEnd
+	}
+
+	ended, err := s.subscriptionRepo.End(ctx, id, at)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrSubscriptionNotFound
+		}
+
+		return nil, err
+	}
+
+	return ended, nil
+}
@@ -3,8 +3,12 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/pkg/pricing"
 	"github.com/usamaroman/faas_demo/price_service/internal/entity"
 	"github.com/usamaroman/faas_demo/price_service/internal/repo"
 	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
@@ -12,40 +16,84 @@ import (
 
 type TariffService struct {
 	tariffRepo repo.Tariff
+	now        func() time.Time
 }
 
 type TariffInput struct {
-	Name      string  `json:"name"`
-	ExecPrice float64 `json:"exec_price"`
-	MemPrice  float64 `json:"mem_price"`
-	CpuPrice  float64 `json:"cpu_price"`
+	Name         string        `json:"name"`
+	ExecPrice    money.Decimal `json:"exec_price"`
+	MemPrice     money.Decimal `json:"mem_price"`
+	CpuPrice     money.Decimal `json:"cpu_price"`
+	RequestPrice money.Decimal `json:"request_price"`
+	Currency     string        `json:"currency"`
+	// nil allowances are zero on create and kept on update
+	FreeExecSec  *money.Decimal `json:"free_exec_sec"`
+	FreeMemMBSec *money.Decimal `json:"free_mem_mb_sec"`
+	FreeCPUSec   *money.Decimal `json:"free_cpu_sec"`
+	// nil tiers are empty on create and kept on update
+	Tiers pricing.Schedule `json:"tiers"`
+	// EffectiveFrom is when updated prices come into force, now by default
+	EffectiveFrom *time.Time `json:"effective_from"`
 }
 
 func NewTariffService(tariffRepo repo.Tariff) *TariffService {
-	slog.Debug("component", "tariff service")
+	slog.Debug("component", slog.String("name", "tariff service"))
 
 	return &TariffService{
 		tariffRepo: tariffRepo,
+		now:        time.Now,
 	}
 }
 
 func (s *TariffService) Create(ctx context.Context, body *TariffInput) (*entity.Tariff, error) {
+	if err := body.Tiers.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTiers, err)
+	}
+
+	tiers := body.Tiers
+	if tiers == nil {
+		tiers = pricing.Schedule{}
+	}
+
+	currency := money.USD
+	if body.Currency != "" {
+		c, err := money.ParseCurrency(body.Currency)
+		if err != nil {
+			return nil, ErrUnknownCurrency
+		}
+		currency = c
+	}
+
 	tariff, err := s.tariffRepo.Create(ctx, &entity.Tariff{
-		Name:      body.Name,
-		ExecPrice: body.ExecPrice,
-		MemPrice:  body.MemPrice,
-		CpuPrice:  body.CpuPrice,
+		Name:          body.Name,
+		EffectiveFrom: s.now().UTC(),
+		ExecPrice:     body.ExecPrice,
+		MemPrice:      body.MemPrice,
+		CpuPrice:      body.CpuPrice,
+		RequestPrice:  body.RequestPrice,
+		Currency:      currency.Code,
+		FreeExecSec:   orZero(body.FreeExecSec),
+		FreeMemMBSec:  orZero(body.FreeMemMBSec),
+		FreeCPUSec:    orZero(body.FreeCPUSec),
+		Tiers:         tiers,
 	})
 	if err != nil {
-		slog.Error("failed to create tariff", err.Error())
+		slog.Error("failed to create tariff", slog.String("error", err.Error()))
 		return nil, err
 	}
 
 	return tariff, nil
 }
 
-func (s *TariffService) GetByID(ctx context.Context, id int) (*entity.Tariff, error) {
-	tariff, err := s.tariffRepo.GetByID(ctx, id)
+// GetByID returns the tariff with the prices in force at the given moment, now
+// if it is nil.
+func (s *TariffService) GetByID(ctx context.Context, id int, at *time.Time) (*entity.Tariff, error) {
+	when := s.now().UTC()
+	if at != nil {
+		when = at.UTC()
+	}
+
+	tariff, err := s.tariffRepo.GetByID(ctx, id, when)
 	if err != nil {
 		if errors.Is(err, repoerrors.ErrNotFound) {
 			return nil, ErrTariffNotFound
@@ -58,19 +106,74 @@ func (s *TariffService) GetByID(ctx context.Context, id int) (*entity.Tariff, er
 }
 
 func (s *TariffService) GetAll(ctx context.Context, filters *entity.TariffFilters) ([]entity.Tariff, error) {
+	if filters.At.IsZero() {
+		filters.At = s.now().UTC()
+	}
+
 	return s.tariffRepo.GetAll(ctx, filters)
 }
 
+// GetVersions returns the tariff's price history, oldest first.
+func (s *TariffService) GetVersions(ctx context.Context, id int, filters *entity.TariffVersionFilters) ([]entity.TariffVersion, error) {
+	versions, err := s.tariffRepo.GetVersions(ctx, id, filters)
+	if err != nil {
+		if errors.Is(err, repoerrors.ErrNotFound) {
+			return nil, ErrTariffNotFound
+		}
+
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// UpdateByID renames the tariff right away. Price changes never overwrite
+// prices already in force, they make a new version starting at EffectiveFrom,
+// which must be after the start of the latest version and not in the past:
+// usage already invoiced is repriced by recalculating its invoice in invoicer.
 func (s *TariffService) UpdateByID(ctx context.Context, id int, updates *TariffInput) (*entity.Tariff, error) {
+	if err := updates.Tiers.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTiers, err)
+	}
+
+	var currency string
+	if updates.Currency != "" {
+		c, err := money.ParseCurrency(updates.Currency)
+		if err != nil {
+			return nil, ErrUnknownCurrency
+		}
+		currency = c.Code
+	}
+
+	now := s.now().UTC()
+	effectiveFrom := now
+	if updates.EffectiveFrom != nil {
+		effectiveFrom = updates.EffectiveFrom.UTC()
+		if effectiveFrom.Before(now) {
+			return nil, ErrBackdatedVersion
+		}
+	}
+
 	updatedTariff, err := s.tariffRepo.UpdateByID(ctx, id, &entity.Tariff{
-		Name:      updates.Name,
-		ExecPrice: updates.ExecPrice,
-		MemPrice:  updates.MemPrice,
-		CpuPrice:  updates.CpuPrice,
+		Name:          updates.Name,
+		EffectiveFrom: effectiveFrom,
+		ExecPrice:     updates.ExecPrice,
+		MemPrice:      updates.MemPrice,
+		CpuPrice:      updates.CpuPrice,
+		RequestPrice:  updates.RequestPrice,
+		Currency:      currency,
+		Tiers:         updates.Tiers,
+	}, &entity.FreeAllowances{
+		ExecSec:  updates.FreeExecSec,
+		MemMBSec: updates.FreeMemMBSec,
+		CPUSec:   updates.FreeCPUSec,
 	})
 	if err != nil {
-		if errors.Is(err, repoerrors.ErrNotFound) {
+		switch {
+		case errors.Is(err, repoerrors.ErrNotFound):
 			return nil, ErrTariffNotFound
+		case errors.Is(err, repoerrors.ErrConflict):
+			return nil, ErrInvalidVersionStart
 		}
 
 		return nil, err
@@ -79,11 +182,16 @@ func (s *TariffService) UpdateByID(ctx context.Context, id int, updates *TariffI
 	return updatedTariff, nil
 }
 
+// DeleteByID retires a tariff nobody is or will be subscribed to. Its price
+// history is kept, invoices priced with it can still be recalculated.
 func (s *TariffService) DeleteByID(ctx context.Context, id int) error {
-	err := s.tariffRepo.DeleteByID(ctx, id)
+	err := s.tariffRepo.DeleteByID(ctx, id, s.now().UTC())
 	if err != nil {
-		if errors.Is(err, repoerrors.ErrNotFound) {
+		switch {
+		case errors.Is(err, repoerrors.ErrNotFound):
 			return ErrTariffNotFound
+		case errors.Is(err, repoerrors.ErrConflict):
+			return ErrTariffInUse
 		}
 
 		return err
@@ -91,3 +199,10 @@ func (s *TariffService) DeleteByID(ctx context.Context, id int) error {
 
 	return nil
 }
+
+func orZero(d *money.Decimal) money.Decimal {
+	if d == nil {
+		return money.Zero
+	}
+	return *d
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo"
+	"github.com/usamaroman/faas_demo/price_service/internal/repo/repoerrors"
+)
+
+// versionedTariffRepo keeps the start of the latest version of tariff 1.
+type versionedTariffRepo struct {
+	repo.Tariff
+	latest time.Time
+	at     time.Time
+}
+
+func (f *versionedTariffRepo) GetByID(_ context.Context, id int, at time.Time) (*entity.Tariff, error) {
+	if id != 1 {
+		return nil, repoerrors.ErrNotFound
+	}
+	f.at = at
+	return &entity.Tariff{ID: id, EffectiveFrom: f.latest}, nil
+}
+
+func (f *versionedTariffRepo) UpdateByID(_ context.Context, id int, updates *entity.Tariff, _ *entity.FreeAllowances) (*entity.Tariff, error) {
+	if id != 1 {
+		return nil, repoerrors.ErrNotFound
+	}
+	if !updates.EffectiveFrom.After(f.latest) {
+		return nil, repoerrors.ErrConflict
+	}
+	f.latest = updates.EffectiveFrom
+	return updates, nil
+}
+
+func (f *versionedTariffRepo) DeleteByID(_ context.Context, id int, now time.Time) error {
+	if id != 1 {
+		return repoerrors.ErrNotFound
+	}
+	f.at = now
+	return repoerrors.ErrConflict
+}
+
+func Test_TariffDelete(t *testing.T) {
+	now := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	tariffs := &versionedTariffRepo{}
+	s := NewTariffService(tariffs)
+	s.now = func() time.Time { return now }
+
+	err := s.DeleteByID(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrTariffInUse)
+	assert.Equal(t, now, tariffs.at, "subscriptions open from now on keep the tariff")
+
+	err = s.DeleteByID(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrTariffNotFound)
+}
+
+func Test_TariffVersions(t *testing.T) {
+	now := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	tariffs := &versionedTariffRepo{latest: now.AddDate(0, -1, 0)}
+	s := NewTariffService(tariffs)
+	s.now = func() time.Time { return now }
+
+	// prices without a start come into force now
+	updated, err := s.UpdateByID(context.Background(), 1, &TariffInput{ExecPrice: dec("0.002")})
+	require.NoError(t, err)
+	assert.Equal(t, now, updated.EffectiveFrom)
+
+	// history is not rewritten
+	earlier := now.Add(-time.Hour)
+	_, err = s.UpdateByID(context.Background(), 1, &TariffInput{ExecPrice: dec("0.003"), EffectiveFrom: &earlier})
+	assert.ErrorIs(t, err, ErrBackdatedVersion)
+	assert.Equal(t, now, tariffs.latest)
+
+	// nor are versions scheduled ahead of the new one
+	later, sooner := now.AddDate(0, 1, 0), now.AddDate(0, 0, 7)
+	_, err = s.UpdateByID(context.Background(), 1, &TariffInput{ExecPrice: dec("0.003"), EffectiveFrom: &later})
+	require.NoError(t, err)
+	_, err = s.UpdateByID(context.Background(), 1, &TariffInput{ExecPrice: dec("0.004"), EffectiveFrom: &sooner})
+	assert.ErrorIs(t, err, ErrInvalidVersionStart)
+
+	_, err = s.UpdateByID(context.Background(), 2, &TariffInput{ExecPrice: dec("0.003")})
+	assert.ErrorIs(t, err, ErrTariffNotFound)
+
+	_, err = s.GetByID(context.Background(), 1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, now, tariffs.at)
+
+	_, err = s.GetByID(context.Background(), 1, &earlier)
+	require.NoError(t, err)
+	assert.Equal(t, earlier, tariffs.at)
+}
@@ -0,0 +1,109 @@
+package invoicer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/usamaroman/faas_demo/pkg/money"
+	"github.com/usamaroman/faas_demo/price_service/internal/entity"
+)
+
+// Client talks to invoicer over its public v1 API.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// maxSeries is the page size of invoicer's usage report.
+const maxSeries = 100
+
+type usageReport struct {
+	Series []struct {
+		Function string  `json:"function"`
+		Total    float64 `json:"total"`
+	} `json:"series"`
+}
+
+// FunctionUsage sums the function's usage over [from, to). A function that
+// did not run has zero usage.
+func (c *Client) FunctionUsage(ctx context.Context, tenant, function string, from, to time.Time) (*entity.QuoteUsage, error) {
+	var usage entity.QuoteUsage
+	metrics := []struct {
+		metric string
+		out    *money.Decimal
+	}{
+		{"duration", &usage.ExecSec},
+		{"mem_mb_sec", &usage.MemoryMBSec},
+		{"cpu_sec", &usage.CPUSec},
+		{"requests", &usage.Requests},
+	}
+
+	for _, m := range metrics {
+		total, err := c.functionTotal(ctx, tenant, function, m.metric, from, to)
+		if err != nil {
+			return nil, err
+		}
+		*m.out = money.NewFromFloat(total).Round(money.Scale)
+	}
+
+	return &usage, nil
+}
+
+// functionTotal pages through the tenant's usage grouped by function until it
+// finds the function's series.
+func (c *Client) functionTotal(ctx context.Context, tenant, function, metric string, from, to time.Time) (float64, error) {
+	for offset := 0; ; offset += maxSeries {
+		query := url.Values{}
+		query.Set("tenant", tenant)
+		query.Set("metric", metric)
+		query.Set("group_by", "function")
+		query.Set("from", from.UTC().Format(time.RFC3339))
+		query.Set("to", to.UTC().Format(time.RFC3339))
+		query.Set("limit", fmt.Sprint(maxSeries))
+		query.Set("offset", fmt.Sprint(offset))
+
+		var report usageReport
+		if err := c.get(ctx, c.baseURL+"/v1/usage?"+query.Encode(), &report); err != nil {
+			return 0, err
+		}
+
+		for _, s := range report.Series {
+			if s.Function == function {
+				return s.Total, nil
+			}
+		}
+		if len(report.Series) < maxSeries {
+			return 0, nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context, target string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("invoicer returned status %d for %s", resp.StatusCode, req.URL.Path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE TABLE subscriptions (
+     id SERIAL PRIMARY KEY,
+     tenant VARCHAR(255) NOT NULL,
+     tariff_id INT NOT NULL REFERENCES tariffs (id),
+     valid_from TIMESTAMPTZ NOT NULL,
+     valid_to TIMESTAMPTZ,
+     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+     CHECK (valid_to IS NULL OR valid_to > valid_from),
+     -- a tenant is on exactly one tariff at any moment
+     EXCLUDE USING gist (tenant WITH =, tstzrange(valid_from, valid_to) WITH &&)
+);
+
+CREATE INDEX subscriptions_tenant_valid_from_idx ON subscriptions (tenant, valid_from);
+
+CREATE TRIGGER update_subscriptions_updated_at BEFORE UPDATE ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_subscriptions_updated_at ON subscriptions;
+DROP TABLE subscriptions;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- per-second prices are often fractions of a cent, two digits are not enough
+ALTER TABLE tariffs
+    ALTER COLUMN exec_price TYPE NUMERIC(20, 10),
+    ALTER COLUMN mem_price TYPE NUMERIC(20, 10),
+    ALTER COLUMN cpu_price TYPE NUMERIC(20, 10),
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tariffs
+    DROP COLUMN currency,
+    ALTER COLUMN exec_price TYPE NUMERIC(10, 2),
+    ALTER COLUMN mem_price TYPE NUMERIC(10, 2),
+    ALTER COLUMN cpu_price TYPE NUMERIC(10, 2);
+-- +goose StatementEnd
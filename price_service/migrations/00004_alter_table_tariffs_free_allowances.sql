@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- usage every tenant on the tariff gets for free in each billing period
+ALTER TABLE tariffs
+    ADD COLUMN free_exec_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_exec_sec >= 0),
+    ADD COLUMN free_mem_mb_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_mem_mb_sec >= 0),
+    ADD COLUMN free_cpu_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_cpu_sec >= 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tariffs
+    DROP COLUMN free_cpu_sec,
+    DROP COLUMN free_mem_mb_sec,
+    DROP COLUMN free_exec_sec;
+-- +goose StatementEnd
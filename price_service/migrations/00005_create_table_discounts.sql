@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE discounts (
+     id SERIAL PRIMARY KEY,
+     tenant VARCHAR(255) NOT NULL,
+     percent NUMERIC(5, 2) NOT NULL CHECK (percent > 0 AND percent <= 100),
+     description VARCHAR(255) NOT NULL DEFAULT '',
+     valid_from TIMESTAMPTZ NOT NULL,
+     valid_to TIMESTAMPTZ,
+     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+     CHECK (valid_to IS NULL OR valid_to > valid_from),
+     -- discounts never stack, a tenant has at most one at any moment
+     EXCLUDE USING gist (tenant WITH =, tstzrange(valid_from, valid_to) WITH &&)
+);
+
+CREATE INDEX discounts_tenant_valid_from_idx ON discounts (tenant, valid_from);
+
+CREATE TRIGGER update_discounts_updated_at BEFORE UPDATE ON discounts
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_discounts_updated_at ON discounts;
+DROP TABLE discounts;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- prepaid promo credits, invoicer keeps track of how much of each is spent
+CREATE TABLE credits (
+     id SERIAL PRIMARY KEY,
+     tenant VARCHAR(255) NOT NULL,
+     amount NUMERIC(20, 10) NOT NULL CHECK (amount > 0),
+     currency CHAR(3) NOT NULL,
+     description VARCHAR(255) NOT NULL DEFAULT '',
+     granted_at TIMESTAMPTZ NOT NULL,
+     expires_at TIMESTAMPTZ,
+     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+     CHECK (expires_at IS NULL OR expires_at > granted_at)
+);
+
+CREATE INDEX credits_tenant_granted_at_idx ON credits (tenant, granted_at);
+
+CREATE TRIGGER update_credits_updated_at BEFORE UPDATE ON credits
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_credits_updated_at ON credits;
+DROP TABLE credits;
+-- +goose StatementEnd
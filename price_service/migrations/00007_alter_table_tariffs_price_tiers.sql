@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- tiers holds the graduated or volume prices of a tariff by dimension, see
+-- pkg/pricing. Dimensions missing from it keep their flat price.
+ALTER TABLE tariffs
+    ADD COLUMN request_price NUMERIC(20, 10) NOT NULL DEFAULT 0 CHECK (request_price >= 0),
+    ADD COLUMN tiers JSONB NOT NULL DEFAULT '{}' CHECK (jsonb_typeof(tiers) = 'object');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tariffs
+    DROP COLUMN tiers,
+    DROP COLUMN request_price;
+-- +goose StatementEnd
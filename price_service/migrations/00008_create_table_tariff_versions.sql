@@ -0,0 +1,83 @@
+-- +goose Up
+-- +goose StatementBegin
+-- prices of a tariff over time. A version is in force from effective_from
+-- until the next one, the first version also prices usage before it. Versions
+-- are never changed, an update adds a new one.
+CREATE TABLE tariff_versions (
+     tariff_id INT NOT NULL REFERENCES tariffs (id) ON DELETE CASCADE,
+     version INT NOT NULL CHECK (version > 0),
+     effective_from TIMESTAMPTZ NOT NULL,
+     exec_price NUMERIC(20, 10) NOT NULL,
+     mem_price NUMERIC(20, 10) NOT NULL,
+     cpu_price NUMERIC(20, 10) NOT NULL,
+     request_price NUMERIC(20, 10) NOT NULL DEFAULT 0 CHECK (request_price >= 0),
+     currency CHAR(3) NOT NULL DEFAULT 'USD',
+     free_exec_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_exec_sec >= 0),
+     free_mem_mb_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_mem_mb_sec >= 0),
+     free_cpu_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_cpu_sec >= 0),
+     tiers JSONB NOT NULL DEFAULT '{}' CHECK (jsonb_typeof(tiers) = 'object'),
+     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+     PRIMARY KEY (tariff_id, version),
+     UNIQUE (tariff_id, effective_from)
+);
+
+INSERT INTO tariff_versions (tariff_id, version, effective_from, exec_price, mem_price, cpu_price, request_price,
+                             currency, free_exec_sec, free_mem_mb_sec, free_cpu_sec, tiers)
+SELECT id, 1, COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(exec_price, 0), COALESCE(mem_price, 0),
+       COALESCE(cpu_price, 0), request_price, currency, free_exec_sec, free_mem_mb_sec, free_cpu_sec, tiers
+FROM tariffs;
+
+ALTER TABLE tariffs
+    DROP COLUMN exec_price,
+    DROP COLUMN mem_price,
+    DROP COLUMN cpu_price,
+    DROP COLUMN request_price,
+    DROP COLUMN currency,
+    DROP COLUMN free_exec_sec,
+    DROP COLUMN free_mem_mb_sec,
+    DROP COLUMN free_cpu_sec,
+    DROP COLUMN tiers;
+
+-- effective_to is where the next version takes over, NULL for the latest one
+CREATE VIEW tariff_version_periods AS
+SELECT *, LEAD(effective_from) OVER (PARTITION BY tariff_id ORDER BY version) AS effective_to
+FROM tariff_versions;
+
+CREATE OR REPLACE FUNCTION reject_tariff_version_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'tariff versions are immutable, add a new version instead';
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER tariff_versions_immutable BEFORE UPDATE ON tariff_versions
+    FOR EACH ROW EXECUTE FUNCTION reject_tariff_version_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS tariff_versions_immutable ON tariff_versions;
+DROP FUNCTION IF EXISTS reject_tariff_version_change();
+DROP VIEW tariff_version_periods;
+
+ALTER TABLE tariffs
+    ADD COLUMN exec_price NUMERIC(20, 10),
+    ADD COLUMN mem_price NUMERIC(20, 10),
+    ADD COLUMN cpu_price NUMERIC(20, 10),
+    ADD COLUMN request_price NUMERIC(20, 10) NOT NULL DEFAULT 0 CHECK (request_price >= 0),
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN free_exec_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_exec_sec >= 0),
+    ADD COLUMN free_mem_mb_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_mem_mb_sec >= 0),
+    ADD COLUMN free_cpu_sec NUMERIC(30, 10) NOT NULL DEFAULT 0 CHECK (free_cpu_sec >= 0),
+    ADD COLUMN tiers JSONB NOT NULL DEFAULT '{}' CHECK (jsonb_typeof(tiers) = 'object');
+
+-- the latest version becomes the tariff's price again
+UPDATE tariffs t
+SET exec_price = v.exec_price, mem_price = v.mem_price, cpu_price = v.cpu_price, request_price = v.request_price,
+    currency = v.currency, free_exec_sec = v.free_exec_sec, free_mem_mb_sec = v.free_mem_mb_sec,
+    free_cpu_sec = v.free_cpu_sec, tiers = v.tiers
+FROM (SELECT DISTINCT ON (tariff_id) * FROM tariff_versions ORDER BY tariff_id, version DESC) v
+WHERE v.tariff_id = t.id;
+
+DROP TABLE tariff_versions;
+-- +goose StatementEnd
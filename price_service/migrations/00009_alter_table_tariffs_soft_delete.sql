@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- issued invoices are priced with the versions of a tariff, so a deleted
+-- tariff is only marked deleted and its versions are kept for good
+ALTER TABLE tariffs ADD COLUMN deleted_at TIMESTAMPTZ;
+
+ALTER TABLE tariff_versions
+    DROP CONSTRAINT tariff_versions_tariff_id_fkey,
+    ADD CONSTRAINT tariff_versions_tariff_id_fkey
+        FOREIGN KEY (tariff_id) REFERENCES tariffs (id) ON DELETE RESTRICT;
+
+DROP TRIGGER tariff_versions_immutable ON tariff_versions;
+CREATE TRIGGER tariff_versions_immutable BEFORE UPDATE OR DELETE ON tariff_versions
+    FOR EACH ROW EXECUTE FUNCTION reject_tariff_version_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER tariff_versions_immutable ON tariff_versions;
+CREATE TRIGGER tariff_versions_immutable BEFORE UPDATE ON tariff_versions
+    FOR EACH ROW EXECUTE FUNCTION reject_tariff_version_change();
+
+ALTER TABLE tariff_versions
+    DROP CONSTRAINT tariff_versions_tariff_id_fkey,
+    ADD CONSTRAINT tariff_versions_tariff_id_fkey
+        FOREIGN KEY (tariff_id) REFERENCES tariffs (id) ON DELETE CASCADE;
+
+ALTER TABLE tariffs DROP COLUMN deleted_at;
+-- +goose StatementEnd